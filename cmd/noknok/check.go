@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/primal-host/noknok/internal/atproto"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// runCheck validates the configuration and the database connection without
+// starting the server, printing one line per check. It returns the process
+// exit code: 0 if every check passed, 1 otherwise.
+//
+// The database check only connects and pings: it doesn't apply schema
+// changes, so it is safe to run against a live database.
+func runCheck() int {
+	failed := false
+	report := func(ok bool, name, detail string) {
+		status := "PASS"
+		if !ok {
+			status = "FAIL"
+			failed = true
+		}
+		fmt.Printf("%s  %-15s %s\n", status, name, detail)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		report(false, "config", err.Error())
+		return 1
+	}
+	report(true, "config", "environment parsed")
+
+	report(checkPublicURL(cfg))
+	report(checkCookieDomains(cfg))
+
+	if _, err := syntax.ParseDID(cfg.OwnerDID); err != nil {
+		report(false, "owner DID", err.Error())
+	} else {
+		report(true, "owner DID", cfg.OwnerDID)
+	}
+
+	if _, err := time.ParseDuration(cfg.SessionTTL); err != nil {
+		report(false, "session TTL", err.Error())
+	} else {
+		report(true, "session TTL", cfg.SessionTTL)
+	}
+
+	paths := atproto.OAuthPaths{
+		Metadata: cfg.OAuthMetadataPath,
+		Callback: cfg.OAuthCallbackPath,
+		JWKS:     cfg.OAuthJWKSPath,
+	}
+	if _, err := atproto.NewOAuthClient(cfg.PublicURL, paths, cfg.OAuthPrivateKey, nil); err != nil {
+		report(false, "OAuth client", err.Error())
+	} else {
+		report(true, "OAuth client", "key parsed, client ID "+strings.TrimSuffix(cfg.PublicURL, "/")+paths.Metadata)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
+	defer cancel()
+	db, err := database.Connect(ctx, cfg.DSN())
+	if err != nil {
+		report(false, "database", err.Error())
+	} else {
+		report(true, "database", fmt.Sprintf("%s:%s/%s reachable", cfg.DBHost, cfg.DBPort, cfg.DBName))
+		if cfg.DBReplicaDSN != "" {
+			if err := db.AttachReplica(ctx, cfg.DBReplicaDSN, cfg.DBReplicaMaxLag); err != nil {
+				report(false, "read replica", err.Error())
+			} else {
+				state := db.ReplicaState()
+				report(state == database.ReplicaOK, "read replica", state)
+			}
+		}
+		db.Close()
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func checkPublicURL(cfg *config.Config) (bool, string, string) {
+	u, err := url.Parse(cfg.PublicURL)
+	if err != nil {
+		return false, "public URL", err.Error()
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false, "public URL", fmt.Sprintf("%q: scheme must be http or https", cfg.PublicURL)
+	}
+	if u.Host == "" {
+		return false, "public URL", fmt.Sprintf("%q: missing host", cfg.PublicURL)
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" || u.Fragment != "" {
+		return false, "public URL", fmt.Sprintf("%q: must not have a path, query, or fragment", cfg.PublicURL)
+	}
+	if u.Scheme == "http" {
+		return true, "public URL", cfg.PublicURL + " (plain http: cookies won't be Secure)"
+	}
+	return true, "public URL", cfg.PublicURL
+}
+
+// checkCookieDomains verifies each cookie domain looks like a domain and
+// that the public host falls under the primary one, so the session cookie
+// set at login is visible to noknok itself.
+func checkCookieDomains(cfg *config.Config) (bool, string, string) {
+	for _, d := range cfg.CookieDomains {
+		base := strings.TrimPrefix(d, ".")
+		if base == "" || strings.ContainsAny(base, "/:; ") {
+			return false, "cookie domains", fmt.Sprintf("%q is not a domain", d)
+		}
+	}
+	u, err := url.Parse(cfg.PublicURL)
+	if err != nil {
+		return false, "cookie domains", "public URL doesn't parse"
+	}
+	host := u.Hostname()
+	base := strings.TrimPrefix(cfg.CookieDomain, ".")
+	if host != base && !strings.HasSuffix(host, "."+base) {
+		return false, "cookie domains", fmt.Sprintf("public host %s is not under primary cookie domain %s", host, cfg.CookieDomain)
+	}
+	return true, "cookie domains", strings.Join(cfg.CookieDomains, ", ")
+}
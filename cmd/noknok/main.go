@@ -17,6 +17,19 @@ import (
 )
 
 func main() {
+	// Apply LOG_LEVEL before anything is logged. An invalid value is
+	// reported by config.Load below.
+	if level, err := config.LogLevel(); err == nil {
+		slog.SetLogLoggerLevel(level)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-traefik" {
+		os.Exit(runImportTraefik(os.Args[2:]))
+	}
+
 	slog.Info("noknok starting", "version", config.Version)
 
 	cfg, err := config.Load()
@@ -24,9 +37,20 @@ func main() {
 		slog.Error("config load failed", "error", err)
 		os.Exit(1)
 	}
+	if cfg.InsecurePublicURL() {
+		if cfg.LocalPublicURL() {
+			slog.Warn("PUBLIC_URL is not https; session cookies are not Secure (fine for local development)",
+				"public_url", cfg.PublicURL)
+		} else {
+			slog.Warn("INSECURE: PUBLIC_URL is not https; session cookies are sent in the clear and can be intercepted. Use https, and set REQUIRE_HTTPS=true to refuse this at startup",
+				"public_url", cfg.PublicURL)
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	db, err := database.Open(ctx, cfg.DSN())
+	// Allow for every retry on top of the usual connect/bootstrap time.
+	dbWait := cfg.StartupTimeout + time.Duration(cfg.DBConnectAttempts)*(cfg.DBConnectInterval+5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), dbWait)
+	db, err := database.Open(ctx, cfg.DSN(), cfg.DBConnectAttempts, cfg.DBConnectInterval)
 	cancel()
 	if err != nil {
 		slog.Error("database open failed", "error", err)
@@ -34,9 +58,25 @@ func main() {
 	}
 	defer db.Close()
 	slog.Info("database connected")
+	if cfg.GrantRoleCap {
+		db.EnableGrantRoleCap()
+	}
+	if cfg.ServiceHostCacheTTL > 0 {
+		db.EnableHostCache(cfg.ServiceHostCacheTTL)
+	}
+	if cfg.DBReplicaDSN != "" {
+		ctx, cancel = context.WithTimeout(context.Background(), cfg.StartupTimeout)
+		err := db.AttachReplica(ctx, cfg.DBReplicaDSN, cfg.DBReplicaMaxLag)
+		cancel()
+		if err != nil {
+			slog.Error("read replica connect failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("read replica connected", "state", db.ReplicaState(), "max_lag", cfg.DBReplicaMaxLag)
+	}
 
 	// Seed owner user.
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.StartupTimeout)
 	if err := db.SeedOwner(ctx, cfg.OwnerDID, cfg.OwnerUsername); err != nil {
 		cancel()
 		slog.Error("failed to seed owner", "error", err)
@@ -46,7 +86,7 @@ func main() {
 	slog.Info("owner seeded", "did", cfg.OwnerDID)
 
 	// Seed services from JSON file and grant owner access to all.
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.StartupTimeout)
 	if err := db.SeedServices(ctx, "services.json"); err != nil {
 		cancel()
 		slog.Error("failed to seed services", "error", err)
@@ -57,12 +97,42 @@ func main() {
 		slog.Error("failed to grant owner services", "error", err)
 		os.Exit(1)
 	}
+	svcs, err := db.ListServices(ctx, "")
 	cancel()
+	if err != nil {
+		slog.Error("failed to list services", "error", err)
+		os.Exit(1)
+	}
 	slog.Info("services seeded and owner granted")
+	hostSlugs := make(map[string][]string)
+	var hosts []string
+	for _, svc := range svcs {
+		if cfg.IsPublicHost(svc.URL) {
+			slog.Warn("service url uses noknok's own host; forwardAuth may gate noknok itself",
+				"slug", svc.Slug, "url", svc.URL)
+		}
+		if h := database.ServiceHost(svc.URL); h != "" {
+			if hostSlugs[h] == nil {
+				hosts = append(hosts, h)
+			}
+			hostSlugs[h] = append(hostSlugs[h], svc.Slug)
+		}
+	}
+	for _, h := range hosts {
+		if len(hostSlugs[h]) > 1 {
+			slog.Warn("services share a host; forwardAuth only matches the oldest of them",
+				"host", h, "slugs", hostSlugs[h])
+		}
+	}
 
 	// OAuth client.
 	store := atproto.NewPgStore(db.Pool)
-	oauthClient, err := atproto.NewOAuthClient(cfg.PublicURL, cfg.OAuthPrivateKey, store)
+	oauthPaths := atproto.OAuthPaths{
+		Metadata: cfg.OAuthMetadataPath,
+		Callback: cfg.OAuthCallbackPath,
+		JWKS:     cfg.OAuthJWKSPath,
+	}
+	oauthClient, err := atproto.NewOAuthClient(cfg.PublicURL, oauthPaths, cfg.OAuthPrivateKey, store)
 	if err != nil {
 		slog.Error("OAuth client init failed", "error", err)
 		os.Exit(1)
@@ -77,6 +147,34 @@ func main() {
 	}
 	secure := strings.HasPrefix(cfg.PublicURL, "https://")
 	sess := session.NewManager(db.Pool, ttl, cfg.CookieDomain, secure)
+	sess.SetCookiePath(cfg.CookiePath)
+	if cfg.DBReplicaDSN != "" {
+		sess.UseReadRouter(db)
+	}
+	if cfg.CookiePrefix != "" {
+		sess.SetCookiePrefix(cfg.CookiePrefix)
+	}
+	if cfg.CookiePartitioned {
+		sess.EnablePartitioning()
+	}
+	if cfg.SessionRotate {
+		sess.EnableRotation()
+	}
+	if cfg.SessionGroupMaxAge > 0 {
+		sess.SetGroupMaxAge(cfg.SessionGroupMaxAge)
+	}
+	if cfg.SessionExpiryGrace > 0 {
+		sess.SetExpiryGrace(cfg.SessionExpiryGrace)
+	}
+	if cfg.SessionRefreshWindow > 0 {
+		sess.SetRefreshWindow(cfg.SessionRefreshWindow)
+	}
+	if cfg.SessionIdleTTL > 0 {
+		sess.SetIdleTTL(cfg.SessionIdleTTL)
+	}
+	if cfg.MaxSessionsPerUser > 0 {
+		sess.SetMaxSessionsPerUser(cfg.MaxSessionsPerUser)
+	}
 	sess.StartCleanup()
 
 	srv := server.New(db, sess, cfg, oauthClient)
@@ -95,7 +193,7 @@ func main() {
 
 	sess.StopCleanup()
 
-	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		slog.Error("shutdown error", "error", err)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// traefikConfig is the part of a Traefik dynamic configuration the importer
+// reads: the HTTP routers.
+type traefikConfig struct {
+	HTTP struct {
+		Routers map[string]traefikRouter `yaml:"routers" toml:"routers"`
+	} `yaml:"http" toml:"http"`
+}
+
+type traefikRouter struct {
+	Rule        string   `yaml:"rule" toml:"rule"`
+	Middlewares []string `yaml:"middlewares" toml:"middlewares"`
+}
+
+// hostRule matches the first host of a router rule: Host(`a`), or the
+// v2 form Host(`a`, `b`). HostRegexp doesn't match.
+var hostRule = regexp.MustCompile("Host\\(\\s*`([^`]+)`")
+
+// pathRule matches the path matchers (Path, PathPrefix, PathRegexp). A
+// service covers its whole host, so a path-scoped router can't be imported
+// without widening it.
+var pathRule = regexp.MustCompile(`\bPath(Prefix|Regexp)?\(`)
+
+// invalidSlugChars are replaced when turning a router name into a slug.
+var invalidSlugChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// importedService is a service derived from one Traefik router. When skip
+// is set the router can't be imported and skip says why.
+type importedService struct {
+	router string
+	slug   string
+	host   string
+	skip   string
+}
+
+// runImportTraefik creates noknok services from the routers in a Traefik
+// dynamic configuration file that use noknok's forwardAuth middleware, so
+// services already routed by Traefik don't have to be entered twice. Each
+// router's first Host rule becomes the service URL and its name the slug.
+// Routers whose slug or host is already taken are skipped, never updated,
+// as are path-scoped routers. It returns the process exit code.
+func runImportTraefik(args []string) int {
+	fs := flag.NewFlagSet("import-traefik", flag.ContinueOnError)
+	middleware := fs.String("middleware", "noknok-auth", "import routers that use this middleware")
+	scheme := fs.String("scheme", "https", "URL scheme of the imported services")
+	dryRun := fs.Bool("dry-run", false, "print what would be imported without writing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: noknok import-traefik [flags] <dynamic-config.yml|.toml>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *scheme != "http" && *scheme != "https" {
+		fmt.Fprintln(os.Stderr, "-scheme must be http or https")
+		return 2
+	}
+
+	candidates, err := readTraefikRouters(fs.Arg(0), *middleware)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(candidates) == 0 {
+		fmt.Printf("no routers with a Host rule use middleware %q\n", *middleware)
+		return 0
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
+	defer cancel()
+	db, err := database.Open(ctx, cfg.DSN(), 1, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	existing, err := db.ListServices(ctx, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "list services:", err)
+		return 1
+	}
+	slugs := make(map[string]bool)
+	hosts := make(map[string]string)
+	for _, svc := range existing {
+		slugs[svc.Slug] = true
+		if h := database.ServiceHost(svc.URL); h != "" {
+			hosts[h] = svc.Slug
+		}
+	}
+
+	created := 0
+	for _, c := range candidates {
+		url := *scheme + "://" + c.host
+		switch {
+		case c.skip != "":
+			fmt.Printf("SKIP    %-20s %s\n", c.slug, c.skip)
+			continue
+		case slugs[c.slug]:
+			fmt.Printf("SKIP    %-20s slug already exists\n", c.slug)
+			continue
+		case hosts[c.host] != "":
+			fmt.Printf("SKIP    %-20s host %s already used by %s\n", c.slug, c.host, hosts[c.host])
+			continue
+		case cfg.IsPublicHost(url):
+			fmt.Printf("SKIP    %-20s %s is noknok's own host\n", c.slug, c.host)
+			continue
+		}
+		slugs[c.slug] = true
+		hosts[c.host] = c.slug
+		if *dryRun {
+			fmt.Printf("CREATE  %-20s %s (router %s)\n", c.slug, url, c.router)
+			created++
+			continue
+		}
+		_, err := db.CreateService(ctx, database.Service{Slug: c.slug, Name: c.router, URL: url})
+		if err != nil {
+			fmt.Printf("FAIL    %-20s %v\n", c.slug, err)
+			continue
+		}
+		fmt.Printf("CREATE  %-20s %s (router %s)\n", c.slug, url, c.router)
+		created++
+	}
+
+	if *dryRun {
+		fmt.Printf("%d service(s) would be created (dry run)\n", created)
+		return 0
+	}
+	if created > 0 {
+		if err := db.GrantOwnerAllServices(ctx, cfg.OwnerDID); err != nil {
+			fmt.Fprintln(os.Stderr, "grant owner services:", err)
+			return 1
+		}
+	}
+	fmt.Printf("%d service(s) created\n", created)
+	return 0
+}
+
+// readTraefikRouters parses a Traefik dynamic configuration, TOML when the
+// file ends in .toml and YAML (or JSON) otherwise, and returns a service for
+// each router that uses middleware and has a Host rule, sorted by router
+// name. When several routers share a host (e.g. an http router that only
+// redirects), the first by name wins. Routers that also match on a path are
+// returned with skip set and don't claim their host.
+func readTraefikRouters(path, middleware string) ([]importedService, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tc traefikConfig
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &tc)
+	} else {
+		err = yaml.Unmarshal(data, &tc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(tc.HTTP.Routers))
+	for name := range tc.HTTP.Routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []importedService
+	seen := make(map[string]bool)
+	for _, name := range names {
+		r := tc.HTTP.Routers[name]
+		if !usesMiddleware(r.Middlewares, middleware) {
+			continue
+		}
+		m := hostRule.FindStringSubmatch(r.Rule)
+		if m == nil {
+			continue
+		}
+		host := strings.ToLower(strings.TrimSpace(m[1]))
+		router, _, _ := strings.Cut(name, "@")
+		slug := strings.Trim(invalidSlugChars.ReplaceAllString(strings.ToLower(router), "-"), "-")
+		if slug == "" || seen[host] {
+			continue
+		}
+		if pathRule.MatchString(r.Rule) {
+			out = append(out, importedService{router: router, slug: slug, host: host,
+				skip: "rule is path-scoped; a service covers all of " + host})
+			continue
+		}
+		seen[host] = true
+		out = append(out, importedService{router: router, slug: slug, host: host})
+	}
+	return out, nil
+}
+
+// usesMiddleware reports whether a router's middleware list includes name,
+// with or without a provider suffix (noknok-auth@docker).
+func usesMiddleware(middlewares []string, name string) bool {
+	for _, m := range middlewares {
+		if m == name || strings.HasPrefix(m, name+"@") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadTraefikRouters(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		file   string
+		config string
+		want   []importedService
+	}{
+		{
+			name: "yaml",
+			file: "dynamic.yml",
+			config: "http:\n  routers:\n" +
+				"    wiki:\n      rule: \"Host(`Wiki.example.com`)\"\n      middlewares: [noknok-auth@file]\n" +
+				"    blog:\n      rule: \"Host(`blog.example.com`)\"\n      middlewares: [other]\n",
+			want: []importedService{{router: "wiki", slug: "wiki", host: "wiki.example.com"}},
+		},
+		{
+			name: "toml",
+			file: "dynamic.toml",
+			config: "[http.routers.wiki]\n  rule = \"Host(`wiki.example.com`)\"\n  middlewares = [\"noknok-auth\"]\n" +
+				"[http.routers.Git_Web]\n  rule = \"Host(`git.example.com`, `git2.example.com`)\"\n  middlewares = [\"noknok-auth\"]\n",
+			want: []importedService{
+				{router: "Git_Web", slug: "git-web", host: "git.example.com"},
+				{router: "wiki", slug: "wiki", host: "wiki.example.com"},
+			},
+		},
+		{
+			name: "shared host keeps the first router",
+			file: "dynamic.yml",
+			config: "http:\n  routers:\n" +
+				"    app-http:\n      rule: \"Host(`app.example.com`)\"\n      middlewares: [noknok-auth]\n" +
+				"    app:\n      rule: \"Host(`app.example.com`)\"\n      middlewares: [noknok-auth]\n",
+			want: []importedService{{router: "app", slug: "app", host: "app.example.com"}},
+		},
+		{
+			name: "path-scoped routers are skipped and don't claim the host",
+			file: "dynamic.yml",
+			config: "http:\n  routers:\n" +
+				"    api:\n      rule: \"Host(`app.example.com`) && PathPrefix(`/api`)\"\n      middlewares: [noknok-auth]\n" +
+				"    app:\n      rule: \"Host(`app.example.com`)\"\n      middlewares: [noknok-auth]\n",
+			want: []importedService{
+				{router: "api", slug: "api", host: "app.example.com", skip: "rule is path-scoped; a service covers all of app.example.com"},
+				{router: "app", slug: "app", host: "app.example.com"},
+			},
+		},
+		{
+			name: "no host rule",
+			file: "dynamic.yml",
+			config: "http:\n  routers:\n" +
+				"    any:\n      rule: \"HostRegexp(`.+`)\"\n      middlewares: [noknok-auth]\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readTraefikRouters(path, "noknok-auth")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package atproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrDirectoryUnavailable is returned while the identity directory circuit
+// breaker is open: recent lookups kept failing, so new ones fail fast
+// instead of waiting on an unreachable PLC directory or DNS.
+var ErrDirectoryUnavailable = errors.New("identity service unavailable")
+
+const (
+	// breakerThreshold is how many consecutive failed lookups open the
+	// breaker.
+	breakerThreshold = 5
+	// breakerCooldown is how long the breaker stays open before letting a
+	// single trial lookup through.
+	breakerCooldown = 30 * time.Second
+	// lookupRetryDelay is the pause before the one retry of a failed lookup.
+	lookupRetryDelay = 250 * time.Millisecond
+	// coalesceWindow is how long after a handle lookup starts that new
+	// lookups for the same handle still join it instead of starting their
+	// own; later callers get a fresh answer even if it is still running.
+	coalesceWindow = 2 * time.Second
+	// sharedLookupTimeout bounds a coalesced lookup, which runs detached
+	// from any one caller's context.
+	sharedLookupTimeout = 15 * time.Second
+)
+
+// Breaker states, as reported by OAuthClient.DirectoryState.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breakerDirectory wraps an identity.Directory with one retry and a circuit
+// breaker. Only resolution failures (network errors, PLC or DNS errors,
+// timeouts) count; a handle or DID that doesn't exist is an answer, not an
+// outage, and is returned as is.
+type breakerDirectory struct {
+	inner  identity.Directory
+	clock  clock
+	flight singleflight.Group // concurrent LookupHandle calls, by normalized handle
+
+	mu        sync.Mutex
+	failures  int       // consecutive failed lookups
+	openUntil time.Time // zero while closed
+	trial     bool      // a half-open trial lookup is in flight
+}
+
+func newBreakerDirectory(inner identity.Directory) *breakerDirectory {
+	return &breakerDirectory{inner: inner, clock: realClock{}}
+}
+
+// clock is the breaker's time source, so tests can move time by hand.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func())    { time.AfterFunc(d, f) }
+
+// LookupHandle coalesces concurrent lookups of the same handle (a burst of
+// logins, an admin bulk add) into one directory lookup, counted once by the
+// breaker. The shared lookup doesn't inherit a caller's cancellation, so one
+// caller giving up doesn't fail the others; each still returns as soon as
+// its own context ends.
+func (d *breakerDirectory) LookupHandle(ctx context.Context, handle syntax.Handle) (*identity.Identity, error) {
+	key := handle.Normalize().String()
+	ch := d.flight.DoChan(key, func() (any, error) {
+		d.clock.AfterFunc(coalesceWindow, func() { d.flight.Forget(key) })
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLookupTimeout)
+		defer cancel()
+		return d.lookup(ctx, handle.AtIdentifier(), func() (*identity.Identity, error) {
+			return d.inner.LookupHandle(ctx, handle)
+		})
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*identity.Identity), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *breakerDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	return d.lookup(ctx, did.AtIdentifier(), func() (*identity.Identity, error) {
+		return d.inner.LookupDID(ctx, did)
+	})
+}
+
+func (d *breakerDirectory) Lookup(ctx context.Context, atid syntax.AtIdentifier) (*identity.Identity, error) {
+	return d.lookup(ctx, atid, func() (*identity.Identity, error) {
+		return d.inner.Lookup(ctx, atid)
+	})
+}
+
+func (d *breakerDirectory) Purge(ctx context.Context, atid syntax.AtIdentifier) error {
+	return d.inner.Purge(ctx, atid)
+}
+
+// lookup runs fn through the breaker, retrying once on a resolution
+// failure. The inner cache remembers errors, so it is purged before the
+// retry.
+func (d *breakerDirectory) lookup(ctx context.Context, atid syntax.AtIdentifier, fn func() (*identity.Identity, error)) (*identity.Identity, error) {
+	if !d.allow() {
+		return nil, ErrDirectoryUnavailable
+	}
+	ident, err := fn()
+	if err != nil && transientLookupError(err) && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-d.clock.After(lookupRetryDelay):
+			_ = d.inner.Purge(ctx, atid)
+			ident, err = fn()
+		}
+	}
+	d.record(err)
+	if err != nil && transientLookupError(err) && d.State() == BreakerOpen {
+		return nil, fmt.Errorf("%w: %w", ErrDirectoryUnavailable, err)
+	}
+	return ident, err
+}
+
+// allow reports whether a lookup may proceed. Once the cooldown has passed,
+// exactly one trial lookup is let through until it reports back.
+func (d *breakerDirectory) allow() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.openUntil.IsZero() {
+		return true
+	}
+	if d.clock.Now().Before(d.openUntil) || d.trial {
+		return false
+	}
+	d.trial = true
+	return true
+}
+
+// record notes a lookup's outcome: an answer closes the breaker; a
+// resolution failure that reaches the threshold, or a failed trial, opens it
+// for a cooldown. A lookup the caller cancelled says nothing either way.
+func (d *breakerDirectory) record(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wasTrial := d.trial
+	d.trial = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil || !transientLookupError(err) {
+		if !d.openUntil.IsZero() {
+			slog.Info("identity directory recovered; circuit breaker closed")
+		}
+		d.failures = 0
+		d.openUntil = time.Time{}
+		return
+	}
+	d.failures++
+	if wasTrial || d.failures >= breakerThreshold {
+		if d.openUntil.IsZero() || wasTrial {
+			slog.Warn("identity directory failing; circuit breaker open", "failures", d.failures, "cooldown", breakerCooldown)
+		}
+		d.openUntil = d.clock.Now().Add(breakerCooldown)
+	}
+}
+
+// State returns the breaker state: closed, open, or half-open (cooldown
+// over, the next lookup is a trial).
+func (d *breakerDirectory) State() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.openUntil.IsZero():
+		return BreakerClosed
+	case d.clock.Now().Before(d.openUntil):
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
+
+// transientLookupError reports whether err means the directory couldn't be
+// reached or answered badly, as opposed to a definitive "not found".
+func transientLookupError(err error) bool {
+	return errors.Is(err, identity.ErrDIDResolutionFailed) ||
+		errors.Is(err, identity.ErrHandleResolutionFailed) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,266 @@
+package atproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// fakeClock moves only when told to. Waiting on After moves it forward at
+// once, so retries don't slow tests down.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), f})
+}
+
+// Advance moves the clock forward and runs the timers that came due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []func()
+	kept := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			kept = append(kept, t)
+		} else {
+			due = append(due, t.f)
+		}
+	}
+	c.timers = kept
+	c.mu.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
+
+// fakeDirectory answers every lookup with lookup.
+type fakeDirectory struct {
+	calls  atomic.Int64
+	lookup func(ctx context.Context) (*identity.Identity, error)
+}
+
+func (f *fakeDirectory) do(ctx context.Context) (*identity.Identity, error) {
+	f.calls.Add(1)
+	return f.lookup(ctx)
+}
+
+func (f *fakeDirectory) LookupHandle(ctx context.Context, _ syntax.Handle) (*identity.Identity, error) {
+	return f.do(ctx)
+}
+
+func (f *fakeDirectory) LookupDID(ctx context.Context, _ syntax.DID) (*identity.Identity, error) {
+	return f.do(ctx)
+}
+
+func (f *fakeDirectory) Lookup(ctx context.Context, _ syntax.AtIdentifier) (*identity.Identity, error) {
+	return f.do(ctx)
+}
+
+func (f *fakeDirectory) Purge(context.Context, syntax.AtIdentifier) error { return nil }
+
+func newTestBreaker(inner *fakeDirectory) (*breakerDirectory, *fakeClock) {
+	clk := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	d := newBreakerDirectory(inner)
+	d.clock = clk
+	return d, clk
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	ident := &identity.Identity{DID: "did:plc:test"}
+	var fail atomic.Bool
+	inner := &fakeDirectory{lookup: func(context.Context) (*identity.Identity, error) {
+		if fail.Load() {
+			return nil, fmt.Errorf("%w: connection refused", identity.ErrDIDResolutionFailed)
+		}
+		return ident, nil
+	}}
+	d, clk := newTestBreaker(inner)
+	ctx := context.Background()
+	lookup := func() error {
+		_, err := d.LookupDID(ctx, "did:plc:test")
+		return err
+	}
+
+	// Failures below the threshold are returned as they are, after a retry.
+	fail.Store(true)
+	for i := 1; i < breakerThreshold; i++ {
+		if err := lookup(); err == nil || errors.Is(err, ErrDirectoryUnavailable) {
+			t.Fatalf("failure %d: err %v, want the directory's error", i, err)
+		}
+		if got := d.State(); got != BreakerClosed {
+			t.Fatalf("after %d failures: state %s, want closed", i, got)
+		}
+	}
+	if got := inner.calls.Load(); got != 2*(breakerThreshold-1) {
+		t.Errorf("directory called %d times, want %d (one retry each)", got, 2*(breakerThreshold-1))
+	}
+
+	// The threshold opens the breaker; lookups then fail fast.
+	if err := lookup(); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Fatalf("failure at threshold: err %v, want ErrDirectoryUnavailable", err)
+	}
+	if got := d.State(); got != BreakerOpen {
+		t.Fatalf("state %s, want open", got)
+	}
+	calls := inner.calls.Load()
+	if err := lookup(); !errors.Is(err, ErrDirectoryUnavailable) || inner.calls.Load() != calls {
+		t.Fatalf("open breaker: err %v after %d directory calls, want a fast ErrDirectoryUnavailable", err, inner.calls.Load()-calls)
+	}
+
+	// After the cooldown one trial goes through; a failed trial reopens it.
+	clk.Advance(breakerCooldown)
+	if got := d.State(); got != BreakerHalfOpen {
+		t.Fatalf("after cooldown: state %s, want half-open", got)
+	}
+	if err := lookup(); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Fatalf("failed trial: err %v, want ErrDirectoryUnavailable", err)
+	}
+	if got := d.State(); got != BreakerOpen {
+		t.Fatalf("after failed trial: state %s, want open", got)
+	}
+
+	// A successful trial closes it.
+	clk.Advance(breakerCooldown)
+	fail.Store(false)
+	if err := lookup(); err != nil {
+		t.Fatalf("successful trial: %v", err)
+	}
+	if got := d.State(); got != BreakerClosed {
+		t.Fatalf("after successful trial: state %s, want closed", got)
+	}
+}
+
+// While the half-open trial is in flight, other lookups fail fast.
+func TestBreakerSingleTrial(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var block atomic.Bool
+	inner := &fakeDirectory{lookup: func(context.Context) (*identity.Identity, error) {
+		if block.Load() {
+			started <- struct{}{}
+			<-release
+			return &identity.Identity{}, nil
+		}
+		return nil, fmt.Errorf("%w: timeout", identity.ErrDIDResolutionFailed)
+	}}
+	d, clk := newTestBreaker(inner)
+	ctx := context.Background()
+	for range breakerThreshold {
+		d.LookupDID(ctx, "did:plc:test")
+	}
+	clk.Advance(breakerCooldown)
+
+	block.Store(true)
+	trial := make(chan error, 1)
+	go func() {
+		_, err := d.LookupDID(ctx, "did:plc:test")
+		trial <- err
+	}()
+	<-started
+	if _, err := d.LookupDID(ctx, "did:plc:test"); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Errorf("lookup during trial: err %v, want ErrDirectoryUnavailable", err)
+	}
+	close(release)
+	if err := <-trial; err != nil {
+		t.Fatalf("trial: %v", err)
+	}
+	if got := d.State(); got != BreakerClosed {
+		t.Errorf("state %s, want closed", got)
+	}
+}
+
+// A handle or DID that doesn't exist is an answer and never opens the
+// breaker.
+func TestBreakerIgnoresNotFound(t *testing.T) {
+	inner := &fakeDirectory{lookup: func(context.Context) (*identity.Identity, error) {
+		return nil, identity.ErrDIDNotFound
+	}}
+	d, _ := newTestBreaker(inner)
+	for range 2 * breakerThreshold {
+		if _, err := d.LookupDID(context.Background(), "did:plc:gone"); !errors.Is(err, identity.ErrDIDNotFound) {
+			t.Fatalf("err %v, want ErrDIDNotFound", err)
+		}
+	}
+	if got := d.State(); got != BreakerClosed {
+		t.Errorf("state %s, want closed", got)
+	}
+	if got := inner.calls.Load(); got != 2*breakerThreshold {
+		t.Errorf("directory called %d times, want %d (no retries)", got, 2*breakerThreshold)
+	}
+}
+
+// Handle lookups within the coalescing window share one directory lookup,
+// which outlives a caller that gives up; later ones start a fresh lookup.
+func TestLookupHandleCoalescing(t *testing.T) {
+	started := make(chan context.Context, 4)
+	release := make(chan struct{})
+	inner := &fakeDirectory{lookup: func(ctx context.Context) (*identity.Identity, error) {
+		started <- ctx
+		<-release
+		return &identity.Identity{Handle: "alice.test"}, nil
+	}}
+	d, clk := newTestBreaker(inner)
+	results := make(chan error, 3)
+	lookup := func(ctx context.Context) {
+		_, err := d.LookupHandle(ctx, "Alice.test")
+		results <- err
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	go lookup(first)
+	shared := <-started
+	go lookup(context.Background()) // joins the lookup in flight
+
+	cancelFirst()
+	if err := <-results; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller: err %v, want context.Canceled", err)
+	}
+	if shared.Err() != nil {
+		t.Fatal("cancelling one caller cancelled the shared lookup")
+	}
+
+	clk.Advance(coalesceWindow)
+	go lookup(context.Background())
+	<-started // past the window: a lookup of its own
+
+	close(release)
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Errorf("lookup: %v", err)
+		}
+	}
+	if got := inner.calls.Load(); got != 2 {
+		t.Errorf("directory called %d times, want 2", got)
+	}
+}
@@ -2,7 +2,10 @@ package atproto
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 
@@ -13,14 +16,49 @@ import (
 
 // OAuthClient wraps the indigo OAuth ClientApp for AT Protocol login.
 type OAuthClient struct {
-	app *oauth.ClientApp
-	cfg *oauth.ClientConfig
+	app       *oauth.ClientApp
+	cfg       *oauth.ClientConfig
+	dir       *breakerDirectory
+	publicURL string
+	paths     OAuthPaths
+}
+
+// OAuthPaths are the paths, relative to the public URL, at which noknok
+// serves its OAuth endpoints. The same values build the URLs advertised to
+// the authorization server and register the routes, so they can't drift.
+type OAuthPaths struct {
+	Metadata string // client metadata document; its URL is the client ID
+	Callback string // redirect URI
+	JWKS     string // public key set
+}
+
+// Validate checks that each path is an absolute path without query or
+// fragment, and that no two paths collide.
+func (p OAuthPaths) Validate() error {
+	named := []struct{ name, path string }{
+		{"metadata", p.Metadata}, {"callback", p.Callback}, {"jwks", p.JWKS},
+	}
+	seen := make(map[string]string)
+	for _, n := range named {
+		if !strings.HasPrefix(n.path, "/") || strings.ContainsAny(n.path, "?#") {
+			return fmt.Errorf("OAuth %s path %q must start with / and have no query or fragment", n.name, n.path)
+		}
+		if other, dup := seen[n.path]; dup {
+			return fmt.Errorf("OAuth %s and %s paths are both %q", other, n.name, n.path)
+		}
+		seen[n.path] = n.name
+	}
+	return nil
 }
 
 // NewOAuthClient creates an OAuth client configured as a confidential web app.
-func NewOAuthClient(publicURL, privateKeyMultibase string, store oauth.ClientAuthStore) (*OAuthClient, error) {
-	clientID := publicURL + "/.well-known/oauth-client-metadata"
-	callbackURL := publicURL + "/oauth/callback"
+func NewOAuthClient(publicURL string, paths OAuthPaths, privateKeyMultibase string, store oauth.ClientAuthStore) (*OAuthClient, error) {
+	if err := paths.Validate(); err != nil {
+		return nil, err
+	}
+	publicURL = strings.TrimSuffix(publicURL, "/")
+	clientID := publicURL + paths.Metadata
+	callbackURL := publicURL + paths.Callback
 
 	cfg := oauth.NewPublicConfig(clientID, callbackURL, []string{"atproto"})
 	cfg.UserAgent = "noknok/0.4.0"
@@ -34,11 +72,20 @@ func NewOAuthClient(publicURL, privateKeyMultibase string, store oauth.ClientAut
 	}
 
 	app := oauth.NewClientApp(&cfg, store)
-	return &OAuthClient{app: app, cfg: &cfg}, nil
+	dir := newBreakerDirectory(app.Dir)
+	app.Dir = dir
+	return &OAuthClient{app: app, cfg: &cfg, dir: dir, publicURL: publicURL, paths: paths}, nil
+}
+
+// Paths returns the endpoint paths the server must register.
+func (c *OAuthClient) Paths() OAuthPaths {
+	return c.paths
 }
 
 // StartLogin begins the OAuth flow for the given handle, returning the
-// authorization URL the user should be redirected to.
+// authorization URL the user should be redirected to. The handle goes to
+// the authorization server as the PAR login_hint, so its sign-in page opens
+// with the account already filled in.
 func (c *OAuthClient) StartLogin(ctx context.Context, handle string) (string, error) {
 	return c.app.StartAuthFlow(ctx, handle)
 }
@@ -59,11 +106,66 @@ func (c *OAuthClient) HandleCallback(ctx context.Context, params url.Values) (st
 	return sess.AccountDID.String(), ident.Handle.String(), nil
 }
 
+// Revalidate refreshes the tokens of a stored OAuth session to check that
+// the authorization server still honors it. revoked is true only when the
+// server rejects the refresh (e.g. the user revoked noknok at their PDS);
+// the stored session is then deleted. Network and server errors return
+// revoked=false with the error, so an unreachable PDS never ends sessions.
+func (c *OAuthClient) Revalidate(ctx context.Context, did, sessionID string) (revoked bool, err error) {
+	accountDID, err := syntax.ParseDID(did)
+	if err != nil {
+		return false, err
+	}
+	sess, err := c.app.ResumeSession(ctx, accountDID, sessionID)
+	if err != nil {
+		return false, err
+	}
+	// indigo doesn't type token endpoint rejections, so read the status off
+	// the wire. It retries once on a DPoP nonce challenge; the last response
+	// is the one that counts.
+	rec := &statusRecorder{base: http.DefaultTransport}
+	client := http.Client{Transport: rec}
+	if sess.Client != nil {
+		client = *sess.Client
+		if client.Transport != nil {
+			rec.base = client.Transport
+		}
+		client.Transport = rec
+	}
+	sess.Client = &client
+	if _, err := sess.RefreshTokens(ctx); err != nil {
+		if rec.status == http.StatusBadRequest || rec.status == http.StatusUnauthorized {
+			if derr := c.app.Store.DeleteSession(ctx, accountDID, sessionID); derr != nil {
+				return true, derr
+			}
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// statusRecorder is an http.RoundTripper that remembers the status of the
+// last response it carried.
+type statusRecorder struct {
+	base   http.RoundTripper
+	status int
+}
+
+func (r *statusRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	r.status = 0
+	if err == nil {
+		r.status = resp.StatusCode
+	}
+	return resp, err
+}
+
 // ClientMetadata returns the OAuth client metadata document.
 func (c *OAuthClient) ClientMetadata() oauth.ClientMetadata {
 	m := c.cfg.ClientMetadata()
 	// Confidential clients must set JWKS URI after the fact.
-	jwksURI := c.cfg.ClientID[:len(c.cfg.ClientID)-len("/.well-known/oauth-client-metadata")] + "/oauth/jwks.json"
+	jwksURI := c.publicURL + c.paths.JWKS
 	m.JWKSURI = &jwksURI
 	name := "noknok"
 	m.ClientName = &name
@@ -75,6 +177,49 @@ func (c *OAuthClient) PublicJWKS() oauth.JWKS {
 	return c.cfg.PublicJWKS()
 }
 
+// SignJWT signs claims as a compact ES256 JWT with the OAuth client key. The
+// header carries the key ID, so holders can verify it against PublicJWKS.
+func (c *OAuthClient) SignJWT(claims any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": *c.cfg.KeyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal claims: %w", err)
+	}
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	sig, err := c.cfg.PrivateKey.HashAndSign([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("sign JWT: %w", err)
+	}
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
+
+// DirectoryState reports the identity directory circuit breaker: closed,
+// open, or half-open.
+func (c *OAuthClient) DirectoryState() string {
+	return c.dir.State()
+}
+
+// ResolveDID returns the current handle for a DID, as declared in its DID
+// document and verified against the handle's own resolution.
+func (c *OAuthClient) ResolveDID(ctx context.Context, did string) (string, error) {
+	d, err := syntax.ParseDID(did)
+	if err != nil {
+		return "", fmt.Errorf("invalid DID: %w", err)
+	}
+	ident, err := c.app.Dir.LookupDID(ctx, d)
+	if err != nil {
+		return "", fmt.Errorf("resolve DID %s: %w", did, err)
+	}
+	if ident.Handle.IsInvalidHandle() {
+		return "", fmt.Errorf("resolve DID %s: handle does not verify", did)
+	}
+	return ident.Handle.String(), nil
+}
+
 // ResolveHandle resolves a handle to a DID and canonical handle.
 // Bare names (no dot) default to .bsky.social.
 func (c *OAuthClient) ResolveHandle(ctx context.Context, handle string) (string, string, error) {
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const Version = "0.5.0"
@@ -19,30 +25,149 @@ type Config struct {
 	DBSSLMode  string
 	ListenAddr string
 
+	StartupTimeout  time.Duration // bound on each startup step: DB bootstrap, seeding (STARTUP_TIMEOUT)
+	ShutdownTimeout time.Duration // grace period for in-flight requests on shutdown (SHUTDOWN_TIMEOUT)
+
+	DBConnectAttempts int           // tries before giving up on the database at startup (DB_CONNECT_ATTEMPTS)
+	DBConnectInterval time.Duration // wait between tries (DB_CONNECT_INTERVAL)
+
+	DBReplicaDSN    string        // optional read replica for read-only queries (DB_REPLICA_DSN)
+	DBReplicaMaxLag time.Duration // how stale replica reads may be (DB_REPLICA_MAX_LAG)
+
 	OAuthPrivateKey string // multibase-encoded ES256 private key
 	SessionTTL      string // duration string, e.g. "24h"
-	OwnerDID       string
-	OwnerUsername  string
-	CookieDomain   string   // primary cookie domain (first entry)
-	CookieDomains  []string // all cookie domains (parsed from COOKIE_DOMAINS)
-	PublicURL      string
+	OwnerDID        string
+	OwnerUsername   string
+	CookieDomain    string   // primary cookie domain (first entry)
+	CookieDomains   []string // all cookie domains (parsed from COOKIE_DOMAINS)
+	CookiePath      string   // Path attribute on every cookie (COOKIE_PATH)
+	CookiePrefix    string   // session cookie name prefix: "", __Host-, or __Secure- (COOKIE_PREFIX)
+	PublicURL       string
+
+	OAuthMetadataPath string // client metadata path under PublicURL (OAUTH_METADATA_PATH)
+	OAuthCallbackPath string // redirect URI path under PublicURL (OAUTH_CALLBACK_PATH)
+	OAuthJWKSPath     string // JWKS path under PublicURL (OAUTH_JWKS_PATH)
+
+	UsageTracking bool // record aggregate service click counts (USAGE_TRACKING)
+	UsagePerUser  bool // also attribute clicks to users (USAGE_PER_USER)
+
+	HealthTLSMinVersion    uint16        // minimum TLS version for health probes (HEALTH_TLS_MIN_VERSION)
+	HealthFollowRedirects  int           // redirects health probes follow before judging status; 0 judges the first response
+	HealthPrewarmTimeout   time.Duration // max wait for a health check before listening (HEALTH_PREWARM_TIMEOUT); 0 skips
+	HealthUserAgent        string        // User-Agent on health probes (HEALTH_USER_AGENT)
+	HealthPollInterval     time.Duration // how often the background poller checks services (HEALTH_POLL_INTERVAL)
+	HealthHistorySize      int           // poller samples kept in memory per service (HEALTH_HISTORY_SIZE); 0 keeps none
+	HealthHistoryRetention time.Duration // how long samples are also kept in health_history (HEALTH_HISTORY_RETENTION); 0 doesn't persist them
+
+	RevokeSessionsOnDowngrade bool // log a user out everywhere when their role is lowered
+	GrantRoleCap              bool // refuse grant roles above the user's global role (GRANT_ROLE_CAP)
+	UniqueServiceHosts        bool // refuse a service URL whose host another service already uses (UNIQUE_SERVICE_HOSTS)
+	SessionRotate             bool // issue a fresh session token on each use (SESSION_ROTATE)
+	CookiePartitioned         bool // Partitioned + SameSite=None session cookies for cross-site embeds (COOKIE_PARTITIONED)
+	AuditFailedLogins         bool // record refused sign-ins in the audit log as login.failed (AUDIT_FAILED_LOGINS)
+	WelcomePage               bool // show a welcome page after a user's first sign-in (WELCOME_PAGE)
+	RequireHTTPS              bool // refuse to start with a plain-http PUBLIC_URL (REQUIRE_HTTPS)
+	AllowInsecureLocalhost    bool // let REQUIRE_HTTPS accept an http PUBLIC_URL on a loopback host (ALLOW_INSECURE_LOCALHOST)
+
+	OAuthRevalidateInterval time.Duration // how often to re-check OAuth sessions upstream; 0 disables
+	AccessLogRetention      time.Duration // how long to keep per-service forwardAuth decisions; 0 doesn't record them
+	ExpiredGrantRetention   time.Duration // how long expired grants stay before the sweep deletes them; 0 keeps them
+	SessionGroupMaxAge      time.Duration // lifetime cap on a browser's identity group from its first sign-in; 0 disables
+	SessionExpiryGrace      time.Duration // how long after expiry the portal re-signs a session in as the same identity; 0 disables
+	SessionRefreshWindow    time.Duration // extend a session used with less than this left to the full SESSION_TTL; 0 disables
+	SessionIdleTTL          time.Duration // sessions unused for longer stop validating, before SESSION_TTL; 0 disables
+	MaxSessionsPerUser      int           // browsers (session groups) a user can be signed in on at once; 0 = unlimited
+	ServiceHostCacheTTL     time.Duration // how long forwardAuth caches host → service lookups; 0 disables
+
+	ValidateAPIToken  string // bearer token POST /api/validate requires; empty leaves it open (VALIDATE_API_TOKEN)
+	MetricsToken      string // bearer token GET /metrics requires; empty disables the endpoint (METRICS_TOKEN)
+	ValidateRateLimit int    // POST /api/validate requests per second per client IP; 0 disables (VALIDATE_RATE_LIMIT)
+
+	LoginEventRetention time.Duration // how long to keep the sign-in trail (login_events); 0 doesn't record it
+	LoginDenyLimit      int           // refused sign-ins from one IP within LoginDenyWindow before it is turned away; 0 disables
+	LoginDenyWindow     time.Duration // LOGIN_DENY_WINDOW
+
+	BulkResolveConcurrency int           // handles a bulk user import resolves at once (BULK_RESOLVE_CONCURRENCY)
+	OAuthRevalidateWorkers int           // OAuth sessions a revalidation run refreshes at once (OAUTH_REVALIDATE_CONCURRENCY)
+	BulkResolveTimeout     time.Duration // limit on resolving one handle during a bulk import (BULK_RESOLVE_TIMEOUT)
+
+	UserDisabledServices string // how non-admins see granted services that are disabled: show, grey, hide
+
+	SignupMode           string // what happens when a DID without a user signs in: closed, open, or approval (SIGNUP_MODE)
+	AccessRequestWebhook string // URL POSTed a JSON notice for each new access request; empty only logs them (ACCESS_REQUEST_WEBHOOK)
+	WebhookSecret        string // key webhook bodies are signed with (HMAC-SHA256 in X-Noknok-Signature); empty sends them unsigned (ACCESS_REQUEST_WEBHOOK_SECRET)
+
+	PublicDownServices string // how anonymous pages show public services failing health checks: show, dim, hide
+
+	PortalReloadAfter time.Duration // reload portal on focus after being hidden this long; 0 disables
+	PortalIdleLogout  time.Duration // log the portal out after this long without interaction; 0 disables
+	PortalStatusPoll  time.Duration // how often an open portal refreshes card status (PORTAL_STATUS_POLL)
+	PortalTabClaim    time.Duration // how long a new portal tab waits for an existing one before it becomes the primary (PORTAL_TAB_CLAIM)
+
+	// Clicks on portal cards that can't be opened get a toast instead of
+	// nothing. {name} in the messages is replaced with the service name.
+	PortalDownClick       string // unreachable services: block, confirm (offer "Open anyway"), or open (PORTAL_DOWN_CLICK)
+	PortalDownMessage     string // PORTAL_DOWN_MESSAGE
+	PortalDisabledMessage string // PORTAL_DISABLED_MESSAGE
+
+	// Traffic-light dot colors (STATUS_COLOR_RED, _YELLOW, _GREEN).
+	StatusColorRed    string
+	StatusColorYellow string
+	StatusColorGreen  string
+
+	BrandName       string // display name in page titles and headers (BRAND_NAME)
+	BrandLogoURL    string // optional logo image URL (BRAND_LOGO_URL)
+	Theme           string // page palette: dark or high-contrast (THEME)
+	FaviconFile     string // icon file served at /favicon.ico (FAVICON_FILE)
+	PageTitleFormat string // browser tab titles; {brand} and {page} are replaced (PAGE_TITLE_FORMAT)
+	WelcomeMessage  string // text of the first sign-in welcome page (WELCOME_MESSAGE)
+
+	TrustedProxies []*net.IPNet // peers whose X-Forwarded-* headers are honored (TRUSTED_PROXIES)
+
+	LogLevel    slog.Level // minimum log level (LOG_LEVEL: debug, info, warn, error)
+	LogAuthDIDs bool       // log DIDs in auth decisions in the clear instead of hashed
 }
 
 // Load reads configuration from environment variables.
 // Supports _FILE suffix for Docker secrets (e.g. DB_PASSWORD_FILE).
 func Load() (*Config, error) {
 	c := &Config{
-		DBHost:       envOrDefault("DB_HOST", "localhost"),
-		DBPort:       envOrDefault("DB_PORT", "5432"),
-		DBName:       envOrDefault("DB_NAME", "noknok"),
-		DBUser:       envOrDefault("DB_USER", "dba_noknok"),
-		DBSSLMode:    envOrDefault("DB_SSLMODE", "disable"),
-		ListenAddr:   envOrDefault("LISTEN_ADDR", ":4321"),
-		SessionTTL:   envOrDefault("SESSION_TTL", "24h"),
+		DBHost:        envOrDefault("DB_HOST", "localhost"),
+		DBPort:        envOrDefault("DB_PORT", "5432"),
+		DBName:        envOrDefault("DB_NAME", "noknok"),
+		DBUser:        envOrDefault("DB_USER", "dba_noknok"),
+		DBSSLMode:     envOrDefault("DB_SSLMODE", "disable"),
+		ListenAddr:    envOrDefault("LISTEN_ADDR", ":4321"),
+		SessionTTL:    envOrDefault("SESSION_TTL", "24h"),
 		OwnerDID:      os.Getenv("OWNER_DID"),
 		OwnerUsername: envOrDefault("OWNER_USERNAME", ""),
-		CookieDomain: envOrDefault("COOKIE_DOMAIN", ".localhost"),
+		CookieDomain:  envOrDefault("COOKIE_DOMAIN", ".localhost"),
+		CookiePath:    envOrDefault("COOKIE_PATH", "/"),
+		CookiePrefix:  os.Getenv("COOKIE_PREFIX"),
 		PublicURL:     envOrDefault("PUBLIC_URL", "http://noknok.localhost"),
+
+		OAuthMetadataPath: envOrDefault("OAUTH_METADATA_PATH", "/.well-known/oauth-client-metadata"),
+		OAuthCallbackPath: envOrDefault("OAUTH_CALLBACK_PATH", "/oauth/callback"),
+		OAuthJWKSPath:     envOrDefault("OAUTH_JWKS_PATH", "/oauth/jwks.json"),
+
+		UsageTracking: envBool("USAGE_TRACKING"),
+		UsagePerUser:  envBool("USAGE_PER_USER"),
+
+		RevokeSessionsOnDowngrade: envBool("REVOKE_SESSIONS_ON_DOWNGRADE"),
+		GrantRoleCap:              envBool("GRANT_ROLE_CAP"),
+		UniqueServiceHosts:        envBool("UNIQUE_SERVICE_HOSTS"),
+		SessionRotate:             envBool("SESSION_ROTATE"),
+		CookiePartitioned:         envBool("COOKIE_PARTITIONED"),
+		AuditFailedLogins:         envBool("AUDIT_FAILED_LOGINS"),
+		WelcomePage:               envBool("WELCOME_PAGE"),
+		RequireHTTPS:              envBool("REQUIRE_HTTPS"),
+		AllowInsecureLocalhost:    envBool("ALLOW_INSECURE_LOCALHOST"),
+
+		BrandName:       envOrDefault("BRAND_NAME", "nokNok"),
+		BrandLogoURL:    os.Getenv("BRAND_LOGO_URL"),
+		FaviconFile:     os.Getenv("FAVICON_FILE"),
+		PageTitleFormat: envOrDefault("PAGE_TITLE_FORMAT", "{brand} — {page}"),
+		WelcomeMessage:  os.Getenv("WELCOME_MESSAGE"),
 	}
 
 	// Parse COOKIE_DOMAINS (comma-separated). Falls back to single CookieDomain.
@@ -60,12 +185,285 @@ func Load() (*Config, error) {
 		c.CookieDomains = []string{c.CookieDomain}
 	}
 
+	if !strings.HasPrefix(c.CookiePath, "/") || strings.ContainsAny(c.CookiePath, ";?# \t\r\n") {
+		return nil, fmt.Errorf("COOKIE_PATH: must be an absolute path without ;, ?, #, or whitespace")
+	}
+
+	// Over plain http session cookies can't be Secure and travel in the
+	// clear; REQUIRE_HTTPS keeps a production deployment from ending up
+	// there by accident.
+	if c.RequireHTTPS && c.InsecurePublicURL() && !(c.AllowInsecureLocalhost && c.LocalPublicURL()) {
+		return nil, fmt.Errorf("REQUIRE_HTTPS: PUBLIC_URL must be https (got %s)", c.PublicURL)
+	}
+
+	// Browsers drop Partitioned (and SameSite=None) cookies that aren't
+	// Secure, which needs an https origin.
+	if c.CookiePartitioned && !strings.HasPrefix(c.PublicURL, "https://") {
+		return nil, fmt.Errorf("COOKIE_PARTITIONED requires an https PUBLIC_URL (partitioned cookies must be Secure)")
+	}
+
+	// Browsers reject prefixed cookies that break the prefix's rules:
+	// both need Secure, and __Host- also needs Path=/ and no Domain, which
+	// rules out sharing the cookie across COOKIE_DOMAINS.
+	switch c.CookiePrefix {
+	case "", "__Secure-", "__Host-":
+	default:
+		return nil, fmt.Errorf("COOKIE_PREFIX: must be __Host-, __Secure-, or empty")
+	}
+	if c.CookiePrefix != "" && !strings.HasPrefix(c.PublicURL, "https://") {
+		return nil, fmt.Errorf("COOKIE_PREFIX requires an https PUBLIC_URL (prefixed cookies must be Secure)")
+	}
+	if c.CookiePrefix == "__Host-" {
+		if c.CookiePath != "/" {
+			return nil, fmt.Errorf("COOKIE_PREFIX=__Host- requires COOKIE_PATH=/")
+		}
+		if len(c.CookieDomains) > 1 {
+			return nil, fmt.Errorf("COOKIE_PREFIX=__Host- is not supported with multiple COOKIE_DOMAINS (__Host- cookies can't set Domain)")
+		}
+		// The cookie stays on PUBLIC_URL's host, so a parent domain would
+		// promise services on other hosts a session they never receive.
+		if d := strings.TrimPrefix(c.CookieDomain, "."); d != "" && !c.IsPublicHost("https://"+d) {
+			return nil, fmt.Errorf("COOKIE_PREFIX=__Host- requires COOKIE_DOMAIN to be empty or the PUBLIC_URL host, not %s (__Host- cookies can't set Domain)", c.CookieDomain)
+		}
+	}
+
+	// Relayed cookies on other domains hold a copy of the token, which
+	// rotation on the primary domain would silently invalidate.
+	if c.SessionRotate && len(c.CookieDomains) > 1 {
+		return nil, fmt.Errorf("SESSION_ROTATE is not supported with multiple COOKIE_DOMAINS")
+	}
+
+	tlsMin, err := parseTLSVersion(envOrDefault("HEALTH_TLS_MIN_VERSION", "1.2"))
+	if err != nil {
+		return nil, fmt.Errorf("HEALTH_TLS_MIN_VERSION: %w", err)
+	}
+	c.HealthTLSMinVersion = tlsMin
+
+	c.UserDisabledServices = envOrDefault("USER_DISABLED_SERVICES", "show")
+	switch c.UserDisabledServices {
+	case "show", "grey", "hide":
+	default:
+		return nil, fmt.Errorf("USER_DISABLED_SERVICES: must be show, grey, or hide")
+	}
+
+	c.SignupMode = envOrDefault("SIGNUP_MODE", "closed")
+	switch c.SignupMode {
+	case "closed", "open", "approval":
+	default:
+		return nil, fmt.Errorf("SIGNUP_MODE: must be closed, open, or approval")
+	}
+
+	c.Theme = envOrDefault("THEME", "dark")
+	switch c.Theme {
+	case "dark", "high-contrast":
+	default:
+		return nil, fmt.Errorf("THEME: must be dark or high-contrast")
+	}
+
+	if c.FaviconFile != "" {
+		if info, err := os.Stat(c.FaviconFile); err != nil {
+			return nil, fmt.Errorf("FAVICON_FILE: %w", err)
+		} else if info.IsDir() {
+			return nil, fmt.Errorf("FAVICON_FILE: %s is a directory", c.FaviconFile)
+		}
+	}
+
+	c.PublicDownServices = envOrDefault("PUBLIC_DOWN_SERVICES", "dim")
+	switch c.PublicDownServices {
+	case "show", "dim", "hide":
+	default:
+		return nil, fmt.Errorf("PUBLIC_DOWN_SERVICES: must be show, dim, or hide")
+	}
+
+	if c.StartupTimeout, err = envPositiveDuration("STARTUP_TIMEOUT", "10s"); err != nil {
+		return nil, err
+	}
+	if c.ShutdownTimeout, err = envPositiveDuration("SHUTDOWN_TIMEOUT", "10s"); err != nil {
+		return nil, err
+	}
+
+	if c.DBConnectAttempts, err = envInt("DB_CONNECT_ATTEMPTS", 10); err != nil {
+		return nil, err
+	}
+	if c.DBConnectAttempts < 1 {
+		return nil, fmt.Errorf("DB_CONNECT_ATTEMPTS: must be at least 1")
+	}
+	if c.DBConnectInterval, err = envDuration("DB_CONNECT_INTERVAL", "2s"); err != nil {
+		return nil, err
+	}
+	if c.DBReplicaMaxLag, err = envPositiveDuration("DB_REPLICA_MAX_LAG", "5s"); err != nil {
+		return nil, err
+	}
+	// Twice this is how long a revocation made by another instance can go
+	// unseen here; keep that short.
+	if c.DBReplicaMaxLag > 30*time.Second {
+		return nil, fmt.Errorf("DB_REPLICA_MAX_LAG: must be at most 30s")
+	}
+
+	if c.HealthFollowRedirects, err = envInt("HEALTH_FOLLOW_REDIRECTS", 0); err != nil {
+		return nil, err
+	}
+
+	if c.HealthPrewarmTimeout, err = envDuration("HEALTH_PREWARM_TIMEOUT", "5s"); err != nil {
+		return nil, err
+	}
+	c.HealthUserAgent = envOrDefault("HEALTH_USER_AGENT", "noknok-healthcheck/"+Version)
+	if c.HealthPollInterval, err = envPositiveDuration("HEALTH_POLL_INTERVAL", "60s"); err != nil {
+		return nil, err
+	}
+	// Probes time out after 4s; a shorter interval would start a run
+	// before the last one finished.
+	if c.HealthPollInterval < 5*time.Second {
+		return nil, fmt.Errorf("HEALTH_POLL_INTERVAL: must be at least 5s")
+	}
+	if c.HealthHistorySize, err = envInt("HEALTH_HISTORY_SIZE", 1440); err != nil {
+		return nil, err
+	}
+	if c.HealthHistoryRetention, err = envDuration("HEALTH_HISTORY_RETENTION", "0"); err != nil {
+		return nil, err
+	}
+	// Persisted samples are only read back into the in-memory history.
+	if c.HealthHistoryRetention > 0 && c.HealthHistorySize == 0 {
+		return nil, fmt.Errorf("HEALTH_HISTORY_RETENTION needs HEALTH_HISTORY_SIZE above 0")
+	}
+
+	if c.OAuthRevalidateInterval, err = envDuration("OAUTH_REVALIDATE_INTERVAL", "0"); err != nil {
+		return nil, err
+	}
+	if c.AccessLogRetention, err = envDuration("ACCESS_LOG_RETENTION", "0"); err != nil {
+		return nil, err
+	}
+	if c.ExpiredGrantRetention, err = envDuration("EXPIRED_GRANT_RETENTION", "168h"); err != nil {
+		return nil, err
+	}
+	if c.SessionGroupMaxAge, err = envDuration("SESSION_GROUP_MAX_AGE", "0"); err != nil {
+		return nil, err
+	}
+	if c.SessionExpiryGrace, err = envDuration("SESSION_EXPIRY_GRACE", "0"); err != nil {
+		return nil, err
+	}
+	if c.SessionRefreshWindow, err = envDuration("SESSION_REFRESH_WINDOW", "0"); err != nil {
+		return nil, err
+	}
+	if c.SessionIdleTTL, err = envDuration("SESSION_IDLE_TTL", "0"); err != nil {
+		return nil, err
+	}
+	if c.MaxSessionsPerUser, err = envInt("MAX_SESSIONS_PER_USER", 0); err != nil {
+		return nil, err
+	}
+	// A window as long as the TTL would rewrite the session on every use.
+	if ttl, err := time.ParseDuration(c.SessionTTL); err == nil && c.SessionRefreshWindow >= ttl {
+		return nil, fmt.Errorf("SESSION_REFRESH_WINDOW (%s) must be shorter than SESSION_TTL (%s)", c.SessionRefreshWindow, c.SessionTTL)
+	}
+	if c.ServiceHostCacheTTL, err = envDuration("SERVICE_HOST_CACHE_TTL", "30s"); err != nil {
+		return nil, err
+	}
+	if c.ValidateRateLimit, err = envInt("VALIDATE_RATE_LIMIT", 20); err != nil {
+		return nil, err
+	}
+	if c.LoginEventRetention, err = envDuration("LOGIN_EVENT_RETENTION", "720h"); err != nil {
+		return nil, err
+	}
+	if c.LoginDenyLimit, err = envInt("LOGIN_DENY_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if c.LoginDenyWindow, err = envPositiveDuration("LOGIN_DENY_WINDOW", "15m"); err != nil {
+		return nil, err
+	}
+	if c.LoginDenyLimit > 0 && c.LoginEventRetention < c.LoginDenyWindow {
+		return nil, fmt.Errorf("LOGIN_DENY_LIMIT needs LOGIN_EVENT_RETENTION of at least LOGIN_DENY_WINDOW (%s)", c.LoginDenyWindow)
+	}
+	if c.BulkResolveConcurrency, err = envInt("BULK_RESOLVE_CONCURRENCY", 8); err != nil {
+		return nil, err
+	}
+	if c.BulkResolveConcurrency < 1 {
+		return nil, fmt.Errorf("BULK_RESOLVE_CONCURRENCY: must be at least 1")
+	}
+	if c.OAuthRevalidateWorkers, err = envInt("OAUTH_REVALIDATE_CONCURRENCY", 4); err != nil {
+		return nil, err
+	}
+	if c.OAuthRevalidateWorkers < 1 {
+		return nil, fmt.Errorf("OAUTH_REVALIDATE_CONCURRENCY: must be at least 1")
+	}
+	if c.BulkResolveTimeout, err = envPositiveDuration("BULK_RESOLVE_TIMEOUT", "10s"); err != nil {
+		return nil, err
+	}
+
+	if c.TrustedProxies, err = parseCIDRs(envOrDefault("TRUSTED_PROXIES", defaultTrustedProxies)); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+
+	if c.LogLevel, err = LogLevel(); err != nil {
+		return nil, err
+	}
+	c.LogAuthDIDs = envBool("LOG_AUTH_DIDS")
+
+	c.PortalReloadAfter, err = envDuration("PORTAL_RELOAD_AFTER", "5s")
+	if err != nil {
+		return nil, err
+	}
+	if c.PortalIdleLogout, err = envDuration("PORTAL_IDLE_LOGOUT", "0"); err != nil {
+		return nil, err
+	}
+	// The portal has nothing new to fetch between poller runs.
+	if c.PortalStatusPoll, err = envPositiveDuration("PORTAL_STATUS_POLL", c.HealthPollInterval.String()); err != nil {
+		return nil, err
+	}
+	if c.PortalTabClaim, err = envPositiveDuration("PORTAL_TAB_CLAIM", "200ms"); err != nil {
+		return nil, err
+	}
+	// The idle timer only makes sense if it fires before the session
+	// expires on its own; otherwise the user is bounced to login first.
+	if ttl, err := time.ParseDuration(c.SessionTTL); err == nil && c.PortalIdleLogout > 0 && c.PortalIdleLogout >= ttl {
+		return nil, fmt.Errorf("PORTAL_IDLE_LOGOUT (%s) must be shorter than SESSION_TTL (%s)", c.PortalIdleLogout, c.SessionTTL)
+	}
+
+	c.PortalDownClick = envOrDefault("PORTAL_DOWN_CLICK", "block")
+	switch c.PortalDownClick {
+	case "block", "confirm", "open":
+	default:
+		return nil, fmt.Errorf("PORTAL_DOWN_CLICK: must be block, confirm, or open")
+	}
+	c.PortalDownMessage = envOrDefault("PORTAL_DOWN_MESSAGE", "{name} isn't responding right now. Try again in a few minutes.")
+	c.PortalDisabledMessage = envOrDefault("PORTAL_DISABLED_MESSAGE", "{name} has been turned off by an admin.")
+
+	if c.StatusColorRed, err = envColor("STATUS_COLOR_RED", "#ef4444"); err != nil {
+		return nil, err
+	}
+	if c.StatusColorYellow, err = envColor("STATUS_COLOR_YELLOW", "#eab308"); err != nil {
+		return nil, err
+	}
+	if c.StatusColorGreen, err = envColor("STATUS_COLOR_GREEN", "#22c55e"); err != nil {
+		return nil, err
+	}
+
 	pw, err := envOrFile("DB_PASSWORD")
 	if err != nil {
 		return nil, fmt.Errorf("DB_PASSWORD: %w", err)
 	}
 	c.DBPassword = pw
 
+	if c.DBReplicaDSN, err = envOrFile("DB_REPLICA_DSN"); err != nil {
+		return nil, fmt.Errorf("DB_REPLICA_DSN: %w", err)
+	}
+	if c.ValidateAPIToken, err = envOrFile("VALIDATE_API_TOKEN"); err != nil {
+		return nil, fmt.Errorf("VALIDATE_API_TOKEN: %w", err)
+	}
+	if c.MetricsToken, err = envOrFile("METRICS_TOKEN"); err != nil {
+		return nil, fmt.Errorf("METRICS_TOKEN: %w", err)
+	}
+	if c.AccessRequestWebhook, err = envOrFile("ACCESS_REQUEST_WEBHOOK"); err != nil {
+		return nil, fmt.Errorf("ACCESS_REQUEST_WEBHOOK: %w", err)
+	}
+	if c.WebhookSecret, err = envOrFile("ACCESS_REQUEST_WEBHOOK_SECRET"); err != nil {
+		return nil, fmt.Errorf("ACCESS_REQUEST_WEBHOOK_SECRET: %w", err)
+	}
+	if c.AccessRequestWebhook != "" {
+		if u, err := url.Parse(c.AccessRequestWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("ACCESS_REQUEST_WEBHOOK must be an http(s) URL")
+		}
+	}
+
 	oauthKey, err := envOrFile("OAUTH_KEY")
 	if err != nil {
 		return nil, fmt.Errorf("OAUTH_KEY: %w", err)
@@ -83,6 +481,17 @@ func Load() (*Config, error) {
 	return c, nil
 }
 
+// LogLevel parses LOG_LEVEL (debug, info, warn, error; default info).
+// It is exported so main can apply the level before the rest of the
+// config is loaded.
+func LogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envOrDefault("LOG_LEVEL", "info"))); err != nil {
+		return 0, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	return level, nil
+}
+
 // DSN returns a PostgreSQL connection string.
 func (c *Config) DSN() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
@@ -107,12 +516,115 @@ func (c *Config) DomainForHost(host string) string {
 	return c.CookieDomain
 }
 
+// defaultTrustedProxies covers loopback and private networks, where Traefik
+// sits in a typical Docker deployment.
+const defaultTrustedProxies = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// parseCIDRs parses a comma-separated list of CIDRs. A bare IP is taken as
+// a single-address range.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", part)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", part)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IsTrustedProxy reports whether remoteAddr (host:port or a bare IP) is in
+// TRUSTED_PROXIES.
+func (c *Config) IsTrustedProxy(remoteAddr string) bool {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// InsecurePublicURL reports whether PublicURL isn't https, so session
+// cookies aren't marked Secure.
+func (c *Config) InsecurePublicURL() bool {
+	return !strings.HasPrefix(c.PublicURL, "https://")
+}
+
+// LocalPublicURL reports whether PublicURL's host is localhost, a
+// *.localhost name, or a loopback address: a development setup whose
+// traffic never leaves the machine.
+func (c *Config) LocalPublicURL() bool {
+	u, err := url.Parse(c.PublicURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// IsPublicHost reports whether rawURL points at noknok's own PublicURL host.
+// A service on that host would be gated by noknok itself.
+func (c *Config) IsPublicHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	pub, err := url.Parse(c.PublicURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Hostname(), pub.Hostname())
+}
+
 // IsExternalHost returns true if the host belongs to a different cookie domain
 // than the primary PublicURL.
 func (c *Config) IsExternalHost(host string) bool {
 	return c.DomainForHost(host) != c.CookieDomain
 }
 
+// cssColor matches the color values accepted for STATUS_COLOR_*: hex
+// (#rgb, #rrggbb, with optional alpha) or a CSS color name. Anything else
+// could break out of the style block it is written into.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// envColor reads a CSS color from key.
+func envColor(key, fallback string) (string, error) {
+	v := envOrDefault(key, fallback)
+	if !cssColor.MatchString(v) {
+		return "", fmt.Errorf("%s: must be a hex color or CSS color name", key)
+	}
+	return v, nil
+}
+
 func envOrDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -120,6 +632,67 @@ func envOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// parseTLSVersion maps "1.0".."1.3" to the crypto/tls version constant.
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q (use 1.0, 1.1, 1.2, or 1.3)", v)
+}
+
+// envDuration parses env var KEY as a non-negative duration.
+func envDuration(key, fallback string) (time.Duration, error) {
+	d, err := time.ParseDuration(envOrDefault(key, fallback))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s: must not be negative", key)
+	}
+	return d, nil
+}
+
+// envPositiveDuration parses env var KEY as a duration greater than zero.
+func envPositiveDuration(key, fallback string) (time.Duration, error) {
+	d, err := envDuration(key, fallback)
+	if err != nil {
+		return 0, err
+	}
+	if d == 0 {
+		return 0, fmt.Errorf("%s: must be positive", key)
+	}
+	return d, nil
+}
+
+// envInt parses env var KEY as a non-negative integer.
+func envInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s: must not be negative", key)
+	}
+	return n, nil
+}
+
+// envBool reports whether env var KEY is set to a true value ("1", "true", ...).
+func envBool(key string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(key))
+	return b
+}
+
 // envOrFile reads a value from env var KEY, or from a file at KEY_FILE.
 func envOrFile(key string) (string, error) {
 	if v := os.Getenv(key); v != "" {
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsPublicHost(t *testing.T) {
+	c := &Config{PublicURL: "https://auth.example.com"}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://auth.example.com", true},
+		{"https://auth.example.com/app", true},
+		{"http://auth.example.com:8080/", true},
+		{"https://AUTH.Example.COM", true},
+		{"https://app.example.com", false},
+		{"https://auth.example.com.evil.test", false},
+		{"https://sub.auth.example.com", false},
+		{"https://example.com", false},
+		{"not a url", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := c.IsPublicHost(tt.url); got != tt.want {
+			t.Errorf("IsPublicHost(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestHostPrefixCookieDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		ok     bool
+	}{
+		{"auth.example.com", true},
+		{".auth.example.com", true},
+		{"AUTH.example.com", true},
+		{".example.com", false},
+		{"example.com", false},
+		{"other.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			t.Setenv("OWNER_DID", "did:plc:owner")
+			t.Setenv("OAUTH_KEY", "z-test")
+			t.Setenv("PUBLIC_URL", "https://auth.example.com")
+			t.Setenv("COOKIE_PREFIX", "__Host-")
+			t.Setenv("COOKIE_DOMAIN", tt.domain)
+			_, err := Load()
+			if tt.ok && err != nil {
+				t.Errorf("COOKIE_DOMAIN=%s: %v", tt.domain, err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "COOKIE_DOMAIN")) {
+				t.Errorf("COOKIE_DOMAIN=%s: error %v, want a COOKIE_DOMAIN error", tt.domain, err)
+			}
+		})
+	}
+}
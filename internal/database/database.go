@@ -6,25 +6,49 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// DB wraps a pgx connection pool.
+// DB wraps a pgx connection pool to the primary, plus an optional read
+// replica (see AttachReplica).
 type DB struct {
 	Pool *pgxpool.Pool
+
+	replica       *replica
+	capGrantRoles bool
+	hosts         *hostCache // nil: GetServiceByHost always queries
 }
 
-// Open creates a connection pool and bootstraps the schema.
-func Open(ctx context.Context, dsn string) (*DB, error) {
-	pool, err := pgxpool.New(ctx, dsn)
-	if err != nil {
-		return nil, fmt.Errorf("connect: %w", err)
-	}
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("ping: %w", err)
+// EnableGrantRoleCap makes CreateGrant refuse grant roles that outrank the
+// user's global role (see RoleRank).
+func (db *DB) EnableGrantRoleCap() {
+	db.capGrantRoles = true
+}
+
+// Open creates a connection pool and bootstraps the schema. If the database
+// isn't reachable yet (e.g. it's still starting alongside noknok), it tries
+// up to attempts times, waiting interval between tries.
+func Open(ctx context.Context, dsn string, attempts int, interval time.Duration) (*DB, error) {
+	var pool *pgxpool.Pool
+	var err error
+	for attempt := 1; ; attempt++ {
+		pool, err = connect(ctx, dsn)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts {
+			return nil, err
+		}
+		slog.Warn("database not ready, retrying", "attempt", attempt, "attempts", attempts, "retry_in", interval, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(interval):
+		}
 	}
+
 	db := &DB{Pool: pool}
 	if err := db.bootstrap(ctx); err != nil {
 		pool.Close()
@@ -33,8 +57,36 @@ func Open(ctx context.Context, dsn string) (*DB, error) {
 	return db, nil
 }
 
-// Close shuts down the connection pool.
+// Connect connects to the database and checks that it answers, without
+// Open's retries or schema bootstrap: nothing is written.
+func Connect(ctx context.Context, dsn string) (*DB, error) {
+	pool, err := connect(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{Pool: pool}, nil
+}
+
+// connect creates a pool and verifies the database answers.
+func connect(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := pool.Ping(pingCtx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ping: %w", err)
+	}
+	return pool, nil
+}
+
+// Close shuts down the connection pools.
 func (db *DB) Close() {
+	if db.replica != nil {
+		db.replica.close()
+	}
 	db.Pool.Close()
 }
 
@@ -168,6 +220,7 @@ func (db *DB) SeedServices(ctx context.Context, path string) error {
 			return fmt.Errorf("seed service %s: %w", s.Slug, err)
 		}
 	}
+	db.invalidateHosts()
 	return nil
 }
 
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDSNEnv names the Postgres database the tests run against; they are
+// skipped without it. Tests create their rows under random names and
+// remove them afterwards, so a shared database is fine.
+const testDSNEnv = "NOKNOK_TEST_DSN"
+
+func testDB(t *testing.T) *DB {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv + " not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db, err := Open(ctx, dsn, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// testService creates a service at url (a random host when empty), deleted
+// when the test ends.
+func testService(t *testing.T, db *DB, url string) *Service {
+	t.Helper()
+	name := randomName(t)
+	if url == "" {
+		url = "https://" + name + ".test"
+	}
+	svc, err := db.CreateService(context.Background(), Service{Slug: name, Name: name, URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DeleteService(context.Background(), svc.ID) })
+	return svc
+}
+
+// testUser creates a user with the given global role, deleted when the
+// test ends.
+func testUser(t *testing.T, db *DB, role string) *User {
+	t.Helper()
+	user, err := db.CreateUser(context.Background(), role, randomName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DeleteUser(context.Background(), user.ID) })
+	return user
+}
+
+func randomName(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "t" + hex.EncodeToString(b)
+}
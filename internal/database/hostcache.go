@@ -0,0 +1,115 @@
+package database
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostCacheSize bounds how many hosts GetServiceByHost remembers.
+const hostCacheSize = 256
+
+// hostCache is a small LRU of GetServiceByHost results, so forwardAuth
+// doesn't query the services table on every request. Hosts that match no
+// service are cached too. Service writes through this DB clear it; entries
+// also expire after ttl, which bounds how long another instance's change
+// goes unseen.
+type hostCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // of *hostCacheEntry, most recently used first
+	gen     uint64     // bumped by invalidation; see put
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type hostCacheEntry struct {
+	host    string
+	svc     *Service // nil: no service matches the host
+	expires time.Time
+}
+
+// EnableHostCache caches GetServiceByHost lookups for ttl.
+func (db *DB) EnableHostCache(ttl time.Duration) {
+	db.hosts = &hostCache{ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// HostCacheStats reports how many GetServiceByHost lookups the cache
+// answered and how many went to the database. Both are 0 when the cache is
+// off.
+func (db *DB) HostCacheStats() (hits, misses uint64) {
+	if db.hosts == nil {
+		return 0, 0
+	}
+	return db.hosts.hits.Load(), db.hosts.misses.Load()
+}
+
+// get returns a copy of the cached result for host, and whether there was a
+// fresh one. On a miss it also returns the generation to pass to put.
+func (c *hostCache) get(host string) (*Service, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[host]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false, c.gen
+	}
+	e := el.Value.(*hostCacheEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, host)
+		c.misses.Add(1)
+		return nil, false, c.gen
+	}
+	c.order.MoveToFront(el)
+	c.hits.Add(1)
+	if e.svc == nil {
+		return nil, true, c.gen
+	}
+	svc := *e.svc
+	return &svc, true, c.gen
+}
+
+// put records the result for host, evicting the least recently used entry
+// when full. A result read before the last invalidation (gen is stale) may
+// predate the write and is dropped.
+func (c *hostCache) put(host string, svc *Service, gen uint64) {
+	if svc != nil {
+		cp := *svc
+		svc = &cp
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	e := &hostCacheEntry{host: host, svc: svc, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[host]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[host] = c.order.PushFront(e)
+	if c.order.Len() > hostCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*hostCacheEntry).host)
+	}
+}
+
+// invalidateHosts drops every cached host lookup; called after any write to
+// the services table.
+func (db *DB) invalidateHosts() {
+	c := db.hosts
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	clear(c.entries)
+	c.order.Init()
+	c.gen++
+	c.mu.Unlock()
+}
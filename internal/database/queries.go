@@ -2,7 +2,16 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // User represents a row in the users table.
@@ -13,10 +22,20 @@ type User struct {
 	Handle    string    `json:"handle"`
 	Username  string    `json:"username"`
 	Role      string    `json:"role"`
+	Status    string    `json:"status"` // UserActive, UserPending, or UserDenied
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// User statuses. Pending users signed themselves up under SIGNUP_MODE=approval
+// and can't sign in until an admin approves them; denied users were turned
+// down and stay recorded so they aren't asked about again.
+const (
+	UserActive  = "active"
+	UserPending = "pending"
+	UserDenied  = "denied"
+)
+
 // Identity represents a row in the user_identities table.
 type Identity struct {
 	ID        int64     `json:"id"`
@@ -29,39 +48,80 @@ type Identity struct {
 
 // Service represents a row in the services table.
 type Service struct {
-	ID          int64     `json:"id"`
-	Slug        string    `json:"slug"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	URL         string    `json:"url"`
-	IconURL     string    `json:"icon_url"`
-	AdminRole   string    `json:"admin_role"`
-	Enabled     bool      `json:"enabled"`
-	Public      bool      `json:"public"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                  int64     `json:"id"`
+	Slug                string    `json:"slug"`
+	Name                string    `json:"name"`
+	Description         string    `json:"description"`
+	URL                 string    `json:"url"`
+	IconURL             string    `json:"icon_url"`
+	AdminRole           string    `json:"admin_role"`
+	Enabled             bool      `json:"enabled"`
+	Public              bool      `json:"public"`
+	GrantTTLDays        int       `json:"grant_ttl_days"`         // default grant lifetime; 0 means grants don't expire
+	Domain              string    `json:"domain"`                 // cookie domain the service belongs to; empty means all domains
+	RequireReauthMaxAge int       `json:"require_reauth_max_age"` // seconds since last sign-in before forwardAuth demands a new one; 0 disables
+	DenyMessage         string    `json:"deny_message"`           // guidance shown to signed-in users without a grant
+	SkipHealthCheck     bool      `json:"skip_health_check"`      // never probe (rate-limited or internal-only); the service always counts as up
+	HealthHeadOnly      bool      `json:"health_head_only"`       // judge the HEAD probe alone, without retrying a refused HEAD as GET
+	HealthPath          string    `json:"health_path"`            // probed instead of the URL's root when set, e.g. /healthz
+	HealthMethod        string    `json:"health_method"`          // HEAD (with GET fallback) or GET
+	HealthExpectStatus  int       `json:"health_expect_status"`   // the only probe status that counts as up; 0 uses the default rule
+	SkipTLSVerify       bool      `json:"skip_tls_verify"`        // probe without verifying the certificate (self-signed backends)
+	HealthType          string    `json:"health_type"`            // http, or tcp for a plain connect to the URL's host and port
+	IssueToken          bool      `json:"issue_token"`            // forwardAuth adds a signed identity JWT in X-User-Token
+	HealthOverride      string    `json:"health_override"`        // "up" or "down" pins the health status (maintenance); "auto" probes
+	Category            string    `json:"category"`               // portal heading the card is grouped under; empty means "Other"
+	SortOrder           int       `json:"sort_order"`             // lower lists first; ties by name
+	HasIcon             bool      `json:"has_icon"`               // an uploaded icon is stored (see ServiceIcon)
+	CreatedAt           time.Time `json:"created_at"`
 }
 
 // Grant represents a row in the grants table with joined user/service info.
 type Grant struct {
-	ID          int64     `json:"id"`
-	UserID      int64     `json:"user_id"`
-	ServiceID   int64     `json:"service_id"`
-	Role        string    `json:"role"`
-	GrantedBy   *int64    `json:"granted_by"`
-	CreatedAt   time.Time `json:"created_at"`
-	UserHandle  string    `json:"user_handle,omitempty"`
-	ServiceName string    `json:"service_name,omitempty"`
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	ServiceID   int64      `json:"service_id"`
+	Role        string     `json:"role"`
+	GrantedBy   *int64     `json:"granted_by"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Note        string     `json:"note"` // why access was given
+	CreatedAt   time.Time  `json:"created_at"`
+	UserHandle  string     `json:"user_handle,omitempty"`
+	ServiceName string     `json:"service_name,omitempty"`
 }
 
 // --- Users ---
 
+// RoleRank orders global roles: owner > admin > user. Any other role,
+// including free-text per-service roles, ranks with user.
+func RoleRank(role string) int {
+	switch role {
+	case "owner":
+		return 2
+	case "admin":
+		return 1
+	}
+	return 0
+}
+
+// ListUsers returns active users. Self-registered users awaiting approval,
+// or denied, are listed by ListSignups.
 func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := db.Pool.Query(ctx, `
+	return db.listUsers(ctx, `WHERE u.status = 'active' ORDER BY u.id`)
+}
+
+// ListSignups returns pending and denied users, pending first, oldest first.
+func (db *DB) ListSignups(ctx context.Context) ([]User, error) {
+	return db.listUsers(ctx, `WHERE u.status IN ('pending', 'denied') ORDER BY u.status DESC, u.created_at`)
+}
+
+func (db *DB) listUsers(ctx context.Context, where string) ([]User, error) {
+	rows, err := db.reader().Query(ctx, `
 		SELECT u.id, COALESCE(pi.did, ''), COALESCE(pi.handle, ''),
-		       u.username, u.role, u.created_at, u.updated_at
+		       u.username, u.role, u.status, u.created_at, u.updated_at
 		FROM users u
 		LEFT JOIN user_identities pi ON pi.user_id = u.id AND pi.is_primary = true
-		ORDER BY u.id`)
+		`+where)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +130,7 @@ func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.Status, &u.CreatedAt, &u.UpdatedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -81,59 +141,194 @@ func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
 // GetUserByIdentityDID finds a user by any of their linked DIDs.
 func (db *DB) GetUserByIdentityDID(ctx context.Context, did string) (*User, error) {
 	var u User
-	err := db.Pool.QueryRow(ctx, `
-		SELECT u.id, ui.did, ui.handle, u.username, u.role, u.created_at, u.updated_at
+	err := db.reader().QueryRow(ctx, `
+		SELECT u.id, ui.did, ui.handle, u.username, u.role, u.status, u.created_at, u.updated_at
 		FROM users u
 		JOIN user_identities ui ON ui.user_id = u.id
 		WHERE ui.did = $1`, did).
-		Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+		Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserByID returns a user with their primary identity.
+func (db *DB) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	var u User
+	err := db.reader().QueryRow(ctx, `
+		SELECT u.id, COALESCE(pi.did, ''), COALESCE(pi.handle, ''),
+		       u.username, u.role, u.status, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN user_identities pi ON pi.user_id = u.id AND pi.is_primary = true
+		WHERE u.id = $1`, id).
+		Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.Status, &u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &u, nil
 }
 
+// ErrUsernameTaken is returned by CreateUser and UpdateUserUsername when
+// another user already has the username.
+var ErrUsernameTaken = errors.New("username already taken")
+
+// usernameTaken reports whether err violates the unique username index.
+func usernameTaken(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_users_username_nonempty"
+}
+
 func (db *DB) CreateUser(ctx context.Context, role, username string) (*User, error) {
 	var u User
-	err := db.Pool.QueryRow(ctx, `
+	err := db.writer().QueryRow(ctx, `
 		INSERT INTO users (role, username)
 		VALUES ($1, $2)
-		RETURNING id, username, role, created_at, updated_at`,
+		RETURNING id, username, role, status, created_at, updated_at`,
 		role, username).
-		Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+		Scan(&u.ID, &u.Username, &u.Role, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	if usernameTaken(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// RegisterUser creates a user with role user and the given status for a DID
+// that signed itself up, with that DID as its primary identity. If another
+// sign-in registered the DID first, that user is returned instead.
+func (db *DB) RegisterUser(ctx context.Context, did, handle, status string) (*User, error) {
+	tx, err := db.writer().Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
+
+	u := User{DID: did, Handle: handle}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (role, status) VALUES ('user', $1)
+		RETURNING id, username, role, status, created_at, updated_at`, status).
+		Scan(&u.ID, &u.Username, &u.Role, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO user_identities (user_id, did, handle, is_primary)
+		VALUES ($1, $2, $3, true)
+		ON CONFLICT (did) DO NOTHING`, u.ID, did, handle)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		tx.Rollback(ctx)
+		return db.GetUserByIdentityDID(ctx, did)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 	return &u, nil
 }
 
+// SuggestUsername returns a free username made by appending a number
+// (2–99) to base, trimmed so the result stays within 39 characters, or ""
+// if none is free.
+func (db *DB) SuggestUsername(ctx context.Context, base string) (string, error) {
+	candidates := make([]string, 0, 98)
+	for n := 2; n < 100; n++ {
+		suffix := strconv.Itoa(n)
+		b := base
+		if len(b)+len(suffix) > 39 {
+			b = b[:39-len(suffix)]
+		}
+		candidates = append(candidates, b+suffix)
+	}
+	var suggestion string
+	err := db.writer().QueryRow(ctx, `
+		SELECT c FROM unnest($1::TEXT[]) WITH ORDINALITY AS t(c, n)
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = t.c)
+		ORDER BY n LIMIT 1`, candidates).Scan(&suggestion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return suggestion, err
+}
+
 func (db *DB) UpdateUserRole(ctx context.Context, id int64, role string) error {
-	_, err := db.Pool.Exec(ctx, `
+	_, err := db.writer().Exec(ctx, `
 		UPDATE users SET role = $1, updated_at = now() WHERE id = $2`, role, id)
 	return err
 }
 
+// SetUserStatus moves a user between active, pending, and denied.
+func (db *DB) SetUserStatus(ctx context.Context, id int64, status string) error {
+	_, err := db.writer().Exec(ctx, `
+		UPDATE users SET status = $1, updated_at = now() WHERE id = $2`, status, id)
+	return err
+}
+
 func (db *DB) UpdateUserUsername(ctx context.Context, id int64, username string) error {
-	_, err := db.Pool.Exec(ctx, `
+	_, err := db.writer().Exec(ctx, `
 		UPDATE users SET username = $1, updated_at = now() WHERE id = $2`, username, id)
+	if usernameTaken(err) {
+		return ErrUsernameTaken
+	}
 	if err != nil {
 		return err
 	}
 	// Propagate to active sessions via user_id.
-	_, err = db.Pool.Exec(ctx, `
+	_, err = db.writer().Exec(ctx, `
 		UPDATE sessions SET username = $1
 		WHERE user_id = $2 AND expires_at > now()`, username, id)
 	return err
 }
 
+// RecordLogin stamps a user's last_login_at and reports whether this is
+// their first sign-in.
+func (db *DB) RecordLogin(ctx context.Context, id int64) (first bool, err error) {
+	err = db.writer().QueryRow(ctx, `
+		WITH prev AS (SELECT last_login_at FROM users WHERE id = $1 FOR UPDATE)
+		UPDATE users SET last_login_at = now() FROM prev
+		WHERE id = $1
+		RETURNING prev.last_login_at IS NULL`, id).Scan(&first)
+	return first, err
+}
+
 func (db *DB) DeleteUser(ctx context.Context, id int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	_, err := db.writer().Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
 	return err
 }
 
+// DeleteUserAccount removes a user and everything tied to them: identities
+// and grants (via cascade), noknok sessions, stored OAuth sessions, and
+// per-user usage counts. Grants they issued to others are kept with
+// granted_by cleared.
+func (db *DB) DeleteUserAccount(ctx context.Context, id int64) error {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	stmts := []string{
+		`DELETE FROM oauth_sessions WHERE did IN (SELECT did FROM user_identities WHERE user_id = $1)`,
+		`DELETE FROM sessions WHERE user_id = $1 OR did IN (SELECT did FROM user_identities WHERE user_id = $1)`,
+		`DELETE FROM service_usage WHERE user_id = $1`,
+		`UPDATE grants SET granted_by = NULL WHERE granted_by = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
 func (db *DB) UserExists(ctx context.Context, did string) (bool, error) {
 	var exists bool
-	err := db.Pool.QueryRow(ctx,
+	err := db.reader().QueryRow(ctx,
 		`SELECT EXISTS(SELECT 1 FROM user_identities WHERE did = $1)`, did).Scan(&exists)
 	return exists, err
 }
@@ -142,7 +337,7 @@ func (db *DB) UserExists(ctx context.Context, did string) (bool, error) {
 
 func (db *DB) AddIdentity(ctx context.Context, userID int64, did, handle string, isPrimary bool) (*Identity, error) {
 	var id Identity
-	err := db.Pool.QueryRow(ctx, `
+	err := db.writer().QueryRow(ctx, `
 		INSERT INTO user_identities (user_id, did, handle, is_primary)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, user_id, did, handle, is_primary, created_at`,
@@ -155,7 +350,7 @@ func (db *DB) AddIdentity(ctx context.Context, userID int64, did, handle string,
 }
 
 func (db *DB) ListIdentities(ctx context.Context, userID int64) ([]Identity, error) {
-	rows, err := db.Pool.Query(ctx, `
+	rows, err := db.reader().Query(ctx, `
 		SELECT id, user_id, did, handle, is_primary, created_at
 		FROM user_identities WHERE user_id = $1
 		ORDER BY is_primary DESC, created_at`, userID)
@@ -175,17 +370,67 @@ func (db *DB) ListIdentities(ctx context.Context, userID int64) ([]Identity, err
 	return ids, rows.Err()
 }
 
+// ListAllIdentities returns every linked identity, for bulk maintenance.
+func (db *DB) ListAllIdentities(ctx context.Context) ([]Identity, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT id, user_id, did, handle, is_primary, created_at
+		FROM user_identities ORDER BY user_id, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []Identity
+	for rows.Next() {
+		var id Identity
+		if err := rows.Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.IsPrimary, &id.CreatedAt); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateIdentityHandle sets the handle recorded for a DID on its identity
+// and on its live sessions, so headers and the portal show the new one
+// without a fresh login.
+func (db *DB) UpdateIdentityHandle(ctx context.Context, did, handle string) error {
+	_, err := db.writer().Exec(ctx, `
+		WITH ident AS (UPDATE user_identities SET handle = $2 WHERE did = $1)
+		UPDATE sessions SET handle = $2 WHERE did = $1 AND expires_at > now()`, did, handle)
+	return err
+}
+
 func (db *DB) RemoveIdentity(ctx context.Context, identityID int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM user_identities WHERE id = $1`, identityID)
+	_, err := db.writer().Exec(ctx, `DELETE FROM user_identities WHERE id = $1`, identityID)
 	return err
 }
 
 // --- Services ---
 
-func (db *DB) ListServices(ctx context.Context) ([]Service, error) {
-	rows, err := db.Pool.Query(ctx, `
-		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at
-		FROM services ORDER BY name`)
+// serviceColumns is the column list scanned by scanService.
+const serviceColumns = `id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public,
+		grant_ttl_days, domain, require_reauth_max_age, deny_message, skip_health_check, health_head_only, health_path, health_method,
+		health_expect_status, skip_tls_verify, health_type, issue_token, health_override, category, sort_order, icon_data IS NOT NULL, created_at`
+
+// rowScanner is satisfied by both pgx.Row and pgx.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanService(row rowScanner, s *Service) error {
+	return row.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public,
+		&s.GrantTTLDays, &s.Domain, &s.RequireReauthMaxAge, &s.DenyMessage, &s.SkipHealthCheck, &s.HealthHeadOnly, &s.HealthPath, &s.HealthMethod,
+		&s.HealthExpectStatus, &s.SkipTLSVerify, &s.HealthType, &s.IssueToken, &s.HealthOverride, &s.Category, &s.SortOrder, &s.HasIcon, &s.CreatedAt)
+}
+
+// ListServices returns the services visible on a cookie domain: global
+// services (empty domain) plus those assigned to it, by sort order then
+// name. Pass "" for every service.
+func (db *DB) ListServices(ctx context.Context, domain string) ([]Service, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT `+serviceColumns+`
+		FROM services WHERE ($1 = '' OR domain = '' OR domain = $1) ORDER BY sort_order, name`, domain)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +439,7 @@ func (db *DB) ListServices(ctx context.Context) ([]Service, error) {
 	var svcs []Service
 	for rows.Next() {
 		var s Service
-		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt); err != nil {
+		if err := scanService(rows, &s); err != nil {
 			return nil, err
 		}
 		svcs = append(svcs, s)
@@ -202,13 +447,39 @@ func (db *DB) ListServices(ctx context.Context) ([]Service, error) {
 	return svcs, rows.Err()
 }
 
-func (db *DB) ListServicesForUser(ctx context.Context, userID int64) ([]Service, error) {
-	rows, err := db.Pool.Query(ctx, `
-		SELECT s.id, s.slug, s.name, s.description, s.url, COALESCE(s.icon_url, ''), s.admin_role, s.enabled, s.public, s.created_at
-		FROM services s
-		JOIN grants g ON g.service_id = s.id
-		WHERE g.user_id = $1
-		ORDER BY s.name`, userID)
+// ListServicesByCategory is ListServices grouped for display: categories
+// alphabetically (ignoring case), uncategorized services last, and services
+// by sort order then name within each.
+func (db *DB) ListServicesByCategory(ctx context.Context, domain string) ([]Service, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT `+serviceColumns+`
+		FROM services WHERE ($1 = '' OR domain = '' OR domain = $1)
+		ORDER BY category = '', lower(category), sort_order, name`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var svcs []Service
+	for rows.Next() {
+		var s Service
+		if err := scanService(rows, &s); err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, s)
+	}
+	return svcs, rows.Err()
+}
+
+func (db *DB) ListServicesForUser(ctx context.Context, userID int64, domain string) ([]Service, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT `+serviceColumns+`
+		FROM services
+		WHERE id IN (
+			SELECT service_id FROM grants
+			WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > now())
+		) AND ($2 = '' OR domain = '' OR domain = $2)
+		ORDER BY sort_order, name`, userID, domain)
 	if err != nil {
 		return nil, err
 	}
@@ -217,7 +488,7 @@ func (db *DB) ListServicesForUser(ctx context.Context, userID int64) ([]Service,
 	var svcs []Service
 	for rows.Next() {
 		var s Service
-		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt); err != nil {
+		if err := scanService(rows, &s); err != nil {
 			return nil, err
 		}
 		svcs = append(svcs, s)
@@ -225,10 +496,14 @@ func (db *DB) ListServicesForUser(ctx context.Context, userID int64) ([]Service,
 	return svcs, rows.Err()
 }
 
-func (db *DB) ListPublicServices(ctx context.Context) ([]Service, error) {
-	rows, err := db.Pool.Query(ctx, `
-		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at
-		FROM services WHERE public = true AND enabled = true ORDER BY name`)
+// ListPublicServices returns services that are both public and enabled, for
+// anonymous pages on domain. A disabled service is never listed even if
+// marked public.
+func (db *DB) ListPublicServices(ctx context.Context, domain string) ([]Service, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT `+serviceColumns+`
+		FROM services WHERE public = true AND enabled = true AND ($1 = '' OR domain = '' OR domain = $1)
+		ORDER BY sort_order, name`, domain)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +512,7 @@ func (db *DB) ListPublicServices(ctx context.Context) ([]Service, error) {
 	var svcs []Service
 	for rows.Next() {
 		var s Service
-		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt); err != nil {
+		if err := scanService(rows, &s); err != nil {
 			return nil, err
 		}
 		svcs = append(svcs, s)
@@ -245,63 +520,202 @@ func (db *DB) ListPublicServices(ctx context.Context) ([]Service, error) {
 	return svcs, rows.Err()
 }
 
-func (db *DB) CreateService(ctx context.Context, slug, name, description, url, iconURL, adminRole string) (*Service, error) {
-	if adminRole == "" {
-		adminRole = "admin"
+// CreateService inserts a service from svc's editable fields. It takes the
+// highest sort order in use, so it lists among the last services instead of
+// jumping ahead of ones an admin has ordered.
+func (db *DB) CreateService(ctx context.Context, svc Service) (*Service, error) {
+	if svc.AdminRole == "" {
+		svc.AdminRole = "admin"
+	}
+	if svc.HealthMethod == "" {
+		svc.HealthMethod = "HEAD"
+	}
+	if svc.HealthType == "" {
+		svc.HealthType = "http"
 	}
 	var s Service
-	err := db.Pool.QueryRow(ctx, `
-		INSERT INTO services (slug, name, description, url, icon_url, admin_role)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at`,
-		slug, name, description, url, iconURL, adminRole).
-		Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt)
+	err := scanService(db.writer().QueryRow(ctx, `
+		INSERT INTO services (slug, name, description, url, icon_url, admin_role, grant_ttl_days, domain,
+			require_reauth_max_age, deny_message, skip_health_check, health_head_only, issue_token,
+			health_path, health_method, health_expect_status, skip_tls_verify, health_type, category, sort_order)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19,
+			(SELECT COALESCE(MAX(sort_order), 0) FROM services))
+		RETURNING `+serviceColumns,
+		svc.Slug, svc.Name, svc.Description, svc.URL, svc.IconURL, svc.AdminRole, svc.GrantTTLDays, svc.Domain,
+		svc.RequireReauthMaxAge, svc.DenyMessage, svc.SkipHealthCheck, svc.HealthHeadOnly, svc.IssueToken,
+		svc.HealthPath, svc.HealthMethod, svc.HealthExpectStatus, svc.SkipTLSVerify, svc.HealthType, svc.Category), &s)
 	if err != nil {
 		return nil, err
 	}
+	db.invalidateHosts()
 	return &s, nil
 }
 
-func (db *DB) UpdateService(ctx context.Context, id int64, name, description, url, iconURL, adminRole string) error {
-	if adminRole == "" {
-		adminRole = "admin"
+// UpdateService replaces a service's editable fields (everything but slug,
+// enabled, and public) with svc's.
+func (db *DB) UpdateService(ctx context.Context, id int64, svc Service) error {
+	if svc.AdminRole == "" {
+		svc.AdminRole = "admin"
 	}
-	_, err := db.Pool.Exec(ctx, `
-		UPDATE services SET name = $1, description = $2, url = $3, icon_url = $4, admin_role = $5
-		WHERE id = $6`, name, description, url, iconURL, adminRole, id)
+	if svc.HealthMethod == "" {
+		svc.HealthMethod = "HEAD"
+	}
+	if svc.HealthType == "" {
+		svc.HealthType = "http"
+	}
+	_, err := db.writer().Exec(ctx, `
+		UPDATE services SET name = $1, description = $2, url = $3, icon_url = $4, admin_role = $5,
+			grant_ttl_days = $6, domain = $7, require_reauth_max_age = $8, deny_message = $9, skip_health_check = $10,
+			issue_token = $11, health_head_only = $12, health_path = $13, health_method = $14,
+			health_expect_status = $15, skip_tls_verify = $16, health_type = $17, category = $18
+		WHERE id = $19`, svc.Name, svc.Description, svc.URL, svc.IconURL, svc.AdminRole, svc.GrantTTLDays, svc.Domain,
+		svc.RequireReauthMaxAge, svc.DenyMessage, svc.SkipHealthCheck, svc.IssueToken, svc.HealthHeadOnly,
+		svc.HealthPath, svc.HealthMethod, svc.HealthExpectStatus, svc.SkipTLSVerify, svc.HealthType, svc.Category, id)
+	db.invalidateHosts()
 	return err
 }
 
 func (db *DB) ToggleServiceEnabled(ctx context.Context, id int64) (bool, error) {
 	var enabled bool
-	err := db.Pool.QueryRow(ctx, `
+	err := db.writer().QueryRow(ctx, `
 		UPDATE services SET enabled = NOT enabled WHERE id = $1
 		RETURNING enabled`, id).Scan(&enabled)
+	db.invalidateHosts()
 	return enabled, err
 }
 
 func (db *DB) ToggleServicePublic(ctx context.Context, id int64) (bool, error) {
 	var public bool
-	err := db.Pool.QueryRow(ctx, `
+	err := db.writer().QueryRow(ctx, `
 		UPDATE services SET public = NOT public WHERE id = $1
 		RETURNING public`, id).Scan(&public)
+	db.invalidateHosts()
 	return public, err
 }
 
+// SetServiceHealthOverride pins a service's health status to "up" or "down",
+// or returns it to probing with "auto", and returns the updated service.
+func (db *DB) SetServiceHealthOverride(ctx context.Context, id int64, override string) (*Service, error) {
+	var s Service
+	err := scanService(db.writer().QueryRow(ctx, `
+		UPDATE services SET health_override = $2 WHERE id = $1
+		RETURNING `+serviceColumns, id, override), &s)
+	if err != nil {
+		return nil, err
+	}
+	db.invalidateHosts()
+	return &s, nil
+}
+
+// SetServiceSortOrder sets a service's sort order explicitly.
+func (db *DB) SetServiceSortOrder(ctx context.Context, id int64, order int) (*Service, error) {
+	var s Service
+	err := scanService(db.writer().QueryRow(ctx, `
+		UPDATE services SET sort_order = $2 WHERE id = $1
+		RETURNING `+serviceColumns, id, order), &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// MoveService moves a service one place up (delta -1) or down (delta 1) in
+// the full service list by swapping it with its neighbour. The list is
+// renumbered 1..n in the same transaction, so ties (every service starts
+// at 0) and gaps left by explicit orders don't make a move a no-op. Moving
+// past either end leaves the order as is. Returns pgx.ErrNoRows if the
+// service doesn't exist.
+func (db *DB) MoveService(ctx context.Context, id int64, delta int) error {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id FROM services ORDER BY sort_order, name FOR UPDATE`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var sid int64
+		if err := rows.Scan(&sid); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, sid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	i := slices.Index(ids, id)
+	if i < 0 {
+		return pgx.ErrNoRows
+	}
+	if j := i + delta; j >= 0 && j < len(ids) {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE services s SET sort_order = o.pos
+		FROM unnest($1::bigint[]) WITH ORDINALITY AS o(id, pos)
+		WHERE s.id = o.id AND s.sort_order <> o.pos`, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ServiceIcon returns a service's uploaded icon and its content type.
+// Returns pgx.ErrNoRows if the service doesn't exist or has none.
+func (db *DB) ServiceIcon(ctx context.Context, id int64) ([]byte, string, error) {
+	var data []byte
+	var mime string
+	err := db.reader().QueryRow(ctx, `
+		SELECT icon_data, icon_mime FROM services WHERE id = $1 AND icon_data IS NOT NULL`, id).Scan(&data, &mime)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mime, nil
+}
+
+// SetServiceIcon stores an uploaded icon for a service, replacing any
+// before it. A nil data removes it, so the icon falls back to the fetched
+// favicon.
+func (db *DB) SetServiceIcon(ctx context.Context, id int64, data []byte, mime string) (*Service, error) {
+	if data == nil {
+		mime = ""
+	}
+	var s Service
+	err := scanService(db.writer().QueryRow(ctx, `
+		UPDATE services SET icon_data = $2, icon_mime = $3 WHERE id = $1
+		RETURNING `+serviceColumns, id, data, mime), &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
 func (db *DB) DeleteService(ctx context.Context, id int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM services WHERE id = $1`, id)
+	_, err := db.writer().Exec(ctx, `DELETE FROM services WHERE id = $1`, id)
+	db.invalidateHosts()
 	return err
 }
 
 // --- Grants ---
 
+// ErrGrantRoleAboveUser is returned by CreateGrant when the grant role cap
+// is enabled and the role outranks the user's global role.
+var ErrGrantRoleAboveUser = errors.New("grant role outranks the user's global role")
+
 func (db *DB) ListGrants(ctx context.Context) ([]Grant, error) {
-	rows, err := db.Pool.Query(ctx, `
-		SELECT g.id, g.user_id, g.service_id, g.role, g.granted_by, g.created_at,
+	rows, err := db.reader().Query(ctx, `
+		SELECT g.id, g.user_id, g.service_id, g.role, g.granted_by, g.expires_at, g.note, g.created_at,
 		       COALESCE(pi.handle, ''), s.name
 		FROM grants g
 		LEFT JOIN user_identities pi ON pi.user_id = g.user_id AND pi.is_primary = true
 		JOIN services s ON s.id = g.service_id
+		WHERE g.expires_at IS NULL OR g.expires_at > now()
 		ORDER BY pi.handle, s.name`)
 	if err != nil {
 		return nil, err
@@ -311,7 +725,7 @@ func (db *DB) ListGrants(ctx context.Context) ([]Grant, error) {
 	var grants []Grant
 	for rows.Next() {
 		var g Grant
-		if err := rows.Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.CreatedAt,
+		if err := rows.Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.ExpiresAt, &g.Note, &g.CreatedAt,
 			&g.UserHandle, &g.ServiceName); err != nil {
 			return nil, err
 		}
@@ -320,80 +734,1421 @@ func (db *DB) ListGrants(ctx context.Context) ([]Grant, error) {
 	return grants, rows.Err()
 }
 
-func (db *DB) CreateGrant(ctx context.Context, userID, serviceID, grantedBy int64, role string) (*Grant, error) {
+// ListUserGrants returns every grant a user holds, expired ones included,
+// with service names.
+func (db *DB) ListUserGrants(ctx context.Context, userID int64) ([]Grant, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT g.id, g.user_id, g.service_id, g.role, g.granted_by, g.expires_at, g.note, g.created_at, s.name
+		FROM grants g
+		JOIN services s ON s.id = g.service_id
+		WHERE g.user_id = $1
+		ORDER BY s.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.ExpiresAt, &g.Note, &g.CreatedAt,
+			&g.ServiceName); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// GrantCounts holds the number of active grants per user and the number of
+// users with active access per service, keyed by ID. IDs with no active
+// grants are absent.
+type GrantCounts struct {
+	Users    map[int64]int64 `json:"users"`
+	Services map[int64]int64 `json:"services"`
+}
+
+// CountGrants aggregates active (unexpired) grants by user and by service in
+// a single pass over the grants table.
+func (db *DB) CountGrants(ctx context.Context) (GrantCounts, error) {
+	counts := GrantCounts{Users: map[int64]int64{}, Services: map[int64]int64{}}
+	rows, err := db.reader().Query(ctx, `
+		SELECT user_id, service_id, count(*)
+		FROM grants
+		WHERE expires_at IS NULL OR expires_at > now()
+		GROUP BY GROUPING SETS ((user_id), (service_id))`)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, serviceID *int64
+		var n int64
+		if err := rows.Scan(&userID, &serviceID, &n); err != nil {
+			return counts, err
+		}
+		if userID != nil {
+			counts.Users[*userID] = n
+		} else if serviceID != nil {
+			counts.Services[*serviceID] = n
+		}
+	}
+	return counts, rows.Err()
+}
+
+// GrantExpiry is what CreateGrant does with a grant's expiry.
+type GrantExpiry struct {
+	// Set makes At the grant's expiry, replacing an existing grant's.
+	// Unset, an existing grant keeps its expiry and a new one gets the
+	// service's default grant TTL.
+	Set bool
+	At  *time.Time // with Set: when the grant expires; nil = never
+}
+
+// CreateGrant creates or updates a user's grant for a service, with its
+// expiry as exp says. An expired grant counts as new: re-granting it starts
+// over from the service's default TTL. A nil note keeps the existing
+// grant's note. With the grant role cap enabled, a role that outranks the
+// user's global role fails with ErrGrantRoleAboveUser.
+func (db *DB) CreateGrant(ctx context.Context, userID, serviceID, grantedBy int64, role string, exp GrantExpiry, note *string) (*Grant, error) {
 	if role == "" {
 		role = "user"
 	}
+	if db.capGrantRoles && RoleRank(role) > 0 {
+		var userRole string
+		if err := db.writer().QueryRow(ctx, `SELECT role FROM users WHERE id = $1`, userID).Scan(&userRole); err != nil {
+			return nil, err
+		}
+		if RoleRank(role) > RoleRank(userRole) {
+			return nil, ErrGrantRoleAboveUser
+		}
+	}
+	g, _, err := upsertGrant(ctx, db.writer(), userID, serviceID, grantedBy, role, exp, note, false)
+	return g, err
+}
+
+// queryRower is a pool or a transaction.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// grantWrite is what upsertGrant did.
+type grantWrite int
+
+const (
+	grantCreated grantWrite = iota
+	grantUpdated
+	grantKept // additive, and the existing grant already gave as much
+)
+
+// upsertGrant is the grant write behind CreateGrant (see there). Additive
+// writes, for grants handed out in bulk or on request rather than set by
+// an admin, only ever add access: a live grant that has an expiry or a role
+// at least as high (by RoleRank) as role is kept as it is, and an updated
+// one keeps its note and expiry. An expired grant is replaced either way.
+// Returns pgx.ErrNoRows if the service doesn't exist.
+func upsertGrant(ctx context.Context, q queryRower, userID, serviceID, grantedBy int64, role string, exp GrantExpiry, note *string, additive bool) (*Grant, grantWrite, error) {
 	var g Grant
-	err := db.Pool.QueryRow(ctx, `
-		INSERT INTO grants (user_id, service_id, role, granted_by)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (user_id, service_id) DO UPDATE SET role = EXCLUDED.role
-		RETURNING id, user_id, service_id, role, granted_by, created_at`,
-		userID, serviceID, role, grantedBy).
-		Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.CreatedAt)
+	var inserted bool
+	err := q.QueryRow(ctx, `
+		INSERT INTO grants (user_id, service_id, role, granted_by, expires_at, note)
+		SELECT $1, s.id, $3, $4,
+			CASE WHEN $7::BOOLEAN THEN $5::TIMESTAMPTZ
+				WHEN s.grant_ttl_days > 0 THEN now() + make_interval(days => s.grant_ttl_days) END,
+			COALESCE($6, '')
+		FROM services s WHERE s.id = $2
+		ON CONFLICT (user_id, service_id) DO UPDATE SET role = EXCLUDED.role,
+			expires_at = CASE WHEN $7::BOOLEAN OR grants.expires_at <= now() THEN EXCLUDED.expires_at ELSE grants.expires_at END,
+			note = CASE WHEN $8::BOOLEAN AND grants.expires_at IS NULL THEN grants.note ELSE COALESCE($6, grants.note) END
+		WHERE NOT $8::BOOLEAN OR grants.expires_at <= now()
+			OR (grants.expires_at IS NULL AND `+roleRankSQL("EXCLUDED.role")+` > `+roleRankSQL("grants.role")+`)
+		RETURNING id, user_id, service_id, role, granted_by, expires_at, note, created_at, xmax = 0`,
+		userID, serviceID, role, grantedBy, exp.At, note, exp.Set, additive).
+		Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.ExpiresAt, &g.Note, &g.CreatedAt, &inserted)
+	if errors.Is(err, pgx.ErrNoRows) && additive {
+		// The conflict update's WHERE kept an existing grant.
+		err = q.QueryRow(ctx, `
+			SELECT id, user_id, service_id, role, granted_by, expires_at, note, created_at
+			FROM grants WHERE user_id = $1 AND service_id = $2`, userID, serviceID).
+			Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.ExpiresAt, &g.Note, &g.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &g, grantKept, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return &g, nil
+	if inserted {
+		return &g, grantCreated, nil
+	}
+	return &g, grantUpdated, nil
+}
+
+// roleRankSQL is RoleRank as SQL over the role in col.
+func roleRankSQL(col string) string {
+	return "CASE " + col + " WHEN 'owner' THEN 2 WHEN 'admin' THEN 1 ELSE 0 END"
 }
 
 func (db *DB) DeleteGrant(ctx context.Context, id int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM grants WHERE id = $1`, id)
+	_, err := db.writer().Exec(ctx, `DELETE FROM grants WHERE id = $1`, id)
 	return err
 }
 
+// GrantServiceID returns the service a grant is for. Returns pgx.ErrNoRows
+// if the grant doesn't exist.
+func (db *DB) GrantServiceID(ctx context.Context, id int64) (int64, error) {
+	var serviceID int64
+	err := db.reader().QueryRow(ctx, `SELECT service_id FROM grants WHERE id = $1`, id).Scan(&serviceID)
+	return serviceID, err
+}
+
 func (db *DB) DeleteGrantByUserService(ctx context.Context, userID, serviceID int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM grants WHERE user_id = $1 AND service_id = $2`, userID, serviceID)
+	_, err := db.writer().Exec(ctx, `DELETE FROM grants WHERE user_id = $1 AND service_id = $2`, userID, serviceID)
 	return err
 }
 
-// GetServiceByHost returns the service whose URL contains the given host.
-// Returns nil (no error) if no service matches.
-func (db *DB) GetServiceByHost(ctx context.Context, host string) (*Service, error) {
+// GetServiceByID returns a service by ID.
+func (db *DB) GetServiceByID(ctx context.Context, id int64) (*Service, error) {
 	var s Service
-	err := db.Pool.QueryRow(ctx, `
-		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at
-		FROM services WHERE url LIKE '%' || $1 || '%'
-		LIMIT 1`, host).
-		Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt)
+	err := scanService(db.reader().QueryRow(ctx, `
+		SELECT `+serviceColumns+` FROM services WHERE id = $1`, id), &s)
 	if err != nil {
 		return nil, err
 	}
 	return &s, nil
 }
 
-// GetUserServiceRole returns the role a user has for a service whose URL
-// contains the given host. For owner/admin users, returns the service's
-// admin_role. For regular users, returns the grant's role.
-func (db *DB) GetUserServiceRole(ctx context.Context, did, host string) (string, error) {
-	var userRole, grantRole, adminRole string
-	err := db.Pool.QueryRow(ctx, `
-		SELECT u.role,
-		       COALESCE(g.role, ''),
-		       COALESCE(s.admin_role, 'admin')
-		FROM user_identities ui
-		JOIN users u ON u.id = ui.user_id
-		LEFT JOIN services s ON s.url LIKE '%' || $2 || '%'
-		LEFT JOIN grants g ON g.user_id = u.id AND g.service_id = s.id
-		WHERE ui.did = $1
-		LIMIT 1`, did, host).Scan(&userRole, &grantRole, &adminRole)
+// ServiceHost returns the lowercased host of a service URL, or "" if it has
+// none.
+func ServiceHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return "", err
+		return ""
 	}
-	if userRole == "owner" || userRole == "admin" {
-		return adminRole, nil
+	return strings.ToLower(u.Hostname())
+}
+
+// ServiceSharingHost returns a service other than excludeID whose URL has
+// the same host as rawURL, or nil if there is none. ForwardAuth resolves a
+// host to a single service, so a second one on the same host is ambiguous.
+func (db *DB) ServiceSharingHost(ctx context.Context, rawURL string, excludeID int64) (*Service, error) {
+	host := ServiceHost(rawURL)
+	if host == "" {
+		return nil, nil
+	}
+	svcs, err := db.ListServices(ctx, "")
+	if err != nil {
+		return nil, err
 	}
-	if grantRole != "" {
-		return grantRole, nil
+	for i := range svcs {
+		if svcs[i].ID != excludeID && ServiceHost(svcs[i].URL) == host {
+			return &svcs[i], nil
+		}
 	}
-	return "", nil
+	return nil, nil
 }
 
-func (db *DB) GrantAllServices(ctx context.Context, userID, grantedBy int64) error {
-	_, err := db.Pool.Exec(ctx, `
-		INSERT INTO grants (user_id, service_id, granted_by)
-		SELECT $1, id, $2 FROM services
-		ON CONFLICT (user_id, service_id) DO NOTHING`, userID, grantedBy)
+// GetServiceByHost returns the service on the given host (see
+// bestHostMatch), whatever the row order; a service never covers other
+// hosts, subdomains included. Returns pgx.ErrNoRows if no service matches.
+// Results are cached when EnableHostCache was called.
+func (db *DB) GetServiceByHost(ctx context.Context, host string) (*Service, error) {
+	var gen uint64
+	if db.hosts != nil {
+		var svc *Service
+		var ok bool
+		if svc, ok, gen = db.hosts.get(host); ok {
+			if svc == nil {
+				return nil, pgx.ErrNoRows
+			}
+			return svc, nil
+		}
+	}
+	// The URL filter only narrows the rows; bestHostMatch decides.
+	rows, err := db.reader().Query(ctx, `
+		SELECT `+serviceColumns+`
+		FROM services WHERE strpos(lower(url), $1) > 0`,
+		ServiceHost("//"+host))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var candidates []Service
+	for rows.Next() {
+		var s Service
+		if err := scanService(rows, &s); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	svc := bestHostMatch(candidates, host)
+	if db.hosts != nil {
+		db.hosts.put(host, svc, gen)
+	}
+	if svc == nil {
+		return nil, pgx.ErrNoRows
+	}
+	return svc, nil
+}
+
+// bestHostMatch picks the service for a forwarded host: only a URL host
+// equal to it, case and port aside, matches — "example.com" covers neither
+// "api.example.com" nor "myexample.com" — and of several the lowest ID
+// wins. Returns nil if none match.
+func bestHostMatch(candidates []Service, host string) *Service {
+	host = ServiceHost("//" + host)
+	var best *Service
+	for i := range candidates {
+		if h := ServiceHost(candidates[i].URL); h == "" || h != host {
+			continue
+		}
+		if best == nil || candidates[i].ID < best.ID {
+			best = &candidates[i]
+		}
+	}
+	return best
+}
+
+// ErrUserInactive is returned by GetUserServiceRole for users who are
+// pending approval or were denied.
+var ErrUserInactive = errors.New("user is not active")
+
+// GetUserServiceRole returns the role a user has for the service
+// GetServiceByHost resolves the given host to. For owner/admin users,
+// returns the service's admin_role ("admin" if no service matches). For
+// regular users, returns the grant's role, or "" without an active grant.
+func (db *DB) GetUserServiceRole(ctx context.Context, did, host string) (string, error) {
+	var userID int64
+	var userRole, userStatus string
+	err := db.reader().QueryRow(ctx, `
+		SELECT u.id, u.role, u.status
+		FROM user_identities ui
+		JOIN users u ON u.id = ui.user_id
+		WHERE ui.did = $1`, did).Scan(&userID, &userRole, &userStatus)
+	if err != nil {
+		return "", err
+	}
+	if userStatus != UserActive {
+		return "", ErrUserInactive
+	}
+
+	svc, err := db.GetServiceByHost(ctx, host)
+	if errors.Is(err, pgx.ErrNoRows) {
+		svc = nil
+	} else if err != nil {
+		return "", err
+	}
+	if userRole == "owner" || userRole == "admin" {
+		if svc == nil {
+			return "admin", nil
+		}
+		return svc.AdminRole, nil
+	}
+	if svc == nil {
+		return "", nil
+	}
+
+	var grantRole string
+	err = db.reader().QueryRow(ctx, `
+		SELECT role FROM grants
+		WHERE user_id = $1 AND service_id = $2 AND (expires_at IS NULL OR expires_at > now())`,
+		userID, svc.ID).Scan(&grantRole)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return grantRole, err
+}
+
+// ReassignGrants moves every grant held by fromID to toID in one transaction.
+// Services the target already has a grant for keep the target's existing
+// grant. Returns the number of grants moved to the target.
+func (db *DB) ReassignGrants(ctx context.Context, fromID, toID, grantedBy int64) (int64, error) {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		INSERT INTO grants (user_id, service_id, role, granted_by, expires_at, note)
+		SELECT $2, service_id, role, $3, expires_at, note FROM grants WHERE user_id = $1
+		ON CONFLICT (user_id, service_id) DO NOTHING`, fromID, toID, grantedBy)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM grants WHERE user_id = $1`, fromID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// GrantCleanup counts dead grants by why they are dead. A grant in more than
+// one category counts in the first: expired, then denied_user, then
+// no_identity.
+type GrantCleanup struct {
+	Expired    int64 `json:"expired"`     // past expires_at
+	DeniedUser int64 `json:"denied_user"` // the user's sign-up was declined
+	NoIdentity int64 `json:"no_identity"` // the user has no identity left to sign in with
+}
+
+// Total is the number of grants across all categories.
+func (g GrantCleanup) Total() int64 {
+	return g.Expired + g.DeniedUser + g.NoIdentity
+}
+
+// deadGrants classifies every grant, with a NULL category for live ones.
+// Users and services cascade to grants, so no grant can reference a missing
+// row; these are the grants that still exist but can never be used.
+const deadGrants = `
+	SELECT g.id, CASE
+		WHEN g.expires_at IS NOT NULL AND g.expires_at <= now() THEN 'expired'
+		WHEN u.status = 'denied' THEN 'denied_user'
+		WHEN NOT EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = g.user_id) THEN 'no_identity'
+	END AS category
+	FROM grants g JOIN users u ON u.id = g.user_id`
+
+// PruneExpiredGrants deletes grants that expired more than maxAge ago.
+func (db *DB) PruneExpiredGrants(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := db.writer().Exec(ctx, `DELETE FROM grants WHERE expires_at < now() - $1::INTERVAL`, maxAge.String())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// CleanupGrants deletes dead grants (see GrantCleanup) and returns how many
+// went, by category. With dryRun it only counts them.
+func (db *DB) CleanupGrants(ctx context.Context, dryRun bool) (GrantCleanup, error) {
+	query := `
+		WITH dead AS (` + deadGrants + `),
+		gone AS (DELETE FROM grants WHERE id IN (SELECT id FROM dead WHERE category IS NOT NULL) RETURNING id)
+		SELECT dead.category, count(*) FROM dead JOIN gone USING (id) GROUP BY dead.category`
+	pool := db.writer()
+	if dryRun {
+		query = `
+		SELECT category, count(*) FROM (` + deadGrants + `) dead
+		WHERE category IS NOT NULL GROUP BY category`
+		pool = db.reader()
+	}
+	var out GrantCleanup
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var n int64
+		if err := rows.Scan(&category, &n); err != nil {
+			return out, err
+		}
+		switch category {
+		case "expired":
+			out.Expired = n
+		case "denied_user":
+			out.DeniedUser = n
+		case "no_identity":
+			out.NoIdentity = n
+		}
+	}
+	return out, rows.Err()
+}
+
+func (db *DB) GrantAllServices(ctx context.Context, userID, grantedBy int64) error {
+	_, err := db.writer().Exec(ctx, `
+		INSERT INTO grants (user_id, service_id, granted_by)
+		SELECT $1, id, $2 FROM services
+		ON CONFLICT (user_id, service_id) DO NOTHING`, userID, grantedBy)
+	return err
+}
+
+// --- Access templates ---
+
+// AccessTemplate is a named set of service grants that can be applied to a
+// user in one step.
+type AccessTemplate struct {
+	ID          int64                 `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Services    []TemplateServiceRole `json:"services"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
+
+// TemplateServiceRole is one service and grant role in an access template.
+// ServiceName is filled in on reads.
+type TemplateServiceRole struct {
+	ServiceID   int64  `json:"service_id"`
+	ServiceName string `json:"service_name,omitempty"`
+	Role        string `json:"role"`
+}
+
+// ErrTemplateNameTaken is returned when another access template already has
+// the name.
+var ErrTemplateNameTaken = errors.New("template name already taken")
+
+// ErrUnknownService is returned when an access template or admin scope
+// names a service that doesn't exist.
+var ErrUnknownService = errors.New("unknown service")
+
+// templateWriteError maps constraint violations from writing a template.
+func templateWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505" && pgErr.ConstraintName == "access_templates_name_key":
+			return ErrTemplateNameTaken
+		case pgErr.Code == "23503":
+			return ErrUnknownService
+		}
+	}
+	return err
+}
+
+// ListAccessTemplates returns every template with its services, by name.
+func (db *DB) ListAccessTemplates(ctx context.Context) ([]AccessTemplate, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT id, name, description, created_at, updated_at
+		FROM access_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var templates []AccessTemplate
+	index := make(map[int64]int)
+	for rows.Next() {
+		var t AccessTemplate
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Services = []TemplateServiceRole{}
+		index[t.ID] = len(templates)
+		templates = append(templates, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.reader().Query(ctx, `
+		SELECT ts.template_id, ts.service_id, s.name, ts.role
+		FROM access_template_services ts
+		JOIN services s ON s.id = ts.service_id
+		ORDER BY s.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var templateID int64
+		var r TemplateServiceRole
+		if err := rows.Scan(&templateID, &r.ServiceID, &r.ServiceName, &r.Role); err != nil {
+			return nil, err
+		}
+		if i, ok := index[templateID]; ok {
+			templates[i].Services = append(templates[i].Services, r)
+		}
+	}
+	return templates, rows.Err()
+}
+
+// GetAccessTemplate returns a template with its services.
+func (db *DB) GetAccessTemplate(ctx context.Context, id int64) (*AccessTemplate, error) {
+	var t AccessTemplate
+	err := db.reader().QueryRow(ctx, `
+		SELECT id, name, description, created_at, updated_at
+		FROM access_templates WHERE id = $1`, id).
+		Scan(&t.ID, &t.Name, &t.Description, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.reader().Query(ctx, `
+		SELECT ts.service_id, s.name, ts.role
+		FROM access_template_services ts
+		JOIN services s ON s.id = ts.service_id
+		WHERE ts.template_id = $1
+		ORDER BY s.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	t.Services = []TemplateServiceRole{}
+	for rows.Next() {
+		var r TemplateServiceRole
+		if err := rows.Scan(&r.ServiceID, &r.ServiceName, &r.Role); err != nil {
+			return nil, err
+		}
+		t.Services = append(t.Services, r)
+	}
+	return &t, rows.Err()
+}
+
+// CreateAccessTemplate stores a template and its services in one
+// transaction.
+func (db *DB) CreateAccessTemplate(ctx context.Context, t AccessTemplate) (*AccessTemplate, error) {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO access_templates (name, description) VALUES ($1, $2)
+		RETURNING id`, t.Name, t.Description).Scan(&id)
+	if err != nil {
+		return nil, templateWriteError(err)
+	}
+	if err := insertTemplateServices(ctx, tx, id, t.Services); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return db.GetAccessTemplate(ctx, id)
+}
+
+// UpdateAccessTemplate replaces a template's name, description, and
+// services in one transaction. Grants already applied from it are not
+// touched. Returns pgx.ErrNoRows if the template doesn't exist.
+func (db *DB) UpdateAccessTemplate(ctx context.Context, id int64, t AccessTemplate) error {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE access_templates SET name = $1, description = $2, updated_at = now()
+		WHERE id = $3`, t.Name, t.Description, id)
+	if err != nil {
+		return templateWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM access_template_services WHERE template_id = $1`, id); err != nil {
+		return err
+	}
+	if err := insertTemplateServices(ctx, tx, id, t.Services); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func insertTemplateServices(ctx context.Context, tx pgx.Tx, templateID int64, services []TemplateServiceRole) error {
+	for _, r := range services {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO access_template_services (template_id, service_id, role)
+			VALUES ($1, $2, $3)`, templateID, r.ServiceID, r.Role)
+		if err != nil {
+			return templateWriteError(err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccessTemplate(ctx context.Context, id int64) error {
+	_, err := db.writer().Exec(ctx, `DELETE FROM access_templates WHERE id = $1`, id)
+	return err
+}
+
+// ApplyAccessTemplate grants a user every service in a template in one
+// transaction: either all grants are written or none are. The grants are
+// additive (see upsertGrant): new ones get the service's default TTL and a
+// note naming the template, a grant that only ranks below the template's
+// role is raised to it, and one with a higher or equal role or with an
+// expiry is left as it is. With the grant role cap enabled, a template role
+// that outranks the user's global role fails the whole apply with
+// ErrGrantRoleAboveUser. Returns how many grants were created, updated, and
+// left unchanged.
+func (db *DB) ApplyAccessTemplate(ctx context.Context, t *AccessTemplate, userID, grantedBy int64) (created, updated, unchanged int, err error) {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userRole string
+	if err := tx.QueryRow(ctx, `SELECT role FROM users WHERE id = $1 FOR SHARE`, userID).Scan(&userRole); err != nil {
+		return 0, 0, 0, err
+	}
+	note := "From template " + t.Name
+	for _, r := range t.Services {
+		if db.capGrantRoles && RoleRank(r.Role) > RoleRank(userRole) {
+			return 0, 0, 0, ErrGrantRoleAboveUser
+		}
+		_, w, err := upsertGrant(ctx, tx, userID, r.ServiceID, grantedBy, r.Role, GrantExpiry{}, &note, true)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue // service deleted since the template was read
+		}
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		switch w {
+		case grantCreated:
+			created++
+		case grantUpdated:
+			updated++
+		case grantKept:
+			unchanged++
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, 0, err
+	}
+	return created, updated, unchanged, nil
+}
+
+// --- Admin scopes ---
+
+// AdminScope reports whether an admin is limited to a set of services, and
+// which. A scoped admin with no services left (all deleted) manages none.
+func (db *DB) AdminScope(ctx context.Context, userID int64) (scoped bool, serviceIDs []int64, err error) {
+	err = db.reader().QueryRow(ctx, `
+		SELECT u.admin_scoped,
+			COALESCE(ARRAY(SELECT service_id FROM admin_scopes WHERE user_id = u.id ORDER BY service_id), '{}')
+		FROM users u WHERE u.id = $1`, userID).Scan(&scoped, &serviceIDs)
+	return scoped, serviceIDs, err
+}
+
+// SetAdminScope limits an admin to serviceIDs, or with scoped false lifts
+// the limit. Returns ErrUnknownService if a service doesn't exist and
+// pgx.ErrNoRows if the user doesn't.
+func (db *DB) SetAdminScope(ctx context.Context, userID int64, scoped bool, serviceIDs []int64) error {
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET admin_scoped = $1, updated_at = now() WHERE id = $2`, scoped, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM admin_scopes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if scoped && len(serviceIDs) > 0 {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO admin_scopes (user_id, service_id)
+			SELECT $1, unnest($2::bigint[]) ON CONFLICT DO NOTHING`, userID, serviceIDs)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrUnknownService
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// --- Usage ---
+
+// ServiceUsage is an aggregated click count for one service on one day.
+// UserID is only set when per-user attribution is enabled.
+type ServiceUsage struct {
+	ServiceID int64  `json:"service_id"`
+	UserID    int64  `json:"user_id,omitempty"`
+	Day       string `json:"day"`
+	Clicks    int64  `json:"clicks"`
+}
+
+// RecordServiceClick increments today's click counter for a service.
+// Pass userID 0 to record an anonymous aggregate click.
+func (db *DB) RecordServiceClick(ctx context.Context, serviceID, userID int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO service_usage (service_id, user_id, day, clicks)
+		VALUES ($1, $2, CURRENT_DATE, 1)
+		ON CONFLICT (service_id, user_id, day) DO UPDATE SET clicks = service_usage.clicks + 1`,
+		serviceID, userID)
+	return err
+}
+
+// ListServiceUsage returns click counts per service and day for the last
+// `days` days. When byUser is false, counts are summed across users.
+func (db *DB) ListServiceUsage(ctx context.Context, days int, byUser bool) ([]ServiceUsage, error) {
+	userCol, groupBy := "0", "service_id, day"
+	if byUser {
+		userCol, groupBy = "user_id", "service_id, user_id, day"
+	}
+	rows, err := db.reader().Query(ctx, `
+		SELECT service_id, `+userCol+`, to_char(day, 'YYYY-MM-DD'), SUM(clicks)::BIGINT
+		FROM service_usage
+		WHERE day > CURRENT_DATE - $1::INT
+		GROUP BY `+groupBy+`
+		ORDER BY day, service_id`, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []ServiceUsage
+	for rows.Next() {
+		var u ServiceUsage
+		if err := rows.Scan(&u.ServiceID, &u.UserID, &u.Day, &u.Clicks); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// --- Audit ---
+
+// RecordAudit appends an entry to the audit log.
+func (db *DB) RecordAudit(ctx context.Context, actor *User, action, targetType, targetID string, detail map[string]any) error {
+	if detail == nil {
+		detail = map[string]any{}
+	}
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	var actorDID, actorHandle string
+	if actor != nil {
+		actorDID, actorHandle = actor.DID, actor.Handle
+	}
+	_, err = db.Pool.Exec(ctx, `
+		INSERT INTO audit_log (actor_did, actor_handle, action, target_type, target_id, detail)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		actorDID, actorHandle, action, targetType, targetID, data)
+	return err
+}
+
+// AuditEntry is a row in the audit_log table.
+type AuditEntry struct {
+	ID          int64           `json:"id"`
+	ActorDID    string          `json:"actor_did"`
+	ActorHandle string          `json:"actor_handle"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    string          `json:"target_id"`
+	Detail      json.RawMessage `json:"detail"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// ListAudit returns up to limit audit entries, newest first, optionally
+// only those with the given action. Pass the last ID of the previous page
+// as after to continue; 0 starts from the newest. Keyset pagination keeps
+// deep pages as cheap as the first.
+func (db *DB) ListAudit(ctx context.Context, action string, after int64, limit int) ([]AuditEntry, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT id, actor_did, actor_handle, action, target_type, target_id, detail, created_at
+		FROM audit_log
+		WHERE ($1 = 0 OR id < $1) AND ($3 = '' OR action = $3)
+		ORDER BY id DESC
+		LIMIT $2`, after, limit, action)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.ActorDID, &e.ActorHandle, &e.Action, &e.TargetType, &e.TargetID, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// --- Access log ---
+
+// AccessEvent is a forwardAuth decision for a service, with the handle of
+// the identity when it is still linked.
+type AccessEvent struct {
+	ID        int64     `json:"id"`
+	ServiceID int64     `json:"service_id"`
+	DID       string    `json:"did"`
+	Handle    string    `json:"handle"`
+	Decision  string    `json:"decision"` // allow, deny, redirect-login, redirect-portal
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AccessRecord is a forwardAuth decision waiting to be written to the
+// access log.
+type AccessRecord struct {
+	ServiceID int64
+	DID       string
+	Decision  string
+	Reason    string
+	At        time.Time // when the decision was made
+}
+
+// RecordAccess appends forwardAuth decisions to the access log in one
+// insert. Decisions for services deleted since are dropped.
+func (db *DB) RecordAccess(ctx context.Context, recs []AccessRecord) error {
+	ids := make([]int64, len(recs))
+	dids := make([]string, len(recs))
+	decisions := make([]string, len(recs))
+	reasons := make([]string, len(recs))
+	ats := make([]time.Time, len(recs))
+	for i, r := range recs {
+		ids[i], dids[i], decisions[i], reasons[i], ats[i] = r.ServiceID, r.DID, r.Decision, r.Reason, r.At
+	}
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO access_log (service_id, did, decision, reason, created_at)
+		SELECT a.service_id, a.did, a.decision, a.reason, a.created_at
+		FROM unnest($1::BIGINT[], $2::TEXT[], $3::TEXT[], $4::TEXT[], $5::TIMESTAMPTZ[])
+			AS a(service_id, did, decision, reason, created_at)
+		JOIN services s ON s.id = a.service_id`,
+		ids, dids, decisions, reasons, ats)
+	return err
+}
+
+// ListAccess returns up to limit access events for a service, newest first,
+// using the same keyset cursor as ListAudit. A non-empty decision filters
+// to that outcome.
+func (db *DB) ListAccess(ctx context.Context, serviceID int64, decision string, after int64, limit int) ([]AccessEvent, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT a.id, a.service_id, a.did, COALESCE(ui.handle, ''), a.decision, a.reason, a.created_at
+		FROM access_log a
+		LEFT JOIN user_identities ui ON ui.did = a.did AND a.did != ''
+		WHERE a.service_id = $1 AND ($2 = '' OR a.decision = $2) AND ($3 = 0 OR a.id < $3)
+		ORDER BY a.id DESC
+		LIMIT $4`, serviceID, decision, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AccessEvent
+	for rows.Next() {
+		var e AccessEvent
+		if err := rows.Scan(&e.ID, &e.ServiceID, &e.DID, &e.Handle, &e.Decision, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// PruneAccess deletes access events older than maxAge and returns how many
+// were removed.
+func (db *DB) PruneAccess(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := db.Pool.Exec(ctx, `DELETE FROM access_log WHERE created_at < now() - $1::INTERVAL`, maxAge.String())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// --- Login events ---
+
+// Results of a sign-in attempt in the login_events table.
+const (
+	LoginSuccess = "success" // signed in
+	LoginDenied  = "denied"  // authenticated, but not let in (blocked, unknown, pending, denied)
+	LoginError   = "error"   // didn't get as far as knowing who it was
+)
+
+// LoginEvent is one sign-in attempt.
+type LoginEvent struct {
+	ID        int64     `json:"id"`
+	DID       string    `json:"did"`
+	Handle    string    `json:"handle"`
+	Result    string    `json:"result"`
+	Reason    string    `json:"reason"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordLoginEvent appends a sign-in attempt to the login trail.
+func (db *DB) RecordLoginEvent(ctx context.Context, did, handle, result, reason, ip string) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO login_events (did, handle, result, reason, ip) VALUES ($1, $2, $3, $4, $5)`,
+		did, handle, result, reason, ip)
+	return err
+}
+
+// ListLoginEvents returns up to limit sign-in attempts, newest first, using
+// the same keyset cursor as ListAudit. Non-empty result, ip, and did filter
+// to exact matches.
+func (db *DB) ListLoginEvents(ctx context.Context, result, ip, did string, after int64, limit int) ([]LoginEvent, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT id, did, handle, result, reason, ip, created_at
+		FROM login_events
+		WHERE ($1 = '' OR result = $1) AND ($2 = '' OR ip = $2) AND ($3 = '' OR did = $3)
+		  AND ($4 = 0 OR id < $4)
+		ORDER BY id DESC
+		LIMIT $5`, result, ip, did, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []LoginEvent
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.ID, &e.DID, &e.Handle, &e.Result, &e.Reason, &e.IP, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// CountDeniedLogins returns how many refused sign-ins came from ip within
+// the last window. It reads the primary so a burst is counted as it
+// happens.
+func (db *DB) CountDeniedLogins(ctx context.Context, ip string, window time.Duration) (int, error) {
+	var n int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT count(*) FROM login_events
+		WHERE ip = $1 AND result = 'denied' AND created_at > now() - $2::INTERVAL`,
+		ip, window.String()).Scan(&n)
+	return n, err
+}
+
+// PruneLoginEvents deletes sign-in attempts older than maxAge and returns
+// how many were removed.
+func (db *DB) PruneLoginEvents(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := db.Pool.Exec(ctx, `DELETE FROM login_events WHERE created_at < now() - $1::INTERVAL`, maxAge.String())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// --- Health history ---
+
+// HealthRecord is one health poller sample of a service.
+type HealthRecord struct {
+	ServiceID int64
+	Alive     bool
+	LatencyMs int64
+	CheckedAt time.Time
+}
+
+// RecordHealthSamples stores one poller run's samples. Samples of services
+// deleted since the run are dropped.
+func (db *DB) RecordHealthSamples(ctx context.Context, recs []HealthRecord) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(recs))
+	alive := make([]bool, len(recs))
+	latency := make([]int64, len(recs))
+	at := make([]time.Time, len(recs))
+	for i, r := range recs {
+		ids[i], alive[i], latency[i], at[i] = r.ServiceID, r.Alive, r.LatencyMs, r.CheckedAt
+	}
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO health_history (service_id, alive, latency_ms, checked_at)
+		SELECT t.service_id, t.alive, t.latency_ms, t.checked_at
+		FROM unnest($1::BIGINT[], $2::BOOLEAN[], $3::BIGINT[], $4::TIMESTAMPTZ[]) AS t(service_id, alive, latency_ms, checked_at)
+		WHERE EXISTS (SELECT 1 FROM services WHERE id = t.service_id)`,
+		ids, alive, latency, at)
+	return err
+}
+
+// RecentHealthSamples returns up to perService of each service's newest
+// samples, oldest first within a service.
+func (db *DB) RecentHealthSamples(ctx context.Context, perService int) ([]HealthRecord, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT service_id, alive, latency_ms, checked_at FROM (
+			SELECT service_id, alive, latency_ms, checked_at,
+				row_number() OVER (PARTITION BY service_id ORDER BY checked_at DESC) AS n
+			FROM health_history
+		) h
+		WHERE n <= $1
+		ORDER BY service_id, checked_at`, perService)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []HealthRecord
+	for rows.Next() {
+		var r HealthRecord
+		if err := rows.Scan(&r.ServiceID, &r.Alive, &r.LatencyMs, &r.CheckedAt); err != nil {
+			return nil, err
+		}
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
+
+// PruneHealthHistory deletes samples older than maxAge and returns how many
+// were removed.
+func (db *DB) PruneHealthHistory(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := db.Pool.Exec(ctx, `DELETE FROM health_history WHERE checked_at < now() - $1::INTERVAL`, maxAge.String())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// --- Sessions ---
+
+// SessionInfo is an active session as shown to admins. The token is never
+// exposed.
+type SessionInfo struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	DID       string    `json:"did"`
+	Handle    string    `json:"handle"`
+	GroupID   string    `json:"group_id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip"`         // client address at sign-in
+	UserAgent string    `json:"user_agent"` // browser at sign-in
+}
+
+// ListSessions returns up to limit unexpired sessions, newest first, using
+// the same keyset cursor as ListAudit.
+func (db *DB) ListSessions(ctx context.Context, after int64, limit int) ([]SessionInfo, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT id, user_id, did, handle, group_id, created_at, last_seen, expires_at, ip, user_agent
+		FROM sessions
+		WHERE expires_at > now() AND ($1 = 0 OR id < $1)
+		ORDER BY id DESC
+		LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []SessionInfo
+	for rows.Next() {
+		var si SessionInfo
+		if err := rows.Scan(&si.ID, &si.UserID, &si.DID, &si.Handle, &si.GroupID, &si.CreatedAt, &si.LastSeen, &si.ExpiresAt, &si.IP, &si.UserAgent); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, si)
+	}
+	return sessions, rows.Err()
+}
+
+// ListUserSessions returns a user's unexpired sessions, newest first.
+func (db *DB) ListUserSessions(ctx context.Context, userID int64) ([]SessionInfo, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT id, user_id, did, handle, group_id, created_at, last_seen, expires_at, ip, user_agent
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > now()
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []SessionInfo
+	for rows.Next() {
+		var si SessionInfo
+		if err := rows.Scan(&si.ID, &si.UserID, &si.DID, &si.Handle, &si.GroupID, &si.CreatedAt, &si.LastSeen, &si.ExpiresAt, &si.IP, &si.UserAgent); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, si)
+	}
+	return sessions, rows.Err()
+}
+
+// OAuthSessionToCheck is a DID with live noknok sessions and its most
+// recent stored OAuth session.
+type OAuthSessionToCheck struct {
+	DID       string
+	SessionID string
+}
+
+// ListOAuthSessionsToCheck returns, for every DID with an unexpired noknok
+// session, the newest OAuth session stored for it.
+func (db *DB) ListOAuthSessionsToCheck(ctx context.Context) ([]OAuthSessionToCheck, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT DISTINCT ON (o.did) o.did, o.session_id
+		FROM oauth_sessions o
+		WHERE o.did IN (SELECT did FROM sessions WHERE expires_at > now())
+		ORDER BY o.did, o.created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []OAuthSessionToCheck
+	for rows.Next() {
+		var o OAuthSessionToCheck
+		if err := rows.Scan(&o.DID, &o.SessionID); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// --- Access requests ---
+
+// AccessRequest is a user asking for a grant to a service.
+type AccessRequest struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	ServiceID   int64      `json:"service_id"`
+	Note        string     `json:"note"`   // the user's reason
+	Status      string     `json:"status"` // RequestPending, RequestApproved, or RequestDenied
+	DecidedBy   *int64     `json:"decided_by"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserHandle  string     `json:"user_handle,omitempty"`
+	ServiceName string     `json:"service_name,omitempty"`
+}
+
+// Access request statuses.
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestDenied   = "denied"
+)
+
+// ErrNotRequestable is returned for access requests to a service that isn't
+// public and enabled, or that the user already has an unexpired grant for.
+var ErrNotRequestable = errors.New("service can't be requested")
+
+// ErrRequestDecided is returned when deciding a request that is no longer
+// pending.
+var ErrRequestDecided = errors.New("access request was already decided")
+
+// ErrRequestPending is returned when the user already has a pending request
+// for the service.
+var ErrRequestPending = errors.New("access already requested")
+
+const accessRequestColumns = `r.id, r.user_id, r.service_id, r.note, r.status, r.decided_by, r.decided_at, r.created_at,
+	COALESCE(pi.handle, ''), s.name`
+
+func scanAccessRequest(row rowScanner, r *AccessRequest) error {
+	return row.Scan(&r.ID, &r.UserID, &r.ServiceID, &r.Note, &r.Status, &r.DecidedBy, &r.DecidedAt, &r.CreatedAt,
+		&r.UserHandle, &r.ServiceName)
+}
+
+// CreateAccessRequest records a user's request for a public, enabled service
+// they have no unexpired grant for.
+func (db *DB) CreateAccessRequest(ctx context.Context, userID, serviceID int64, note string) (*AccessRequest, error) {
+	var r AccessRequest
+	err := scanAccessRequest(db.writer().QueryRow(ctx, `
+		WITH r AS (
+			INSERT INTO access_requests (user_id, service_id, note)
+			SELECT $1, s.id, $3 FROM services s
+			WHERE s.id = $2 AND s.public AND s.enabled AND NOT EXISTS (
+				SELECT 1 FROM grants g
+				WHERE g.user_id = $1 AND g.service_id = s.id AND (g.expires_at IS NULL OR g.expires_at > now()))
+			RETURNING *
+		)
+		SELECT `+accessRequestColumns+`
+		FROM r
+		LEFT JOIN user_identities pi ON pi.user_id = r.user_id AND pi.is_primary = true
+		JOIN services s ON s.id = r.service_id`, userID, serviceID, note), &r)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotRequestable
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_access_requests_pending" {
+		return nil, ErrRequestPending
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListAccessRequests returns access requests with the given status (all of
+// them when empty), oldest first.
+func (db *DB) ListAccessRequests(ctx context.Context, status string) ([]AccessRequest, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT `+accessRequestColumns+`
+		FROM access_requests r
+		LEFT JOIN user_identities pi ON pi.user_id = r.user_id AND pi.is_primary = true
+		JOIN services s ON s.id = r.service_id
+		WHERE $1 = '' OR r.status = $1
+		ORDER BY r.created_at`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reqs []AccessRequest
+	for rows.Next() {
+		var r AccessRequest
+		if err := scanAccessRequest(rows, &r); err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, rows.Err()
+}
+
+// GetAccessRequest returns one access request. Returns pgx.ErrNoRows if it
+// doesn't exist.
+func (db *DB) GetAccessRequest(ctx context.Context, id int64) (*AccessRequest, error) {
+	var r AccessRequest
+	err := scanAccessRequest(db.reader().QueryRow(ctx, `
+		SELECT `+accessRequestColumns+`
+		FROM access_requests r
+		LEFT JOIN user_identities pi ON pi.user_id = r.user_id AND pi.is_primary = true
+		JOIN services s ON s.id = r.service_id
+		WHERE r.id = $1`, id), &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DecideAccessRequest approves or denies a pending request. Returns
+// ErrRequestDecided if it is no longer pending (or gone).
+func (db *DB) DecideAccessRequest(ctx context.Context, id int64, status string, decidedBy int64) error {
+	result, err := db.writer().Exec(ctx, `
+		UPDATE access_requests SET status = $2, decided_by = $3, decided_at = now()
+		WHERE id = $1 AND status = 'pending'`, id, status, decidedBy)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRequestDecided
+	}
+	return nil
+}
+
+// ApproveAccessRequest marks a pending request approved and grants the user
+// the service with the given role, in one transaction. The grant is
+// additive, as with ApplyAccessTemplate: a permanent grant with an equal or
+// higher role, or an unexpired expiring one, is kept as it is. The
+// requester's reason becomes a new grant's note. Returns ErrRequestDecided
+// if the request is no longer pending (or gone) and ErrGrantRoleAboveUser
+// as CreateGrant does.
+func (db *DB) ApproveAccessRequest(ctx context.Context, id int64, role string, decidedBy int64) (*Grant, error) {
+	if role == "" {
+		role = "user"
+	}
+	tx, err := db.writer().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID, serviceID int64
+	var note string
+	err = tx.QueryRow(ctx, `
+		UPDATE access_requests SET status = 'approved', decided_by = $2, decided_at = now()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING user_id, service_id, note`, id, decidedBy).Scan(&userID, &serviceID, &note)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRequestDecided
+	}
+	if err != nil {
+		return nil, err
+	}
+	if db.capGrantRoles && RoleRank(role) > 0 {
+		var userRole string
+		if err := tx.QueryRow(ctx, `SELECT role FROM users WHERE id = $1 FOR SHARE`, userID).Scan(&userRole); err != nil {
+			return nil, err
+		}
+		if RoleRank(role) > RoleRank(userRole) {
+			return nil, ErrGrantRoleAboveUser
+		}
+	}
+	if note == "" {
+		note = "Access request"
+	}
+	g, _, err := upsertGrant(ctx, tx, userID, serviceID, decidedBy, role, GrantExpiry{}, &note, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// PendingRequestServices returns the services a user has pending requests
+// for.
+func (db *DB) PendingRequestServices(ctx context.Context, userID int64) (map[int64]bool, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT service_id FROM access_requests WHERE user_id = $1 AND status = 'pending'`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		pending[id] = true
+	}
+	return pending, rows.Err()
+}
+
+// --- Blocked DIDs ---
+
+// BlockedDID is a DID banned from signing in, regardless of user records.
+type BlockedDID struct {
+	DID       string    `json:"did"`
+	Reason    string    `json:"reason"`
+	BlockedBy *int64    `json:"blocked_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) ListBlockedDIDs(ctx context.Context) ([]BlockedDID, error) {
+	rows, err := db.reader().Query(ctx, `
+		SELECT did, reason, blocked_by, created_at FROM blocked_dids ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []BlockedDID
+	for rows.Next() {
+		var b BlockedDID
+		if err := rows.Scan(&b.DID, &b.Reason, &b.BlockedBy, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+// IsDIDBlocked reports whether a DID is on the block list. Callers must
+// refuse the DID when it returns an error: a lookup that failed can't vouch
+// that it isn't blocked.
+func (db *DB) IsDIDBlocked(ctx context.Context, did string) (bool, error) {
+	var blocked bool
+	err := db.reader().QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM blocked_dids WHERE did = $1)`, did).Scan(&blocked)
+	return blocked, err
+}
+
+// BlockDID adds a DID to the block list, updating the reason if already blocked.
+func (db *DB) BlockDID(ctx context.Context, did, reason string, blockedBy int64) (*BlockedDID, error) {
+	var b BlockedDID
+	err := db.writer().QueryRow(ctx, `
+		INSERT INTO blocked_dids (did, reason, blocked_by) VALUES ($1, $2, $3)
+		ON CONFLICT (did) DO UPDATE SET reason = EXCLUDED.reason
+		RETURNING did, reason, blocked_by, created_at`,
+		did, reason, blockedBy).Scan(&b.DID, &b.Reason, &b.BlockedBy, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// UnblockDID removes a DID from the block list.
+func (db *DB) UnblockDID(ctx context.Context, did string) error {
+	_, err := db.writer().Exec(ctx, `DELETE FROM blocked_dids WHERE did = $1`, did)
 	return err
 }
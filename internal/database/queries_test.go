@@ -0,0 +1,301 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Anonymous pages list a service only when it is both enabled and public.
+func TestListPublicServicesMatrix(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	tests := []struct {
+		enabled, public, listed bool
+	}{
+		{enabled: true, public: true, listed: true},
+		{enabled: true, public: false, listed: false},
+		{enabled: false, public: true, listed: false},
+		{enabled: false, public: false, listed: false},
+	}
+	ids := make([]int64, len(tests))
+	for i, tt := range tests {
+		svc := testService(t, db, "")
+		// Services start enabled and not public.
+		if !tt.enabled {
+			if _, err := db.ToggleServiceEnabled(ctx, svc.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.public {
+			if _, err := db.ToggleServicePublic(ctx, svc.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids[i] = svc.ID
+	}
+
+	svcs, err := db.ListPublicServices(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[int64]bool)
+	for _, svc := range svcs {
+		listed[svc.ID] = true
+		if !svc.Enabled || !svc.Public {
+			t.Errorf("%s listed with enabled=%v public=%v", svc.Slug, svc.Enabled, svc.Public)
+		}
+	}
+	for i, tt := range tests {
+		if listed[ids[i]] != tt.listed {
+			t.Errorf("enabled=%v public=%v: listed %v, want %v", tt.enabled, tt.public, listed[ids[i]], tt.listed)
+		}
+	}
+}
+
+// A grant keeps its expiry through role and note edits; only an explicit
+// GrantExpiry changes it, and Set with a nil At makes it permanent even on
+// a service with a default TTL.
+func TestCreateGrantExpiry(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	user := testUser(t, db, "user")
+	name := randomName(t)
+	svc, err := db.CreateService(ctx, Service{Slug: name, Name: name, URL: "https://" + name + ".test", GrantTTLDays: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DeleteService(context.Background(), svc.ID) })
+
+	grant := func(role string, exp GrantExpiry, note *string) *Grant {
+		t.Helper()
+		g, err := db.CreateGrant(ctx, user.ID, svc.ID, user.ID, role, exp, note)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	note := "on call"
+
+	g := grant("user", GrantExpiry{}, nil)
+	if g.ExpiresAt == nil || g.ExpiresAt.Sub(time.Now()) < 6*24*time.Hour {
+		t.Fatalf("new grant expires %v, want the service's 7-day default", g.ExpiresAt)
+	}
+	def := *g.ExpiresAt
+
+	if g = grant("admin", GrantExpiry{}, &note); g.ExpiresAt == nil || !g.ExpiresAt.Equal(def) {
+		t.Fatalf("role and note edit changed the expiry to %v, want %v", g.ExpiresAt, def)
+	}
+	if g = grant("admin", GrantExpiry{Set: true}, nil); g.ExpiresAt != nil {
+		t.Fatalf("no expiry: grant expires %v, want never", g.ExpiresAt)
+	}
+	if g = grant("user", GrantExpiry{}, nil); g.ExpiresAt != nil {
+		t.Fatalf("role edit of a permanent grant made it expire %v", g.ExpiresAt)
+	}
+	if g.Note != note {
+		t.Fatalf("note %q, want %q kept", g.Note, note)
+	}
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	if g = grant("user", GrantExpiry{Set: true, At: &at}, nil); g.ExpiresAt == nil || !g.ExpiresAt.Equal(at) {
+		t.Fatalf("explicit expiry: grant expires %v, want %v", g.ExpiresAt, at)
+	}
+}
+
+// Applying a template only adds access: it raises lower permanent grants,
+// replaces expired ones, and leaves higher or expiring grants alone.
+func TestApplyAccessTemplateAdditive(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	owner := testUser(t, db, "owner")
+	user := testUser(t, db, "owner")
+	note := "hand-made"
+	grant := func(svc *Service, role string, exp GrantExpiry) {
+		t.Helper()
+		if _, err := db.CreateGrant(ctx, user.ID, svc.ID, owner.ID, role, exp, &note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	soon := time.Now().Add(time.Hour).Truncate(time.Second)
+	past := time.Now().Add(-time.Hour)
+
+	fresh, lower, higher, expiring, expired := testService(t, db, ""), testService(t, db, ""), testService(t, db, ""), testService(t, db, ""), testService(t, db, "")
+	grant(lower, "user", GrantExpiry{Set: true})
+	grant(higher, "owner", GrantExpiry{Set: true})
+	grant(expiring, "user", GrantExpiry{Set: true, At: &soon})
+	grant(expired, "owner", GrantExpiry{Set: true, At: &past})
+
+	tpl := &AccessTemplate{Name: "staff"}
+	for _, svc := range []*Service{fresh, lower, higher, expiring, expired} {
+		tpl.Services = append(tpl.Services, TemplateServiceRole{ServiceID: svc.ID, Role: "admin"})
+	}
+	created, updated, unchanged, err := db.ApplyAccessTemplate(ctx, tpl, user.ID, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 || updated != 2 || unchanged != 2 {
+		t.Errorf("created %d, updated %d, unchanged %d; want 1, 2, 2", created, updated, unchanged)
+	}
+
+	grants, err := db.ListUserGrants(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int64]Grant)
+	for _, g := range grants {
+		got[g.ServiceID] = g
+	}
+	for _, tt := range []struct {
+		name    string
+		svc     *Service
+		role    string
+		note    string
+		expires *time.Time
+	}{
+		{"fresh", fresh, "admin", "From template staff", nil},
+		{"lower", lower, "admin", note, nil},
+		{"higher", higher, "owner", note, nil},
+		{"expiring", expiring, "user", note, &soon},
+		{"expired", expired, "admin", "From template staff", nil},
+	} {
+		g, ok := got[tt.svc.ID]
+		switch {
+		case !ok:
+			t.Errorf("%s: no grant", tt.name)
+		case g.Role != tt.role || g.Note != tt.note:
+			t.Errorf("%s: role %q note %q, want %q %q", tt.name, g.Role, g.Note, tt.role, tt.note)
+		case (g.ExpiresAt == nil) != (tt.expires == nil) || tt.expires != nil && !g.ExpiresAt.Equal(*tt.expires):
+			t.Errorf("%s: expires %v, want %v", tt.name, g.ExpiresAt, tt.expires)
+		}
+	}
+}
+
+// Approving an access request grants the service and decides the request
+// together, and never cuts back a grant the user got in the meantime.
+func TestApproveAccessRequest(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	owner := testUser(t, db, "owner")
+	user := testUser(t, db, "owner")
+	request := func(svc *Service) *AccessRequest {
+		t.Helper()
+		if _, err := db.ToggleServicePublic(ctx, svc.ID); err != nil {
+			t.Fatal(err)
+		}
+		r, err := db.CreateAccessRequest(ctx, user.ID, svc.ID, "need it")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	fresh, granted := testService(t, db, ""), testService(t, db, "")
+	freshReq, grantedReq := request(fresh), request(granted)
+	note := "hand-made"
+	if _, err := db.CreateGrant(ctx, user.ID, granted.ID, owner.ID, "owner", GrantExpiry{Set: true}, &note); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := db.ApproveAccessRequest(ctx, freshReq.ID, "admin", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Role != "admin" || g.Note != "need it" {
+		t.Errorf("new grant: role %q note %q, want admin and the request's reason", g.Role, g.Note)
+	}
+	if r, err := db.GetAccessRequest(ctx, freshReq.ID); err != nil || r.Status != RequestApproved {
+		t.Errorf("request after approval: %+v, %v; want approved", r, err)
+	}
+	if _, err := db.ApproveAccessRequest(ctx, freshReq.ID, "admin", owner.ID); !errors.Is(err, ErrRequestDecided) {
+		t.Errorf("second approval: err %v, want ErrRequestDecided", err)
+	}
+
+	g, err = db.ApproveAccessRequest(ctx, grantedReq.ID, "user", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Role != "owner" || g.Note != note || g.ExpiresAt != nil {
+		t.Errorf("existing grant: role %q note %q expires %v, want it kept", g.Role, g.Note, g.ExpiresAt)
+	}
+}
+
+// Only a service on exactly the host matches, whatever the order;
+// subdomains and parent domains don't.
+func TestBestHostMatch(t *testing.T) {
+	svcs := []Service{
+		{ID: 1, URL: "https://example.com"},
+		{ID: 2, URL: "https://api.example.com:8443/v1"},
+		{ID: 3, URL: "https://myexample.com"},
+		{ID: 4, URL: "https://example.com.evil.test"},
+		{ID: 5, URL: "https://other.test/example.com"},
+		{ID: 6, URL: "https://API.example.com"},
+	}
+	reversed := slices.Clone(svcs)
+	slices.Reverse(reversed)
+	for _, tc := range []struct {
+		host string
+		want int64
+	}{
+		{"example.com", 1},
+		{"EXAMPLE.com:443", 1},
+		{"api.example.com", 2},
+		{"v2.api.example.com", 0},
+		{"www.example.com", 0},
+		{"myexample.com", 3},
+		{"evil.test", 0},
+		{"other.test", 5},
+		{"com", 0},
+		{"", 0},
+	} {
+		for _, candidates := range [][]Service{svcs, reversed} {
+			var got int64
+			if s := bestHostMatch(candidates, tc.host); s != nil {
+				got = s.ID
+			}
+			if got != tc.want {
+				t.Errorf("%q: got service %d, want %d", tc.host, got, tc.want)
+			}
+		}
+	}
+}
+
+// GetServiceByHost finds the service on exactly the host and skips
+// services on its subdomains, parent domains, or whose URL merely contains
+// it.
+func TestGetServiceByHostOverlap(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	name := randomName(t)
+	create := func(slug, url string) *Service {
+		t.Helper()
+		svc, err := db.CreateService(ctx, Service{Slug: slug, Name: slug, URL: url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.DeleteService(context.Background(), svc.ID) })
+		return svc
+	}
+	parent := create(name, "https://"+name+".test")
+	api := create(name+"-api", "https://api."+name+".test")
+	create(name+"-my", "https://my"+name+".test")
+
+	for host, want := range map[string]*Service{
+		name + ".test":          parent,
+		"api." + name + ".test": api,
+	} {
+		got, err := db.GetServiceByHost(ctx, host)
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if got.ID != want.ID {
+			t.Errorf("%s: got %s, want %s", host, got.Slug, want.Slug)
+		}
+	}
+	for _, host := range []string{"y" + name + ".test", "www." + name + ".test", "v2.api." + name + ".test"} {
+		if _, err := db.GetServiceByHost(ctx, host); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("%s: err %v, want no service", host, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Replica states, as reported by ReplicaState.
+const (
+	ReplicaNone        = "none" // no DB_REPLICA_DSN; everything uses the primary
+	ReplicaOK          = "ok"
+	ReplicaLagging     = "lagging"
+	ReplicaUnavailable = "unavailable"
+)
+
+// replicaCheckInterval is how often the replica's lag is measured, at
+// most: with a shorter maxLag it is measured every maxLag.
+const replicaCheckInterval = 10 * time.Second
+
+// replicaLagQuery returns how far the replica's replay trails the primary,
+// in seconds. A replica that has replayed everything it received is caught
+// up however old its last transaction is (an idle primary sends nothing).
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN pg_last_wal_receive_lsn() IS NOT DISTINCT FROM pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+// replica is a read-only pool that queries are routed to while it keeps up.
+type replica struct {
+	pool      *pgxpool.Pool
+	maxLag    time.Duration
+	interval  time.Duration // between lag checks
+	lastWrite atomic.Int64  // unix nanos of this process's last access-affecting write
+	state     atomic.Value  // ReplicaOK, ReplicaLagging, or ReplicaUnavailable
+	stop      chan struct{}
+}
+
+// AttachReplica connects to a read replica and routes read-only queries
+// (session validation, role resolution, listings) to it. maxLag bounds how
+// stale those reads may be: for maxLag after this process changes users,
+// identities, services, grants, blocks, or sessions, reads stay on the
+// primary so the change is visible at once, and so do all reads while the
+// replica reports more lag than maxLag or doesn't answer.
+//
+// Only this process's writes are tracked. A change made by another instance
+// (or straight in the database) — a revoked session, a removed grant, a
+// blocked user — can go unseen by session validation and role resolution
+// here for up to twice maxLag: the replica may trail by maxLag when its lag
+// is measured and by up to one check interval (at most maxLag) more before
+// the next measurement sends reads back to the primary.
+func (db *DB) AttachReplica(ctx context.Context, dsn string, maxLag time.Duration) error {
+	pool, err := connect(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("replica: %w", err)
+	}
+	r := &replica{pool: pool, maxLag: maxLag, interval: min(maxLag, replicaCheckInterval), stop: make(chan struct{})}
+	r.check()
+	db.replica = r
+	go r.monitor()
+	return nil
+}
+
+// reader returns the pool for a read-only query: the replica if there is
+// one, it is keeping up, and this process hasn't just written.
+func (db *DB) reader() *pgxpool.Pool {
+	r := db.replica
+	if r == nil || r.state.Load() != ReplicaOK {
+		return db.Pool
+	}
+	if time.Since(time.Unix(0, r.lastWrite.Load())) < r.maxLag {
+		return db.Pool
+	}
+	return r.pool
+}
+
+// writer returns the primary pool for a write that changes who may access
+// what, and keeps reads on the primary long enough to see it.
+func (db *DB) writer() *pgxpool.Pool {
+	db.MarkWrite()
+	return db.Pool
+}
+
+// ReadPool returns the pool for read-only queries made outside this package
+// (session validation).
+func (db *DB) ReadPool() *pgxpool.Pool {
+	return db.reader()
+}
+
+// MarkWrite notes an access-affecting write made outside this package
+// (sessions created or destroyed), so reads stay on the primary for a
+// while. Logging writes (usage, audit, access log) don't count.
+func (db *DB) MarkWrite() {
+	if db.replica != nil {
+		db.replica.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+// ReplicaState reports the read replica's state: none, ok, lagging, or
+// unavailable.
+func (db *DB) ReplicaState() string {
+	if db.replica == nil {
+		return ReplicaNone
+	}
+	return db.replica.state.Load().(string)
+}
+
+func (r *replica) monitor() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// check measures the replica's lag and logs state changes.
+func (r *replica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var lag float64
+	err := r.pool.QueryRow(ctx, replicaLagQuery).Scan(&lag)
+	state := ReplicaOK
+	switch {
+	case err != nil:
+		state = ReplicaUnavailable
+	case lag > r.maxLag.Seconds():
+		state = ReplicaLagging
+	}
+	prev := r.state.Swap(state)
+	if prev == state {
+		return
+	}
+	switch state {
+	case ReplicaOK:
+		if prev != nil {
+			slog.Info("read replica caught up; routing reads to it")
+		}
+	case ReplicaLagging:
+		slog.Warn("read replica lagging; routing reads to the primary", "lag_seconds", lag, "max_lag", r.maxLag)
+	default:
+		slog.Warn("read replica unavailable; routing reads to the primary", "error", err)
+	}
+}
+
+func (r *replica) close() {
+	close(r.stop)
+	r.pool.Close()
+}
@@ -16,6 +16,27 @@ ALTER TABLE sessions ADD COLUMN IF NOT EXISTS username TEXT NOT NULL DEFAULT '';
 ALTER TABLE sessions ADD COLUMN IF NOT EXISTS group_id TEXT NOT NULL DEFAULT '';
 CREATE INDEX IF NOT EXISTS idx_sessions_group_id ON sessions (group_id) WHERE group_id != '';
 ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS prev_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_sessions_prev_token ON sessions (prev_token) WHERE prev_token != '';
+-- Sessions that exist when the column is added count as authenticated when
+-- they were created, not at migration time, so require_reauth_max_age
+-- still applies to them.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_at TIMESTAMPTZ;
+UPDATE sessions SET auth_at = created_at WHERE auth_at IS NULL;
+ALTER TABLE sessions ALTER COLUMN auth_at SET DEFAULT now();
+ALTER TABLE sessions ALTER COLUMN auth_at SET NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS group_created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS session_domain_identities (
+    group_id   TEXT NOT NULL,
+    domain     TEXT NOT NULL,
+    did        TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (group_id, domain)
+);
 
 CREATE TABLE IF NOT EXISTS users (
     id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -26,6 +47,12 @@ CREATE TABLE IF NOT EXISTS users (
 );
 ALTER TABLE users ADD COLUMN IF NOT EXISTS username TEXT NOT NULL DEFAULT '';
 CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_nonempty ON users (username) WHERE username != '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS admin_scoped BOOLEAN NOT NULL DEFAULT false;
+-- Users that exist when the column is added get the epoch, so only users
+-- who really have never signed in are NULL.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ DEFAULT 'epoch';
+ALTER TABLE users ALTER COLUMN last_login_at DROP DEFAULT;
 
 CREATE TABLE IF NOT EXISTS user_identities (
     id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -50,6 +77,26 @@ CREATE TABLE IF NOT EXISTS services (
 ALTER TABLE services ADD COLUMN IF NOT EXISTS admin_role TEXT NOT NULL DEFAULT 'admin';
 ALTER TABLE services ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true;
 ALTER TABLE services ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS grant_ttl_days INT NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS require_reauth_max_age INT NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS deny_message TEXT NOT NULL DEFAULT '';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS skip_health_check BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS health_head_only BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS health_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS health_method TEXT NOT NULL DEFAULT 'HEAD';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS health_expect_status INTEGER NOT NULL DEFAULT 0;
+-- Probes used to skip certificate checks for every service: existing
+-- services keep that, new ones verify.
+ALTER TABLE services ADD COLUMN IF NOT EXISTS skip_tls_verify BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE services ALTER COLUMN skip_tls_verify SET DEFAULT false;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS health_type TEXT NOT NULL DEFAULT 'http';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS issue_token BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS health_override TEXT NOT NULL DEFAULT 'auto';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT '';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS sort_order INT NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS icon_data BYTEA;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS icon_mime TEXT NOT NULL DEFAULT '';
 
 CREATE TABLE IF NOT EXISTS grants (
     id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -61,6 +108,41 @@ CREATE TABLE IF NOT EXISTS grants (
     UNIQUE(user_id, service_id)
 );
 ALTER TABLE grants ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE grants ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE grants ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS access_requests (
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    service_id BIGINT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    note       TEXT NOT NULL DEFAULT '',
+    status     TEXT NOT NULL DEFAULT 'pending',
+    decided_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_requests_pending ON access_requests (user_id, service_id) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS access_templates (
+    id          BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name        TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS access_template_services (
+    template_id BIGINT NOT NULL REFERENCES access_templates(id) ON DELETE CASCADE,
+    service_id  BIGINT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    role        TEXT NOT NULL DEFAULT 'user',
+    PRIMARY KEY (template_id, service_id)
+);
+
+CREATE TABLE IF NOT EXISTS admin_scopes (
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    service_id BIGINT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, service_id)
+);
 
 CREATE TABLE IF NOT EXISTS oauth_requests (
     state      TEXT PRIMARY KEY,
@@ -75,4 +157,63 @@ CREATE TABLE IF NOT EXISTS oauth_sessions (
     created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
     PRIMARY KEY (did, session_id)
 );
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    actor_did    TEXT NOT NULL DEFAULT '',
+    actor_handle TEXT NOT NULL DEFAULT '',
+    action       TEXT NOT NULL,
+    target_type  TEXT NOT NULL DEFAULT '',
+    target_id    TEXT NOT NULL DEFAULT '',
+    detail       JSONB NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+CREATE TABLE IF NOT EXISTS service_usage (
+    service_id BIGINT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    user_id    BIGINT NOT NULL DEFAULT 0,
+    day        DATE NOT NULL DEFAULT CURRENT_DATE,
+    clicks     BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (service_id, user_id, day)
+);
+
+CREATE TABLE IF NOT EXISTS access_log (
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    service_id BIGINT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    did        TEXT NOT NULL DEFAULT '',
+    decision   TEXT NOT NULL,
+    reason     TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_access_log_service_id ON access_log (service_id, id);
+CREATE INDEX IF NOT EXISTS idx_access_log_created_at ON access_log (created_at);
+
+CREATE TABLE IF NOT EXISTS login_events (
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    did        TEXT NOT NULL DEFAULT '',
+    handle     TEXT NOT NULL DEFAULT '',
+    result     TEXT NOT NULL,
+    reason     TEXT NOT NULL DEFAULT '',
+    ip         TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_login_events_denied_ip ON login_events (ip, created_at) WHERE result = 'denied';
+
+CREATE TABLE IF NOT EXISTS health_history (
+    service_id BIGINT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    alive      BOOLEAN NOT NULL,
+    latency_ms INTEGER NOT NULL DEFAULT 0,
+    checked_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_health_history_service ON health_history (service_id, checked_at);
+CREATE INDEX IF NOT EXISTS idx_health_history_checked_at ON health_history (checked_at);
+
+CREATE TABLE IF NOT EXISTS blocked_dids (
+    did        TEXT PRIMARY KEY,
+    reason     TEXT NOT NULL DEFAULT '',
+    blocked_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
 `
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// requestableService is a public service the portal offers a non-admin to
+// request access to.
+type requestableService struct {
+	database.Service
+	Pending bool // the user already asked and is waiting for an admin
+}
+
+// requestableServices returns the public services on the request's cookie
+// domain that the user isn't granted (granted being what the portal lists).
+// A failed lookup is logged and offers nothing.
+func (s *Server) requestableServices(c echo.Context, userID int64, granted []database.Service) []requestableService {
+	ctx := c.Request().Context()
+	public, err := s.db.ListPublicServices(ctx, s.requestDomain(c))
+	if err != nil {
+		slog.Warn("portal: failed to load requestable services", "error", err)
+		return nil
+	}
+	pending, err := s.db.PendingRequestServices(ctx, userID)
+	if err != nil {
+		slog.Warn("portal: failed to load access requests", "error", err)
+		return nil
+	}
+	has := make(map[int64]bool, len(granted))
+	for _, svc := range granted {
+		has[svc.ID] = true
+	}
+	var out []requestableService
+	for _, svc := range public {
+		if !has[svc.ID] {
+			out = append(out, requestableService{Service: svc, Pending: pending[svc.ID]})
+		}
+	}
+	return out
+}
+
+// handleRequestAccess records the signed-in user's request for a grant to a
+// public service (form values id and an optional note) and notifies admins.
+// POST /request-access
+func (s *Server) handleRequestAccess(c echo.Context) error {
+	sess, ok := s.currentSession(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	ctx := c.Request().Context()
+	user, err := s.db.GetUserByIdentityDID(ctx, sess.DID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
+	}
+
+	serviceID, err := strconv.ParseInt(c.FormValue("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	note := strings.TrimSpace(c.FormValue("note"))
+	if utf8.RuneCountInString(note) > maxGrantNote {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("note must be at most %d characters", maxGrantNote)})
+	}
+
+	req, err := s.db.CreateAccessRequest(ctx, user.ID, serviceID, note)
+	if errors.Is(err, database.ErrNotRequestable) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, database.ErrRequestPending) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		slog.Error("access request failed", "user_id", user.ID, "service_id", serviceID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to request access"})
+	}
+
+	if err := s.db.RecordAudit(ctx, user, "access_request.create", "access_request", strconv.FormatInt(req.ID, 10),
+		map[string]any{"service_id": serviceID, "note": note}); err != nil {
+		slog.Warn("audit record failed", "action", "access_request.create", "error", err)
+	}
+	s.notifyAccessRequest(req, sess.DID)
+	return c.JSON(http.StatusCreated, req)
+}
+
+// notifyAccessRequest tells admins about a new access request: in the log,
+// and with ACCESS_REQUEST_WEBHOOK set, as a JSON POST to it. The webhook is
+// sent in the background; a failure is logged.
+func (s *Server) notifyAccessRequest(req *database.AccessRequest, did string) {
+	slog.Info("access requested", "request_id", req.ID, "handle", req.UserHandle, "did", did, "service", req.ServiceName)
+	if s.cfg.AccessRequestWebhook == "" {
+		return
+	}
+	body, err := s.accessRequestNotice("access_request", req, did)
+	if err != nil {
+		slog.Warn("access request webhook: encode failed", "error", err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		if status, err := s.postWebhook(ctx, body); err != nil {
+			slog.Warn("access request webhook failed", "request_id", req.ID, "error", err)
+		} else if status >= 300 {
+			slog.Warn("access request webhook failed", "request_id", req.ID, "status", status)
+		}
+	}()
+}
+
+// webhookTimeout bounds one ACCESS_REQUEST_WEBHOOK delivery.
+const webhookTimeout = 10 * time.Second
+
+// accessRequestNotice encodes the webhook body for an access request.
+func (s *Server) accessRequestNotice(event string, req *database.AccessRequest, did string) ([]byte, error) {
+	who := req.UserHandle
+	if who == "" {
+		who = did
+	}
+	// "text" makes the notice readable as-is in Slack-compatible chats.
+	return json.Marshal(map[string]any{
+		"event":     event,
+		"text":      fmt.Sprintf("%s requested access to %s", who, req.ServiceName),
+		"request":   req,
+		"did":       did,
+		"admin_url": s.cfg.PublicURL + "/?admin&tab=access",
+	})
+}
+
+// webhookSignatureHeader carries the HMAC-SHA256 of a webhook body, keyed
+// with ACCESS_REQUEST_WEBHOOK_SECRET, as "sha256=<hex>".
+const webhookSignatureHeader = "X-Noknok-Signature"
+
+// postWebhook POSTs body to ACCESS_REQUEST_WEBHOOK, signed when a secret is
+// set, and returns the response status.
+func (s *Server) postWebhook(ctx context.Context, body []byte) (int, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.AccessRequestWebhook, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if s.cfg.WebhookSecret != "" {
+		r.Header.Set(webhookSignatureHeader, webhookSignature(s.cfg.WebhookSecret, body))
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// webhookSignature returns the X-Noknok-Signature value for body.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleTestWebhook sends ACCESS_REQUEST_WEBHOOK a sample access request,
+// marked with event "test", and reports how the endpoint answered. Owners
+// only, as the webhook is instance configuration.
+// POST /admin/api/webhooks/test
+func (s *Server) handleTestWebhook(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+	if s.cfg.AccessRequestWebhook == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "ACCESS_REQUEST_WEBHOOK is not set"})
+	}
+
+	now := time.Now()
+	sample := &database.AccessRequest{
+		UserID:      caller.ID,
+		UserHandle:  caller.Handle,
+		ServiceName: "Example service",
+		Note:        "Test notice sent from the admin panel",
+		Status:      database.RequestPending,
+		CreatedAt:   now,
+	}
+	body, err := s.accessRequestNotice("test", sample, caller.DID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to encode test notice"})
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), webhookTimeout)
+	defer cancel()
+	status, err := s.postWebhook(ctx, body)
+	latency := time.Since(now).Milliseconds()
+	if err != nil {
+		slog.Warn("webhook test failed", "by", caller.Handle, "error", err)
+		return c.JSON(http.StatusBadGateway, map[string]any{"error": "webhook unreachable: " + err.Error(), "latency_ms": latency})
+	}
+	slog.Info("webhook test sent", "by", caller.Handle, "status", status, "latency_ms", latency)
+	return c.JSON(http.StatusOK, map[string]any{"status": status, "latency_ms": latency})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+func TestTestWebhook(t *testing.T) {
+	var got map[string]any
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q, want application/json", ct)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if sig := r.Header.Get("X-Noknok-Signature"); sig != want {
+			t.Errorf("X-Noknok-Signature %q, want %q", sig, want)
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hook.Close()
+
+	send := func(s *Server, user *database.User) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := s.echo.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+		c.Set(ctxKeyUser, user)
+		if err := s.handleTestWebhook(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	owner := &database.User{ID: 1, Role: "owner", DID: "did:plc:owner", Handle: "owner.test"}
+
+	s := &Server{echo: echo.New(), cfg: &config.Config{PublicURL: "https://auth.example.com", AccessRequestWebhook: hook.URL, WebhookSecret: "hook-secret"}}
+	rec := send(s, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d %s, want 200", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Status int `json:"status"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusAccepted {
+		t.Errorf("reported status %d, want %d", resp.Status, http.StatusAccepted)
+	}
+	if got["event"] != "test" || got["text"] != "owner.test requested access to Example service" {
+		t.Errorf("webhook got %v", got)
+	}
+
+	if rec := send(s, &database.User{ID: 2, Role: "admin"}); rec.Code != http.StatusForbidden {
+		t.Errorf("admin: status %d, want 403", rec.Code)
+	}
+
+	s.cfg.AccessRequestWebhook = ""
+	if rec := send(s, owner); rec.Code != http.StatusBadRequest {
+		t.Errorf("unset webhook: status %d, want 400", rec.Code)
+	}
+
+	hook.Close()
+	s.cfg.AccessRequestWebhook = hook.URL
+	if rec := send(s, owner); rec.Code != http.StatusBadGateway {
+		t.Errorf("unreachable webhook: status %d, want 502", rec.Code)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// handleAccountDeletePage shows the confirmation step for self-service
+// account deletion.
+func (s *Server) handleAccountDeletePage(c echo.Context) error {
+	sess, ok := s.currentSession(c)
+	if !ok {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+	return c.HTML(http.StatusOK, accountDeleteHTML(s.brand(), sess.Handle, ""))
+}
+
+// handleAccountDelete removes the current user's account and data, then logs
+// them out. The user must type their handle to confirm. Owners cannot
+// delete themselves.
+func (s *Server) handleAccountDelete(c echo.Context) error {
+	sess, ok := s.currentSession(c)
+	if !ok {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+	ctx := c.Request().Context()
+
+	user, err := s.db.GetUserByIdentityDID(ctx, sess.DID)
+	if err != nil {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+	if user.Role == "owner" || sess.DID == s.cfg.OwnerDID {
+		return c.HTML(http.StatusForbidden, accountDeleteHTML(s.brand(), sess.Handle, "Owners cannot delete their own account."))
+	}
+	if !strings.EqualFold(strings.TrimSpace(c.FormValue("confirm")), sess.Handle) {
+		return c.HTML(http.StatusOK, accountDeleteHTML(s.brand(), sess.Handle, "Type your handle exactly to confirm."))
+	}
+
+	if err := s.db.DeleteUserAccount(ctx, user.ID); err != nil {
+		slog.Error("account deletion failed", "user_id", user.ID, "error", err)
+		return c.HTML(http.StatusInternalServerError, accountDeleteHTML(s.brand(), sess.Handle, "Could not delete your account. Please try again."))
+	}
+
+	// Other identities in this browser's group stay signed in.
+	if sess.GroupID != "" {
+		if cookie, err := s.sess.DestroyOne(ctx, sess.GroupID, sess.ID, true); err == nil && cookie != nil {
+			c.SetCookie(cookie)
+		}
+	} else {
+		c.SetCookie(s.sess.ClearCookie())
+	}
+
+	if err := s.db.RecordAudit(ctx, user, "user.self_delete", "user", strconv.FormatInt(user.ID, 10),
+		map[string]any{"handle": sess.Handle}); err != nil {
+		slog.Warn("audit record failed", "action", "user.self_delete", "error", err)
+	}
+	slog.Info("account self-deleted", "user_id", user.ID, "did", sess.DID)
+	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Your account has been deleted."))
+}
+
+// currentSession returns the validated session for the request's cookie.
+func (s *Server) currentSession(c echo.Context) (*session.Session, bool) {
+	cookie, err := c.Cookie(s.sess.CookieName())
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	sess, err := s.validateSession(c, cookie.Value)
+	if err != nil {
+		return nil, false
+	}
+	return sess, true
+}
+
+// validateSession validates a session token for a request answered directly
+// to the browser. With SESSION_ROTATE, the token is replaced and the new
+// cookie set on the response. forwardAuth doesn't use this: its response
+// cookies never reach the browser.
+func (s *Server) validateSession(c echo.Context, token string) (*session.Session, error) {
+	sess, cookie, err := s.sess.Rotate(c.Request().Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if cookie != nil {
+		c.SetCookie(cookie)
+	}
+	return sess, nil
+}
+
+func accountDeleteHTML(b brand, handle, errMsg string) string {
+	errorBlock := ""
+	if errMsg != "" {
+		errorBlock = `<div class="error">` + html.EscapeString(errMsg) + `</div>`
+	}
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>` + b.title("Delete account") + `</title>
+` + faviconLink + `
+<style>
+  *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+  body {
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+    background: #0f172a;
+    color: #e2e8f0;
+    min-height: 100vh;
+    padding: 2rem;
+  }
+  .card {
+    background: #1e293b;
+    border-radius: 12px;
+    padding: 1.5rem;
+    max-width: 480px;
+    margin: 4rem auto 0;
+  }
+  h1 { font-size: 1.25rem; color: #f8fafc; margin-bottom: 0.5rem; }
+  p { font-size: 0.875rem; color: #94a3b8; margin-bottom: 1rem; line-height: 1.5; }
+  .error {
+    background: #7f1d1d;
+    color: #fca5a5;
+    padding: 0.75rem 1rem;
+    border-radius: 8px;
+    font-size: 0.875rem;
+    margin-bottom: 1rem;
+  }
+  input[type="text"] {
+    width: 100%;
+    padding: 0.625rem 0.75rem;
+    background: #0f172a;
+    border: 1px solid #334155;
+    border-radius: 8px;
+    color: #f8fafc;
+    font-size: 0.9375rem;
+    margin-bottom: 0.75rem;
+    outline: none;
+  }
+  input[type="text"]:focus { border-color: #ef4444; }
+  .actions { display: flex; gap: 0.5rem; }
+  button, .cancel {
+    flex: 1;
+    padding: 0.625rem;
+    border: none;
+    border-radius: 8px;
+    font-size: 0.9375rem;
+    font-weight: 500;
+    cursor: pointer;
+    text-align: center;
+    text-decoration: none;
+  }
+  button { background: #dc2626; color: #fff; }
+  button:hover { background: #b91c1c; }
+  .cancel { background: #334155; color: #e2e8f0; }
+  .cancel:hover { background: #475569; }
+  .card .brand { margin-bottom: 1rem; }` + b.css() + `
+</style>
+</head>
+<body>
+<div class="card">
+  ` + b.headerHTML() + `
+  <h1>Delete account</h1>
+  ` + errorBlock + `
+  <p>This permanently removes your account, linked identities, service access, and sessions. Type <strong>` + html.EscapeString(handle) + `</strong> to confirm.</p>
+  <form method="POST" action="/account/delete">
+    <input type="text" name="confirm" placeholder="` + html.EscapeString(handle) + `" autocomplete="off" required>
+    <div class="actions">
+      <a href="/" class="cancel">Cancel</a>
+      <button type="submit">Delete</button>
+    </div>
+  </form>
+</div>
+</body>
+</html>`
+}
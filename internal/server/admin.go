@@ -36,6 +36,7 @@ if (document.readyState === 'loading') {
     <a href="/" class="admin-close">&times;</a>
   </div>
   <div class="admin-tabs">
+    <a href="/?admin&tab=overview" class="admin-tab` + tabActive("overview") + `" data-tab="overview">Overview</a>
     <a href="/?admin&tab=users" class="admin-tab` + tabActive("users") + `" data-tab="users">Users</a>
     <a href="/?admin&tab=services" class="admin-tab` + tabActive("services") + `" data-tab="services">Services</a>
     <a href="/?admin&tab=access" class="admin-tab` + tabActive("access") + `" data-tab="access">Access</a>
@@ -124,16 +125,32 @@ if (document.readyState === 'loading') {
   font-size:0.75rem;cursor:pointer;transition:background 0.15s;
 }
 .admin-btn-danger:hover { background:#b91c1c; }
+.order-btn { background:none;border:none;color:#64748b;cursor:pointer;padding:0 0.125rem;font-size:0.75rem; }
+.order-btn:hover { color:#f8fafc; }
+.order-btn:disabled { opacity:0.3;cursor:default; }
+.svc-icon { width:20px;height:20px;border-radius:4px;vertical-align:middle; }
+.icon-upload { font-size:0.6875rem;color:#93c5fd;cursor:pointer;margin-left:0.25rem; }
 .admin-form { display:flex;gap:0.5rem;align-items:center;margin-top:1rem;flex-wrap:wrap; }
 .admin-msg { font-size:0.8125rem;padding:0.5rem;border-radius:6px;margin-bottom:0.75rem; }
 .admin-msg-ok { background:#14532d;color:#86efac; }
 .admin-msg-err { background:#7f1d1d;color:#fca5a5; }
+.ov-grid { display:grid;grid-template-columns:repeat(auto-fit,minmax(150px,1fr));gap:0.75rem;margin-bottom:1.25rem; }
+.ov-stat { background:#0f172a;border:1px solid #334155;border-radius:8px;padding:0.75rem 1rem; }
+.ov-value { font-size:1.5rem;font-weight:600;color:#f8fafc; }
+.ov-label { font-size:0.8125rem;color:#94a3b8; }
+.ov-detail { font-size:0.75rem;color:#64748b;margin-top:0.25rem; }
+.ov-heading { font-size:0.875rem;font-weight:500;color:#94a3b8;margin:0 0 0.5rem; }
 .access-check { width:18px;height:18px;cursor:pointer;accent-color:#3b82f6; }
+.grant-note { font-size:0.625rem;color:#64748b;text-decoration:none; }
+.grant-note.has-note { color:#93c5fd; }
+.grant-expiry.soon { color:#fbbf24; }
 </style>
 
 <script>
 var ROLE = '` + role + `';
-var adminData = { users: [], services: [], grants: [] };
+var adminData = { users: [], services: [], grants: [], usage: {}, uptime: {}, counts: { users: {}, services: {} }, templates: [], signups: [], requests: [] };
+// iconStamp busts the browser's cached /icon/:id after an upload or removal.
+var iconStamp = Date.now();
 
 function api(method, path, body, callback) {
   var xhr = new XMLHttpRequest();
@@ -160,17 +177,34 @@ function loadTab(tab) {
   var el = document.getElementById('admin-content');
   if (!el) return;
   el.innerHTML = '<div style="color:#64748b;padding:1rem">Loading...</div>';
-  if (tab === 'users') {
+  if (tab === 'overview') {
+    api('GET', '/dashboard', null, function(err, data) {
+      if (err) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+      renderOverview(el, data);
+    });
+  } else if (tab === 'users') {
     api('GET', '/users', null, function(err, data) {
       if (err) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
       adminData.users = data;
-      renderUsers(el);
+      loadGrantCounts(function() { loadTemplates(function() { loadSignups(function() { renderUsers(el); }); }); });
     });
   } else if (tab === 'services') {
     api('GET', '/services', null, function(err, data) {
       if (err) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
       adminData.services = data;
-      renderServices(el);
+      api('GET', '/services/usage', null, function(err2, usage) {
+        adminData.usage = {};
+        if (!err2 && usage) {
+          for (var i = 0; i < usage.length; i++) {
+            var u = usage[i];
+            adminData.usage[u.service_id] = (adminData.usage[u.service_id] || 0) + u.clicks;
+          }
+        }
+        api('GET', '/services/uptime', null, function(err3, uptime) {
+          adminData.uptime = (!err3 && uptime) ? uptime : {};
+          loadGrantCounts(function() { renderServices(el); });
+        });
+      });
     });
   } else if (tab === 'access') {
     api('GET', '/users', null, function(err1, users) {
@@ -182,7 +216,7 @@ function loadTab(tab) {
         api('GET', '/grants', null, function(err3, grants) {
           if (err3) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err3) + '</div>'; return; }
           adminData.grants = grants;
-          renderAccess(el);
+          loadTemplates(function() { loadRequests(function() { renderAccess(el); }); });
         });
       });
     });
@@ -195,6 +229,89 @@ function esc(s) {
   return d.innerHTML;
 }
 
+// loadGrantCounts fetches active grant counts for the listings. A failure
+// leaves the counts empty rather than blocking the tab.
+function loadGrantCounts(cb) {
+  api('GET', '/grants/counts', null, function(err, data) {
+    adminData.counts = (!err && data) ? data : { users: {}, services: {} };
+    cb();
+  });
+}
+
+// loadTemplates fetches the access templates. A failure leaves the list
+// empty, which hides the template controls.
+function loadTemplates(cb) {
+  api('GET', '/access-templates', null, function(err, data) {
+    adminData.templates = (!err && data) ? data : [];
+    cb();
+  });
+}
+
+// loadRequests fetches pending access requests. A failure leaves the list
+// empty, which hides the section.
+function loadRequests(cb) {
+  api('GET', '/requests', null, function(err, data) {
+    adminData.requests = (!err && data) ? data : [];
+    cb();
+  });
+}
+
+// loadSignups fetches self-registered users who are pending or denied. A
+// failure leaves the list empty, which hides the section.
+function loadSignups(cb) {
+  api('GET', '/users/pending', null, function(err, data) {
+    adminData.signups = (!err && data) ? data : [];
+    cb();
+  });
+}
+
+function renderOverview(el, d) {
+  var stat = function(label, value, detail) {
+    return '<div class="ov-stat"><div class="ov-value">' + value + '</div><div class="ov-label">' + esc(label) + '</div>' +
+      (detail ? '<div class="ov-detail">' + esc(detail) + '</div>' : '') + '</div>';
+  };
+  var roles = [];
+  for (var r in d.users.by_role) roles.push(d.users.by_role[r] + ' ' + r);
+  var h = d.health;
+  var healthDetail = h.down + ' down' + (h.unknown ? ', ' + h.unknown + ' unchecked' : '') + (h.skipped ? ', ' + h.skipped + ' skipped' : '');
+  var html = '<div class="ov-grid">' +
+    stat('Users', d.users.total, roles.join(', ')) +
+    stat('Services', d.services.total, d.services.enabled + ' enabled, ' + d.services.public + ' public') +
+    stat('Active grants', d.grants.active, d.grants.users_with_access + ' users with access') +
+    stat('Services up', h.up, healthDetail) +
+    '</div>';
+  html += '<h3 class="ov-heading">Recent activity ' +
+    '<select class="admin-select" style="margin-left:0.5rem;font-size:0.75rem" onchange="filterAudit(this.value)">' +
+    '<option value="">All</option><option value="login.failed">Failed sign-ins</option></select></h3>';
+  html += '<div id="audit-list">' + auditTableHTML(d.recent_audit, '') + '</div>';
+  el.innerHTML = html;
+}
+
+// auditTableHTML renders audit entries for the Overview. Failed sign-ins
+// have no target; their reason and client IP are shown instead.
+function auditTableHTML(entries, action) {
+  if (!entries.length) {
+    return '<div style="color:#64748b;font-size:0.8125rem">' + (action ? 'No matching entries.' : 'No audit entries yet.') + '</div>';
+  }
+  var html = '<table class="admin-tbl"><thead><tr><th>When</th><th>Who</th><th>Action</th><th>Target</th></tr></thead><tbody>';
+  for (var i = 0; i < entries.length; i++) {
+    var a = entries[i];
+    var target = a.target_type + (a.target_id ? ' ' + a.target_id : '');
+    if (a.action === 'login.failed' && a.detail) target = (a.detail.reason || '') + (a.detail.ip ? ' from ' + a.detail.ip : '');
+    html += '<tr><td>' + esc(new Date(a.created_at).toLocaleString()) + '</td><td>' + esc(a.actor_handle || a.actor_did || '(unknown)') +
+      '</td><td>' + esc(a.action) + '</td><td>' + esc(target) + '</td></tr>';
+  }
+  return html + '</tbody></table>';
+}
+
+function filterAudit(action) {
+  var list = document.getElementById('audit-list');
+  api('GET', '/audit?limit=10' + (action ? '&action=' + encodeURIComponent(action) : ''), null, function(err, data) {
+    if (err) { list.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+    list.innerHTML = auditTableHTML(data.items, action);
+  });
+}
+
 function renderUsers(el) {
   // Sort: owners first, then admins, then users.
   var roleOrder = { owner: 0, admin: 1, user: 2 };
@@ -203,7 +320,7 @@ function renderUsers(el) {
     var ob = roleOrder[b.role] !== undefined ? roleOrder[b.role] : 3;
     return oa - ob;
   });
-  var html = '<table class="admin-tbl"><thead><tr><th style="width:30px"></th><th>Handle</th><th>Username</th><th>Role</th></tr></thead><tbody>';
+  var html = renderSignups() + '<table class="admin-tbl"><thead><tr><th style="width:30px"></th><th>Handle</th><th>Username</th><th>Role</th><th title="Active grants">Grants</th></tr></thead><tbody>';
   for (var i = 0; i < adminData.users.length; i++) {
     var u = adminData.users[i];
     var canChangeRole = ROLE === 'owner';
@@ -215,7 +332,7 @@ function renderUsers(el) {
         '<option value="admin"' + (u.role==='admin'?' selected':'') + '>Admin</option>' +
         '<option value="owner"' + (u.role==='owner'?' selected':'') + '>Owner</option></select>'
       : esc(u.role);
-    html += '<tr><td>' + radio + '</td><td>' + esc(u.handle || '(no handle)') + '</td><td>' + usernameCell + '</td><td>' + roleCell + '</td></tr>';
+    html += '<tr><td>' + radio + '</td><td>' + esc(u.handle || '(no handle)') + '</td><td>' + usernameCell + '</td><td>' + roleCell + '</td><td style="color:#94a3b8;text-align:right">' + (adminData.counts.users[u.id] || 0) + '</td></tr>';
   }
   html += '</tbody></table>';
   html += '<div class="admin-form">' +
@@ -223,7 +340,24 @@ function renderUsers(el) {
     '<input class="admin-input" id="add-username" placeholder="username" style="width:90px" oninput="checkAddUser()">' +
     '<select class="admin-select" id="add-role" onchange="checkAddUser()"><option value="" disabled selected>role</option><option value="user">User</option>` + ownerOnly + `</select>' +
     '<button class="admin-btn" id="add-user-btn" onclick="addUser()" disabled style="opacity:0.4;cursor:default">Add</button>' +
+    '<button class="admin-btn" id="link-user-btn" onclick="copyLoginLink()" disabled style="opacity:0.4;cursor:default" title="Copy login link for the selected user">Copy link</button>' +
+    '<button class="admin-btn" id="debug-user-btn" onclick="toggleUserDebug()" disabled style="opacity:0.4;cursor:default" title="Sessions, grants, and effective roles for the selected user">Debug</button>' +
     '<button class="admin-btn-danger" id="del-user-btn" onclick="deleteSelectedUser()" disabled style="opacity:0.4;cursor:default;padding:0.375rem 0.75rem;font-size:0.8125rem">Delete</button></div>';
+  if (adminData.templates.length) {
+    html += '<div class="admin-form"><select class="admin-select" id="apply-template-sel">';
+    for (var t = 0; t < adminData.templates.length; t++) {
+      var tpl = adminData.templates[t];
+      html += '<option value="' + tpl.id + '">' + esc(tpl.name) + ' (' + tpl.services.length + ' services)</option>';
+    }
+    html += '</select><button class="admin-btn" id="apply-template-btn" onclick="applyTemplate()" disabled style="opacity:0.4;cursor:default" title="Grant the selected user every service in the template">Apply template</button></div>';
+  }
+  html += '<div class="admin-form">' +
+    '<textarea class="admin-input" id="bulk-users" rows="2" placeholder="Bulk add: one handle per line, optionally followed by a username" style="flex:1;min-width:150px;resize:vertical;font-family:inherit"></textarea>' +
+    '<select class="admin-select" id="bulk-role"><option value="user">User</option>` + ownerOnly + `</select>' +
+    '<button class="admin-btn" id="bulk-users-btn" onclick="bulkAddUsers()">Add all</button></div>';
+  if (ROLE === 'owner') {
+    html += '<div class="admin-form"><button class="admin-btn" id="resync-handles-btn" onclick="resyncHandles()" title="Re-resolve every identity\'s handle from its DID">Resync handles</button></div>';
+  }
   html += '<div id="users-msg"></div>';
   html += '<div id="identities-section" style="display:none;margin-top:1rem;border-top:1px solid #334155;padding-top:0.75rem">' +
     '<div style="font-size:0.8125rem;color:#94a3b8;margin-bottom:0.5rem;font-weight:500">Identities</div>' +
@@ -232,6 +366,12 @@ function renderUsers(el) {
     '<input class="admin-input" id="add-identity-handle" placeholder="handle" style="flex:1;min-width:150px">' +
     '<button class="admin-btn" onclick="addIdentity()">Link</button></div>' +
     '<div id="identities-msg"></div></div>';
+  html += '<div id="scope-section" style="display:none;margin-top:1rem;border-top:1px solid #334155;padding-top:0.75rem">' +
+    '<div style="font-size:0.8125rem;color:#94a3b8;margin-bottom:0.5rem;font-weight:500">Admin scope</div>' +
+    '<div id="scope-body"></div><div id="scope-msg"></div></div>';
+  html += '<div id="debug-section" style="display:none;margin-top:1rem;border-top:1px solid #334155;padding-top:0.75rem">' +
+    '<div style="font-size:0.8125rem;color:#94a3b8;margin-bottom:0.5rem;font-weight:500">Debug</div>' +
+    '<div id="debug-body"></div></div>';
   el.innerHTML = html;
   // Re-select or auto-select first user.
   var targetId = selectedUserId;
@@ -258,6 +398,41 @@ function renderUsers(el) {
   }
 }
 
+function renderSignups() {
+  if (!adminData.signups.length) return '';
+  var pending = 0;
+  for (var p = 0; p < adminData.signups.length; p++) if (adminData.signups[p].status === 'pending') pending++;
+  var html = '<div style="margin-bottom:1rem;border-bottom:1px solid #334155;padding-bottom:0.75rem">' +
+    '<div style="font-size:0.8125rem;color:#94a3b8;margin-bottom:0.5rem;font-weight:500">Pending sign-ups (' + pending + ')</div>' +
+    '<table class="admin-tbl"><thead><tr><th>Handle</th><th>Signed up</th><th>Status</th><th></th></tr></thead><tbody>';
+  for (var i = 0; i < adminData.signups.length; i++) {
+    var u = adminData.signups[i];
+    var actions = '<button class="admin-btn" onclick="decideSignup(' + u.id + ',\'approve\')">Approve</button> ' +
+      (u.status === 'pending'
+        ? '<button class="admin-btn-danger" onclick="decideSignup(' + u.id + ',\'deny\')">Deny</button>'
+        : '<button class="admin-btn-danger" onclick="deleteSignup(' + u.id + ')" title="Forget this user so they can sign up again">Delete</button>');
+    html += '<tr><td>' + esc(u.handle || u.did) + '</td><td>' + esc(new Date(u.created_at).toLocaleString()) + '</td>' +
+      '<td style="color:' + (u.status === 'pending' ? '#eab308' : '#94a3b8') + '">' + esc(u.status) + '</td><td style="white-space:nowrap">' + actions + '</td></tr>';
+  }
+  html += '</tbody></table></div>';
+  return html;
+}
+
+function decideSignup(id, decision) {
+  api('POST', '/users/' + id + '/' + decision, null, function(err) {
+    if (err) { alert(err); return; }
+    loadTab('users');
+  });
+}
+
+function deleteSignup(id) {
+  if (!confirm('Delete this user? They will be able to sign up again.')) return;
+  api('DELETE', '/users/' + id, null, function(err) {
+    if (err) { alert(err); return; }
+    loadTab('users');
+  });
+}
+
 function checkAddUser() {
   var h = document.getElementById('add-handle').value.trim();
   var u = document.getElementById('add-username').value.trim();
@@ -310,6 +485,7 @@ var selectedUserId = 0;
 var selectedUserRole = '';
 var selectedUserGrants = {};
 var lastHealthData = {};
+var lastHealthLatency = {};
 var activeDetailSvcId = 0;
 
 function selectUser(userId) {
@@ -322,13 +498,19 @@ function selectUser(userId) {
     }
   }
   closeDetail();
-  var btn = document.getElementById('del-user-btn');
-  if (btn) {
-    btn.disabled = false;
-    btn.style.opacity = '1';
-    btn.style.cursor = 'pointer';
+  var btnIds = ['del-user-btn', 'link-user-btn', 'debug-user-btn', 'apply-template-btn'];
+  for (var b = 0; b < btnIds.length; b++) {
+    var btn = document.getElementById(btnIds[b]);
+    if (btn) {
+      btn.disabled = false;
+      btn.style.opacity = '1';
+      btn.style.cursor = 'pointer';
+    }
   }
   loadIdentities(userId);
+  loadAdminScope(userId);
+  var dbg = document.getElementById('debug-section');
+  if (dbg && dbg.style.display === 'block') loadUserDebug(userId);
   if (selectedUserRole === 'owner' || selectedUserRole === 'admin') {
     selectedUserGrants = {};
     fetchAndUpdateDots();
@@ -362,6 +544,18 @@ function fetchAndUpdateDots() {
   });
 }
 
+// applyHealthDetail stores a /services/health?detail=1 response: alive flags
+// for the traffic lights and probe latencies for the detail panel.
+function applyHealthDetail(health) {
+  lastHealthData = {};
+  lastHealthLatency = {};
+  for (var id in health) {
+    if (!health.hasOwnProperty(id)) continue;
+    lastHealthData[id] = health[id].alive;
+    if (health[id].checked_at) lastHealthLatency[id] = health[id].latency_ms;
+  }
+}
+
 function updateTrafficDots() {
   var isAdmin = selectedUserRole === 'owner' || selectedUserRole === 'admin';
   var cards = document.querySelectorAll('.card[data-svc-id]');
@@ -418,8 +612,8 @@ function toggleDetail(card) {
     adminData.services = services;
     var isAdmin = selectedUserRole === 'owner' || selectedUserRole === 'admin';
     if (isAdmin) {
-      api('GET', '/services/health', null, function(err2, health) {
-        lastHealthData = err2 ? {} : (health || {});
+      api('GET', '/services/health?detail=1', null, function(err2, health) {
+        applyHealthDetail(err2 ? {} : (health || {}));
         buildDetail(card, svcId);
       });
     } else {
@@ -472,6 +666,13 @@ function buildDetail(card, svcId) {
   inner.appendChild(yellowBtn);
   inner.appendChild(greenBtn);
   panel.appendChild(inner);
+  if (isAdmin) {
+    var latency = document.createElement('div');
+    latency.className = 'detail-latency';
+    var ms = lastHealthLatency[String(svcId)];
+    latency.textContent = ms === undefined ? 'Not probed' : (lastHealthData[String(svcId)] ? 'Up' : 'Down') + ' · ' + ms + ' ms';
+    panel.appendChild(latency);
+  }
   var tl = card.querySelector('.traffic-light');
   if (tl) tl.style.display = 'none';
   card.appendChild(panel);
@@ -482,8 +683,8 @@ function refreshDetail(card, svcId) {
   api('GET', '/services', null, function(err, services) {
     if (err) return;
     adminData.services = services;
-    api('GET', '/services/health', null, function(err2, health) {
-      lastHealthData = err2 ? {} : (health || {});
+    api('GET', '/services/health?detail=1', null, function(err2, health) {
+      applyHealthDetail(err2 ? {} : (health || {}));
       updateTrafficDots();
       if (activeDetailSvcId === svcId) {
         var old = card.querySelector('.detail-panel');
@@ -561,6 +762,109 @@ function loadIdentities(userId) {
   });
 }
 
+// loadAdminScope shows owners which services the selected admin may manage.
+function loadAdminScope(userId) {
+  var section = document.getElementById('scope-section');
+  var body = document.getElementById('scope-body');
+  if (!section || !body) return;
+  if (ROLE !== 'owner' || selectedUserRole !== 'admin') { section.style.display = 'none'; return; }
+  section.style.display = 'block';
+  body.innerHTML = '<div style="color:#64748b;font-size:0.75rem">Loading...</div>';
+  api('GET', '/users/' + userId + '/admin-scope', null, function(err, scope) {
+    if (err) { body.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+    api('GET', '/services', null, function(err2, services) {
+      if (err2) { body.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err2) + '</div>'; return; }
+      var html = '<label style="display:flex;align-items:center;gap:0.5rem;font-size:0.8125rem;color:#e2e8f0;margin-bottom:0.5rem">' +
+        '<input type="checkbox" id="scope-limited"' + (scope.scoped ? ' checked' : '') + ' onchange="toggleScopeList()"> Only manage these services</label>' +
+        '<div id="scope-list" style="display:' + (scope.scoped ? 'block' : 'none') + ';margin-left:1.25rem">';
+      for (var i = 0; i < services.length; i++) {
+        var checked = scope.service_ids.indexOf(services[i].id) >= 0;
+        html += '<label style="display:flex;align-items:center;gap:0.5rem;font-size:0.8125rem;color:#cbd5e1;padding:0.125rem 0">' +
+          '<input type="checkbox" class="scope-svc" value="' + services[i].id + '"' + (checked ? ' checked' : '') + '> ' + esc(services[i].name) + '</label>';
+      }
+      html += '</div><div class="admin-form" style="margin-top:0.5rem"><button class="admin-btn" onclick="saveAdminScope()">Save scope</button></div>';
+      body.innerHTML = html;
+    });
+  });
+}
+
+function toggleScopeList() {
+  document.getElementById('scope-list').style.display = document.getElementById('scope-limited').checked ? 'block' : 'none';
+}
+
+function saveAdminScope() {
+  if (!selectedUserId) return;
+  var msg = document.getElementById('scope-msg');
+  var ids = [];
+  var boxes = document.querySelectorAll('.scope-svc');
+  for (var i = 0; i < boxes.length; i++) {
+    if (boxes[i].checked) ids.push(parseInt(boxes[i].value));
+  }
+  var scoped = document.getElementById('scope-limited').checked;
+  api('PUT', '/users/' + selectedUserId + '/admin-scope', { scoped: scoped, service_ids: ids }, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = scoped ? 'Limited to ' + ids.length + ' service(s)' : 'Manages all services';
+    setTimeout(function() { msg.className = ''; msg.textContent = ''; }, 1500);
+  });
+}
+
+function toggleUserDebug() {
+  var section = document.getElementById('debug-section');
+  if (!section || !selectedUserId) return;
+  if (section.style.display === 'block') { section.style.display = 'none'; return; }
+  section.style.display = 'block';
+  loadUserDebug(selectedUserId);
+}
+
+function loadUserDebug(userId) {
+  var body = document.getElementById('debug-body');
+  if (!body) return;
+  body.innerHTML = '<div style="color:#64748b;font-size:0.75rem">Loading...</div>';
+  api('GET', '/users/' + userId + '/debug', null, function(err, d) {
+    if (err) { body.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+    var when = function(t) { return t ? esc(new Date(t).toLocaleString()) : '—'; };
+    var html = '<div style="font-size:0.75rem;color:#64748b;margin:0.25rem 0">Sessions</div>';
+    if (d.sessions.length === 0) {
+      html += '<div style="color:#64748b;font-size:0.75rem">No active sessions</div>';
+    } else {
+      html += '<table class="admin-tbl"><thead><tr><th>Handle</th><th>Last seen</th><th>Expires</th></tr></thead><tbody>';
+      for (var i = 0; i < d.sessions.length; i++) {
+        var ss = d.sessions[i];
+        html += '<tr><td>' + esc(ss.handle) + '</td><td>' + when(ss.last_seen) + '</td><td>' + when(ss.expires_at) + '</td></tr>';
+      }
+      html += '</tbody></table>';
+    }
+    html += '<div style="font-size:0.75rem;color:#64748b;margin:0.5rem 0 0.25rem">Grants</div>';
+    if (d.grants.length === 0) {
+      html += '<div style="color:#64748b;font-size:0.75rem">No grants</div>';
+    } else {
+      html += '<table class="admin-tbl"><thead><tr><th>Service</th><th>Role</th><th>Expires</th><th>Note</th></tr></thead><tbody>';
+      var now = new Date();
+      for (var j = 0; j < d.grants.length; j++) {
+        var g = d.grants[j];
+        var expired = g.expires_at && new Date(g.expires_at) <= now;
+        html += '<tr' + (expired ? ' style="color:#64748b"' : '') + '><td>' + esc(g.service_name) + '</td><td>' + esc(g.role) + '</td><td>' +
+          (g.expires_at ? when(g.expires_at) + (expired ? ' (expired)' : '') : 'never') + '</td><td>' + esc(g.note || '') + '</td></tr>';
+      }
+      html += '</tbody></table>';
+    }
+    html += '<div style="font-size:0.75rem;color:#64748b;margin:0.5rem 0 0.25rem">Effective roles</div>';
+    if (d.roles.length === 0) {
+      html += '<div style="color:#64748b;font-size:0.75rem">No identities to resolve</div>';
+    } else {
+      html += '<table class="admin-tbl"><thead><tr><th>Service</th><th>Role</th></tr></thead><tbody>';
+      for (var k = 0; k < d.roles.length; k++) {
+        var ro = d.roles[k];
+        var cell = ro.role ? esc(ro.role) : '<span style="color:#f87171">no access</span>';
+        if (!ro.enabled) cell += ' <span style="color:#64748b">(service disabled)</span>';
+        html += '<tr><td>' + esc(ro.name) + '</td><td>' + cell + '</td></tr>';
+      }
+      html += '</tbody></table>';
+    }
+    body.innerHTML = html;
+  });
+}
+
 function addIdentity() {
   if (!selectedUserId) return;
   var handle = document.getElementById('add-identity-handle').value.trim();
@@ -587,6 +891,101 @@ function removeIdentity(userId, identityId) {
   });
 }
 
+function copyLoginLink() {
+  if (!selectedUserId) return;
+  var msg = document.getElementById('users-msg');
+  api('GET', '/users/' + selectedUserId + '/login-link', null, function(err, data) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    var ta = document.createElement('textarea');
+    ta.value = data.url;
+    ta.style.position = 'fixed';
+    ta.style.opacity = '0';
+    document.body.appendChild(ta);
+    ta.select();
+    var copied = false;
+    try { copied = document.execCommand('copy'); } catch (e) {}
+    document.body.removeChild(ta);
+    if (!copied) { prompt('Login link', data.url); return; }
+    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Login link copied';
+    setTimeout(function() { msg.className = ''; msg.textContent = ''; }, 1500);
+  });
+}
+
+function bulkAddUsers() {
+  var msg = document.getElementById('users-msg');
+  var btn = document.getElementById('bulk-users-btn');
+  var lines = document.getElementById('bulk-users').value.split('\n');
+  var users = [];
+  for (var i = 0; i < lines.length; i++) {
+    var parts = lines[i].trim().split(/\s+/);
+    if (parts[0]) users.push({ handle: parts[0], username: parts[1] || '' });
+  }
+  if (!users.length) return;
+  btn.disabled = true;
+  msg.className = 'admin-msg'; msg.textContent = 'Resolving ' + users.length + ' handles...';
+  api('POST', '/users/bulk', { role: document.getElementById('bulk-role').value, users: users }, function(err, data) {
+    btn.disabled = false;
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    var text = 'Created ' + data.created + ', failed ' + data.failed;
+    for (var i = 0; i < data.results.length; i++) {
+      var r = data.results[i];
+      if (r.error) text += '\n' + r.handle + ': ' + r.error + ' (' + r.elapsed_ms + ' ms)';
+    }
+    var cls = data.failed ? 'admin-msg admin-msg-err' : 'admin-msg admin-msg-ok';
+    api('GET', '/users', null, function(err2, users) {
+      if (!err2) {
+        adminData.users = users;
+        renderUsers(document.getElementById('admin-content'));
+      }
+      var m = document.getElementById('users-msg');
+      m.className = cls; m.style.whiteSpace = 'pre-line'; m.textContent = text;
+    });
+  });
+}
+
+function resyncHandles() {
+  var msg = document.getElementById('users-msg');
+  var btn = document.getElementById('resync-handles-btn');
+  btn.disabled = true;
+  msg.className = 'admin-msg'; msg.textContent = 'Resolving handles...';
+  api('POST', '/users/resync-handles', {}, function(err, data) {
+    btn.disabled = false;
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    var text = 'Checked ' + data.checked + ', changed ' + data.changed.length + ', failed ' + data.failed.length;
+    for (var i = 0; i < data.failed.length; i++) text += '\n' + data.failed[i].handle + ': ' + data.failed[i].error;
+    msg.className = data.failed.length ? 'admin-msg admin-msg-err' : 'admin-msg admin-msg-ok';
+    msg.style.whiteSpace = 'pre-line';
+    msg.textContent = text;
+    if (data.changed.length) {
+      api('GET', '/users', null, function(err2, users) {
+        if (err2) return;
+        adminData.users = users;
+        var keep = msg.textContent, cls = msg.className;
+        renderUsers(document.getElementById('admin-content'));
+        var m = document.getElementById('users-msg');
+        m.className = cls; m.style.whiteSpace = 'pre-line'; m.textContent = keep;
+      });
+    }
+  });
+}
+
+function applyTemplate() {
+  var sel = document.getElementById('apply-template-sel');
+  if (!selectedUserId || !sel) return;
+  var name = sel.options[sel.selectedIndex].text;
+  var userId = selectedUserId;
+  api('POST', '/users/' + userId + '/apply-template', { template_id: parseInt(sel.value, 10) }, function(err, data) {
+    var msg = document.getElementById('users-msg');
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    loadGrantCounts(function() {
+      renderUsers(document.getElementById('admin-content'));
+      var msg = document.getElementById('users-msg');
+      msg.className = 'admin-msg admin-msg-ok';
+      msg.textContent = 'Applied ' + name + ': ' + data.created + ' granted, ' + data.updated + ' updated, ' + data.unchanged + ' unchanged';
+    });
+  });
+}
+
 function deleteSelectedUser() {
   if (!selectedUserId) return;
   if (!confirm('Delete this user?')) return;
@@ -601,11 +1000,42 @@ function deleteSelectedUser() {
 }
 
 function renderServices(el) {
-  var html = '<table class="admin-tbl"><thead><tr><th>Name</th><th>Slug</th><th>URL</th><th>Admin Role</th><th></th></tr></thead><tbody>';
+  var html = '<table class="admin-tbl"><thead><tr><th title="Portal order: lower first, ties by name">Order</th><th title="Uploaded icon, else the service\'s favicon or a letter">Icon</th><th>Name</th><th>Slug</th><th>URL</th><th title="Portal heading the service is grouped under (blank = Other)">Category</th><th>Admin Role</th><th title="Default grant lifetime in days (0 = no expiry)">Grant TTL</th><th title="Cookie domain the service is listed on (blank = all)">Domain</th><th title="Require a sign-in within this many minutes (0 = off)">Reauth</th><th title="Shown to signed-in users without access (blank = redirect to portal)">Deny message</th><th title="Health-check this service (unchecked services always show as up)">Probe</th><th title="http probes the URL; tcp only opens a connection to its host and port (non-HTTP backends)">Check</th><th title="Probe this path and method instead of a HEAD of the URL (a path must answer 2xx/3xx)">Health path</th><th title="Retry a probe the service refuses as HEAD (405/501) with GET">GET</th><th title="Only this probe status counts as up (blank = below 404, or 2xx/3xx with a health path)">Expect</th><th title="Probe without verifying the TLS certificate (self-signed backends)">Skip TLS</th><th title="Send a signed identity JWT in X-User-Token">Token</th><th title="Pin the health status during maintenance (auto = probe)">Status</th><th title="Share of health checks in the last 24 hours that passed">Uptime</th><th title="Users with active grants">Users</th><th title="Clicks in the last 30 days">Usage</th><th></th></tr></thead><tbody>';
   for (var i = 0; i < adminData.services.length; i++) {
     var s = adminData.services[i];
-    html += '<tr><td>' + esc(s.name) + '</td><td style="color:#64748b">' + esc(s.slug) + '</td><td style="font-size:0.75rem;color:#64748b">' + esc(s.url) + '</td>' +
-      '<td><input class="admin-input" style="width:70px;font-size:0.75rem" value="' + esc(s.admin_role) + '" onchange="updateServiceAdminRole(' + s.id + ',this.value)"></td>' +
+    var last = adminData.services.length - 1;
+    html += '<tr><td style="white-space:nowrap">' +
+        '<button class="order-btn" title="Move up"' + (i === 0 ? ' disabled' : '') + ' onclick="moveService(' + s.id + ',\'up\')">&#9650;</button>' +
+        '<button class="order-btn" title="Move down"' + (i === last ? ' disabled' : '') + ' onclick="moveService(' + s.id + ',\'down\')">&#9660;</button>' +
+        '<input class="admin-input" type="number" style="width:52px;font-size:0.75rem" value="' + (s.sort_order || 0) + '" title="Sort order (lower first)" onchange="setSortOrder(' + s.id + ',parseInt(this.value,10)||0)"></td>' +
+      '<td style="white-space:nowrap"><img class="svc-icon" src="/icon/' + s.id + '?v=' + iconStamp + '" alt="">' +
+        '<label class="icon-upload" title="Upload a PNG, JPEG, GIF, WebP, or ICO icon (max 256 KB)">upload<input type="file" accept="image/png,image/jpeg,image/gif,image/webp,image/x-icon" style="display:none" onchange="uploadServiceIcon(' + s.id + ',this)"></label>' +
+        (s.has_icon ? '<button class="order-btn" title="Remove the uploaded icon" onclick="removeServiceIcon(' + s.id + ')">&times;</button>' : '') + '</td>' +
+      '<td>' + esc(s.name) + '</td><td style="color:#64748b">' + esc(s.slug) + '</td><td style="font-size:0.75rem;color:#64748b">' + esc(s.url) + '</td>' +
+      '<td><input class="admin-input" style="width:90px;font-size:0.75rem" maxlength="50" value="' + esc(s.category || '').replace(/"/g, '&quot;') + '" placeholder="Other" onchange="updateServiceField(' + s.id + ',\'category\',this.value.trim(),\'Category updated\')"></td>' +
+      '<td><input class="admin-input" style="width:70px;font-size:0.75rem" value="' + esc(s.admin_role) + '" onchange="updateServiceField(' + s.id + ',\'admin_role\',this.value,\'Admin role updated\')"></td>' +
+      '<td><input class="admin-input" type="number" min="0" style="width:56px;font-size:0.75rem" value="' + (s.grant_ttl_days || 0) + '" title="Days (0 = no expiry)" onchange="updateServiceField(' + s.id + ',\'grant_ttl_days\',parseInt(this.value,10)||0,\'Grant TTL updated\')"></td>' +
+      '<td><input class="admin-input" style="width:90px;font-size:0.75rem" value="' + esc(s.domain || '') + '" placeholder="all" onchange="updateServiceField(' + s.id + ',\'domain\',this.value.trim(),\'Domain updated\')"></td>' +
+      '<td><input class="admin-input" type="number" min="0" style="width:56px;font-size:0.75rem" value="' + Math.round((s.require_reauth_max_age || 0) / 60) + '" title="Minutes (0 = off)" onchange="updateServiceField(' + s.id + ',\'require_reauth_max_age\',(parseInt(this.value,10)||0)*60,\'Reauth window updated\')"></td>' +
+      '<td><input class="admin-input" style="width:120px;font-size:0.75rem" maxlength="500" value="' + esc(s.deny_message || '').replace(/"/g, '&quot;') + '" placeholder="portal" onchange="updateServiceField(' + s.id + ',\'deny_message\',this.value.trim(),\'Deny message updated\')"></td>' +
+      '<td style="text-align:center"><input type="checkbox" style="accent-color:#3b82f6"' + (s.skip_health_check ? '' : ' checked') + ' onchange="updateServiceField(' + s.id + ',\'skip_health_check\',!this.checked,this.checked?\'Health checks on\':\'Health checks off\')"></td>' +
+      '<td><select class="admin-select" style="font-size:0.75rem" onchange="updateServiceField(' + s.id + ',\'health_type\',this.value,\'Health check type updated\')">' +
+        ['http', 'tcp'].map(function(t) { return '<option' + ((s.health_type || 'http') === t ? ' selected' : '') + '>' + t + '</option>'; }).join('') +
+        '</select></td>' +
+      '<td style="white-space:nowrap"><input class="admin-input" style="width:80px;font-size:0.75rem" maxlength="200" value="' + esc(s.health_path || '').replace(/"/g, '&quot;') + '" placeholder="/" onchange="updateServiceField(' + s.id + ',\'health_path\',this.value.trim(),\'Health path updated\')">' +
+        '<select class="admin-select" style="font-size:0.75rem" onchange="updateServiceField(' + s.id + ',\'health_method\',this.value,\'Health method updated\')">' +
+        ['HEAD', 'GET'].map(function(m) { return '<option' + ((s.health_method || 'HEAD') === m ? ' selected' : '') + '>' + m + '</option>'; }).join('') +
+        '</select></td>' +
+      '<td style="text-align:center"><input type="checkbox" style="accent-color:#3b82f6"' + (s.health_head_only ? '' : ' checked') + ' onchange="updateServiceField(' + s.id + ',\'health_head_only\',!this.checked,this.checked?\'GET fallback on\':\'GET fallback off\')"></td>' +
+      '<td><input class="admin-input" type="number" min="100" max="599" style="width:56px;font-size:0.75rem" value="' + (s.health_expect_status || '') + '" placeholder="any" onchange="updateServiceField(' + s.id + ',\'health_expect_status\',parseInt(this.value,10)||0,\'Expected status updated\')"></td>' +
+      '<td style="text-align:center"><input type="checkbox" style="accent-color:#3b82f6"' + (s.skip_tls_verify ? ' checked' : '') + ' onchange="updateServiceField(' + s.id + ',\'skip_tls_verify\',this.checked,this.checked?\'TLS verification off\':\'TLS verification on\')"></td>' +
+      '<td style="text-align:center"><input type="checkbox" style="accent-color:#3b82f6"' + (s.issue_token ? ' checked' : '') + ' onchange="updateServiceField(' + s.id + ',\'issue_token\',this.checked,this.checked?\'Identity token on\':\'Identity token off\')"></td>' +
+      '<td><select class="admin-select" style="font-size:0.75rem" onchange="setHealthOverride(' + s.id + ',this.value)">' +
+        ['auto', 'up', 'down'].map(function(o) { return '<option value="' + o + '"' + (s.health_override === o ? ' selected' : '') + '>' + o + '</option>'; }).join('') +
+        '</select></td>' +
+      uptimeCell(adminData.uptime[s.id]) +
+      '<td style="color:#94a3b8;text-align:right">' + (adminData.counts.services[s.id] || 0) + '</td>' +
+      '<td style="color:#94a3b8;text-align:right">' + (adminData.usage[s.id] || 0) + '</td>' +
       '<td><button class="admin-btn-danger" onclick="deleteService(' + s.id + ')">Delete</button></td></tr>';
   }
   html += '</tbody></table>';
@@ -614,12 +1044,25 @@ function renderServices(el) {
     '<input class="admin-input" id="svc-slug" placeholder="slug" style="width:80px" oninput="checkAddService()">' +
     '<input class="admin-input" id="svc-url" placeholder="https://..." style="flex:1;min-width:130px" oninput="checkAddService()">' +
     '<input class="admin-input" id="svc-desc" placeholder="description" style="width:110px">' +
+    '<input class="admin-input" id="svc-category" placeholder="category" maxlength="50" title="Portal heading (blank = Other)" style="width:90px">' +
     '<input class="admin-input" id="svc-admin-role" placeholder="admin" style="width:70px">' +
+    '<input class="admin-input" id="svc-grant-ttl" type="number" min="0" placeholder="TTL days" title="Default grant lifetime in days (blank = no expiry)" style="width:80px">' +
+    '<input class="admin-input" id="svc-domain" placeholder="domain" title="Cookie domain, e.g. .example.com (blank = all domains)" style="width:90px">' +
+    '<select class="admin-select" id="svc-health-type" title="Health check: http probes the URL, tcp only connects to its host and port"><option>http</option><option>tcp</option></select>' +
+    '<input class="admin-input" id="svc-health-path" placeholder="health path" title="Probe this path, e.g. /healthz (blank = the URL)" style="width:90px">' +
+    '<select class="admin-select" id="svc-health-method" title="Health probe method (HEAD falls back to GET)"><option>HEAD</option><option>GET</option></select>' +
     '<button class="admin-btn" id="add-svc-btn" onclick="addService()" disabled style="opacity:0.4;cursor:default">Add</button></div>';
   html += '<div id="services-msg"></div>';
   el.innerHTML = html;
 }
 
+// uptimeCell renders a service's 24-hour uptime, amber below 99% so
+// flapping services stand out.
+function uptimeCell(pct) {
+  if (pct === undefined) return '<td style="color:#64748b;text-align:right" title="No health samples yet">-</td>';
+  return '<td style="color:' + (pct < 99 ? '#f59e0b' : '#94a3b8') + ';text-align:right">' + pct.toFixed(1) + '%</td>';
+}
+
 function checkAddService() {
   var n = document.getElementById('svc-name').value.trim();
   var s = document.getElementById('svc-slug').value.trim();
@@ -641,37 +1084,119 @@ function addService() {
   var slug = document.getElementById('svc-slug').value.trim();
   var url = document.getElementById('svc-url').value.trim();
   var desc = document.getElementById('svc-desc').value.trim();
+  var category = document.getElementById('svc-category').value.trim();
   var adminRole = document.getElementById('svc-admin-role').value.trim() || 'admin';
+  var grantTTL = parseInt(document.getElementById('svc-grant-ttl').value, 10) || 0;
+  var domain = document.getElementById('svc-domain').value.trim();
+  var healthPath = document.getElementById('svc-health-path').value.trim();
+  var healthMethod = document.getElementById('svc-health-method').value;
+  var healthType = document.getElementById('svc-health-type').value;
   var msg = document.getElementById('services-msg');
   if (!name || !slug || !url) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = 'Name, slug, and URL required'; return; }
-  api('POST', '/services', { name: name, slug: slug, url: url, description: desc, icon_url: '', admin_role: adminRole }, function(err) {
+  api('POST', '/services', { name: name, slug: slug, url: url, description: desc, category: category, icon_url: '', admin_role: adminRole, grant_ttl_days: grantTTL, domain: domain, health_path: healthPath, health_method: healthMethod, health_type: healthType }, function(err) {
     if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
     document.getElementById('svc-name').value = '';
     document.getElementById('svc-slug').value = '';
     document.getElementById('svc-url').value = '';
     document.getElementById('svc-desc').value = '';
+    document.getElementById('svc-category').value = '';
     document.getElementById('svc-admin-role').value = '';
+    document.getElementById('svc-grant-ttl').value = '';
+    document.getElementById('svc-domain').value = '';
+    document.getElementById('svc-health-path').value = '';
+    document.getElementById('svc-health-method').value = 'HEAD';
+    document.getElementById('svc-health-type').value = 'http';
     checkAddService();
     msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Service added';
     loadTab('services');
   });
 }
 
-function updateServiceAdminRole(id, adminRole) {
+// updateServiceField saves one editable field; PUT replaces them all, so the
+// rest are sent from the loaded copy.
+function updateServiceField(id, field, value, okText) {
   var svc = null;
   for (var i = 0; i < adminData.services.length; i++) {
     if (adminData.services[i].id === id) { svc = adminData.services[i]; break; }
   }
   if (!svc) return;
+  var body = {};
+  for (var k in svc) { if (svc.hasOwnProperty(k)) body[k] = svc[k]; }
+  body[field] = value;
   var msg = document.getElementById('services-msg');
-  api('PUT', '/services/' + id, { name: svc.name, description: svc.description, url: svc.url, icon_url: svc.icon_url, admin_role: adminRole }, function(err) {
+  api('PUT', '/services/' + id, body, function(err) {
     if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
-    svc.admin_role = adminRole;
-    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Admin role updated';
+    svc[field] = value;
+    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = okText;
     setTimeout(function() { msg.className = ''; msg.textContent = ''; }, 1500);
   });
 }
 
+function setHealthOverride(id, override) {
+  var msg = document.getElementById('services-msg');
+  api('PUT', '/services/' + id + '/health-override', { override: override }, function(err, svc) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; loadTab('services'); return; }
+    for (var i = 0; i < adminData.services.length; i++) {
+      if (adminData.services[i].id === id) adminData.services[i] = svc;
+    }
+    msg.className = 'admin-msg admin-msg-ok';
+    msg.textContent = override === 'auto' ? 'Health probing resumed' : 'Status pinned ' + override;
+    setTimeout(function() { msg.className = ''; msg.textContent = ''; }, 1500);
+  });
+}
+
+// moveService swaps a service with its neighbour in the list; the server
+// renumbers every service and returns the new order.
+function moveService(id, direction) {
+  var msg = document.getElementById('services-msg');
+  api('POST', '/services/' + id + '/move', { direction: direction }, function(err, svcs) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    adminData.services = svcs;
+    renderServices(document.getElementById('admin-content'));
+  });
+}
+
+function setSortOrder(id, order) {
+  var msg = document.getElementById('services-msg');
+  api('PUT', '/services/' + id + '/sort-order', { sort_order: order }, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    loadTab('services');
+  });
+}
+
+// uploadServiceIcon sends the chosen file as the raw request body (the
+// endpoint takes the image itself, not a form).
+function uploadServiceIcon(id, input) {
+  var file = input.files[0];
+  if (!file) return;
+  var msg = document.getElementById('services-msg');
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', '/admin/api/services/' + id + '/icon', true);
+  xhr.setRequestHeader('Content-Type', file.type || 'application/octet-stream');
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState !== 4) return;
+    var data = null;
+    try { data = JSON.parse(xhr.responseText); } catch (e) {}
+    if (xhr.status < 200 || xhr.status >= 300) {
+      msg.className = 'admin-msg admin-msg-err';
+      msg.textContent = (data && data.error) || 'upload failed';
+      return;
+    }
+    iconStamp = Date.now();
+    loadTab('services');
+  };
+  xhr.send(file);
+}
+
+function removeServiceIcon(id) {
+  if (!confirm('Remove the uploaded icon? The service\'s favicon will be used again.')) return;
+  api('DELETE', '/services/' + id + '/icon', null, function(err) {
+    if (err) { alert(err); return; }
+    iconStamp = Date.now();
+    loadTab('services');
+  });
+}
+
 function deleteService(id) {
   if (!confirm('Delete this service? Grants will also be removed.')) return;
   api('DELETE', '/services/' + id, null, function(err) {
@@ -689,7 +1214,7 @@ function renderAccess(el) {
     grantMap[g.user_id + ':' + g.service_id] = g;
   }
 
-  var html = '<table class="admin-tbl"><thead><tr><th>User</th>';
+  var html = renderRequests() + '<table class="admin-tbl"><thead><tr><th>User</th>';
   for (var i = 0; i < services.length; i++) {
     html += '<th style="text-align:center;font-size:0.75rem">' + esc(services[i].name) + '</th>';
   }
@@ -709,15 +1234,104 @@ function renderAccess(el) {
         '<br><input class="admin-input" style="width:60px;font-size:0.6875rem;margin-top:2px;text-align:center" ' +
         'value="' + esc(role) + '" ' +
         'onchange="updateGrantRole(' + u.id + ',' + s.id + ',this.value)"' +
-        (grant ? '' : ' disabled') + '></td>';
+        (grant ? '' : ' disabled') + '>' +
+        (grant ? '<br><a href="#" class="grant-note' + (grant.note ? ' has-note' : '') + '" title="' + esc(grant.note || 'Add a note').replace(/"/g, '&quot;') + '" ' +
+          'onclick="editGrantNote(' + u.id + ',' + s.id + ');return false">' + (grant.note ? 'note' : '+ note') + '</a>' : '') +
+        (grant ? '<br>' + grantExpiry(grant, u.id, s.id) : '') +
+        '</td>';
     }
     html += '</tr>';
   }
   html += '</tbody></table>';
   html += '<div id="access-msg"></div>';
+  if (ROLE === 'owner') html += '<div class="admin-form"><button class="admin-btn" onclick="testWebhook()" title="POST a sample access request to ACCESS_REQUEST_WEBHOOK">Send test webhook</button></div>';
+  if (ROLE === 'owner') html += renderTemplates();
   el.innerHTML = html;
 }
 
+function renderRequests() {
+  if (!adminData.requests.length) return '';
+  var html = '<div style="margin-bottom:1rem;border-bottom:1px solid #334155;padding-bottom:0.75rem">' +
+    '<div style="font-size:0.8125rem;color:#94a3b8;margin-bottom:0.5rem;font-weight:500">Access requests (' + adminData.requests.length + ')</div>' +
+    '<table class="admin-tbl"><thead><tr><th>User</th><th>Service</th><th>Reason</th><th>Requested</th><th></th></tr></thead><tbody>';
+  for (var i = 0; i < adminData.requests.length; i++) {
+    var r = adminData.requests[i];
+    html += '<tr><td>' + esc(r.user_handle || ('user ' + r.user_id)) + '</td><td>' + esc(r.service_name) + '</td>' +
+      '<td style="color:#94a3b8">' + esc(r.note) + '</td><td>' + esc(new Date(r.created_at).toLocaleString()) + '</td>' +
+      '<td style="white-space:nowrap"><button class="admin-btn" onclick="decideRequest(' + r.id + ',\'approve\')">Approve</button> ' +
+      '<button class="admin-btn-danger" onclick="decideRequest(' + r.id + ',\'deny\')">Deny</button></td></tr>';
+  }
+  html += '</tbody></table></div>';
+  return html;
+}
+
+function decideRequest(id, decision) {
+  api('POST', '/requests/' + id + '/' + decision, null, function(err) {
+    if (err) { alert(err); return; }
+    loadTab('access');
+  });
+}
+
+function testWebhook() {
+  var msg = document.getElementById('access-msg');
+  api('POST', '/webhooks/test', null, function(err, data) {
+    if (err) { msg.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+    var ok = data.status < 300;
+    msg.innerHTML = '<div class="admin-msg' + (ok ? ' admin-msg-ok' : ' admin-msg-err') + '">Webhook answered HTTP ' + data.status + ' in ' + data.latency_ms + 'ms</div>';
+  });
+}
+
+// renderTemplates lists the access templates for owners, with a form that
+// saves a user's current grants as a new template.
+function renderTemplates() {
+  var html = '<div style="margin-top:1rem;border-top:1px solid #334155;padding-top:0.75rem">' +
+    '<div style="font-size:0.8125rem;color:#94a3b8;margin-bottom:0.5rem;font-weight:500">Access templates</div>';
+  if (adminData.templates.length) {
+    html += '<table class="admin-tbl"><thead><tr><th>Name</th><th>Services</th><th></th></tr></thead><tbody>';
+    for (var i = 0; i < adminData.templates.length; i++) {
+      var t = adminData.templates[i];
+      var svcs = [];
+      for (var j = 0; j < t.services.length; j++) svcs.push(t.services[j].service_name + (t.services[j].role !== 'user' ? ' (' + t.services[j].role + ')' : ''));
+      html += '<tr><td title="' + esc(t.description).replace(/"/g, '&quot;') + '">' + esc(t.name) + '</td><td style="font-size:0.75rem;color:#94a3b8">' + esc(svcs.join(', ')) + '</td>' +
+        '<td><button class="admin-btn-danger" onclick="deleteTemplate(' + t.id + ')">Delete</button></td></tr>';
+    }
+    html += '</tbody></table>';
+  }
+  html += '<div class="admin-form"><select class="admin-select" id="tpl-from-user">';
+  for (var k = 0; k < adminData.users.length; k++) {
+    html += '<option value="' + adminData.users[k].id + '">' + esc(adminData.users[k].handle || adminData.users[k].did) + '</option>';
+  }
+  html += '</select><input class="admin-input" id="tpl-name" placeholder="template name" maxlength="100" style="flex:1;min-width:120px">' +
+    '<button class="admin-btn" onclick="saveTemplateFromUser()" title="Save the user\'s current grants and roles as a template">Save grants as template</button></div>' +
+    '<div id="templates-msg"></div></div>';
+  return html;
+}
+
+function saveTemplateFromUser() {
+  var userId = parseInt(document.getElementById('tpl-from-user').value, 10);
+  var name = document.getElementById('tpl-name').value.trim();
+  var msg = document.getElementById('templates-msg');
+  var services = [];
+  for (var i = 0; i < adminData.grants.length; i++) {
+    var g = adminData.grants[i];
+    if (g.user_id === userId) services.push({ service_id: g.service_id, role: g.role });
+  }
+  if (!name) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = 'Name the template first'; return; }
+  if (!services.length) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = 'That user has no grants to copy'; return; }
+  api('POST', '/access-templates', { name: name, services: services }, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    loadTab('access');
+  });
+}
+
+function deleteTemplate(id) {
+  if (!confirm('Delete this template? Grants already applied from it stay.')) return;
+  api('DELETE', '/access-templates/' + id, null, function(err) {
+    if (err) { alert(err); return; }
+    loadTab('access');
+  });
+}
+
 function toggleGrant(userId, serviceId, checked) {
   var msg = document.getElementById('access-msg');
   if (checked) {
@@ -729,11 +1343,7 @@ function toggleGrant(userId, serviceId, checked) {
       });
     });
   } else {
-    var grant = null;
-    for (var i = 0; i < adminData.grants.length; i++) {
-      var g = adminData.grants[i];
-      if (g.user_id === userId && g.service_id === serviceId) { grant = g; break; }
-    }
+    var grant = findGrant(userId, serviceId);
     if (grant) {
       api('DELETE', '/grants/' + grant.id, null, function(err) {
         if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; loadTab('access'); return; }
@@ -746,6 +1356,64 @@ function toggleGrant(userId, serviceId, checked) {
   }
 }
 
+// grantExpiry is a grant's expiry link in the access matrix: time left
+// (amber under a day) or "+ expiry" for grants that don't expire.
+function grantExpiry(grant, userId, serviceId) {
+  var onclick = ' onclick="editGrantExpiry(' + userId + ',' + serviceId + ');return false"';
+  if (!grant.expires_at) return '<a href="#" class="grant-note" title="Set an expiry"' + onclick + '>+ expiry</a>';
+  var left = new Date(grant.expires_at).getTime() - Date.now();
+  var label = left >= 86400000 ? Math.floor(left / 86400000) + 'd' : Math.max(1, Math.floor(left / 3600000)) + 'h';
+  return '<a href="#" class="grant-note grant-expiry' + (left < 86400000 ? ' soon' : '') + '" title="Expires ' +
+    esc(new Date(grant.expires_at).toLocaleString()) + '"' + onclick + '>expires in ' + label + '</a>';
+}
+
+function editGrantExpiry(userId, serviceId) {
+  var grant = findGrant(userId, serviceId);
+  if (!grant) return;
+  var d = prompt('Access expires in (e.g. 168h for 7 days, or never)', grant.expires_at ? 'never' : '168h');
+  if (d === null || !d.trim()) return;
+  d = d.trim();
+  var body = { user_id: userId, service_id: serviceId, role: grant.role };
+  if (d.toLowerCase() === 'never') body.no_expiry = true; else body.expires_in = d;
+  var msg = document.getElementById('access-msg');
+  api('POST', '/grants', body, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    api('GET', '/grants', null, function(err2, grants) {
+      if (!err2) adminData.grants = grants;
+      renderAccess(document.getElementById('admin-content'));
+      var m = document.getElementById('access-msg');
+      m.className = 'admin-msg admin-msg-ok'; m.textContent = body.no_expiry ? 'Expiry removed' : 'Expiry set';
+      setTimeout(function() { m.className = ''; m.textContent = ''; }, 1500);
+    });
+  });
+}
+
+function findGrant(userId, serviceId) {
+  for (var i = 0; i < adminData.grants.length; i++) {
+    var g = adminData.grants[i];
+    if (g.user_id === userId && g.service_id === serviceId) return g;
+  }
+  return null;
+}
+
+function editGrantNote(userId, serviceId) {
+  var grant = findGrant(userId, serviceId);
+  if (!grant) return;
+  var note = prompt('Why does this user have access? (max 500 characters)', grant.note || '');
+  if (note === null) return;
+  var msg = document.getElementById('access-msg');
+  api('POST', '/grants', { user_id: userId, service_id: serviceId, role: grant.role, note: note }, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    api('GET', '/grants', null, function(err2, grants) {
+      if (!err2) adminData.grants = grants;
+      renderAccess(document.getElementById('admin-content'));
+      var m = document.getElementById('access-msg');
+      m.className = 'admin-msg admin-msg-ok'; m.textContent = 'Note saved';
+      setTimeout(function() { m.className = ''; m.textContent = ''; }, 1500);
+    });
+  });
+}
+
 function updateGrantRole(userId, serviceId, role) {
   var msg = document.getElementById('access-msg');
   api('POST', '/grants', { user_id: userId, service_id: serviceId, role: role }, function(err) {
@@ -1,31 +1,51 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/atproto"
 	"github.com/primal-host/noknok/internal/database"
-	"github.com/primal-host/noknok/internal/session"
 )
 
 var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,39}$`)
 
-const ctxKeyUser = "admin_user"
+const (
+	ctxKeyUser       = "admin_user"
+	ctxKeyAdminScope = "admin_scope"
+)
+
+// errOutOfScope is the 403 message for a scoped admin touching a service
+// outside their scope.
+const errOutOfScope = "service is outside your admin scope"
 
 // requireAdmin validates the session and ensures the user is owner or admin.
+// A scoped admin's services are loaded for inAdminScope.
 func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cookie, err := c.Cookie(session.CookieName())
+		cookie, err := c.Cookie(s.sess.CookieName())
 		if err != nil || cookie.Value == "" {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
 		}
-		sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+		sess, err := s.validateSession(c, cookie.Value)
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
 		}
@@ -36,6 +56,19 @@ func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 		if user.Role != "owner" && user.Role != "admin" {
 			return c.JSON(http.StatusForbidden, map[string]string{"error": "admin access required"})
 		}
+		if user.Role == "admin" {
+			scoped, ids, err := s.db.AdminScope(c.Request().Context(), user.ID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load admin scope"})
+			}
+			if scoped {
+				scope := make(map[int64]bool, len(ids))
+				for _, id := range ids {
+					scope[id] = true
+				}
+				c.Set(ctxKeyAdminScope, scope)
+			}
+		}
 		c.Set(ctxKeyUser, user)
 		return next(c)
 	}
@@ -45,6 +78,197 @@ func adminUser(c echo.Context) *database.User {
 	return c.Get(ctxKeyUser).(*database.User)
 }
 
+// adminScoped reports whether the caller is an admin limited to a set of
+// services. Scoped admins can't make changes that span all services.
+func adminScoped(c echo.Context) bool {
+	return c.Get(ctxKeyAdminScope) != nil
+}
+
+// inAdminScope reports whether the caller may manage serviceID: owners and
+// unscoped admins may manage every service.
+func inAdminScope(c echo.Context, serviceID int64) bool {
+	scope, ok := c.Get(ctxKeyAdminScope).(map[int64]bool)
+	return !ok || scope[serviceID]
+}
+
+// bindJSON binds the request body into v. On failure the returned error is
+// a client-facing message saying what was wrong: malformed JSON, a field of
+// the wrong type (naming the field), or an unsupported content type.
+func bindJSON(c echo.Context, v any) error {
+	err := c.Bind(v)
+	if err == nil {
+		return nil
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		if he.Code == http.StatusUnsupportedMediaType {
+			return errors.New("content type must be application/json")
+		}
+		if he.Internal != nil {
+			err = he.Internal
+		}
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed JSON at offset %d: %s", syntaxErr.Offset, syntaxErr.Error())
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Errorf("request body must be %s", jsonTypeName(typeErr.Type))
+		}
+		return fmt.Errorf("field %q must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+	case errors.As(err, &timeErr):
+		return fmt.Errorf("invalid timestamp %q: want RFC 3339, e.g. 2006-01-02T15:04:05Z", timeErr.Value)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("malformed JSON: unexpected end of body")
+	}
+	return errors.New("invalid request")
+}
+
+// jsonTypeName describes a Go type in JSON terms for bind errors.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// --- Dashboard ---
+
+// dashboardAuditEntries is how many recent audit entries the dashboard shows.
+const dashboardAuditEntries = 10
+
+type dashboardUsers struct {
+	Total  int            `json:"total"`
+	ByRole map[string]int `json:"by_role"`
+}
+
+type dashboardServices struct {
+	Total   int `json:"total"`
+	Enabled int `json:"enabled"`
+	Public  int `json:"public"`
+}
+
+type dashboardGrants struct {
+	Active          int64 `json:"active"`
+	UsersWithAccess int   `json:"users_with_access"`
+}
+
+// dashboardHostCache counts forwardAuth's host → service lookups answered
+// from memory (hits) and from the database (misses).
+type dashboardHostCache struct {
+	Enabled bool   `json:"enabled"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+type dashboardHealth struct {
+	Up        int        `json:"up"`
+	Down      int        `json:"down"`
+	Unknown   int        `json:"unknown"`
+	Skipped   int        `json:"skipped"`
+	CheckedAt *time.Time `json:"checked_at"`
+}
+
+// handleDashboard returns the admin overview in one response: user,
+// service, and grant totals, the poller's cached health summary, and the
+// most recent audit entries. The four queries run concurrently; health
+// comes from the cache, so no checks are run.
+func (s *Server) handleDashboard(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var (
+		wg       sync.WaitGroup
+		users    []database.User
+		svcs     []database.Service
+		counts   database.GrantCounts
+		audit    []database.AuditEntry
+		usersErr error
+		svcsErr  error
+		countErr error
+		auditErr error
+	)
+	wg.Add(4)
+	go func() { defer wg.Done(); users, usersErr = s.db.ListUsers(ctx) }()
+	go func() { defer wg.Done(); svcs, svcsErr = s.db.ListServices(ctx, "") }()
+	go func() { defer wg.Done(); counts, countErr = s.db.CountGrants(ctx) }()
+	go func() { defer wg.Done(); audit, auditErr = s.db.ListAudit(ctx, "", 0, dashboardAuditEntries) }()
+	wg.Wait()
+	if err := errors.Join(usersErr, svcsErr, countErr, auditErr); err != nil {
+		slog.Error("dashboard: failed to load", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load dashboard"})
+	}
+
+	du := dashboardUsers{Total: len(users), ByRole: map[string]int{}}
+	for _, u := range users {
+		du.ByRole[u.Role]++
+	}
+
+	var ds dashboardServices
+	var dh dashboardHealth
+	health := s.cachedHealth()
+	for _, svc := range svcs {
+		ds.Total++
+		if svc.Enabled {
+			ds.Enabled++
+		}
+		if svc.Public {
+			ds.Public++
+		}
+		sample, checked := health[svc.ID]
+		switch {
+		case svc.SkipHealthCheck:
+			dh.Skipped++
+		case !checked:
+			dh.Unknown++
+		case sample.Alive:
+			dh.Up++
+		default:
+			dh.Down++
+		}
+	}
+	if _, at := s.healthTimes(); !at.IsZero() {
+		dh.CheckedAt = &at
+	}
+
+	dg := dashboardGrants{UsersWithAccess: len(counts.Users)}
+	for _, n := range counts.Users {
+		dg.Active += n
+	}
+
+	dc := dashboardHostCache{Enabled: s.cfg.ServiceHostCacheTTL > 0}
+	dc.Hits, dc.Misses = s.db.HostCacheStats()
+
+	if audit == nil {
+		audit = []database.AuditEntry{}
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"users":        du,
+		"services":     ds,
+		"grants":       dg,
+		"health":       dh,
+		"host_cache":   dc,
+		"recent_audit": audit,
+	})
+}
+
 // --- Users ---
 
 func (s *Server) handleListUsers(c echo.Context) error {
@@ -58,6 +282,64 @@ func (s *Server) handleListUsers(c echo.Context) error {
 	return c.JSON(http.StatusOK, users)
 }
 
+// handleListSignups lists self-registered users awaiting approval, then
+// those already denied.
+func (s *Server) handleListSignups(c echo.Context) error {
+	users, err := s.db.ListSignups(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list pending users"})
+	}
+	if users == nil {
+		users = []database.User{}
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// handleApproveSignup makes a pending (or previously denied) user active.
+// They start with no grants.
+func (s *Server) handleApproveSignup(c echo.Context) error {
+	return s.decideSignup(c, database.UserActive, "user.approve")
+}
+
+// handleDenySignup turns down a pending user. The user is kept as denied so
+// signing in again shows a refusal instead of a new request; deleting them
+// lets them sign up afresh.
+func (s *Server) handleDenySignup(c echo.Context) error {
+	return s.decideSignup(c, database.UserDenied, "user.deny")
+}
+
+func (s *Server) decideSignup(c echo.Context, status, action string) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	if adminScoped(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "scoped admins can't decide sign-ups"})
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+	user, err := s.db.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+	}
+	if user.Status == database.UserActive || user.Status == status {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "user is already " + user.Status})
+	}
+
+	if err := s.db.SetUserStatus(ctx, id, status); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
+	}
+	if err := s.db.RecordAudit(ctx, caller, action, "user", strconv.FormatInt(id, 10),
+		map[string]any{"handle": user.Handle, "from": user.Status}); err != nil {
+		slog.Warn("audit record failed", "action", action, "error", err)
+	}
+
+	slog.Info("signup decided", "user_id", id, "handle", user.Handle, "status", status, "by", caller.Handle)
+	user.Status = status
+	return c.JSON(http.StatusOK, user)
+}
+
 func (s *Server) handleCreateUser(c echo.Context) error {
 	caller := adminUser(c)
 
@@ -66,8 +348,8 @@ func (s *Server) handleCreateUser(c echo.Context) error {
 		Role     string `json:"role"`
 		Username string `json:"username"`
 	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	if req.Handle == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "handle is required"})
@@ -88,6 +370,9 @@ func (s *Server) handleCreateUser(c echo.Context) error {
 	did, resolvedHandle, err := s.oauth.ResolveHandle(c.Request().Context(), req.Handle)
 	if err != nil {
 		slog.Warn("handle resolution failed", "handle", req.Handle, "error", err)
+		if errors.Is(err, atproto.ErrDirectoryUnavailable) {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "identity service unavailable; try again shortly"})
+		}
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "could not resolve handle"})
 	}
 
@@ -101,6 +386,9 @@ func (s *Server) handleCreateUser(c echo.Context) error {
 	}
 
 	user, err := s.db.CreateUser(c.Request().Context(), req.Role, req.Username)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		return s.usernameConflict(c, req.Username)
+	}
 	if err != nil {
 		slog.Warn("create user failed", "error", err)
 		return c.JSON(http.StatusConflict, map[string]string{"error": "user already exists"})
@@ -115,13 +403,153 @@ func (s *Server) handleCreateUser(c echo.Context) error {
 	user.DID = did
 	user.Handle = resolvedHandle
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "user.create", "user", strconv.FormatInt(user.ID, 10),
+		map[string]any{"did": did, "handle": resolvedHandle, "role": req.Role, "username": req.Username}); err != nil {
+		slog.Warn("audit record failed", "action", "user.create", "error", err)
+	}
+
 	slog.Info("user created", "did", did, "handle", resolvedHandle, "role", req.Role, "by", caller.Handle)
 	return c.JSON(http.StatusCreated, user)
 }
 
+// bulkImportMax caps the users one bulk import may create.
+const bulkImportMax = 500
+
+// bulkImportResult is the outcome for one handle of a bulk import, in the
+// order the handles were given.
+type bulkImportResult struct {
+	Handle    string `json:"handle"`
+	DID       string `json:"did,omitempty"`
+	UserID    int64  `json:"user_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMS int64  `json:"elapsed_ms"` // time spent resolving the handle
+}
+
+// handleBulkCreateUsers creates a user for each handle in the request, with
+// one role for all of them. Handles are resolved BULK_RESOLVE_CONCURRENCY at
+// a time, each limited to BULK_RESOLVE_TIMEOUT, so a few slow ones don't
+// hold up the batch; those that fail are reported and the rest are created.
+func (s *Server) handleBulkCreateUsers(c echo.Context) error {
+	caller := adminUser(c)
+
+	var req struct {
+		Role  string `json:"role"`
+		Users []struct {
+			Handle   string `json:"handle"`
+			Username string `json:"username"`
+		} `json:"users"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(req.Users) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "users is required"})
+	}
+	if len(req.Users) > bulkImportMax {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d users per import", bulkImportMax)})
+	}
+	if req.Role == "" {
+		req.Role = "user"
+	}
+	if caller.Role != "owner" && req.Role != "user" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "only owners can assign admin/owner roles"})
+	}
+	if req.Role != "user" && req.Role != "admin" && req.Role != "owner" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid role"})
+	}
+
+	ctx := c.Request().Context()
+	results := make([]bulkImportResult, len(req.Users))
+	handles := make([]string, len(req.Users))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.cfg.BulkResolveConcurrency)
+	for i, u := range req.Users {
+		results[i].Handle = strings.TrimPrefix(strings.TrimSpace(u.Handle), "@")
+		switch {
+		case results[i].Handle == "":
+			results[i].Error = "handle is required"
+			continue
+		case u.Username != "" && !validUsername.MatchString(u.Username):
+			results[i].Error = "invalid username (alphanumeric, hyphens, underscores, 1-39 chars)"
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *bulkImportResult, handle *string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			rctx, cancel := context.WithTimeout(ctx, s.cfg.BulkResolveTimeout)
+			defer cancel()
+			start := time.Now()
+			did, resolved, err := s.oauth.ResolveHandle(rctx, r.Handle)
+			r.ElapsedMS = time.Since(start).Milliseconds()
+			switch {
+			case errors.Is(err, context.DeadlineExceeded) || errors.Is(rctx.Err(), context.DeadlineExceeded):
+				r.Error = "timed out resolving handle"
+			case errors.Is(err, atproto.ErrDirectoryUnavailable):
+				r.Error = "identity service unavailable"
+			case err != nil:
+				r.Error = "could not resolve handle"
+			default:
+				r.DID, *handle = did, resolved
+			}
+		}(&results[i], &handles[i])
+	}
+	wg.Wait()
+
+	// Users are created one at a time, so a DID listed twice is caught as
+	// already existing the second time.
+	created := 0
+	for i := range results {
+		r := &results[i]
+		if r.Error != "" {
+			continue
+		}
+		if exists, _ := s.db.UserExists(ctx, r.DID); exists {
+			r.Error = "identity already exists"
+			continue
+		}
+		user, err := s.db.CreateUser(ctx, req.Role, req.Users[i].Username)
+		if errors.Is(err, database.ErrUsernameTaken) {
+			r.Error = "username already taken"
+			continue
+		}
+		if err != nil {
+			slog.Warn("bulk create user failed", "handle", r.Handle, "error", err)
+			r.Error = "failed to create user"
+			continue
+		}
+		if _, err := s.db.AddIdentity(ctx, user.ID, r.DID, handles[i], true); err != nil {
+			slog.Warn("add identity failed", "did", r.DID, "error", err)
+			_ = s.db.DeleteUser(ctx, user.ID)
+			r.Error = "failed to add identity"
+			continue
+		}
+		r.UserID = user.ID
+		r.Handle = handles[i]
+		created++
+	}
+	failed := len(results) - created
+	if err := s.db.RecordAudit(ctx, caller, "users.bulk_import", "user", "",
+		map[string]any{"role": req.Role, "created": created, "failed": failed}); err != nil {
+		slog.Warn("audit record failed", "action", "users.bulk_import", "error", err)
+	}
+
+	slog.Info("users bulk imported", "created", created, "failed", failed, "role", req.Role, "by", caller.Handle)
+	return c.JSON(http.StatusOK, map[string]any{
+		"created": created,
+		"failed":  failed,
+		"results": results,
+	})
+}
+
 func (s *Server) handleUpdateUserRole(c echo.Context) error {
 	caller := adminUser(c)
 
+	if adminScoped(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "scoped admins can't change roles"})
+	}
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
@@ -130,8 +558,8 @@ func (s *Server) handleUpdateUserRole(c echo.Context) error {
 	var req struct {
 		Role string `json:"role"`
 	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	if req.Role != "user" && req.Role != "admin" && req.Role != "owner" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid role"})
@@ -142,21 +570,40 @@ func (s *Server) handleUpdateUserRole(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, map[string]string{"error": "only owners can assign admin/owner roles"})
 	}
 
-	// Prevent changing the seed owner's role.
-	users, err := s.db.ListUsers(c.Request().Context())
+	target, err := s.db.GetUserByID(c.Request().Context(), id)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
 	}
-	for _, u := range users {
-		if u.ID == id && u.DID == s.cfg.OwnerDID {
-			return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot change seed owner role"})
-		}
+	if !canManageUser(caller, target) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot change the role of a user who ranks as high as you"})
+	}
+
+	// Prevent changing the seed owner's role.
+	if target.DID == s.cfg.OwnerDID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot change seed owner role"})
 	}
 
 	if err := s.db.UpdateUserRole(c.Request().Context(), id, req.Role); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
 	}
 
+	// Role checks are re-resolved on every request, so a downgrade already
+	// takes effect immediately. Optionally also end the user's sessions so
+	// open portal tabs stop showing elevated controls.
+	if s.cfg.RevokeSessionsOnDowngrade && database.RoleRank(req.Role) < database.RoleRank(target.Role) {
+		n, err := s.sess.DestroyUser(c.Request().Context(), id)
+		if err != nil {
+			slog.Warn("failed to revoke sessions after downgrade", "user_id", id, "error", err)
+		} else {
+			slog.Info("sessions revoked after downgrade", "user_id", id, "count", n)
+		}
+	}
+
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "user.role", "user", strconv.FormatInt(id, 10),
+		map[string]any{"handle": target.Handle, "from": target.Role, "to": req.Role}); err != nil {
+		slog.Warn("audit record failed", "action", "user.role", "error", err)
+	}
+
 	slog.Info("user role updated", "user_id", id, "role", req.Role, "by", caller.Handle)
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -164,6 +611,9 @@ func (s *Server) handleUpdateUserRole(c echo.Context) error {
 func (s *Server) handleUpdateUserUsername(c echo.Context) error {
 	caller := adminUser(c)
 
+	if adminScoped(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "scoped admins can't change usernames"})
+	}
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
@@ -172,23 +622,67 @@ func (s *Server) handleUpdateUserUsername(c echo.Context) error {
 	var req struct {
 		Username string `json:"username"`
 	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	if req.Username != "" && !validUsername.MatchString(req.Username) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid username (alphanumeric, hyphens, underscores, 1-39 chars)"})
 	}
 
-	if err := s.db.UpdateUserUsername(c.Request().Context(), id, req.Username); err != nil {
+	target, err := s.db.GetUserByID(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+	}
+	if !canManageUser(caller, target) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot change the username of a user who ranks as high as you"})
+	}
+
+	err = s.db.UpdateUserUsername(c.Request().Context(), id, req.Username)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		return s.usernameConflict(c, req.Username)
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update username"})
 	}
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "user.username", "user", strconv.FormatInt(id, 10),
+		map[string]any{"handle": target.Handle, "from": target.Username, "to": req.Username}); err != nil {
+		slog.Warn("audit record failed", "action", "user.username", "error", err)
+	}
+
 	slog.Info("user username updated", "user_id", id, "username", req.Username, "by", caller.Handle)
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// canManageUser reports whether caller may change target's role or
+// username: owners may change anyone, admins only users ranked below them.
+func canManageUser(caller, target *database.User) bool {
+	return caller.Role == "owner" || database.RoleRank(target.Role) < database.RoleRank(caller.Role)
+}
+
+// usernameConflict answers a taken username with 409 and, when one is
+// free, a numbered alternative in both the message and "suggestion".
+func (s *Server) usernameConflict(c echo.Context, username string) error {
+	suggestion, err := s.db.SuggestUsername(c.Request().Context(), username)
+	if err != nil {
+		slog.Warn("username suggestion failed", "username", username, "error", err)
+	}
+	if suggestion == "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "username " + username + " is already taken"})
+	}
+	return c.JSON(http.StatusConflict, map[string]string{
+		"error":      "username " + username + " is already taken (try " + suggestion + ")",
+		"suggestion": suggestion,
+	})
+}
+
+// handleDeleteUser deletes a user. Scoped admins can't, and admins can only
+// delete users with the user role.
 func (s *Server) handleDeleteUser(c echo.Context) error {
 	caller := adminUser(c)
+	if adminScoped(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "scoped admins can't delete users"})
+	}
 
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -205,8 +699,10 @@ func (s *Server) handleDeleteUser(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
 	}
+	var target database.User
 	for _, u := range users {
 		if u.ID == id {
+			target = u
 			if u.DID == s.cfg.OwnerDID {
 				return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot delete seed owner"})
 			}
@@ -222,180 +718,1175 @@ func (s *Server) handleDeleteUser(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
 	}
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "user.delete", "user", strconv.FormatInt(id, 10),
+		map[string]any{"did": target.DID, "handle": target.Handle, "role": target.Role}); err != nil {
+		slog.Warn("audit record failed", "action", "user.delete", "error", err)
+	}
+
 	slog.Info("user deleted", "user_id", id, "by", caller.Handle)
 	return c.NoContent(http.StatusNoContent)
 }
 
-// --- Services ---
-
-func (s *Server) handleListServicesAdmin(c echo.Context) error {
-	svcs, err := s.db.ListServices(c.Request().Context())
+// handleUserLoginLink returns the login URL to send a pre-created user.
+// Login is OAuth-based, so the link carries no secret; an optional
+// ?redirect= is included when it points at an allowed domain.
+func (s *Server) handleUserLoginLink(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
 	}
-	if svcs == nil {
-		svcs = []database.Service{}
+	user, err := s.db.GetUserByID(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
 	}
-	return c.JSON(http.StatusOK, svcs)
+
+	link := s.cfg.PublicURL + "/login"
+	if redirect := c.QueryParam("redirect"); redirect != "" {
+		if !isAllowedRedirect(redirect, s.cfg) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "redirect is not on an allowed domain"})
+		}
+		link += "?redirect=" + url.QueryEscape(redirect)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"url": link, "handle": user.Handle})
 }
 
-func (s *Server) handleCreateService(c echo.Context) error {
-	caller := adminUser(c)
+// effectiveRole is the role forwardAuth would resolve for a user on a service.
+type effectiveRole struct {
+	ServiceID int64  `json:"service_id"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	Enabled   bool   `json:"enabled"`
+	Role      string `json:"role"` // empty means no access
+}
 
-	var req struct {
-		Slug        string `json:"slug"`
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		URL         string `json:"url"`
-		IconURL     string `json:"icon_url"`
-		AdminRole   string `json:"admin_role"`
+// handleUserDebug gathers what support needs to answer "why can't I reach
+// X": the user's identities, active sessions, grants (expired included), and
+// the effective role per service as resolved by GetUserServiceRole for the
+// primary identity.
+func (s *Server) handleUserDebug(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
 	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	ctx := c.Request().Context()
+
+	user, err := s.db.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
 	}
-	if req.Slug == "" || req.Name == "" || req.URL == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "slug, name, and url are required"})
+	ids, err := s.db.ListIdentities(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list identities"})
 	}
-
-	svc, err := s.db.CreateService(c.Request().Context(), req.Slug, req.Name, req.Description, req.URL, req.IconURL, req.AdminRole)
+	sessions, err := s.db.ListUserSessions(ctx, id)
 	if err != nil {
-		return c.JSON(http.StatusConflict, map[string]string{"error": "service slug already exists"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
 	}
-
-	slog.Info("service created", "slug", req.Slug, "by", caller.Handle)
-	return c.JSON(http.StatusCreated, svc)
-}
-
-func (s *Server) handleUpdateService(c echo.Context) error {
-	caller := adminUser(c)
-
-	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	grants, err := s.db.ListUserGrants(ctx, id)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list grants"})
+	}
+	svcs, err := s.db.ListServices(ctx, "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
 	}
 
-	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		URL         string `json:"url"`
-		IconURL     string `json:"icon_url"`
-		AdminRole   string `json:"admin_role"`
+	roles := []effectiveRole{}
+	if len(ids) > 0 {
+		// ListIdentities puts the primary identity first.
+		did := ids[0].DID
+		for _, svc := range svcs {
+			u, err := url.Parse(svc.URL)
+			if err != nil || u.Host == "" {
+				continue
+			}
+			role, err := s.db.GetUserServiceRole(ctx, did, u.Host)
+			if err != nil && !errors.Is(err, database.ErrUserInactive) {
+				slog.Warn("user debug: role lookup failed", "user_id", id, "service", svc.Slug, "error", err)
+			}
+			roles = append(roles, effectiveRole{
+				ServiceID: svc.ID, Slug: svc.Slug, Name: svc.Name, Enabled: svc.Enabled, Role: role,
+			})
+		}
 	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	if ids == nil {
+		ids = []database.Identity{}
 	}
-	if req.Name == "" || req.URL == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name and url are required"})
+	if sessions == nil {
+		sessions = []database.SessionInfo{}
 	}
-
-	if err := s.db.UpdateService(c.Request().Context(), id, req.Name, req.Description, req.URL, req.IconURL, req.AdminRole); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update service"})
+	if grants == nil {
+		grants = []database.Grant{}
 	}
 
-	slog.Info("service updated", "service_id", id, "by", caller.Handle)
-	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	return c.JSON(http.StatusOK, map[string]any{
+		"user":       user,
+		"identities": ids,
+		"sessions":   sessions,
+		"grants":     grants,
+		"roles":      roles,
+	})
 }
 
-func (s *Server) handleDeleteService(c echo.Context) error {
-	caller := adminUser(c)
+// adminScopeResponse is an admin's service scope. Scoped false means the
+// admin manages every service.
+type adminScopeResponse struct {
+	Scoped     bool    `json:"scoped"`
+	ServiceIDs []int64 `json:"service_ids"`
+}
 
+// handleGetAdminScope returns the services an admin is limited to. Owner only.
+func (s *Server) handleGetAdminScope(c echo.Context) error {
+	if adminUser(c).Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
 	}
-
-	if err := s.db.DeleteService(c.Request().Context(), id); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete service"})
+	ctx := c.Request().Context()
+	if _, err := s.db.GetUserByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
 	}
-
-	slog.Info("service deleted", "service_id", id, "by", caller.Handle)
-	return c.NoContent(http.StatusNoContent)
+	scoped, ids, err := s.db.AdminScope(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load admin scope"})
+	}
+	return c.JSON(http.StatusOK, adminScopeResponse{Scoped: scoped, ServiceIDs: ids})
 }
 
-func (s *Server) handleToggleServiceEnabled(c echo.Context) error {
+// handleSetAdminScope limits an admin to managing a set of services, or
+// with scoped false lets them manage all of them again. A scoped admin can
+// only edit, toggle, and delete those services and grant or revoke access
+// to them; creating services and reassigning grants wholesale stay with
+// owners and unscoped admins. Owner only.
+func (s *Server) handleSetAdminScope(c echo.Context) error {
 	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
 	}
-	enabled, err := s.db.ToggleServiceEnabled(c.Request().Context(), id)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to toggle"})
+	var req adminScopeResponse
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-	slog.Info("service enabled toggled", "service_id", id, "enabled", enabled, "by", caller.Handle)
-	return c.JSON(http.StatusOK, map[string]bool{"enabled": enabled})
-}
 
-func (s *Server) handleToggleServicePublic(c echo.Context) error {
-	caller := adminUser(c)
-	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	ctx := c.Request().Context()
+	target, err := s.db.GetUserByID(ctx, id)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+	}
+	if target.Role != "admin" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "only admins can be scoped"})
+	}
+	if !req.Scoped {
+		req.ServiceIDs = nil
+	}
+	err = s.db.SetAdminScope(ctx, id, req.Scoped, req.ServiceIDs)
+	if errors.Is(err, database.ErrUnknownService) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown service in service_ids"})
 	}
-	public, err := s.db.ToggleServicePublic(c.Request().Context(), id)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to toggle"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to set admin scope"})
 	}
-	slog.Info("service public toggled", "service_id", id, "public", public, "by", caller.Handle)
-	return c.JSON(http.StatusOK, map[string]bool{"public": public})
+	if req.ServiceIDs == nil {
+		req.ServiceIDs = []int64{}
+	}
+
+	slog.Info("admin scope set", "user_id", id, "scoped", req.Scoped, "services", len(req.ServiceIDs), "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "admin.scope", "user", strconv.FormatInt(id, 10),
+		map[string]any{"scoped": req.Scoped, "service_ids": req.ServiceIDs}); err != nil {
+		slog.Warn("audit record failed", "action", "admin.scope", "error", err)
+	}
+	return c.JSON(http.StatusOK, req)
 }
 
-// checkServicesHealth runs parallel HEAD requests against service URLs
-// and returns a map of service ID → alive.
-func (s *Server) checkServicesHealth(svcs []database.Service) map[int64]bool {
-	client := &http.Client{
-		Timeout: 4 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+// handleReassignGrants moves all of a user's grants to another user,
+// e.g. when offboarding someone and handing their access to a successor.
+func (s *Server) handleReassignGrants(c echo.Context) error {
+	caller := adminUser(c)
+	if adminScoped(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "scoped admins can't reassign all grants"})
 	}
 
-	type result struct {
-		id    int64
-		alive bool
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
 	}
 
-	var wg sync.WaitGroup
+	var req struct {
+		TargetUserID int64 `json:"target_user_id"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.TargetUserID == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "target_user_id is required"})
+	}
+	if req.TargetUserID == id {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "source and target must differ"})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetUserByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+	}
+	if _, err := s.db.GetUserByID(ctx, req.TargetUserID); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "target user not found"})
+	}
+
+	moved, err := s.db.ReassignGrants(ctx, id, req.TargetUserID, caller.ID)
+	if err != nil {
+		slog.Error("reassign grants failed", "from", id, "to", req.TargetUserID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to reassign grants"})
+	}
+
+	if err := s.db.RecordAudit(ctx, caller, "grants.reassign", "user", strconv.FormatInt(id, 10),
+		map[string]any{"target_user_id": req.TargetUserID, "moved": moved}); err != nil {
+		slog.Warn("audit record failed", "action", "grants.reassign", "error", err)
+	}
+
+	slog.Info("grants reassigned", "from", id, "to", req.TargetUserID, "moved", moved, "by", caller.Handle)
+	return c.JSON(http.StatusOK, map[string]int64{"moved": moved})
+}
+
+// grantCleanupResponse reports a grants cleanup or its dry run.
+type grantCleanupResponse struct {
+	DryRun  bool                  `json:"dry_run"`
+	Deleted database.GrantCleanup `json:"deleted"`
+	Total   int64                 `json:"total"`
+}
+
+// handleCleanupGrants deletes grants that can never be used again (expired,
+// of declined users, or of users without an identity), or with
+// ?dry_run=true only reports what would go. Owner only.
+func (s *Server) handleCleanupGrants(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	ctx := c.Request().Context()
+	counts, err := s.db.CleanupGrants(ctx, dryRun)
+	if err != nil {
+		slog.Error("grants cleanup failed", "dry_run", dryRun, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to clean up grants"})
+	}
+
+	if !dryRun {
+		if err := s.db.RecordAudit(ctx, caller, "grants.cleanup", "", "",
+			map[string]any{"expired": counts.Expired, "denied_user": counts.DeniedUser, "no_identity": counts.NoIdentity}); err != nil {
+			slog.Warn("audit record failed", "action", "grants.cleanup", "error", err)
+		}
+		slog.Info("grants cleaned up", "total", counts.Total(), "by", caller.Handle)
+	}
+	return c.JSON(http.StatusOK, grantCleanupResponse{DryRun: dryRun, Deleted: counts, Total: counts.Total()})
+}
+
+// resyncConcurrency bounds the parallel DID lookups of a handle resync.
+const resyncConcurrency = 8
+
+// handleChange is one identity whose handle changed during a resync.
+type handleChange struct {
+	DID       string `json:"did"`
+	OldHandle string `json:"old_handle"`
+	NewHandle string `json:"new_handle"`
+}
+
+// handleFailure is one identity whose handle couldn't be re-resolved.
+type handleFailure struct {
+	DID    string `json:"did"`
+	Handle string `json:"handle"`
+	Error  string `json:"error"`
+}
+
+// handleResyncHandles re-resolves the handle of every linked identity from
+// its DID and records the ones that changed, on the identity and on its
+// live sessions. Owner only; for bulk clean-up after handle migrations.
+func (s *Server) handleResyncHandles(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+
+	ctx := c.Request().Context()
+	ids, err := s.db.ListAllIdentities(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list identities"})
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		changed = []handleChange{}
+		failed  = []handleFailure{}
+	)
+	sem := make(chan struct{}, resyncConcurrency)
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id database.Identity) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			handle, err := s.oauth.ResolveDID(ctx, id.DID)
+			if err == nil && handle != id.Handle {
+				err = s.db.UpdateIdentityHandle(ctx, id.DID, handle)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				failed = append(failed, handleFailure{DID: id.DID, Handle: id.Handle, Error: err.Error()})
+			case handle != id.Handle:
+				changed = append(changed, handleChange{DID: id.DID, OldHandle: id.Handle, NewHandle: handle})
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if err := s.db.RecordAudit(ctx, caller, "users.resync_handles", "user", "",
+		map[string]any{"checked": len(ids), "changed": len(changed), "failed": len(failed)}); err != nil {
+		slog.Warn("audit record failed", "action", "users.resync_handles", "error", err)
+	}
+
+	slog.Info("handles resynced", "checked", len(ids), "changed", len(changed), "failed", len(failed), "by", caller.Handle)
+	return c.JSON(http.StatusOK, map[string]any{
+		"checked": len(ids),
+		"changed": changed,
+		"failed":  failed,
+	})
+}
+
+// --- Services ---
+
+func (s *Server) handleListServicesAdmin(c echo.Context) error {
+	svcs, err := s.db.ListServices(c.Request().Context(), "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
+	}
+	if svcs == nil {
+		svcs = []database.Service{}
+	}
+	return c.JSON(http.StatusOK, svcs)
+}
+
+// serviceRequest is the body of POST /services and PUT /services/:id. Only
+// the fields sent are applied, so an update leaves the rest of the service
+// as it is rather than resetting it.
+type serviceRequest struct {
+	Slug                *string `json:"slug"` // create only
+	Name                *string `json:"name"`
+	Description         *string `json:"description"`
+	URL                 *string `json:"url"`
+	IconURL             *string `json:"icon_url"`
+	AdminRole           *string `json:"admin_role"`
+	GrantTTLDays        *int    `json:"grant_ttl_days"`
+	Domain              *string `json:"domain"`
+	RequireReauthMaxAge *int    `json:"require_reauth_max_age"`
+	DenyMessage         *string `json:"deny_message"`
+	SkipHealthCheck     *bool   `json:"skip_health_check"`
+	HealthHeadOnly      *bool   `json:"health_head_only"`
+	HealthPath          *string `json:"health_path"`
+	HealthMethod        *string `json:"health_method"`
+	HealthExpectStatus  *int    `json:"health_expect_status"`
+	SkipTLSVerify       *bool   `json:"skip_tls_verify"`
+	HealthType          *string `json:"health_type"`
+	IssueToken          *bool   `json:"issue_token"`
+	Category            *string `json:"category"`
+}
+
+// apply copies the fields that were sent onto svc.
+func (r *serviceRequest) apply(svc *database.Service) {
+	setIfSent(&svc.Slug, r.Slug)
+	setIfSent(&svc.Name, r.Name)
+	setIfSent(&svc.Description, r.Description)
+	setIfSent(&svc.URL, r.URL)
+	setIfSent(&svc.IconURL, r.IconURL)
+	setIfSent(&svc.AdminRole, r.AdminRole)
+	setIfSent(&svc.GrantTTLDays, r.GrantTTLDays)
+	setIfSent(&svc.Domain, r.Domain)
+	setIfSent(&svc.RequireReauthMaxAge, r.RequireReauthMaxAge)
+	setIfSent(&svc.DenyMessage, r.DenyMessage)
+	setIfSent(&svc.SkipHealthCheck, r.SkipHealthCheck)
+	setIfSent(&svc.HealthHeadOnly, r.HealthHeadOnly)
+	setIfSent(&svc.HealthPath, r.HealthPath)
+	setIfSent(&svc.HealthMethod, r.HealthMethod)
+	setIfSent(&svc.HealthExpectStatus, r.HealthExpectStatus)
+	setIfSent(&svc.SkipTLSVerify, r.SkipTLSVerify)
+	setIfSent(&svc.HealthType, r.HealthType)
+	setIfSent(&svc.IssueToken, r.IssueToken)
+	setIfSent(&svc.Category, r.Category)
+}
+
+// setIfSent overwrites *dst with *src when src was sent.
+func setIfSent[T any](dst, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func (s *Server) handleCreateService(c echo.Context) error {
+	caller := adminUser(c)
+	if adminScoped(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "scoped admins can't create services"})
+	}
+
+	var body serviceRequest
+	if err := bindJSON(c, &body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	var req database.Service
+	body.apply(&req)
+	if req.Slug == "" || req.Name == "" || req.URL == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "slug, name, and url are required"})
+	}
+	if req.GrantTTLDays < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "grant_ttl_days must not be negative"})
+	}
+	if req.RequireReauthMaxAge < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "require_reauth_max_age must not be negative"})
+	}
+	req.DenyMessage = strings.TrimSpace(req.DenyMessage)
+	if utf8.RuneCountInString(req.DenyMessage) > maxDenyMessage {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("deny_message must be at most %d characters", maxDenyMessage)})
+	}
+	req.Category = strings.TrimSpace(req.Category)
+	if utf8.RuneCountInString(req.Category) > maxServiceCategory {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("category must be at most %d characters", maxServiceCategory)})
+	}
+	if !s.validServiceDomain(req.Domain) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "domain must be empty or one of COOKIE_DOMAINS"})
+	}
+	if msg := normalizeHealthProbe(&req); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	if s.cfg.IsPublicHost(req.URL) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "service url must not use noknok's own host"})
+	}
+	conflict, err := s.serviceHostConflict(c.Request().Context(), req.URL, 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to check service url"})
+	}
+	if conflict != "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": conflict})
+	}
+
+	svc, err := s.db.CreateService(c.Request().Context(), req)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "service slug already exists"})
+	}
+
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "service.create", "service", strconv.FormatInt(svc.ID, 10),
+		map[string]any{"slug": svc.Slug, "url": svc.URL}); err != nil {
+		slog.Warn("audit record failed", "action", "service.create", "error", err)
+	}
+
+	slog.Info("service created", "slug", req.Slug, "by", caller.Handle)
+	return c.JSON(http.StatusCreated, svc)
+}
+
+func (s *Server) handleUpdateService(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+
+	var body serviceRequest
+	if err := bindJSON(c, &body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if body.URL != nil && s.cfg.IsPublicHost(*body.URL) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "service url must not use noknok's own host"})
+	}
+	req, err := s.db.GetServiceByID(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	body.Slug = nil // slugs don't change
+	body.apply(req)
+	if req.Name == "" || req.URL == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name and url are required"})
+	}
+	if req.GrantTTLDays < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "grant_ttl_days must not be negative"})
+	}
+	if req.RequireReauthMaxAge < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "require_reauth_max_age must not be negative"})
+	}
+	req.DenyMessage = strings.TrimSpace(req.DenyMessage)
+	if utf8.RuneCountInString(req.DenyMessage) > maxDenyMessage {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("deny_message must be at most %d characters", maxDenyMessage)})
+	}
+	req.Category = strings.TrimSpace(req.Category)
+	if utf8.RuneCountInString(req.Category) > maxServiceCategory {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("category must be at most %d characters", maxServiceCategory)})
+	}
+	if !s.validServiceDomain(req.Domain) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "domain must be empty or one of COOKIE_DOMAINS"})
+	}
+	if msg := normalizeHealthProbe(req); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	conflict, err := s.serviceHostConflict(c.Request().Context(), req.URL, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to check service url"})
+	}
+	if conflict != "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": conflict})
+	}
+
+	if err := s.db.UpdateService(c.Request().Context(), id, *req); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update service"})
+	}
+
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "service.update", "service", strconv.FormatInt(id, 10),
+		map[string]any{"name": req.Name, "url": req.URL}); err != nil {
+		slog.Warn("audit record failed", "action", "service.update", "error", err)
+	}
+
+	slog.Info("service updated", "service_id", id, "by", caller.Handle)
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// maxDenyMessage caps the length of a service's deny message, in characters.
+const maxDenyMessage = 500
+
+// maxServiceCategory caps the length of a service's category, in characters.
+const maxServiceCategory = 50
+
+// serviceHostConflict enforces UNIQUE_SERVICE_HOSTS: it returns an error
+// message if a service other than excludeID already uses rawURL's host, or
+// "" when the host is free or enforcement is off.
+func (s *Server) serviceHostConflict(ctx context.Context, rawURL string, excludeID int64) (string, error) {
+	if !s.cfg.UniqueServiceHosts {
+		return "", nil
+	}
+	other, err := s.db.ServiceSharingHost(ctx, rawURL, excludeID)
+	if err != nil || other == nil {
+		return "", err
+	}
+	return fmt.Sprintf("service %q already uses host %s", other.Name, database.ServiceHost(rawURL)), nil
+}
+
+// maxHealthPath caps the length of a service's health_path.
+const maxHealthPath = 200
+
+// normalizeHealthProbe cleans up a service's probe settings in place and
+// returns an error message if they are invalid, or "".
+func normalizeHealthProbe(svc *database.Service) string {
+	svc.HealthPath = strings.TrimSpace(svc.HealthPath)
+	svc.HealthMethod = strings.ToUpper(strings.TrimSpace(svc.HealthMethod))
+	if svc.HealthMethod == "" {
+		svc.HealthMethod = http.MethodHead
+	}
+	if svc.HealthMethod != http.MethodHead && svc.HealthMethod != http.MethodGet {
+		return "health_method must be HEAD or GET"
+	}
+	if svc.HealthPath != "" && (!strings.HasPrefix(svc.HealthPath, "/") || strings.ContainsAny(svc.HealthPath, " \t\r\n")) {
+		return "health_path must be empty or a path starting with /"
+	}
+	if len(svc.HealthPath) > maxHealthPath {
+		return fmt.Sprintf("health_path must be at most %d characters", maxHealthPath)
+	}
+	if svc.HealthExpectStatus != 0 && (svc.HealthExpectStatus < 100 || svc.HealthExpectStatus > 599) {
+		return "health_expect_status must be 0 or an HTTP status (100-599)"
+	}
+	svc.HealthType = strings.ToLower(strings.TrimSpace(svc.HealthType))
+	switch svc.HealthType {
+	case "", "http":
+		svc.HealthType = "http"
+	case "tcp":
+		if _, err := tcpProbeAddr(svc.URL); err != nil {
+			return "health_type tcp needs a url with a host and a port (or a scheme with a well-known port)"
+		}
+	default:
+		return "health_type must be http or tcp"
+	}
+	return ""
+}
+
+// validServiceDomain reports whether domain is empty (global) or one of the
+// configured cookie domains.
+func (s *Server) validServiceDomain(domain string) bool {
+	return domain == "" || slices.Contains(s.cfg.CookieDomains, domain)
+}
+
+func (s *Server) handleDeleteService(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+
+	var slug string
+	if svc, err := s.db.GetServiceByID(c.Request().Context(), id); err == nil {
+		slug = svc.Slug
+	}
+	if err := s.db.DeleteService(c.Request().Context(), id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete service"})
+	}
+
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "service.delete", "service", strconv.FormatInt(id, 10),
+		map[string]any{"slug": slug}); err != nil {
+		slog.Warn("audit record failed", "action", "service.delete", "error", err)
+	}
+
+	slog.Info("service deleted", "service_id", id, "by", caller.Handle)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) handleToggleServiceEnabled(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	enabled, err := s.db.ToggleServiceEnabled(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to toggle"})
+	}
+	slog.Info("service enabled toggled", "service_id", id, "enabled", enabled, "by", caller.Handle)
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "service.enabled", "service", strconv.FormatInt(id, 10),
+		map[string]any{"enabled": enabled}); err != nil {
+		slog.Warn("audit record failed", "action", "service.enabled", "error", err)
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"enabled": enabled})
+}
+
+// handleServiceHealthOverride pins a service's health status for a
+// maintenance window, or clears the pin with "auto". The cache is updated
+// right away so the portal reflects the change without waiting for the
+// next poll; "auto" probes the service once to get there.
+func (s *Server) handleServiceHealthOverride(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	var req struct {
+		Override string `json:"override"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	switch req.Override {
+	case "up", "down", "auto":
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "override must be up, down, or auto"})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetServiceByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	svc, err := s.db.SetServiceHealthOverride(ctx, id, req.Override)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to set health override"})
+	}
+	s.setCachedHealth(*svc, s.checkServicesHealth([]database.Service{*svc})[svc.ID])
+
+	slog.Info("service health override set", "service_id", id, "override", req.Override, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "service.health_override", "service", strconv.FormatInt(id, 10),
+		map[string]any{"override": req.Override}); err != nil {
+		slog.Warn("audit record failed", "action", "service.health_override", "error", err)
+	}
+	return c.JSON(http.StatusOK, svc)
+}
+
+// maxSortOrder bounds an explicit service sort order either way.
+const maxSortOrder = 1000000
+
+// handleSetServiceSortOrder sets a service's sort order (body {"sort_order":
+// n}). Lower lists first on the portal; ties go by name.
+// PUT /admin/api/services/:id/sort-order
+func (s *Server) handleSetServiceSortOrder(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	var req struct {
+		SortOrder *int `json:"sort_order"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.SortOrder == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "sort_order is required"})
+	}
+	if *req.SortOrder < -maxSortOrder || *req.SortOrder > maxSortOrder {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("sort_order must be between %d and %d", -maxSortOrder, maxSortOrder)})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetServiceByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	svc, err := s.db.SetServiceSortOrder(ctx, id, *req.SortOrder)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to set sort order"})
+	}
+
+	slog.Info("service sort order set", "service_id", id, "sort_order", svc.SortOrder, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "service.sort_order", "service", strconv.FormatInt(id, 10),
+		map[string]any{"sort_order": svc.SortOrder}); err != nil {
+		slog.Warn("audit record failed", "action", "service.sort_order", "error", err)
+	}
+	return c.JSON(http.StatusOK, svc)
+}
+
+// handleMoveService moves a service one place up or down the service list
+// (body {"direction": "up"|"down"}), renumbering every service's sort
+// order. Returns the reordered list.
+// POST /admin/api/services/:id/move
+func (s *Server) handleMoveService(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	var req struct {
+		Direction string `json:"direction"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	var delta int
+	switch req.Direction {
+	case "up":
+		delta = -1
+	case "down":
+		delta = 1
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "direction must be up or down"})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetServiceByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	if err := s.db.MoveService(ctx, id, delta); err != nil {
+		slog.Error("service move failed", "service_id", id, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to move service"})
+	}
+
+	slog.Info("service moved", "service_id", id, "direction", req.Direction, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "service.move", "service", strconv.FormatInt(id, 10),
+		map[string]any{"direction": req.Direction}); err != nil {
+		slog.Warn("audit record failed", "action", "service.move", "error", err)
+	}
+	return s.handleListServicesAdmin(c)
+}
+
+func (s *Server) handleToggleServicePublic(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	public, err := s.db.ToggleServicePublic(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to toggle"})
+	}
+	slog.Info("service public toggled", "service_id", id, "public", public, "by", caller.Handle)
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "service.public", "service", strconv.FormatInt(id, 10),
+		map[string]any{"public": public}); err != nil {
+		slog.Warn("audit record failed", "action", "service.public", "error", err)
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"public": public})
+}
+
+// healthProbeTimeout bounds one health probe, HTTP or TCP.
+const healthProbeTimeout = 4 * time.Second
+
+// healthClient returns the HTTP client used for service health probes, and
+// for icon fetches. Certificates are verified unless skipVerify (for
+// services with skip_tls_verify, e.g. self-signed internal certs); the TLS
+// version floor applies either way. By default the first response is
+// judged, so a root that redirects to /login counts as alive; with
+// HEALTH_FOLLOW_REDIRECTS=N up to N hops are followed and the final status
+// is judged, and longer chains count as down.
+func (s *Server) healthClient(skipVerify bool) *http.Client {
+	maxHops := s.cfg.HealthFollowRedirects
+	return &http.Client{
+		Timeout:   healthProbeTimeout,
+		Transport: s.healthTransport(skipVerify),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if maxHops == 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxHops {
+				return fmt.Errorf("stopped after %d redirects", maxHops)
+			}
+			return nil
+		},
+	}
+}
+
+// healthTransport returns the transport behind healthClient. There is one
+// per skipVerify value, built on first use and shared by every probe round
+// and icon fetch, so connections are reused and idle ones closed.
+func (s *Server) healthTransport(skipVerify bool) *http.Transport {
+	s.tlsOnce.Do(func() {
+		for i, skip := range []bool{false, true} {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.Proxy = nil
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: skip, MinVersion: s.cfg.HealthTLSMinVersion}
+			s.transports[i] = t
+		}
+	})
+	if skipVerify {
+		return s.transports[1]
+	}
+	return s.transports[0]
+}
+
+// healthSample is one service's health check result. Services that aren't
+// probed (skip_health_check or a health override) have no latency and a
+// zero CheckedAt.
+type healthSample struct {
+	Alive     bool      `json:"alive"`
+	LatencyMs int64     `json:"latency_ms"` // round trip of the probe, including a GET fallback
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+}
+
+// checkServicesHealth probes services in parallel (see probeAlive, and
+// probeTCP for health_type tcp) and returns a sample per service ID.
+// Services with skip_health_check are not probed and count as alive, so
+// they never show as unreachable. A health override (maintenance) wins
+// over both.
+func (s *Server) checkServicesHealth(svcs []database.Service) map[int64]healthSample {
+	verifying, insecure := s.healthClient(false), s.healthClient(true)
+	userAgent := s.cfg.HealthUserAgent
+
+	type result struct {
+		id     int64
+		sample healthSample
+	}
+
+	var wg sync.WaitGroup
 	ch := make(chan result, len(svcs))
 	for _, svc := range svcs {
+		if svc.HealthOverride != "auto" {
+			ch <- result{svc.ID, healthSample{Alive: svc.HealthOverride == "up"}}
+			continue
+		}
+		if svc.SkipHealthCheck {
+			ch <- result{svc.ID, healthSample{Alive: true}}
+			continue
+		}
 		wg.Add(1)
-		go func(id int64, url string) {
+		client := verifying
+		if svc.SkipTLSVerify {
+			client = insecure
+		}
+		go func(svc database.Service) {
 			defer wg.Done()
-			resp, err := client.Head(url)
-			if err != nil {
-				ch <- result{id, false}
-				return
+			start := time.Now()
+			var alive bool
+			if svc.HealthType == "tcp" {
+				alive = probeTCP(svc.URL)
+			} else {
+				alive = probeAlive(client, userAgent, svc)
 			}
-			resp.Body.Close()
-			ch <- result{id, resp.StatusCode < 404}
-		}(svc.ID, svc.URL)
+			ch <- result{svc.ID, healthSample{Alive: alive, LatencyMs: time.Since(start).Milliseconds(), CheckedAt: time.Now()}}
+		}(svc)
 	}
 	wg.Wait()
 	close(ch)
 
-	health := make(map[int64]bool)
+	health := make(map[int64]healthSample)
 	for r := range ch {
-		health[r.id] = r.alive
+		health[r.id] = r.sample
 	}
 	return health
 }
 
+// probeAlive probes a service with its health_method (HEAD by default) and
+// reports whether it answered as alive. With health_expect_status only that
+// status counts. Otherwise the service URL itself counts as alive below 404,
+// so a root that wants a sign-in (401/403) is still up but a 5xx from a
+// failing backend is not; a health_path is a dedicated endpoint and must
+// answer 2xx or 3xx. Some servers refuse HEAD (405 or 501) while serving
+// GET fine; unless health_head_only, those are asked again with a GET
+// whose body is closed unread, and judged by that.
+func probeAlive(client *http.Client, userAgent string, svc database.Service) bool {
+	url := probeURL(svc)
+	method := svc.HealthMethod
+	if method == "" {
+		method = http.MethodHead
+	}
+	status, err := probeStatus(client, method, userAgent, url)
+	if err == nil && method == http.MethodHead && !svc.HealthHeadOnly &&
+		(status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = probeStatus(client, http.MethodGet, userAgent, url)
+	}
+	if err != nil {
+		return false
+	}
+	if svc.HealthExpectStatus != 0 {
+		return status == svc.HealthExpectStatus
+	}
+	if svc.HealthPath != "" {
+		return status >= 200 && status < 400
+	}
+	return status < 404
+}
+
+// probeTCP reports whether a TCP connection to the service URL's host and
+// port opens within healthProbeTimeout. It is closed right away.
+func probeTCP(rawURL string) bool {
+	addr, err := tcpProbeAddr(rawURL)
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", addr, healthProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// tcpProbeAddr returns the host:port a TCP health probe dials: the URL's
+// port, or the scheme's well-known port (ssh://, https://).
+func tcpProbeAddr(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", errors.New("url has no host")
+	}
+	port := u.Port()
+	if port == "" {
+		p, err := net.LookupPort("tcp", u.Scheme)
+		if err != nil {
+			return "", err
+		}
+		port = strconv.Itoa(p)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// probeURL is the URL a service's health probe requests: its URL, with
+// health_path appended when one is set.
+func probeURL(svc database.Service) string {
+	if svc.HealthPath == "" {
+		return svc.URL
+	}
+	return strings.TrimSuffix(svc.URL, "/") + svc.HealthPath
+}
+
+// probeStatus sends one probe request and returns the response status.
+func probeStatus(client *http.Client, method, userAgent, url string) (int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// handleServiceHealth probes every service now and returns service ID →
+// alive. With ?detail=1 each value is the full sample instead, with the
+// probe latency.
 func (s *Server) handleServiceHealth(c echo.Context) error {
-	svcs, err := s.db.ListServices(c.Request().Context())
+	svcs, err := s.db.ListServices(c.Request().Context(), "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
+	}
+
+	healthMap := s.checkServicesHealth(svcs)
+
+	if c.QueryParam("detail") == "1" {
+		detail := make(map[string]healthSample, len(healthMap))
+		for id, sample := range healthMap {
+			detail[strconv.FormatInt(id, 10)] = sample
+		}
+		return c.JSON(http.StatusOK, detail)
+	}
+	health := make(map[string]bool)
+	for id, sample := range healthMap {
+		health[strconv.FormatInt(id, 10)] = sample.Alive
+	}
+	return c.JSON(http.StatusOK, health)
+}
+
+// serviceHealthExport is one service in the health export.
+type serviceHealthExport struct {
+	ID                  int64      `json:"id"`
+	Slug                string     `json:"slug"`
+	Name                string     `json:"name"`
+	URL                 string     `json:"url"`
+	Enabled             bool       `json:"enabled"`
+	Status              string     `json:"status"` // "up", "down", "unknown" (not checked yet), or "skipped" (skip_health_check)
+	HealthOverride      string     `json:"health_override"`
+	LastChecked         *time.Time `json:"last_checked"`
+	LatencyMs           *int64     `json:"latency_ms"` // of the last probe; null when not probed
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// handleServiceHealthExport returns the poller's cached health for every
+// service, for external monitoring. Unlike /services/health it runs no
+// checks. ?format=prometheus returns the same gauges /metrics exports (see
+// serviceHealthCollector) instead of JSON.
+func (s *Server) handleServiceHealthExport(c echo.Context) error {
+	out, err := s.healthExport(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
+	}
+	if c.QueryParam("format") == "prometheus" {
+		healthMetricsHandler(out).ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+	return c.JSON(http.StatusOK, map[string]any{"services": out})
+}
+
+// healthExport builds the health export from the poller's cache, sorted by
+// slug.
+func (s *Server) healthExport(ctx context.Context) ([]serviceHealthExport, error) {
+	svcs, err := s.db.ListServices(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	health := s.cachedHealth()
+	streaks, checkedAt := s.healthStreaks()
+
+	out := make([]serviceHealthExport, 0, len(svcs))
+	for _, svc := range svcs {
+		e := serviceHealthExport{
+			ID: svc.ID, Slug: svc.Slug, Name: svc.Name, URL: svc.URL, Enabled: svc.Enabled,
+			Status:              "unknown",
+			HealthOverride:      svc.HealthOverride,
+			ConsecutiveFailures: streaks[svc.ID],
+		}
+		if svc.HealthOverride != "auto" {
+			e.Status = svc.HealthOverride
+		} else if svc.SkipHealthCheck {
+			e.Status = "skipped"
+		} else if sample, ok := health[svc.ID]; ok {
+			e.Status = "down"
+			if sample.Alive {
+				e.Status = "up"
+			}
+			at := checkedAt
+			e.LastChecked = &at
+			latency := sample.LatencyMs
+			e.LatencyMs = &latency
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Slug < out[j].Slug })
+	return out, nil
+}
+
+// healthTransition is a change in a service's health history: the state
+// it entered and the first sample that showed it.
+type healthTransition struct {
+	Alive bool      `json:"alive"`
+	At    time.Time `json:"at"`
+}
+
+// handleServiceHealthHistory returns a service's health transitions from
+// the in-memory history, newest first (the oldest entry is the state the
+// history starts in), with its uptime over the last 24 hours.
+func (s *Server) handleServiceHealthHistory(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if _, err := s.db.GetServiceByID(c.Request().Context(), id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+
+	points := s.healthHistory(id)
+	transitions := []healthTransition{}
+	for i, p := range points {
+		if i == 0 || p.Alive != points[i-1].Alive {
+			transitions = append(transitions, healthTransition{Alive: p.Alive, At: p.At})
+		}
+	}
+	slices.Reverse(transitions)
+
+	var since *time.Time
+	if len(points) > 0 {
+		since = &points[0].At
+	}
+	var uptime24h *float64
+	if pct, ok := uptime(points, time.Now().Add(-24*time.Hour)); ok {
+		uptime24h = &pct
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"enabled":     s.cfg.HealthHistorySize > 0,
+		"samples":     len(points),
+		"since":       since,
+		"uptime_24h":  uptime24h,
+		"transitions": transitions,
+	})
+}
+
+// handleServiceUptime returns service ID → percentage of health samples in
+// the last 24 hours that were up. Services without samples are left out.
+func (s *Server) handleServiceUptime(c echo.Context) error {
+	uptimes := s.healthUptimes(time.Now().Add(-24 * time.Hour))
+	out := make(map[string]float64, len(uptimes))
+	for id, pct := range uptimes {
+		out[strconv.FormatInt(id, 10)] = pct
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// handleServiceUsage returns click counts per service and day.
+// ?days=N limits the window (default 30, max 365).
+func (s *Server) handleServiceUsage(c echo.Context) error {
+	days := 30
+	if d, err := strconv.Atoi(c.QueryParam("days")); err == nil && d > 0 && d <= 365 {
+		days = d
+	}
+	usage, err := s.db.ListServiceUsage(c.Request().Context(), days, s.cfg.UsagePerUser)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load usage"})
 	}
-
-	healthMap := s.checkServicesHealth(svcs)
-
-	health := make(map[string]bool)
-	for id, alive := range healthMap {
-		health[strconv.FormatInt(id, 10)] = alive
+	if usage == nil {
+		usage = []database.ServiceUsage{}
 	}
-	return c.JSON(http.StatusOK, health)
+	return c.JSON(http.StatusOK, usage)
 }
 
 // --- Grants ---
@@ -411,6 +1902,19 @@ func (s *Server) handleListGrants(c echo.Context) error {
 	return c.JSON(http.StatusOK, grants)
 }
 
+// handleGrantCounts returns active grant counts per user and per service
+// for the admin listings.
+func (s *Server) handleGrantCounts(c echo.Context) error {
+	counts, err := s.db.CountGrants(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to count grants"})
+	}
+	return c.JSON(http.StatusOK, counts)
+}
+
+// maxGrantNote caps the length of a grant's note, in characters.
+const maxGrantNote = 500
+
 func (s *Server) handleCreateGrant(c echo.Context) error {
 	caller := adminUser(c)
 
@@ -418,19 +1922,65 @@ func (s *Server) handleCreateGrant(c echo.Context) error {
 		UserID    int64  `json:"user_id"`
 		ServiceID int64  `json:"service_id"`
 		Role      string `json:"role"`
-	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		// ExpiresAt sets when the grant expires; ExpiresIn does the same
+		// relative to now (e.g. "168h"), and NoExpiry makes it permanent.
+		// With none of them an existing grant keeps its expiry and a new
+		// one gets the service's default grant TTL.
+		ExpiresAt *time.Time `json:"expires_at"`
+		ExpiresIn string     `json:"expires_in"`
+		NoExpiry  bool       `json:"no_expiry"`
+		// Note records why access was given; omit it to keep the
+		// existing grant's note.
+		Note *string `json:"note"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	if req.UserID == 0 || req.ServiceID == 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "user_id and service_id are required"})
 	}
+	if !inAdminScope(c, req.ServiceID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	if req.ExpiresIn != "" {
+		if req.ExpiresAt != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "set expires_at or expires_in, not both"})
+		}
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_in must be a positive duration (e.g. 168h)"})
+		}
+		at := time.Now().Add(d)
+		req.ExpiresAt = &at
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_at must be in the future"})
+	}
+	if req.NoExpiry && req.ExpiresAt != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "no_expiry can't be combined with expires_at or expires_in"})
+	}
+	exp := database.GrantExpiry{Set: req.ExpiresAt != nil || req.NoExpiry, At: req.ExpiresAt}
+	if req.Note != nil {
+		note := strings.TrimSpace(*req.Note)
+		if utf8.RuneCountInString(note) > maxGrantNote {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("note must be at most %d characters", maxGrantNote)})
+		}
+		req.Note = &note
+	}
 
-	grant, err := s.db.CreateGrant(c.Request().Context(), req.UserID, req.ServiceID, caller.ID, req.Role)
+	grant, err := s.db.CreateGrant(c.Request().Context(), req.UserID, req.ServiceID, caller.ID, req.Role, exp, req.Note)
+	if errors.Is(err, database.ErrGrantRoleAboveUser) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "role outranks the user's global role"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create grant"})
 	}
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "grant.create", "grant", strconv.FormatInt(grant.ID, 10),
+		map[string]any{"user_id": req.UserID, "service_id": req.ServiceID, "role": grant.Role, "expires_at": grant.ExpiresAt}); err != nil {
+		slog.Warn("audit record failed", "action", "grant.create", "error", err)
+	}
+
 	slog.Info("grant created", "user_id", req.UserID, "service_id", req.ServiceID, "by", caller.Handle)
 	return c.JSON(http.StatusCreated, grant)
 }
@@ -442,15 +1992,332 @@ func (s *Server) handleDeleteGrant(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid grant ID"})
 	}
+	if adminScoped(c) {
+		serviceID, err := s.db.GrantServiceID(c.Request().Context(), id)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "grant not found"})
+		}
+		if !inAdminScope(c, serviceID) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+		}
+	}
 
 	if err := s.db.DeleteGrant(c.Request().Context(), id); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete grant"})
 	}
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "grant.delete", "grant", strconv.FormatInt(id, 10),
+		nil); err != nil {
+		slog.Warn("audit record failed", "action", "grant.delete", "error", err)
+	}
+
 	slog.Info("grant deleted", "grant_id", id, "by", caller.Handle)
 	return c.NoContent(http.StatusNoContent)
 }
 
+// --- Access requests ---
+
+// handleListAccessRequests lists access requests, oldest first: pending ones
+// unless ?status= is approved, denied, or all. Scoped admins only see
+// requests for their services.
+func (s *Server) handleListAccessRequests(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "":
+		status = database.RequestPending
+	case "all":
+		status = ""
+	case database.RequestPending, database.RequestApproved, database.RequestDenied:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be pending, approved, denied, or all"})
+	}
+	reqs, err := s.db.ListAccessRequests(c.Request().Context(), status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list access requests"})
+	}
+	shown := make([]database.AccessRequest, 0, len(reqs))
+	for _, r := range reqs {
+		if inAdminScope(c, r.ServiceID) {
+			shown = append(shown, r)
+		}
+	}
+	return c.JSON(http.StatusOK, shown)
+}
+
+// handleApproveAccessRequest grants the requester the service, with the
+// optional role in the body (default "user") and the service's default grant
+// TTL, and marks the request approved in the same transaction. A grant the
+// user already has is only ever raised, never cut back. A new grant's note
+// is the requester's reason.
+func (s *Server) handleApproveAccessRequest(c echo.Context) error {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return s.decideAccessRequest(c, database.RequestApproved, req.Role)
+}
+
+// handleDenyAccessRequest turns an access request down. The user can ask
+// again.
+func (s *Server) handleDenyAccessRequest(c echo.Context) error {
+	return s.decideAccessRequest(c, database.RequestDenied, "")
+}
+
+func (s *Server) decideAccessRequest(c echo.Context, status, role string) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request ID"})
+	}
+	req, err := s.db.GetAccessRequest(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "access request not found"})
+	}
+	if !inAdminScope(c, req.ServiceID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	if req.Status != database.RequestPending {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "access request is already " + req.Status})
+	}
+
+	detail := map[string]any{"user_id": req.UserID, "service_id": req.ServiceID}
+	if status == database.RequestApproved {
+		grant, err := s.db.ApproveAccessRequest(ctx, id, role, caller.ID)
+		switch {
+		case errors.Is(err, database.ErrRequestDecided):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		case errors.Is(err, database.ErrGrantRoleAboveUser):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "role outranks the user's global role"})
+		case err != nil:
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to approve access request"})
+		}
+		detail["grant_id"], detail["role"], detail["expires_at"] = grant.ID, grant.Role, grant.ExpiresAt
+	} else if err := s.db.DecideAccessRequest(ctx, id, status, caller.ID); errors.Is(err, database.ErrRequestDecided) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update access request"})
+	}
+
+	action := "access_request.deny"
+	if status == database.RequestApproved {
+		action = "access_request.approve"
+	}
+	if err := s.db.RecordAudit(ctx, caller, action, "access_request", strconv.FormatInt(id, 10), detail); err != nil {
+		slog.Warn("audit record failed", "action", action, "error", err)
+	}
+
+	slog.Info("access request decided", "request_id", id, "user_id", req.UserID, "service_id", req.ServiceID, "status", status, "by", caller.Handle)
+	req.Status = status
+	return c.JSON(http.StatusOK, req)
+}
+
+// --- Access templates ---
+
+// maxTemplateName and maxTemplateDescription cap access template fields, in
+// characters.
+const (
+	maxTemplateName        = 100
+	maxTemplateDescription = 500
+)
+
+func (s *Server) handleListAccessTemplates(c echo.Context) error {
+	templates, err := s.db.ListAccessTemplates(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list templates"})
+	}
+	if templates == nil {
+		templates = []database.AccessTemplate{}
+	}
+	return c.JSON(http.StatusOK, templates)
+}
+
+// bindAccessTemplate reads and validates a template body for create and
+// update, returning a client-facing error message.
+func bindAccessTemplate(c echo.Context) (database.AccessTemplate, string) {
+	var req database.AccessTemplate
+	if err := bindJSON(c, &req); err != nil {
+		return req, err.Error()
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" {
+		return req, "name is required"
+	}
+	if utf8.RuneCountInString(req.Name) > maxTemplateName {
+		return req, fmt.Sprintf("name must be at most %d characters", maxTemplateName)
+	}
+	if utf8.RuneCountInString(req.Description) > maxTemplateDescription {
+		return req, fmt.Sprintf("description must be at most %d characters", maxTemplateDescription)
+	}
+	if len(req.Services) == 0 {
+		return req, "services must list at least one service"
+	}
+	seen := make(map[int64]bool, len(req.Services))
+	for i := range req.Services {
+		r := &req.Services[i]
+		if r.ServiceID == 0 {
+			return req, "each service needs a service_id"
+		}
+		if seen[r.ServiceID] {
+			return req, fmt.Sprintf("service %d is listed twice", r.ServiceID)
+		}
+		seen[r.ServiceID] = true
+		r.Role = strings.TrimSpace(r.Role)
+		if r.Role == "" {
+			r.Role = "user"
+		}
+	}
+	return req, ""
+}
+
+// accessTemplateWriteError turns a template write failure into a response.
+func accessTemplateWriteError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, database.ErrTemplateNameTaken):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "a template with that name already exists"})
+	case errors.Is(err, database.ErrUnknownService):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "template lists a service that doesn't exist"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save template"})
+}
+
+func (s *Server) handleCreateAccessTemplate(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+	req, msg := bindAccessTemplate(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	ctx := c.Request().Context()
+	t, err := s.db.CreateAccessTemplate(ctx, req)
+	if err != nil {
+		return accessTemplateWriteError(c, err)
+	}
+
+	slog.Info("access template created", "template_id", t.ID, "name", t.Name, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "template.create", "template", strconv.FormatInt(t.ID, 10),
+		map[string]any{"name": t.Name, "services": t.Services}); err != nil {
+		slog.Warn("audit record failed", "action", "template.create", "error", err)
+	}
+	return c.JSON(http.StatusCreated, t)
+}
+
+func (s *Server) handleUpdateAccessTemplate(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid template ID"})
+	}
+	req, msg := bindAccessTemplate(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetAccessTemplate(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "template not found"})
+	}
+	if err := s.db.UpdateAccessTemplate(ctx, id, req); err != nil {
+		return accessTemplateWriteError(c, err)
+	}
+	t, err := s.db.GetAccessTemplate(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load template"})
+	}
+
+	slog.Info("access template updated", "template_id", id, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "template.update", "template", strconv.FormatInt(id, 10),
+		map[string]any{"name": t.Name, "services": t.Services}); err != nil {
+		slog.Warn("audit record failed", "action", "template.update", "error", err)
+	}
+	return c.JSON(http.StatusOK, t)
+}
+
+func (s *Server) handleDeleteAccessTemplate(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid template ID"})
+	}
+
+	ctx := c.Request().Context()
+	t, err := s.db.GetAccessTemplate(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "template not found"})
+	}
+	if err := s.db.DeleteAccessTemplate(ctx, id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete template"})
+	}
+
+	slog.Info("access template deleted", "template_id", id, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "template.delete", "template", strconv.FormatInt(id, 10),
+		map[string]any{"name": t.Name}); err != nil {
+		slog.Warn("audit record failed", "action", "template.delete", "error", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleApplyAccessTemplate grants a user every service in a template, all
+// or nothing. Admins may apply templates; only owners edit them.
+func (s *Server) handleApplyAccessTemplate(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+	var req struct {
+		TemplateID int64 `json:"template_id"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.TemplateID == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "template_id is required"})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetUserByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+	}
+	t, err := s.db.GetAccessTemplate(ctx, req.TemplateID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "template not found"})
+	}
+	for _, r := range t.Services {
+		if !inAdminScope(c, r.ServiceID) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "template includes a service outside your admin scope"})
+		}
+	}
+	created, updated, unchanged, err := s.db.ApplyAccessTemplate(ctx, t, id, caller.ID)
+	if errors.Is(err, database.ErrGrantRoleAboveUser) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "template has a role that outranks the user's global role"})
+	}
+	if err != nil {
+		slog.Error("apply template failed", "user_id", id, "template_id", t.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to apply template"})
+	}
+
+	slog.Info("access template applied", "user_id", id, "template_id", t.ID, "created", created, "updated", updated, "unchanged", unchanged, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "grants.apply_template", "user", strconv.FormatInt(id, 10),
+		map[string]any{"template_id": t.ID, "template": t.Name, "created": created, "updated": updated, "unchanged": unchanged}); err != nil {
+		slog.Warn("audit record failed", "action", "grants.apply_template", "error", err)
+	}
+	return c.JSON(http.StatusOK, map[string]int{"created": created, "updated": updated, "unchanged": unchanged})
+}
+
 // --- Identities ---
 
 func (s *Server) handleListUserIdentities(c echo.Context) error {
@@ -469,18 +2336,43 @@ func (s *Server) handleListUserIdentities(c echo.Context) error {
 	return c.JSON(http.StatusOK, ids)
 }
 
-func (s *Server) handleAddIdentity(c echo.Context) error {
-	caller := adminUser(c)
-
+// identityTarget loads the user whose identities the caller wants to
+// change. Linking a DID to a user signs its holder in as them, so scoped
+// admins can't, and nobody can for a user who outranks them. On refusal it
+// returns the status and message to answer with.
+func (s *Server) identityTarget(c echo.Context) (*database.User, int, string) {
+	if adminScoped(c) {
+		return nil, http.StatusForbidden, "scoped admins can't change identities"
+	}
 	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+		return nil, http.StatusBadRequest, "invalid user ID"
+	}
+	target, err := s.db.GetUserByID(c.Request().Context(), userID)
+	if err != nil {
+		return nil, http.StatusNotFound, "user not found"
 	}
+	if database.RoleRank(target.Role) > database.RoleRank(adminUser(c).Role) {
+		return nil, http.StatusForbidden, "cannot change identities of a user who outranks you"
+	}
+	return target, 0, ""
+}
+
+func (s *Server) handleAddIdentity(c echo.Context) error {
+	caller := adminUser(c)
+	target, status, msg := s.identityTarget(c)
+	if msg != "" {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+	userID := target.ID
 
 	var req struct {
 		Handle string `json:"handle"`
 	}
-	if err := c.Bind(&req); err != nil || req.Handle == "" {
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Handle == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "handle is required"})
 	}
 
@@ -488,6 +2380,9 @@ func (s *Server) handleAddIdentity(c echo.Context) error {
 	did, resolvedHandle, err := s.oauth.ResolveHandle(c.Request().Context(), req.Handle)
 	if err != nil {
 		slog.Warn("handle resolution failed", "handle", req.Handle, "error", err)
+		if errors.Is(err, atproto.ErrDirectoryUnavailable) {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "identity service unavailable; try again shortly"})
+		}
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "could not resolve handle"})
 	}
 
@@ -502,17 +2397,22 @@ func (s *Server) handleAddIdentity(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to add identity"})
 	}
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "identity.add", "user", strconv.FormatInt(userID, 10),
+		map[string]any{"did": did, "handle": resolvedHandle}); err != nil {
+		slog.Warn("audit record failed", "action", "identity.add", "error", err)
+	}
+
 	slog.Info("identity added", "user_id", userID, "did", did, "handle", resolvedHandle, "by", caller.Handle)
 	return c.JSON(http.StatusCreated, identity)
 }
 
 func (s *Server) handleRemoveIdentity(c echo.Context) error {
 	caller := adminUser(c)
-
-	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	target, status, msg := s.identityTarget(c)
+	if msg != "" {
+		return c.JSON(status, map[string]string{"error": msg})
 	}
+	userID := target.ID
 
 	identityID, err := strconv.ParseInt(c.Param("identityId"), 10, 64)
 	if err != nil {
@@ -526,9 +2426,11 @@ func (s *Server) handleRemoveIdentity(c echo.Context) error {
 	}
 
 	var found bool
+	var removed database.Identity
 	for _, id := range ids {
 		if id.ID == identityID {
 			found = true
+			removed = id
 			if id.IsPrimary {
 				return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot remove primary identity"})
 			}
@@ -547,6 +2449,243 @@ func (s *Server) handleRemoveIdentity(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to remove identity"})
 	}
 
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "identity.remove", "user", strconv.FormatInt(userID, 10),
+		map[string]any{"did": removed.DID, "handle": removed.Handle}); err != nil {
+		slog.Warn("audit record failed", "action", "identity.remove", "error", err)
+	}
+
 	slog.Info("identity removed", "user_id", userID, "identity_id", identityID, "by", caller.Handle)
 	return c.NoContent(http.StatusNoContent)
 }
+
+// --- Audit & sessions ---
+
+// pageParams reads ?after= (a cursor from a previous page's next_cursor) and
+// ?limit= (default 50, max 200).
+func pageParams(c echo.Context) (after int64, limit int, ok bool) {
+	limit = 50
+	if v := c.QueryParam("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		after = n
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		limit = min(n, 200)
+	}
+	return after, limit, true
+}
+
+// nextCursor returns the cursor for the page after one ending at lastID, or
+// "" if the page was short (no more rows).
+func nextCursor(count, limit int, lastID int64) string {
+	if count < limit {
+		return ""
+	}
+	return strconv.FormatInt(lastID, 10)
+}
+
+func (s *Server) handleListAudit(c echo.Context) error {
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid after or limit"})
+	}
+	entries, err := s.db.ListAudit(c.Request().Context(), c.QueryParam("action"), after, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list audit log"})
+	}
+	if entries == nil {
+		entries = []database.AuditEntry{}
+	}
+	var lastID int64
+	if len(entries) > 0 {
+		lastID = entries[len(entries)-1].ID
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"items":       entries,
+		"next_cursor": nextCursor(len(entries), limit, lastID),
+	})
+}
+
+// accessDecisions are the outcomes handleAuth records, for filtering.
+var accessDecisions = []string{"allow", "deny", "redirect-login", "redirect-portal"}
+
+// handleServiceAccessLog pages through a service's recorded forwardAuth
+// decisions, newest first. ?decision= filters by outcome.
+func (s *Server) handleServiceAccessLog(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid after or limit"})
+	}
+	decision := c.QueryParam("decision")
+	if decision != "" && !slices.Contains(accessDecisions, decision) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "decision must be one of " + strings.Join(accessDecisions, ", ")})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetServiceByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	events, err := s.db.ListAccess(ctx, id, decision, after, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list access log"})
+	}
+	if events == nil {
+		events = []database.AccessEvent{}
+	}
+	var lastID int64
+	if len(events) > 0 {
+		lastID = events[len(events)-1].ID
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"enabled":     s.cfg.AccessLogRetention > 0,
+		"items":       events,
+		"next_cursor": nextCursor(len(events), limit, lastID),
+	})
+}
+
+// loginResults are the outcomes login_events records, for filtering.
+var loginResults = []string{database.LoginSuccess, database.LoginDenied, database.LoginError}
+
+// handleListLoginEvents pages through sign-in attempts, newest first.
+// ?result=, ?ip=, and ?did= filter by exact match.
+func (s *Server) handleListLoginEvents(c echo.Context) error {
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid after or limit"})
+	}
+	result := c.QueryParam("result")
+	if result != "" && !slices.Contains(loginResults, result) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "result must be one of " + strings.Join(loginResults, ", ")})
+	}
+	events, err := s.db.ListLoginEvents(c.Request().Context(), result, c.QueryParam("ip"), c.QueryParam("did"), after, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list login events"})
+	}
+	if events == nil {
+		events = []database.LoginEvent{}
+	}
+	var lastID int64
+	if len(events) > 0 {
+		lastID = events[len(events)-1].ID
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"enabled":     s.cfg.LoginEventRetention > 0,
+		"items":       events,
+		"next_cursor": nextCursor(len(events), limit, lastID),
+	})
+}
+
+func (s *Server) handleListSessions(c echo.Context) error {
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid after or limit"})
+	}
+	sessions, err := s.db.ListSessions(c.Request().Context(), after, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
+	}
+	if sessions == nil {
+		sessions = []database.SessionInfo{}
+	}
+	var lastID int64
+	if len(sessions) > 0 {
+		lastID = sessions[len(sessions)-1].ID
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"items":       sessions,
+		"next_cursor": nextCursor(len(sessions), limit, lastID),
+	})
+}
+
+// --- Blocked DIDs (owner only) ---
+
+func (s *Server) handleListBlockedDIDs(c echo.Context) error {
+	if adminUser(c).Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+	blocked, err := s.db.ListBlockedDIDs(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list blocked DIDs"})
+	}
+	if blocked == nil {
+		blocked = []database.BlockedDID{}
+	}
+	return c.JSON(http.StatusOK, blocked)
+}
+
+// handleBlockDID bans a DID from signing in and ends its active sessions.
+func (s *Server) handleBlockDID(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+
+	var req struct {
+		DID    string `json:"did"`
+		Reason string `json:"reason"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	req.DID = strings.TrimSpace(req.DID)
+	if !strings.HasPrefix(req.DID, "did:") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "a valid DID is required"})
+	}
+	if req.DID == s.cfg.OwnerDID || req.DID == caller.DID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot block the owner"})
+	}
+
+	ctx := c.Request().Context()
+	blocked, err := s.db.BlockDID(ctx, req.DID, strings.TrimSpace(req.Reason), caller.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to block DID"})
+	}
+
+	revoked, err := s.sess.DestroyDID(ctx, req.DID)
+	if err != nil {
+		slog.Warn("failed to revoke sessions for blocked DID", "did", req.DID, "error", err)
+	}
+
+	if err := s.db.RecordAudit(ctx, caller, "did.block", "did", req.DID,
+		map[string]any{"reason": blocked.Reason, "sessions_revoked": revoked}); err != nil {
+		slog.Warn("audit record failed", "action", "did.block", "error", err)
+	}
+
+	slog.Info("DID blocked", "did", req.DID, "sessions_revoked", revoked, "by", caller.Handle)
+	return c.JSON(http.StatusCreated, blocked)
+}
+
+func (s *Server) handleUnblockDID(c echo.Context) error {
+	caller := adminUser(c)
+	if caller.Role != "owner" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+	}
+
+	did, err := url.PathUnescape(c.Param("did"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid DID"})
+	}
+	if err := s.db.UnblockDID(c.Request().Context(), did); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to unblock DID"})
+	}
+
+	if err := s.db.RecordAudit(c.Request().Context(), caller, "did.unblock", "did", did, nil); err != nil {
+		slog.Warn("audit record failed", "action", "did.unblock", "error", err)
+	}
+
+	slog.Info("DID unblocked", "did", did, "by", caller.Handle)
+	return c.NoContent(http.StatusNoContent)
+}
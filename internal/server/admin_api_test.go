@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// A demoted admin must lose the admin API on their very next request, with
+// the session they already hold.
+func TestDemotedAdminLosesAdminAccess(t *testing.T) {
+	s := newTestServer(t, nil)
+	_, ownerCookie := testUser(t, s, "owner")
+	admin, adminCookie := testUser(t, s, "admin")
+
+	if rec := serve(s, http.MethodGet, "/admin/api/users", nil, adminCookie); rec.Code != http.StatusOK {
+		t.Fatalf("admin before demotion: status %d, want 200", rec.Code)
+	}
+
+	rec := serve(s, http.MethodPut, "/admin/api/users/"+strconv.FormatInt(admin.ID, 10)+"/role", []byte(`{"role":"user"}`), ownerCookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("demote: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := serve(s, http.MethodGet, "/admin/api/users", nil, adminCookie); rec.Code != http.StatusForbidden {
+		t.Fatalf("admin after demotion: status %d, want 403", rec.Code)
+	}
+}
+
+// With REVOKE_SESSIONS_ON_DOWNGRADE the demoted admin's sessions end too.
+func TestDemotionRevokesSessions(t *testing.T) {
+	s := newTestServer(t, map[string]string{"REVOKE_SESSIONS_ON_DOWNGRADE": "true"})
+	_, ownerCookie := testUser(t, s, "owner")
+	admin, adminCookie := testUser(t, s, "admin")
+
+	rec := serve(s, http.MethodPut, "/admin/api/users/"+strconv.FormatInt(admin.ID, 10)+"/role", []byte(`{"role":"user"}`), ownerCookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("demote: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := serve(s, http.MethodGet, "/admin/api/users", nil, adminCookie); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("admin after demotion: status %d, want 401", rec.Code)
+	}
+}
+
+// Identities, roles, usernames, sign-up decisions and user deletion are for
+// owners and unscoped admins; identities only on users who don't outrank the
+// caller, roles and usernames only on users an admin outranks.
+func TestIdentityAndDeletePermissions(t *testing.T) {
+	s := newTestServer(t, nil)
+	owner, ownerCookie := testUser(t, s, "owner")
+	admin, adminCookie := testUser(t, s, "admin")
+	scoped, scopedCookie := testUser(t, s, "admin")
+	if err := s.db.SetAdminScope(context.Background(), scoped.ID, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := testUser(t, s, "user")
+	userPath := "/admin/api/users/" + strconv.FormatInt(user.ID, 10)
+	ownerPath := "/admin/api/users/" + strconv.FormatInt(owner.ID, 10)
+	adminPath := "/admin/api/users/" + strconv.FormatInt(admin.ID, 10)
+	otherAdmin, _ := testUser(t, s, "admin")
+	otherAdminPath := "/admin/api/users/" + strconv.FormatInt(otherAdmin.ID, 10)
+	handle := []byte(`{"handle":"someone.test"}`)
+	toUser := []byte(`{"role":"user"}`)
+	rename := []byte(`{"username":"` + randomName(t) + `"}`)
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		target string
+		body   []byte
+		cookie *http.Cookie
+	}{
+		{"scoped add identity", http.MethodPost, userPath + "/identities", handle, scopedCookie},
+		{"scoped remove identity", http.MethodDelete, userPath + "/identities/1", nil, scopedCookie},
+		{"scoped delete user", http.MethodDelete, userPath, nil, scopedCookie},
+		{"scoped approve sign-up", http.MethodPost, userPath + "/approve", nil, scopedCookie},
+		{"scoped deny sign-up", http.MethodPost, userPath + "/deny", nil, scopedCookie},
+		{"scoped change role", http.MethodPut, userPath + "/role", toUser, scopedCookie},
+		{"scoped rename", http.MethodPut, userPath + "/username", rename, scopedCookie},
+		{"admin demotes admin", http.MethodPut, otherAdminPath + "/role", toUser, adminCookie},
+		{"admin demotes owner", http.MethodPut, ownerPath + "/role", toUser, adminCookie},
+		{"admin renames admin", http.MethodPut, otherAdminPath + "/username", rename, adminCookie},
+		{"admin renames owner", http.MethodPut, ownerPath + "/username", rename, adminCookie},
+		{"admin adds to owner", http.MethodPost, ownerPath + "/identities", handle, adminCookie},
+		{"admin removes from owner", http.MethodDelete, ownerPath + "/identities/1", nil, adminCookie},
+	} {
+		if rec := serve(s, tt.method, tt.target, tt.body, tt.cookie); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d %s, want 403", tt.name, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := serve(s, http.MethodPut, userPath+"/username", rename, adminCookie); rec.Code != http.StatusOK {
+		t.Errorf("unscoped admin renames user: status %d %s, want 200", rec.Code, rec.Body)
+	}
+	if rec := serve(s, http.MethodDelete, userPath, nil, adminCookie); rec.Code != http.StatusNoContent {
+		t.Errorf("unscoped admin deletes user: status %d %s, want 204", rec.Code, rec.Body)
+	}
+	if rec := serve(s, http.MethodPut, adminPath+"/role", toUser, ownerCookie); rec.Code != http.StatusOK {
+		t.Errorf("owner demotes admin: status %d %s, want 200", rec.Code, rec.Body)
+	}
+}
+
+// Creating or updating a service on noknok's own host is refused before
+// anything is written.
+func TestServiceOnPublicHostRejected(t *testing.T) {
+	s := &Server{echo: echo.New(), cfg: &config.Config{PublicURL: "https://auth.example.com"}}
+	owner := &database.User{ID: 1, Role: "owner"}
+	for _, tt := range []struct {
+		name    string
+		handler echo.HandlerFunc
+		body    string
+	}{
+		{"create", s.handleCreateService, `{"slug":"self","name":"Self","url":"https://auth.example.com/app"}`},
+		{"create port", s.handleCreateService, `{"slug":"self","name":"Self","url":"http://AUTH.example.com:8080"}`},
+		{"update", s.handleUpdateService, `{"name":"Self","url":"https://auth.example.com"}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			c := s.echo.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			c.Set(ctxKeyUser, owner)
+			if err := tt.handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "noknok's own host") {
+				t.Errorf("status %d %s, want 400 for noknok's own host", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// A service update only changes the fields sent; an older client that
+// doesn't know a field leaves it alone.
+func TestUpdateServicePartial(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	_, ownerCookie := testUser(t, s, "owner")
+	name := randomName(t)
+	svc, err := s.db.CreateService(ctx, database.Service{
+		Slug: name, Name: name, URL: "https://" + name + ".test",
+		DenyMessage: "Ask in #ops", HealthPath: "/healthz", IssueToken: true, Category: "Tools",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.DeleteService(context.Background(), svc.ID) })
+
+	rec := serve(s, http.MethodPut, "/admin/api/services/"+strconv.FormatInt(svc.ID, 10), []byte(`{"name":"Renamed"}`), ownerCookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status %d: %s", rec.Code, rec.Body)
+	}
+	got, err := s.db.GetServiceByID(ctx, svc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Renamed" {
+		t.Errorf("name %q, want Renamed", got.Name)
+	}
+	if got.URL != svc.URL || got.DenyMessage != svc.DenyMessage || got.HealthPath != svc.HealthPath ||
+		!got.IssueToken || got.Category != svc.Category {
+		t.Errorf("fields not sent changed: %+v, want as %+v", got, svc)
+	}
+
+	rec = serve(s, http.MethodPut, "/admin/api/services/"+strconv.FormatInt(svc.ID, 10), []byte(`{"name":""}`), ownerCookie)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty name: status %d, want 400", rec.Code)
+	}
+}
+
+// Scoped admins only read the access log of services in their scope.
+func TestServiceAccessLogScope(t *testing.T) {
+	s := &Server{echo: echo.New(), cfg: &config.Config{}}
+	rec := httptest.NewRecorder()
+	c := s.echo.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	c.Set(ctxKeyUser, &database.User{ID: 1, Role: "admin"})
+	c.Set(ctxKeyAdminScope, map[int64]bool{2: true})
+	if err := s.handleServiceAccessLog(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", rec.Code)
+	}
+}
+
+// The export's Prometheus format has the same gauges as /metrics, the last
+// check included; unchecked services have only a failure count.
+func TestHealthMetricsHandler(t *testing.T) {
+	checked := time.Unix(1_700_000_000, 0)
+	latency := int64(250)
+	svcs := []serviceHealthExport{
+		{Slug: "app", Status: "up", LastChecked: &checked, LatencyMs: &latency},
+		{Slug: "new", Status: "unknown"},
+	}
+	rec := httptest.NewRecorder()
+	healthMetricsHandler(svcs).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`noknok_service_up{slug="app"} 1`,
+		`noknok_service_consecutive_failures{slug="app"} 0`,
+		`noknok_service_consecutive_failures{slug="new"} 0`,
+		`noknok_service_last_check_timestamp_seconds{slug="app"} 1.7e+09`,
+		`noknok_service_probe_duration_seconds{slug="app"} 0.25`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `noknok_service_up{slug="new"}`) {
+		t.Errorf("unchecked service has an up gauge:\n%s", body)
+	}
+}
@@ -1,12 +1,19 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
 	"github.com/primal-host/noknok/internal/session"
 )
 
@@ -15,107 +22,280 @@ func (s *Server) handleHealth(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// handleReadyz reports whether the server can do its job: 200 when the
+// database answers, 503 otherwise. The identity directory breaker and the
+// read replica are reported but don't fail readiness — every instance
+// shares the same directory, forwardAuth for signed-in users works without
+// it, and reads fall back to the primary when the replica can't serve them.
+func (s *Server) handleReadyz(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+	defer cancel()
+	status, dbStatus := http.StatusOK, "ok"
+	if err := s.db.Pool.Ping(ctx); err != nil {
+		slog.Warn("readyz: database ping failed", "error", err)
+		status, dbStatus = http.StatusServiceUnavailable, "unavailable"
+	}
+	ready := "ready"
+	if status != http.StatusOK {
+		ready = "not ready"
+	}
+	return c.JSON(status, map[string]string{
+		"status":             ready,
+		"database":           dbStatus,
+		"database_replica":   s.db.ReplicaState(),
+		"identity_directory": s.oauth.DirectoryState(),
+	})
+}
+
 // handleAuth is the Traefik forwardAuth endpoint.
 // Valid session → 200 with X-User-DID and X-User-Handle headers.
 // Authorization header present → 200 (let backend validate the token).
 // No/invalid session → 302 redirect to login page.
 func (s *Server) handleAuth(c echo.Context) error {
+	// Forwarded headers decide which service is being authorized; only a
+	// trusted proxy may set them. Requests from anywhere else are handled as
+	// direct, and any carrying forwarded headers are refused as spoofed.
+	if !s.cfg.IsTrustedProxy(c.Request().RemoteAddr) {
+		if h := forwardedAuthHeader(c.Request().Header); h != "" {
+			slog.Warn("forwardAuth: forwarded header from untrusted peer",
+				"remote_addr", c.Request().RemoteAddr, "header", h)
+			return c.NoContent(http.StatusForbidden)
+		}
+	}
+
 	host := c.Request().Header.Get("X-Forwarded-Host")
 
 	// Check service status — disabled blocks all, public allows all.
+	var svc *database.Service
 	if host != "" {
-		svc, _ := s.db.GetServiceByHost(c.Request().Context(), host)
+		svc, _ = s.db.GetServiceByHost(c.Request().Context(), host)
 		if svc != nil && !svc.Enabled {
-			accept := c.Request().Header.Get("X-Forwarded-Accept")
-			if accept == "" {
-				accept = c.Request().Header.Get("Accept")
-			}
-			if strings.Contains(accept, "text/html") {
+			if wantsHTML(c) {
+				s.logAuthDecision(svc, host, "", "redirect-portal", "service disabled")
 				return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
 			}
+			s.logAuthDecision(svc, host, "", "deny", "service disabled")
 			return c.NoContent(http.StatusServiceUnavailable)
 		}
 		if svc != nil && svc.Public {
+			s.logAuthDecision(svc, host, "", "allow", "public service")
 			return c.NoContent(http.StatusOK)
 		}
 	}
 
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err == nil && cookie.Value != "" {
-		sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+		sess, refreshed, err := s.sess.ValidateAndRefresh(c.Request().Context(), cookie.Value)
 		if err == nil {
+			// Blocking revokes sessions, but check anyway so a block takes
+			// effect even if a session slipped through.
+			blocked, err := s.db.IsDIDBlocked(c.Request().Context(), sess.DID)
+			if err != nil {
+				slog.Error("forwardAuth: block list lookup failed", "did", sess.DID, "error", err)
+				s.logAuthDecision(svc, host, sess.DID, "deny", "block list unavailable")
+				if wantsHTML(c) {
+					return c.HTML(http.StatusServiceUnavailable, s.unavailableHTML())
+				}
+				return c.NoContent(http.StatusServiceUnavailable)
+			}
+			if blocked {
+				_ = s.sess.Destroy(c.Request().Context(), cookie.Value)
+				s.logAuthDecision(svc, host, sess.DID, "deny", "blocked DID")
+				if wantsHTML(c) {
+					return c.HTML(http.StatusForbidden, s.accessDeniedHTML())
+				}
+				return c.NoContent(http.StatusForbidden)
+			}
+
 			// Check if user is owner/admin (full access) or has a grant for this service.
+			var role string
 			if host != "" {
-				role, roleErr := s.db.GetUserServiceRole(c.Request().Context(), sess.DID, host)
+				var roleErr error
+				role, roleErr = s.db.GetUserServiceRole(c.Request().Context(), sess.DID, host)
+				if errors.Is(roleErr, database.ErrUserInactive) {
+					// Pending and denied users don't get sessions; drop
+					// one that exists anyway (status changed in the DB).
+					_ = s.sess.Destroy(c.Request().Context(), cookie.Value)
+					s.logAuthDecision(svc, host, sess.DID, "deny", "user not active")
+					if wantsHTML(c) {
+						return c.HTML(http.StatusForbidden, s.pendingApprovalHTML())
+					}
+					return c.NoContent(http.StatusForbidden)
+				}
 				if roleErr != nil || role == "" {
 					// User has no grant for this service — deny access.
 					// Redirect browser to portal so they see what they can access.
-					accept := c.Request().Header.Get("X-Forwarded-Accept")
-					if accept == "" {
-						accept = c.Request().Header.Get("Accept")
-					}
-					if strings.Contains(accept, "text/html") {
+					if wantsHTML(c) {
+						// A deny message turns the dead end into guidance;
+						// without one the portal shows what they can reach.
+						if svc != nil && svc.DenyMessage != "" {
+							s.logAuthDecision(svc, host, sess.DID, "deny", "no grant")
+							return c.HTML(http.StatusForbidden, s.noAccessHTML(svc))
+						}
+						s.logAuthDecision(svc, host, sess.DID, "redirect-portal", "no grant")
 						return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
 					}
+					s.logAuthDecision(svc, host, sess.DID, "deny", "no grant")
 					return c.NoContent(http.StatusForbidden)
 				}
 				c.Response().Header().Set("X-User-Role", role)
 			}
 
+			// Sensitive services can demand a recent sign-in. Browsers go
+			// back through login (which resets the session's auth age) and
+			// return to the page they asked for.
+			if svc != nil && svc.RequireReauthMaxAge > 0 &&
+				time.Since(sess.AuthAt) > time.Duration(svc.RequireReauthMaxAge)*time.Second {
+				if wantsHTML(c) {
+					s.logAuthDecision(svc, host, sess.DID, "redirect-login", "reauth required")
+					loginURL := s.cfg.PublicURL + "/login?redirect=" + url.QueryEscape(forwardedURL(c, host)) +
+						"&login_hint=" + url.QueryEscape(sess.Handle) +
+						"&error=" + url.QueryEscape(svc.Name+" requires you to sign in again.")
+					return c.Redirect(http.StatusFound, loginURL)
+				}
+				s.logAuthDecision(svc, host, sess.DID, "deny", "reauth required")
+				return c.NoContent(http.StatusUnauthorized)
+			}
+			s.logAuthDecision(svc, host, sess.DID, "allow", "valid session")
+
+			// An extended session gets a cookie with the new expiry, for the
+			// service host's cookie domain. Traefik passes it to the browser
+			// when listed in addAuthCookiesToResponse.
+			if refreshed != nil {
+				c.SetCookie(s.sess.MakeCookieForDomain(sess.Token, sess.ExpiresAt, s.cfg.DomainForHost(host)))
+			}
+
 			c.Response().Header().Set("X-User-DID", sess.DID)
 			c.Response().Header().Set("X-User-Handle", sess.Handle)
 			if sess.Username != "" {
 				c.Response().Header().Set("X-WEBAUTH-USER", sess.Username)
 			}
+			// Backends that authorize offline get the same identity as a
+			// signed token. Without one they fall back to their own checks,
+			// so a signing failure doesn't block the request.
+			if svc != nil && svc.IssueToken && role != "" {
+				token, err := s.identityToken(c.Request().Context(), sess, svc, role)
+				if err != nil {
+					slog.Warn("forwardAuth: identity token failed", "service", svc.Slug, "did", sess.DID, "error", err)
+				} else {
+					c.Response().Header().Set("X-User-Token", token)
+				}
+			}
 
 			return c.NoContent(http.StatusOK)
 		}
+		if errors.Is(err, session.ErrIdleExpired) {
+			slog.Debug("forwardAuth: session refused", "host", host, "reason", "idle expiry")
+		} else if errors.Is(err, session.ErrExpired) {
+			slog.Debug("forwardAuth: session refused", "host", host, "reason", "absolute expiry")
+		}
 	}
 
 	// Pass through requests with an Authorization header (e.g. PATs, API tokens)
 	// so the backend service can validate them itself.
 	if c.Request().Header.Get("X-Forwarded-Authorization") != "" ||
 		c.Request().Header.Get("Authorization") != "" {
+		s.logAuthDecision(svc, host, "", "allow", "authorization header passthrough")
 		return c.NoContent(http.StatusOK)
 	}
 
 	// Non-browser clients (git, curl, API) get 401 so they can retry with
 	// credentials. The backend (e.g. Gitea) will issue its own WWW-Authenticate
 	// challenge once it receives the request.
+	if !wantsHTML(c) {
+		s.logAuthDecision(svc, host, "", "deny", "no session")
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	loginURL := fmt.Sprintf("%s/login", s.cfg.PublicURL)
+	if host != "" {
+		loginURL += "?redirect=" + url.QueryEscape(forwardedURL(c, host))
+	}
+
+	s.logAuthDecision(svc, host, "", "redirect-login", "no session")
+	return c.Redirect(http.StatusFound, loginURL)
+}
+
+// wantsHTML reports whether the client is a browser expecting a page: its
+// Accept header, as forwarded by the proxy in X-Forwarded-Accept or sent
+// directly, includes text/html.
+func wantsHTML(c echo.Context) bool {
 	accept := c.Request().Header.Get("X-Forwarded-Accept")
 	if accept == "" {
 		accept = c.Request().Header.Get("Accept")
 	}
-	if !strings.Contains(accept, "text/html") {
-		return c.NoContent(http.StatusUnauthorized)
-	}
+	return strings.Contains(accept, "text/html")
+}
 
-	// Build redirect URL from forwarded headers.
+// forwardedURL rebuilds the URL the user originally requested from the
+// forwarded headers, for use as a post-login redirect. X-Forwarded-Uri is
+// the raw request URI, path and query still percent-encoded as the browser
+// sent them, and is kept byte for byte; callers query-escape the whole URL
+// once. A URI that isn't a path (which would change the host the URL
+// names) is replaced by "/". The fragment never reaches the server; the
+// login page recovers it (see loginHTML).
+func forwardedURL(c echo.Context, host string) string {
 	scheme := c.Request().Header.Get("X-Forwarded-Proto")
 	if scheme == "" {
 		scheme = "https"
 	}
-	if host == "" {
-		host = c.Request().Header.Get("X-Forwarded-Host")
-	}
 	uri := c.Request().Header.Get("X-Forwarded-Uri")
-
-	redirectTarget := ""
-	if host != "" {
-		redirectTarget = fmt.Sprintf("%s://%s%s", scheme, host, uri)
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/"
 	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, uri)
+}
 
-	loginURL := fmt.Sprintf("%s/login", s.cfg.PublicURL)
-	if redirectTarget != "" {
-		loginURL += "?redirect=" + url.QueryEscape(redirectTarget)
+// forwardedAuthHeaders are the proxy-set headers handleAuth acts on.
+var forwardedAuthHeaders = []string{
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Uri",
+	"X-Forwarded-Accept",
+	"X-Forwarded-Authorization",
+}
+
+// forwardedAuthHeader returns the first forwardAuth header present in h, or
+// "" if there are none.
+func forwardedAuthHeader(h http.Header) string {
+	for _, name := range forwardedAuthHeaders {
+		if h.Get(name) != "" {
+			return name
+		}
 	}
+	return ""
+}
 
-	return c.Redirect(http.StatusFound, loginURL)
+// logAuthDecision counts a forwardAuth outcome in
+// noknok_forwardauth_decisions_total and logs it at debug level. DIDs are
+// hashed unless LOG_AUTH_DIDS is set, so debug logs don't build a
+// per-user access history by default.
+//
+// With ACCESS_LOG_RETENTION set, decisions for known services are also
+// queued, unhashed, for the access log writer, so the write never delays the
+// proxied request. A full queue drops the decision.
+func (s *Server) logAuthDecision(svc *database.Service, host, did, decision, reason string) {
+	s.metrics.authDecisions.WithLabelValues(decision).Inc()
+	if svc != nil && s.accessLog != nil {
+		select {
+		case s.accessLog <- database.AccessRecord{ServiceID: svc.ID, DID: did, Decision: decision, Reason: reason, At: time.Now()}:
+		default:
+			s.metrics.accessLogDropped.Inc()
+		}
+	}
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	if did != "" && !s.cfg.LogAuthDIDs {
+		sum := sha256.Sum256([]byte(did))
+		did = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	slog.Debug("auth decision", "host", host, "did", did, "decision", decision, "reason", reason)
 }
 
 // handleLogout destroys the entire session group and redirects to login.
 func (s *Server) handleLogout(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err == nil && cookie.Value != "" {
 		sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
 		if err == nil && sess.GroupID != "" {
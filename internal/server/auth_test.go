@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A full access log queue drops decisions instead of blocking forwardAuth.
+func TestAccessLogQueueDrops(t *testing.T) {
+	s := &Server{cfg: &config.Config{}, accessLog: make(chan database.AccessRecord, 2)}
+	s.metrics = s.newMetrics()
+	svc := &database.Service{ID: 7}
+	for range 5 {
+		s.logAuthDecision(svc, "app.example.com", "did:plc:test", "allow", "")
+	}
+	s.logAuthDecision(nil, "unknown.example.com", "", "deny", "unknown host")
+
+	if n := len(s.accessLog); n != 2 {
+		t.Errorf("%d decisions queued, want 2", n)
+	}
+	if r := <-s.accessLog; r.ServiceID != 7 || r.Decision != "allow" || r.At.IsZero() {
+		t.Errorf("queued %+v", r)
+	}
+	var m dto.Metric
+	if err := s.metrics.accessLogDropped.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 3 {
+		t.Errorf("dropped %v, want 3", got)
+	}
+}
+
+func TestWantsHTML(t *testing.T) {
+	for _, tt := range []struct {
+		forwarded, accept string
+		want              bool
+	}{
+		{"text/html,application/xhtml+xml", "", true},
+		{"", "text/html", true},
+		{"application/json", "text/html", false}, // the proxied client's header wins
+		{"", "*/*", false},
+		{"", "", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-Accept", tt.forwarded)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsHTML(echo.New().NewContext(req, httptest.NewRecorder())); got != tt.want {
+			t.Errorf("X-Forwarded-Accept %q, Accept %q: got %v, want %v", tt.forwarded, tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// handleCatalog renders the public services without requiring a session.
+// Each card goes through login with the service as the redirect target.
+func (s *Server) handleCatalog(c echo.Context) error {
+	svcs, down, err := s.publicServices(c)
+	if err != nil {
+		slog.Warn("catalog: failed to load public services", "error", err)
+	}
+	return c.HTML(http.StatusOK, catalogHTML(s.brand(), svcs, down))
+}
+
+func catalogHTML(b brand, svcs []database.Service, down map[int64]bool) string {
+	content := `<p class="empty">No public services yet.</p>`
+	if len(svcs) > 0 {
+		content = `<div class="grid">` + serviceCardsHTML(svcs, down, true) + `
+</div>`
+	}
+
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>` + b.title("Catalog") + `</title>
+` + faviconLink + `
+<style>
+  *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+  body {
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+    background: #0f172a;
+    color: #e2e8f0;
+    min-height: 100vh;
+    padding: 2rem;
+  }
+  header {
+    display: flex;
+    align-items: center;
+    justify-content: space-between;
+    max-width: 800px;
+    margin: 0 auto 1.5rem;
+  }
+  .signin {
+    padding: 0.5rem 1rem;
+    background: #3b82f6;
+    color: #fff;
+    border-radius: 8px;
+    font-size: 0.875rem;
+    text-decoration: none;
+    transition: background 0.15s;
+  }
+  .signin:hover { background: #2563eb; }
+  .empty { max-width: 800px; margin: 0 auto; color: #94a3b8; font-size: 0.875rem; }` + b.css() + serviceCardCSS + `
+</style>
+</head>
+<body>
+<header>
+  ` + b.headerHTML() + `
+  <a href="/login" class="signin">Sign in</a>
+</header>
+` + content + `
+</body>
+</html>`
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// clientConfig is what the portal's scripts need to know about the
+// deployment. The portal embeds it and GET /api/config serves it, so both
+// come from the same values; nothing in it is secret.
+type clientConfig struct {
+	Brand struct {
+		Name    string `json:"name"`
+		LogoURL string `json:"logo_url"`
+		Theme   string `json:"theme"`
+	} `json:"brand"`
+	StatusPollMS  int64 `json:"status_poll_ms"`  // how often the portal refreshes card status
+	StatusStaleMS int64 `json:"status_stale_ms"` // health results older than this are flagged as out of date
+	ReloadAfterMS int64 `json:"reload_after_ms"` // reload on focus after being hidden this long; 0 disables
+	IdleLogoutMS  int64 `json:"idle_logout_ms"`  // sign out after this long without interaction; 0 disables
+	TabClaimMS    int64 `json:"tab_claim_ms"`    // wait for an existing portal tab before becoming the primary
+	TrackUsage    bool  `json:"track_usage"`
+
+	// Clicks on cards that can't be opened; see PORTAL_DOWN_CLICK.
+	DownClick       string `json:"down_click"`
+	DownMessage     string `json:"down_message"`
+	DisabledMessage string `json:"disabled_message"`
+}
+
+func (s *Server) clientConfig() clientConfig {
+	cc := clientConfig{
+		StatusPollMS: s.cfg.PortalStatusPoll.Milliseconds(),
+		// Three missed poller runs.
+		StatusStaleMS: 3 * s.cfg.HealthPollInterval.Milliseconds(),
+		ReloadAfterMS: s.cfg.PortalReloadAfter.Milliseconds(),
+		IdleLogoutMS:  s.cfg.PortalIdleLogout.Milliseconds(),
+		TabClaimMS:    s.cfg.PortalTabClaim.Milliseconds(),
+		TrackUsage:    s.cfg.UsageTracking,
+
+		DownClick:       s.cfg.PortalDownClick,
+		DownMessage:     s.cfg.PortalDownMessage,
+		DisabledMessage: s.cfg.PortalDisabledMessage,
+	}
+	cc.Brand.Name = s.cfg.BrandName
+	cc.Brand.LogoURL = s.cfg.BrandLogoURL
+	cc.Brand.Theme = s.cfg.Theme
+	return cc
+}
+
+// handleClientConfig serves the portal's client settings, for pages and
+// tools that aren't rendered with them. Unauthenticated.
+//
+// GET /api/config
+func (s *Server) handleClientConfig(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, s.clientConfig())
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// rejectCrossSite refuses state-changing requests a browser sent from a
+// site outside noknok's cookie domains. SameSite alone doesn't cover them:
+// COOKIE_PARTITIONED makes the session cookie SameSite=None, and bindJSON
+// also accepts form bodies, which any page can POST. Browsers say where a
+// request came from in Origin, or failing that Sec-Fetch-Site; requests
+// with neither (backends, scripts) carry no ambient cookie risk and pass.
+func (s *Server) rejectCrossSite(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+		if s.crossSite(r) {
+			slog.Warn("cross-site request refused", "method", r.Method, "path", r.URL.Path,
+				"origin", r.Header.Get("Origin"), "sec_fetch_site", r.Header.Get("Sec-Fetch-Site"))
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "cross-site request refused"})
+		}
+		return next(c)
+	}
+}
+
+// crossSite reports whether a browser sent r from another site. An Origin
+// under any of COOKIE_DOMAINS is noknok's own, even though the browser
+// counts the other domains as cross-site.
+func (s *Server) crossSite(r *http.Request) bool {
+	switch origin := r.Header.Get("Origin"); origin {
+	case "":
+		return r.Header.Get("Sec-Fetch-Site") == "cross-site"
+	case "null": // sandboxed frames, data: URLs, cross-origin redirects
+		return true
+	default:
+		return !isAllowedRedirect(origin, s.cfg)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+)
+
+func TestRejectCrossSite(t *testing.T) {
+	s := &Server{echo: echo.New(), cfg: &config.Config{
+		PublicURL:     "https://auth.example.com",
+		CookieDomain:  ".example.com",
+		CookieDomains: []string{".example.com", ".ker.test"},
+	}}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	for _, tt := range []struct {
+		name          string
+		method        string
+		origin, fetch string
+		refused       bool
+	}{
+		{"no headers", http.MethodPost, "", "", false},
+		{"same origin", http.MethodPost, "https://auth.example.com", "same-origin", false},
+		{"sibling subdomain", http.MethodPost, "https://app.example.com", "same-site", false},
+		{"other cookie domain", http.MethodPost, "https://app.ker.test", "cross-site", false},
+		{"fetch metadata only", http.MethodPost, "", "same-origin", false},
+		{"user navigation", http.MethodPost, "", "none", false},
+		{"foreign origin", http.MethodPost, "https://evil.test", "cross-site", true},
+		{"lookalike origin", http.MethodDelete, "https://example.com.evil.test", "", true},
+		{"null origin", http.MethodPut, "null", "cross-site", true},
+		{"cross-site without origin", http.MethodPost, "", "cross-site", true},
+		{"cross-site GET", http.MethodGet, "https://evil.test", "cross-site", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/logout", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.fetch != "" {
+				req.Header.Set("Sec-Fetch-Site", tt.fetch)
+			}
+			rec := httptest.NewRecorder()
+			if err := s.rejectCrossSite(ok)(s.echo.NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Code == http.StatusForbidden; got != tt.refused {
+				t.Errorf("status %d, refused %v, want %v", rec.Code, got, tt.refused)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// healthPoint is one poller sample in a service's health history.
+type healthPoint struct {
+	Alive     bool
+	LatencyMs int64
+	At        time.Time
+}
+
+// healthRing keeps the last HEALTH_HISTORY_SIZE samples of one service.
+type healthRing struct {
+	buf  []healthPoint
+	next int // slot the next sample overwrites once buf is full
+}
+
+func (r *healthRing) add(p healthPoint, size int) {
+	if len(r.buf) < size {
+		r.buf = append(r.buf, p)
+		return
+	}
+	r.buf[r.next] = p
+	r.next = (r.next + 1) % size
+}
+
+// points returns a copy of the samples, oldest first.
+func (r *healthRing) points() []healthPoint {
+	out := make([]healthPoint, 0, len(r.buf))
+	out = append(out, r.buf[r.next:]...)
+	return append(out, r.buf[:r.next]...)
+}
+
+// addHealthHistory appends one poller run's probed samples to the history
+// and forgets services the run no longer saw (deleted). Callers hold
+// healthMu.
+func (s *Server) addHealthHistory(health map[int64]healthSample) {
+	size := s.cfg.HealthHistorySize
+	if size == 0 {
+		return
+	}
+	if s.healthHist == nil {
+		s.healthHist = make(map[int64]*healthRing)
+	}
+	for id := range s.healthHist {
+		if _, ok := health[id]; !ok {
+			delete(s.healthHist, id)
+		}
+	}
+	for id, sample := range health {
+		if sample.CheckedAt.IsZero() {
+			continue
+		}
+		r := s.healthHist[id]
+		if r == nil {
+			r = &healthRing{}
+			s.healthHist[id] = r
+		}
+		r.add(healthPoint{Alive: sample.Alive, LatencyMs: sample.LatencyMs, At: sample.CheckedAt}, size)
+	}
+}
+
+// persistHealthHistory stores one poller run's probed samples in
+// health_history when HEALTH_HISTORY_RETENTION is set. A failed write is
+// logged; the in-memory history still has the samples.
+func (s *Server) persistHealthHistory(health map[int64]healthSample) {
+	if s.cfg.HealthHistoryRetention == 0 {
+		return
+	}
+	var recs []database.HealthRecord
+	for id, sample := range health {
+		if !sample.CheckedAt.IsZero() {
+			recs = append(recs, database.HealthRecord{ServiceID: id, Alive: sample.Alive, LatencyMs: sample.LatencyMs, CheckedAt: sample.CheckedAt})
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.db.RecordHealthSamples(ctx, recs); err != nil {
+		slog.Warn("health history: record failed", "error", err)
+	}
+}
+
+// loadHealthHistory fills the in-memory history from health_history, so
+// uptime survives a restart. Only used when samples are persisted.
+func (s *Server) loadHealthHistory() {
+	size := s.cfg.HealthHistorySize
+	if s.cfg.HealthHistoryRetention == 0 || size == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	recs, err := s.db.RecentHealthSamples(ctx, size)
+	if err != nil {
+		slog.Error("health history: load failed", "error", err)
+		return
+	}
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if s.healthHist == nil {
+		s.healthHist = make(map[int64]*healthRing)
+	}
+	for _, rec := range recs {
+		r := s.healthHist[rec.ServiceID]
+		if r == nil {
+			r = &healthRing{}
+			s.healthHist[rec.ServiceID] = r
+		}
+		r.add(healthPoint{Alive: rec.Alive, LatencyMs: rec.LatencyMs, At: rec.CheckedAt}, size)
+	}
+	slog.Info("health history loaded", "samples", len(recs))
+}
+
+// healthHistory returns a service's samples, oldest first.
+func (s *Server) healthHistory(id int64) []healthPoint {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+	r := s.healthHist[id]
+	if r == nil {
+		return nil
+	}
+	return r.points()
+}
+
+// healthUptimes returns each service's uptime since since (see uptime).
+// Services without samples in that window are absent.
+func (s *Server) healthUptimes(since time.Time) map[int64]float64 {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+	m := make(map[int64]float64, len(s.healthHist))
+	for id, r := range s.healthHist {
+		if pct, ok := uptime(r.buf, since); ok {
+			m[id] = pct
+		}
+	}
+	return m
+}
+
+// uptime returns the percentage (two decimals) of samples taken at or after
+// since that were up, and false when there are none.
+func uptime(points []healthPoint, since time.Time) (float64, bool) {
+	total, up := 0, 0
+	for _, p := range points {
+		if p.At.Before(since) {
+			continue
+		}
+		total++
+		if p.Alive {
+			up++
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return math.Round(float64(up)/float64(total)*10000) / 100, true
+}
+
+// startHealthHistoryPruner deletes persisted samples older than
+// HEALTH_HISTORY_RETENTION every 15 minutes. Disabled when samples aren't
+// persisted.
+func (s *Server) startHealthHistoryPruner() {
+	retention := s.cfg.HealthHistoryRetention
+	if retention == 0 {
+		return
+	}
+	s.histStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				n, err := s.db.PruneHealthHistory(ctx, retention)
+				cancel()
+				if err != nil {
+					slog.Error("health history: prune failed", "error", err)
+				} else if n > 0 {
+					slog.Debug("health history: pruned", "deleted", n)
+				}
+			case <-s.histStop:
+				return
+			}
+		}
+	}()
+}
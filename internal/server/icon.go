@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+const (
+	// iconCacheTTL is how long a fetched favicon is served from memory.
+	iconCacheTTL = 6 * time.Hour
+	// iconFallbackTTL is how long a letter avatar stands in before the
+	// favicon is tried again, so a service that was down at the first
+	// request gets its real icon soon after it comes back.
+	iconFallbackTTL = 30 * time.Minute
+	// iconMaxBytes caps a fetched icon, where anything larger gets the
+	// avatar, and an uploaded one.
+	iconMaxBytes = 256 << 10
+)
+
+// iconEntry is a cached service icon.
+type iconEntry struct {
+	source      string // URL the icon came from; a changed icon_url or url refetches
+	body        []byte
+	contentType string
+	expires     time.Time
+}
+
+// handleServiceIcon serves a service's icon for the portal, login, and
+// catalog cards: the icon an admin uploaded, else its icon_url or
+// /favicon.ico fetched server-side, or a letter avatar when neither yields
+// an image. Icons of services that aren't public and enabled are only
+// served to signed-in users who can see the service (see canSeeService).
+// Browsers revalidate icons on each use by their ETag, a hash of the bytes,
+// so an upload or its removal shows up on the next page load; an unchanged
+// icon costs a 304.
+//
+// GET /icon/:id
+func (s *Server) handleServiceIcon(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	svc, err := s.db.GetServiceByID(c.Request().Context(), id)
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	cacheControl := "public, no-cache"
+	if !svc.Public || !svc.Enabled {
+		if !s.canSeeService(c, svc.ID) {
+			return c.NoContent(http.StatusNotFound)
+		}
+		cacheControl = "private, no-cache"
+	}
+
+	icon := s.serviceIcon(c.Request().Context(), svc)
+	etag := iconETag(icon.body)
+	h := c.Response().Header()
+	h.Set("Cache-Control", cacheControl)
+	h.Set("ETag", etag)
+	if strings.Contains(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	return c.Blob(http.StatusOK, icon.contentType, icon.body)
+}
+
+// iconETag returns a strong ETag for an icon's bytes.
+func iconETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+// canSeeService reports whether the signed-in user may see a service that
+// isn't public: owners and admins see every service, others those their
+// portal lists.
+func (s *Server) canSeeService(c echo.Context, id int64) bool {
+	cookie, err := c.Cookie(s.sess.CookieName())
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	ctx := c.Request().Context()
+	sess, err := s.sess.Validate(ctx, cookie.Value)
+	if err != nil {
+		return false
+	}
+	user, err := s.db.GetUserByIdentityDID(ctx, sess.DID)
+	if err != nil {
+		return false
+	}
+	if user.Role == "owner" || user.Role == "admin" {
+		return true
+	}
+	svcs, err := s.portalServices(c, user)
+	if err != nil {
+		slog.Warn("icon: failed to load services", "error", err)
+		return false
+	}
+	return slices.ContainsFunc(svcs, func(svc database.Service) bool { return svc.ID == id })
+}
+
+// serviceIcon returns svc's uploaded icon, or else its fetched icon from the
+// cache, fetching it when missing, stale, or its source changed; requests
+// that miss the cache together share one fetch. Uploaded icons are read
+// from the database each time, so every instance serves a new upload at
+// once.
+func (s *Server) serviceIcon(ctx context.Context, svc *database.Service) iconEntry {
+	if svc.HasIcon {
+		body, contentType, err := s.db.ServiceIcon(ctx, svc.ID)
+		if err == nil {
+			return iconEntry{body: body, contentType: contentType}
+		}
+		slog.Warn("failed to load uploaded service icon", "service", svc.Slug, "error", err)
+	}
+
+	source := svc.IconURL
+	if source == "" {
+		source = strings.TrimRight(svc.URL, "/") + "/favicon.ico"
+	}
+
+	s.iconMu.Lock()
+	cached, ok := s.icons[svc.ID]
+	s.iconMu.Unlock()
+	if ok && cached.source == source && time.Now().Before(cached.expires) {
+		return cached
+	}
+
+	v, _, _ := s.iconFlight.Do(strconv.FormatInt(svc.ID, 10), func() (any, error) {
+		// Not the request's context: a browser giving up on the image
+		// shouldn't leave the avatar cached in place of a favicon that was
+		// on its way, or fail the requests sharing the fetch.
+		fetchCtx, cancel := context.WithTimeout(context.Background(), iconFetchTimeout)
+		defer cancel()
+		entry := iconEntry{source: source, expires: time.Now().Add(iconCacheTTL)}
+		body, contentType, err := fetchIcon(fetchCtx, s.iconClient(svc), source)
+		if err != nil {
+			slog.Debug("service icon unavailable, using letter avatar", "service", svc.Slug, "source", source, "error", err)
+			body, contentType = letterAvatarSVG(svc.Name), "image/svg+xml"
+			entry.expires = time.Now().Add(iconFallbackTTL)
+		}
+		entry.body, entry.contentType = body, contentType
+
+		s.iconMu.Lock()
+		if s.icons == nil {
+			s.icons = make(map[int64]iconEntry)
+		}
+		s.icons[svc.ID] = entry
+		s.iconMu.Unlock()
+		return entry, nil
+	})
+	return v.(iconEntry)
+}
+
+// iconFetchTimeout bounds one icon fetch.
+const iconFetchTimeout = 5 * time.Second
+
+// iconClient returns the client a service's icon is fetched with: the
+// health probe client, so certificates are verified unless the service has
+// skip_tls_verify, but with the icon timeout and redirects followed.
+func (s *Server) iconClient(svc *database.Service) *http.Client {
+	client := s.healthClient(svc.SkipTLSVerify)
+	client.Timeout = iconFetchTimeout
+	client.CheckRedirect = nil
+	return client
+}
+
+// fetchIcon downloads an icon and checks that it is a raster image. The
+// type is sniffed from the bytes, not taken from the response, and SVG is
+// refused: it is served from noknok's origin, where a script inside an SVG
+// would run.
+func fetchIcon(ctx context.Context, client *http.Client, rawURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "noknok/"+config.Version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, iconMaxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > iconMaxBytes {
+		return nil, "", fmt.Errorf("larger than %d bytes", iconMaxBytes)
+	}
+	contentType, err := sniffIcon(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, contentType, nil
+}
+
+// sniffIcon returns the content type of a raster image, sniffed from its
+// bytes. Anything else, SVG included (it sniffs as text), is an error.
+func sniffIcon(body []byte) (string, error) {
+	contentType := http.DetectContentType(body)
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("not an image (%s)", contentType)
+	}
+	return contentType, nil
+}
+
+// handleUploadServiceIcon stores the request body as a service's icon,
+// served by /icon/:id ahead of its icon_url or favicon. The body is the
+// image itself with an image/* Content-Type (as a browser sends a File),
+// not a multipart form, so a cross-site form can't post one. It must be a
+// raster image of at most iconMaxBytes; the stored type is sniffed.
+// POST /admin/api/services/:id/icon
+func (s *Server) handleUploadServiceIcon(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	if !strings.HasPrefix(c.Request().Header.Get("Content-Type"), "image/") {
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": "content type must be an image type"})
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, iconMaxBytes+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read icon"})
+	}
+	if len(body) > iconMaxBytes {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("icon must be at most %d KB", iconMaxBytes>>10)})
+	}
+	contentType, err := sniffIcon(body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "icon must be a PNG, JPEG, GIF, WebP, or ICO image"})
+	}
+
+	ctx := c.Request().Context()
+	if _, err := s.db.GetServiceByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	svc, err := s.db.SetServiceIcon(ctx, id, body, contentType)
+	if err != nil {
+		slog.Error("service icon upload failed", "service_id", id, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to store icon"})
+	}
+
+	slog.Info("service icon uploaded", "service_id", id, "type", contentType, "bytes", len(body), "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "service.icon", "service", strconv.FormatInt(id, 10),
+		map[string]any{"type": contentType, "bytes": len(body)}); err != nil {
+		slog.Warn("audit record failed", "action", "service.icon", "error", err)
+	}
+	return c.JSON(http.StatusOK, svc)
+}
+
+// handleDeleteServiceIcon removes a service's uploaded icon, so /icon/:id
+// falls back to its icon_url or favicon.
+// DELETE /admin/api/services/:id/icon
+func (s *Server) handleDeleteServiceIcon(c echo.Context) error {
+	caller := adminUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid service ID"})
+	}
+	if !inAdminScope(c, id) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errOutOfScope})
+	}
+	ctx := c.Request().Context()
+	if _, err := s.db.GetServiceByID(ctx, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	svc, err := s.db.SetServiceIcon(ctx, id, nil, "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to remove icon"})
+	}
+
+	slog.Info("service icon removed", "service_id", id, "by", caller.Handle)
+	if err := s.db.RecordAudit(ctx, caller, "service.icon_remove", "service", strconv.FormatInt(id, 10), nil); err != nil {
+		slog.Warn("audit record failed", "action", "service.icon_remove", "error", err)
+	}
+	return c.JSON(http.StatusOK, svc)
+}
+
+// letterAvatarSVG renders the first letter of name on a square whose color
+// is derived from the name, so a service keeps the same color everywhere.
+func letterAvatarSVG(name string) []byte {
+	initial := "?"
+	if r := []rune(strings.TrimSpace(name)); len(r) > 0 {
+		initial = strings.ToUpper(string(r[0]))
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hue := h.Sum32() % 360
+	return []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="64" height="64" viewBox="0 0 64 64">` +
+		`<rect width="64" height="64" rx="12" fill="hsl(` + strconv.Itoa(int(hue)) + `,55%,42%)"/>` +
+		`<text x="32" y="32" dy="0.35em" text-anchor="middle" font-family="-apple-system,BlinkMacSystemFont,Segoe UI,Roboto,sans-serif" font-size="32" font-weight="600" fill="#fff">` +
+		html.EscapeString(initial) + `</text></svg>`)
+}
+
+// handleFavicon serves the browser tab icon for noknok's own pages:
+// FAVICON_FILE if set, else a redirect to BRAND_LOGO_URL, else a letter
+// avatar of the brand name.
+//
+// GET /favicon.ico
+func (s *Server) handleFavicon(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+	switch {
+	case s.cfg.FaviconFile != "":
+		return c.File(s.cfg.FaviconFile)
+	case s.cfg.BrandLogoURL != "":
+		return c.Redirect(http.StatusFound, s.cfg.BrandLogoURL)
+	}
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, "image/svg+xml", letterAvatarSVG(s.cfg.BrandName))
+}
+
+// serviceIconHTML is the <img> for a service card.
+func serviceIconHTML(svc database.Service) string {
+	return `<img src="/icon/` + strconv.FormatInt(svc.ID, 10) + `" alt="" style="width:28px;height:28px;border-radius:4px">`
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// Requests that miss the icon cache together share one fetch.
+func TestServiceIconSharedFetch(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	var hits atomic.Int64
+	first, release := make(chan struct{}), make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			close(first)
+		}
+		<-release
+		w.Write(png)
+	}))
+	defer origin.Close()
+
+	s := &Server{cfg: &config.Config{}}
+	svc := &database.Service{ID: 1, Slug: "app", Name: "App", URL: origin.URL}
+
+	var wg sync.WaitGroup
+	icons := make([]iconEntry, 8)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		icons[0] = s.serviceIcon(context.Background(), svc)
+	}()
+	<-first
+	// The fetch is in flight until released: these either join it or, once
+	// it is done, find its result cached.
+	for i := 1; i < len(icons); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			icons[i] = s.serviceIcon(context.Background(), svc)
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	if got := hits.Load(); got != 1 {
+		t.Errorf("favicon fetched %d times, want once", got)
+	}
+	for i, icon := range icons {
+		if icon.contentType != "image/png" {
+			t.Errorf("request %d: content type %q, want image/png", i, icon.contentType)
+		}
+	}
+}
+
+// Icons of services that aren't public go only to users who can see them.
+func TestServiceIconAccess(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	owner, ownerCookie := testUser(t, s, "owner")
+	user, userCookie := testUser(t, s, "user")
+	_, strangerCookie := testUser(t, s, "user")
+	name := randomName(t)
+	svc, err := s.db.CreateService(ctx, database.Service{Slug: name, Name: name, URL: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.DeleteService(context.Background(), svc.ID) })
+	if _, err := s.db.CreateGrant(ctx, user.ID, svc.ID, owner.ID, "user", database.GrantExpiry{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/icon/" + strconv.FormatInt(svc.ID, 10)
+	for _, tt := range []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"owner", ownerCookie, http.StatusOK},
+		{"granted user", userCookie, http.StatusOK},
+		{"user without a grant", strangerCookie, http.StatusNotFound},
+		{"signed out", nil, http.StatusNotFound},
+	} {
+		if rec := serve(s, http.MethodGet, target, nil, tt.cookie); rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+// Icons are revalidated by ETag, so an uploaded replacement is served on
+// the next request instead of the browser's cached copy.
+func TestUploadedIconRevalidates(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	name := randomName(t)
+	svc, err := s.db.CreateService(ctx, database.Service{Slug: name, Name: name, URL: "http://127.0.0.1:1", Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.DeleteService(context.Background(), svc.ID) })
+	target := "/icon/" + strconv.FormatInt(svc.ID, 10)
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if _, err := s.db.SetServiceIcon(ctx, svc.ID, png, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	rec := get("")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first load: status %d, ETag %q", rec.Code, etag)
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "no-cache") {
+		t.Errorf("Cache-Control %q, want no-cache", cc)
+	}
+	if rec := get(etag); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged icon: status %d, want 304", rec.Code)
+	}
+
+	replaced := append(slices.Clone(png), 0)
+	if _, err := s.db.SetServiceIcon(ctx, svc.ID, replaced, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	rec = get(etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("replaced icon: status %d, ETag %q, want 200 with a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+}
@@ -1,16 +1,17 @@
 package server
 
 import (
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
-	"github.com/primal-host/noknok/internal/session"
 )
 
 // handleSwitchIdentity switches the active identity within the session group.
 func (s *Server) handleSwitchIdentity(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err != nil || cookie.Value == "" {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
@@ -34,9 +35,40 @@ func (s *Server) handleSwitchIdentity(c echo.Context) error {
 	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
 }
 
+// handleDomainIdentity chooses which of the group's identities is relayed
+// to an external cookie domain, so that domain gets the same identity
+// whichever one is active in the portal. An empty did clears the choice.
+func (s *Server) handleDomainIdentity(c echo.Context) error {
+	cookie, err := c.Cookie(s.sess.CookieName())
+	if err != nil || cookie.Value == "" {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+
+	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	if err != nil || sess.GroupID == "" {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+
+	domain := c.FormValue("domain")
+	did := c.FormValue("did")
+	if !slices.Contains(s.cfg.CookieDomains, domain) || domain == s.cfg.CookieDomain {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
+	}
+	if did != "" {
+		if _, _, ok := s.sess.GroupHasDID(c.Request().Context(), sess.GroupID, did); !ok {
+			return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
+		}
+	}
+
+	if err := s.sess.SetDomainIdentity(c.Request().Context(), sess.GroupID, domain, did); err != nil {
+		slog.Warn("set domain identity failed", "domain", domain, "error", err)
+	}
+	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
+}
+
 // handleLogoutOne logs out a single identity from the session group.
 func (s *Server) handleLogoutOne(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err != nil || cookie.Value == "" {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
@@ -71,12 +103,12 @@ func (s *Server) handleLogoutOne(c echo.Context) error {
 
 // handleListIdentities returns all identities in the current session group as JSON.
 func (s *Server) handleListIdentities(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err != nil || cookie.Value == "" {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
 	}
 
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.validateSession(c, cookie.Value)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
 	}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/atproto"
 	"github.com/primal-host/noknok/internal/config"
 	"github.com/primal-host/noknok/internal/database"
 	"github.com/primal-host/noknok/internal/session"
@@ -15,27 +20,113 @@ import (
 
 const redirectCookieName = "noknok_redirect"
 
+// lastHandleCookieName holds the handle of the last sign-in on this browser,
+// for users who ticked "Remember my handle". It is not a credential.
+const lastHandleCookieName = "noknok_last_handle"
+
+// lastHandleMaxAge is how long a remembered handle is kept: a year.
+const lastHandleMaxAge = 365 * 24 * 60 * 60
+
 // handleLoginPage renders the login form (handle only, no password).
 func (s *Server) handleLoginPage(c echo.Context) error {
 	redirect := c.QueryParam("redirect")
 	errMsg := c.QueryParam("error")
 
-	svcs, err := s.db.ListPublicServices(c.Request().Context())
+	// The portal sends a session that expired within SESSION_EXPIRY_GRACE
+	// here to be signed straight back in as the same identity. The handle
+	// comes from the session, never from the query.
+	var refreshHandle string
+	if c.QueryParam("refresh") != "" {
+		if sess := s.expiredSession(c); sess != nil {
+			authURL, err := s.oauth.StartLogin(c.Request().Context(), sess.Handle)
+			if err == nil {
+				s.setRedirectCookie(c, redirect)
+				return c.Redirect(http.StatusFound, authURL)
+			}
+			slog.Warn("OAuth restart for expired session failed", "handle", sess.Handle, "error", err)
+			refreshHandle = sess.Handle
+		}
+	}
+
+	svcs, down, err := s.publicServices(c)
 	if err != nil {
 		slog.Warn("login: failed to load public services", "error", err)
-		svcs = nil
 	}
 
-	return c.HTML(http.StatusOK, loginHTML(redirect, errMsg, s.hasValidSession(c), svcs))
+	hint, remembered := s.loginHint(c)
+	if refreshHandle != "" {
+		hint = refreshHandle
+	}
+	return c.HTML(http.StatusOK, loginHTML(s.brand(), redirect, errMsg, hint, remembered, s.hasValidSession(c), svcs, down))
+}
+
+// expiredSession returns the browser's session if it has expired but is
+// still within SESSION_EXPIRY_GRACE, else nil.
+func (s *Server) expiredSession(c echo.Context) *session.Session {
+	cookie, err := c.Cookie(s.sess.CookieName())
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	sess, err := s.sess.ValidateGrace(c.Request().Context(), cookie.Value)
+	if err != nil || !sess.NeedsRefresh {
+		return nil
+	}
+	return sess
+}
+
+// loginHint returns the handle to prefill on the login form: a login_hint
+// query parameter (forwardAuth adds one when a service demands a fresh
+// sign-in), else the remembered last handle. remembered reports whether a
+// handle is remembered, so the checkbox stays ticked. Anything that isn't a
+// valid handle is ignored.
+func (s *Server) loginHint(c echo.Context) (hint string, remembered bool) {
+	if rc, err := c.Cookie(lastHandleCookieName); err == nil && rc.Value != "" {
+		if h, err := syntax.ParseHandle(rc.Value); err == nil {
+			hint, remembered = h.String(), true
+		}
+	}
+	if q := strings.TrimPrefix(strings.TrimSpace(c.QueryParam("login_hint")), "@"); q != "" {
+		if h, err := syntax.ParseHandle(q); err == nil {
+			hint = h.String()
+		}
+	}
+	return hint, remembered
+}
+
+// rememberHandle stores handle in the last-handle cookie when the user
+// asked for it, and forgets any remembered handle when they didn't.
+func (s *Server) rememberHandle(c echo.Context, handle string, remember bool) {
+	cookie := &http.Cookie{
+		Name:     lastHandleCookieName,
+		Path:     s.cfg.CookiePath,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.cfg.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if remember {
+		cookie.Value = handle
+		cookie.MaxAge = lastHandleMaxAge
+	} else {
+		if _, err := c.Cookie(lastHandleCookieName); err != nil {
+			return
+		}
+		cookie.MaxAge = -1
+	}
+	c.SetCookie(cookie)
 }
 
+// directoryUnavailableMsg is shown at sign-in while the identity directory
+// circuit breaker is open.
+const directoryUnavailableMsg = "The identity service is unavailable right now. Please try again in a minute."
+
 // handleLogin processes the login form — starts the OAuth flow.
 func (s *Server) handleLogin(c echo.Context) error {
-	handle := strings.TrimSpace(c.FormValue("handle"))
+	handle := strings.TrimPrefix(strings.TrimSpace(c.FormValue("handle")), "@")
 	redirect := c.FormValue("redirect")
+	remember := c.FormValue("remember") != ""
 
 	if handle == "" {
-		return c.HTML(http.StatusOK, loginHTML(redirect, "Handle is required.", s.hasValidSession(c), nil))
+		return c.HTML(http.StatusOK, loginHTML(s.brand(), redirect, "Handle is required.", "", remember, s.hasValidSession(c), nil, nil))
 	}
 
 	// Default bare names to .bsky.social.
@@ -43,27 +134,53 @@ func (s *Server) handleLogin(c echo.Context) error {
 		handle += ".bsky.social"
 	}
 
-	// Store redirect URL in a cookie so we can use it after the OAuth callback.
+	if s.loginThrottled(c) {
+		return c.HTML(http.StatusTooManyRequests, loginHTML(s.brand(), redirect, "Too many refused sign-ins from your network. Please try again later.", handle, remember, s.hasValidSession(c), nil, nil))
+	}
+
+	authURL, err := s.oauth.StartLogin(c.Request().Context(), handle)
+	if err != nil {
+		slog.Warn("OAuth start failed", "handle", handle, "error", err)
+		msg := "Could not start login. Check your handle and try again."
+		if errors.Is(err, atproto.ErrDirectoryUnavailable) {
+			msg = directoryUnavailableMsg
+			s.recordLoginEvent(c, handle, "", database.LoginError, "identity service unavailable")
+		} else {
+			s.recordFailedLogin(c, handle, "", database.LoginError, "could not start login")
+		}
+		return c.HTML(http.StatusOK, loginHTML(s.brand(), redirect, msg, handle, remember, s.hasValidSession(c), nil, nil))
+	}
+
+	s.setRedirectCookie(c, redirect)
+	s.rememberHandle(c, handle, remember)
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// setRedirectCookie stores the URL to return to in a cookie so it can be
+// used after the OAuth callback. It is escaped because cookie values can't
+// carry every URL byte (";", "\", quotes), which would otherwise be dropped
+// from deep links. Without one, a cookie left by an abandoned sign-in is
+// cleared so it can't redirect this one.
+func (s *Server) setRedirectCookie(c echo.Context, redirect string) {
 	if redirect != "" && isAllowedRedirect(redirect, s.cfg) {
 		secure := strings.HasPrefix(s.cfg.PublicURL, "https://")
 		c.SetCookie(&http.Cookie{
 			Name:     redirectCookieName,
-			Value:    redirect,
-			Path:     "/",
+			Value:    url.QueryEscape(redirect),
+			Path:     s.cfg.CookiePath,
 			MaxAge:   600, // 10 minutes
 			HttpOnly: true,
 			Secure:   secure,
 			SameSite: http.SameSiteLaxMode,
 		})
+	} else if rc, err := c.Cookie(redirectCookieName); err == nil && rc.Value != "" {
+		c.SetCookie(&http.Cookie{
+			Name:   redirectCookieName,
+			Value:  "",
+			Path:   s.cfg.CookiePath,
+			MaxAge: -1,
+		})
 	}
-
-	authURL, err := s.oauth.StartLogin(c.Request().Context(), handle)
-	if err != nil {
-		slog.Warn("OAuth start failed", "handle", handle, "error", err)
-		return c.HTML(http.StatusOK, loginHTML(redirect, "Could not start login. Check your handle and try again.", s.hasValidSession(c), nil))
-	}
-
-	return c.Redirect(http.StatusFound, authURL)
 }
 
 // handleOAuthCallback processes the auth server redirect.
@@ -71,24 +188,63 @@ func (s *Server) handleOAuthCallback(c echo.Context) error {
 	did, resolvedHandle, err := s.oauth.HandleCallback(c.Request().Context(), c.QueryParams())
 	if err != nil {
 		slog.Warn("OAuth callback failed", "error", err)
-		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Authentication failed. Please try again."))
+		msg := "Authentication failed. Please try again."
+		if errors.Is(err, atproto.ErrDirectoryUnavailable) {
+			msg = directoryUnavailableMsg
+			s.recordLoginEvent(c, "", "", database.LoginError, "identity service unavailable")
+		} else {
+			s.recordFailedLogin(c, "", "", database.LoginError, "authentication failed")
+		}
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape(msg))
+	}
+
+	if blocked, err := s.db.IsDIDBlocked(c.Request().Context(), did); err != nil {
+		slog.Error("login: block list lookup failed", "did", did, "error", err)
+		s.recordLoginEvent(c, resolvedHandle, did, database.LoginError, "block list unavailable")
+		return c.HTML(http.StatusServiceUnavailable, s.unavailableHTML())
+	} else if blocked {
+		slog.Warn("blocked DID attempted login", "did", did, "handle", resolvedHandle)
+		s.recordFailedLogin(c, resolvedHandle, did, database.LoginDenied, "blocked")
+		return c.HTML(http.StatusForbidden, s.accessDeniedHTML())
 	}
 
 	// Look up user by identity DID.
 	user, err := s.db.GetUserByIdentityDID(c.Request().Context(), did)
 	if err != nil {
-		slog.Warn("unauthorized DID attempted login", "did", did, "handle", resolvedHandle)
-		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Access denied. You are not authorized."))
+		var msg string
+		if user, msg = s.signUp(c, did, resolvedHandle); user == nil {
+			return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape(msg))
+		}
+	}
+	switch user.Status {
+	case database.UserPending:
+		slog.Info("pending user attempted login", "did", did, "handle", resolvedHandle)
+		s.recordFailedLogin(c, resolvedHandle, did, database.LoginDenied, "pending approval")
+		return c.HTML(http.StatusForbidden, s.pendingApprovalHTML())
+	case database.UserDenied:
+		slog.Warn("denied user attempted login", "did", did, "handle", resolvedHandle)
+		s.recordFailedLogin(c, resolvedHandle, did, database.LoginDenied, "signup denied")
+		return c.HTML(http.StatusForbidden, s.signupDeniedHTML())
+	}
+
+	first, err := s.db.RecordLogin(c.Request().Context(), user.ID)
+	if err != nil {
+		slog.Warn("failed to record login", "user_id", user.ID, "error", err)
 	}
 
-	// Check for existing session group (adding identity to existing browser session).
+	// Check for existing session group (adding identity to existing browser
+	// session). A session within SESSION_EXPIRY_GRACE still counts, so
+	// signing back in after it expired keeps the browser's other identities.
 	var groupID string
-	if existing, err := c.Cookie(session.CookieName()); err == nil && existing.Value != "" {
-		if existingSess, err := s.sess.Validate(c.Request().Context(), existing.Value); err == nil {
+	if existing, err := c.Cookie(s.sess.CookieName()); err == nil && existing.Value != "" {
+		if existingSess, err := s.sess.ValidateGrace(c.Request().Context(), existing.Value); err == nil {
 			groupID = existingSess.GroupID
 
 			// If this DID already exists in the group, switch to it instead of creating a duplicate.
 			if existingID, _, found := s.sess.GroupHasDID(c.Request().Context(), groupID, did); found {
+				if err := s.sess.MarkAuthenticated(c.Request().Context(), existingID); err != nil {
+					slog.Warn("failed to record re-authentication", "did", did, "error", err)
+				}
 				switchCookie, switchErr := s.sess.SwitchTo(c.Request().Context(), groupID, existingID)
 				if switchErr != nil {
 					slog.Warn("failed to switch to existing identity", "did", did, "error", switchErr)
@@ -96,66 +252,144 @@ func (s *Server) handleOAuthCallback(c echo.Context) error {
 					c.SetCookie(switchCookie)
 				}
 				slog.Info("switched to existing identity in group", "did", did, "handle", resolvedHandle)
-				dest := s.cfg.PublicURL + "/"
-				if rc, err := c.Cookie(redirectCookieName); err == nil && rc.Value != "" {
-					if isAllowedRedirect(rc.Value, s.cfg) {
-						dest = rc.Value
-					}
-					c.SetCookie(&http.Cookie{Name: redirectCookieName, Value: "", Path: "/", MaxAge: -1})
-				}
-				// Relay to external domain if needed.
-				if destURL, parseErr := url.Parse(dest); parseErr == nil && destURL.Host != "" {
-					if s.cfg.IsExternalHost(destURL.Host) {
-						token := existing.Value
-						if switchCookie != nil {
-							token = switchCookie.Value
-						}
-						relayURL := fmt.Sprintf("%s://%s/__noknok_set?t=%s&r=%s",
-							destURL.Scheme, destURL.Host, token, url.QueryEscape(destURL.RequestURI()))
-						return c.Redirect(http.StatusFound, relayURL)
-					}
+				s.recordLoginEvent(c, resolvedHandle, did, database.LoginSuccess, "")
+				token := existing.Value
+				if switchCookie != nil {
+					token = switchCookie.Value
 				}
-				return c.Redirect(http.StatusFound, dest)
+				return c.Redirect(http.StatusFound, s.loginDestination(c, token))
 			}
 		}
 	}
 
 	// Create noknok session.
-	cookie, err := s.sess.Create(c.Request().Context(), user.ID, did, resolvedHandle, groupID)
+	cookie, err := s.sess.Create(c.Request().Context(), user.ID, did, resolvedHandle, groupID, c.RealIP(), c.Request().UserAgent())
 	if err != nil {
 		slog.Error("failed to create session", "error", err)
+		s.recordLoginEvent(c, resolvedHandle, did, database.LoginError, "could not create session")
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Internal error. Please try again."))
 	}
 	c.SetCookie(cookie)
 
 	slog.Info("login successful", "did", did, "handle", resolvedHandle)
+	s.recordLoginEvent(c, resolvedHandle, did, database.LoginSuccess, "")
+
+	dest := s.loginDestination(c, cookie.Value)
+	if first && s.cfg.WelcomePage {
+		return c.HTML(http.StatusOK, s.welcomeHTML(resolvedHandle, dest))
+	}
+	return c.Redirect(http.StatusFound, dest)
+}
+
+// signUp registers a DID that authenticated but has no user, as SIGNUP_MODE
+// allows: as an active user (open) or one awaiting approval (approval).
+// With signups closed, or if registration fails, it returns a nil user and
+// the message for the login page.
+func (s *Server) signUp(c echo.Context, did, handle string) (*database.User, string) {
+	ctx := c.Request().Context()
+	status := database.UserActive
+	switch s.cfg.SignupMode {
+	case "open":
+	case "approval":
+		status = database.UserPending
+	default:
+		slog.Warn("unauthorized DID attempted login", "did", did, "handle", handle)
+		s.recordFailedLogin(c, handle, did, database.LoginDenied, "not authorized")
+		return nil, "Access denied. You are not authorized."
+	}
+	user, err := s.db.RegisterUser(ctx, did, handle, status)
+	if err != nil {
+		slog.Error("failed to register user", "did", did, "handle", handle, "error", err)
+		return nil, "Internal error. Please try again."
+	}
+	slog.Info("user signed up", "did", did, "handle", handle, "status", user.Status)
+	if err := s.db.RecordAudit(ctx, user, "user.signup", "user", strconv.FormatInt(user.ID, 10),
+		map[string]any{"handle": handle, "status": user.Status}); err != nil {
+		slog.Warn("audit record failed", "action", "user.signup", "error", err)
+	}
+	return user, ""
+}
+
+// recordFailedLogin records a refused sign-in in the login trail and, when
+// AUDIT_FAILED_LOGINS is on, in the audit log as login.failed, with the
+// identity that tried as the actor (as far as it is known) and the reason
+// and client IP as detail. Outages of the identity directory only go to
+// the login trail: nobody was refused.
+func (s *Server) recordFailedLogin(c echo.Context, handle, did, result, reason string) {
+	s.recordLoginEvent(c, handle, did, result, reason)
+	if !s.cfg.AuditFailedLogins {
+		return
+	}
+	actor := &database.User{DID: did, Handle: handle}
+	if err := s.db.RecordAudit(c.Request().Context(), actor, "login.failed", "", "",
+		map[string]any{"reason": reason, "ip": c.RealIP()}); err != nil {
+		slog.Warn("audit record failed", "action", "login.failed", "error", err)
+	}
+}
+
+// recordLoginEvent counts a sign-in attempt in noknok_logins_total and
+// appends it to login_events, unless LOGIN_EVENT_RETENTION is 0. A failed
+// write is logged; it never fails the sign-in.
+func (s *Server) recordLoginEvent(c echo.Context, handle, did, result, reason string) {
+	s.metrics.logins.WithLabelValues(result).Inc()
+	if s.cfg.LoginEventRetention == 0 {
+		return
+	}
+	if err := s.db.RecordLoginEvent(c.Request().Context(), did, handle, result, reason, c.RealIP()); err != nil {
+		slog.Warn("login events: failed to record", "result", result, "error", err)
+	}
+}
+
+// loginThrottled reports whether the client's IP has had LOGIN_DENY_LIMIT
+// refused sign-ins within LOGIN_DENY_WINDOW, in which case it can't start
+// another for now. If the count can't be read, sign-in goes ahead.
+func (s *Server) loginThrottled(c echo.Context) bool {
+	if s.cfg.LoginDenyLimit == 0 {
+		return false
+	}
+	n, err := s.db.CountDeniedLogins(c.Request().Context(), c.RealIP(), s.cfg.LoginDenyWindow)
+	if err != nil {
+		slog.Warn("login events: failed to count refusals", "error", err)
+		return false
+	}
+	if n < s.cfg.LoginDenyLimit {
+		return false
+	}
+	slog.Warn("sign-in refused: too many refused sign-ins", "ip", c.RealIP(), "count", n)
+	return true
+}
 
-	// Redirect to the stored destination or portal.
+// loginDestination consumes the redirect cookie and returns where to send
+// the user after login: the stored URL, or the portal. If the destination
+// is on a different cookie domain, the session is relayed through that
+// domain so the cookie gets set there too; the relay carries the full path
+// and query so the user lands on the exact page they asked for.
+func (s *Server) loginDestination(c echo.Context, token string) string {
 	dest := s.cfg.PublicURL + "/"
 	if rc, err := c.Cookie(redirectCookieName); err == nil && rc.Value != "" {
-		if isAllowedRedirect(rc.Value, s.cfg) {
-			dest = rc.Value
+		if stored, err := url.QueryUnescape(rc.Value); err == nil && isAllowedRedirect(stored, s.cfg) {
+			dest = stored
 		}
 		// Clear the redirect cookie.
 		c.SetCookie(&http.Cookie{
 			Name:   redirectCookieName,
 			Value:  "",
-			Path:   "/",
+			Path:   s.cfg.CookiePath,
 			MaxAge: -1,
 		})
 	}
 
-	// If the destination is on a different cookie domain, relay the session
-	// through that domain so the cookie gets set there too.
-	if destURL, err := url.Parse(dest); err == nil && destURL.Host != "" {
-		if s.cfg.IsExternalHost(destURL.Host) {
-			relayURL := fmt.Sprintf("%s://%s/__noknok_set?t=%s&r=%s",
-				destURL.Scheme, destURL.Host, cookie.Value, url.QueryEscape(destURL.RequestURI()))
-			return c.Redirect(http.StatusFound, relayURL)
+	if destURL, err := url.Parse(dest); err == nil && destURL.Host != "" && s.cfg.IsExternalHost(destURL.Host) {
+		// The fragment rides on the relay URL itself: browsers carry it over
+		// to the relay's own redirect, which has none.
+		relay := fmt.Sprintf("%s://%s/__noknok_set?t=%s&r=%s",
+			destURL.Scheme, destURL.Host, url.QueryEscape(token), url.QueryEscape(destURL.RequestURI()))
+		if destURL.Fragment != "" {
+			relay += "#" + destURL.EscapedFragment()
 		}
+		return relay
 	}
-
-	return c.Redirect(http.StatusFound, dest)
+	return dest
 }
 
 // handleClientMetadata serves the OAuth client metadata document.
@@ -178,14 +412,15 @@ func isAllowedRedirect(rawURL string, cfg *config.Config) bool {
 	if u.Scheme != "http" && u.Scheme != "https" {
 		return false
 	}
+	host := u.Hostname()
 	for _, domain := range cfg.CookieDomains {
 		if strings.HasPrefix(domain, ".") {
 			base := domain[1:]
-			if u.Host == base || strings.HasSuffix(u.Host, domain) {
+			if host == base || strings.HasSuffix(host, domain) {
 				return true
 			}
 		} else {
-			if u.Host == domain {
+			if host == domain {
 				return true
 			}
 		}
@@ -193,9 +428,15 @@ func isAllowedRedirect(rawURL string, cfg *config.Config) bool {
 	return false
 }
 
+// requestDomain returns the cookie domain the request arrived on, used to
+// scope service lists to that domain's tenants.
+func (s *Server) requestDomain(c echo.Context) string {
+	return s.cfg.DomainForHost(c.Request().Host)
+}
+
 // hasValidSession returns true if the request has a valid session cookie.
 func (s *Server) hasValidSession(c echo.Context) bool {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err != nil || cookie.Value == "" {
 		return false
 	}
@@ -203,15 +444,20 @@ func (s *Server) hasValidSession(c echo.Context) bool {
 	return err == nil
 }
 
-func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service) string {
+func loginHTML(b brand, redirect, errMsg, hint string, remember, hasSession bool, svcs []database.Service, down map[int64]bool) string {
 	errorBlock := ""
 	if errMsg != "" {
-		errorBlock = `<div class="error">` + errMsg + `</div>`
+		errorBlock = `<div class="error">` + html.EscapeString(errMsg) + `</div>`
 	}
 
 	redirectInput := ""
 	if redirect != "" {
-		redirectInput = `<input type="hidden" name="redirect" value="` + redirect + `">`
+		redirectInput = `<input type="hidden" name="redirect" value="` + html.EscapeString(redirect) + `">`
+	}
+
+	rememberChecked := ""
+	if remember {
+		rememberChecked = " checked"
 	}
 
 	closeBtn := ""
@@ -219,34 +465,12 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
 		closeBtn = `<a href="/" class="close-btn" title="Cancel">&times;</a>`
 	}
 
-	// Build public service cards.
-	serviceCards := ""
-	for _, svc := range svcs {
-		initial := "?"
-		if len(svc.Name) > 0 {
-			initial = string([]rune(svc.Name)[0])
-		}
-		faviconURL := strings.TrimRight(svc.URL, "/") + "/favicon.ico"
-		desc := svc.Description
-		if len([]rune(desc)) > 20 {
-			desc = string([]rune(desc)[:20]) + "..."
-		}
-		serviceCards += `
-      <a href="` + svc.URL + `" target="` + svc.Slug + `" class="card svc-card" rel="noopener">
-        <div class="icon"><img src="` + faviconURL + `" onerror="this.style.display='none';this.nextSibling.style.display=''" style="width:28px;height:28px;border-radius:4px"><span style="display:none">` + initial + `</span></div>
-        <div class="info">
-          <h3>` + svc.Name + `</h3>
-          <p>` + desc + `</p>
-        </div>
-      </a>`
-	}
-
 	// Only show service grid section if there are public services.
 	serviceSection := ""
-	if serviceCards != "" {
-		serviceSection = fmt.Sprintf(`
-<div class="grid">%s
-</div>`, serviceCards)
+	if len(svcs) > 0 {
+		serviceSection = `
+<div class="grid">` + serviceCardsHTML(svcs, down, false) + `
+</div>`
 	}
 
 	return `<!DOCTYPE html>
@@ -254,7 +478,8 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
 <head>
 <meta charset="utf-8">
 <meta name="viewport" content="width=device-width, initial-scale=1">
-<title>sign in</title>
+<title>` + b.title("sign in") + `</title>
+` + faviconLink + `
 <style>
   *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
   body {
@@ -293,6 +518,7 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
     text-decoration: none;
   }
   .close-btn:hover { color: #fff; border-color: #f97316; background: #f97316; }
+  .login-card .brand { margin-bottom: 1rem; }` + b.css() + `
   .error {
     background: #7f1d1d;
     color: #fca5a5;
@@ -328,6 +554,113 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
     transition: background 0.15s;
   }
   button:hover { background: #2563eb; }
+  .remember {
+    display: flex;
+    align-items: center;
+    gap: 0.375rem;
+    font-size: 0.8125rem;
+    color: #94a3b8;
+    margin-bottom: 0.75rem;
+    cursor: pointer;
+  }
+  .remember input { accent-color: #3b82f6; }
+` + serviceCardCSS + `
+</style>
+</head>
+<body>
+<div class="login-card">
+  ` + closeBtn + `
+  ` + b.headerHTML() + `
+  ` + errorBlock + `
+  <form method="POST" action="/login">
+    ` + redirectInput + `
+    <input type="text" id="handle" name="handle" value="` + html.EscapeString(hint) + `" placeholder="you.bsky.social" autocomplete="username" autofocus required>
+    <label class="remember"><input type="checkbox" name="remember" value="1"` + rememberChecked + `> Remember my handle on this device</label>
+    <button type="submit">Sign in with Bluesky</button>
+  </form>
+</div>
+` + serviceSection + `
+<script>
+// Fragments never reach the server, but browsers keep the original one
+// across forwardAuth's redirect to this page; put it back on the deep link.
+(function() {
+  var input = document.querySelector('input[name="redirect"]');
+  if (input && location.hash.length > 1 && input.value.indexOf('#') < 0) input.value += location.hash;
+})();
+(function() {
+  if (typeof BroadcastChannel === 'undefined') return;
+  var ch = new BroadcastChannel('noknok_portal');
+  ch.postMessage({ type: 'ping' });
+  ch.onmessage = function(e) {
+    if (e.data.type === 'pong') {
+      ch.postMessage({ type: 'focus' });
+      window.close();
+    }
+  };
+  setTimeout(function() { ch.close(); }, 500);
+})();
+</script>
+</body>
+</html>`
+}
+
+// publicServices lists the services shown on anonymous pages, applying
+// PUBLIC_DOWN_SERVICES: with "hide", services the health poller last saw
+// down are dropped; with "dim", they are returned in the down set so the
+// page can render them as unavailable. Services not yet checked count as up.
+// Only services on the request's cookie domain are listed.
+func (s *Server) publicServices(c echo.Context) ([]database.Service, map[int64]bool, error) {
+	svcs, err := s.db.ListPublicServices(c.Request().Context(), s.requestDomain(c))
+	if err != nil || s.cfg.PublicDownServices == "show" {
+		return svcs, nil, err
+	}
+	health := s.cachedHealth()
+	down := make(map[int64]bool)
+	var shown []database.Service
+	for _, svc := range svcs {
+		if sample, checked := health[svc.ID]; checked && !sample.Alive {
+			if s.cfg.PublicDownServices == "hide" {
+				continue
+			}
+			down[svc.ID] = true
+		}
+		shown = append(shown, svc)
+	}
+	return shown, down, nil
+}
+
+// serviceCardsHTML renders public service cards for the login and catalog
+// pages. Services in down are rendered greyed out and not clickable. With
+// viaLogin, each card links to the login page with the service as the
+// post-login redirect instead of straight to the service.
+func serviceCardsHTML(svcs []database.Service, down map[int64]bool, viaLogin bool) string {
+	var cards strings.Builder
+	for _, svc := range svcs {
+		desc := svc.Description
+		if len([]rune(desc)) > 20 {
+			desc = string([]rune(desc)[:20]) + "..."
+		}
+		link := `href="` + html.EscapeString(svc.URL) + `" target="` + html.EscapeString(svc.Slug) + `" rel="noopener"`
+		if viaLogin {
+			link = `href="/login?redirect=` + html.EscapeString(url.QueryEscape(svc.URL)) + `"`
+		}
+		openTag, closeTag := `<a `+link+` class="card svc-card">`, `</a>`
+		if down[svc.ID] {
+			openTag, closeTag = `<div class="card svc-card down" title="Currently unavailable">`, `</div>`
+		}
+		cards.WriteString(`
+      ` + openTag + `
+        <div class="icon">` + serviceIconHTML(svc) + `</div>
+        <div class="info">
+          <h3>` + html.EscapeString(svc.Name) + `</h3>
+          <p>` + html.EscapeString(desc) + `</p>
+        </div>
+      ` + closeTag)
+	}
+	return cards.String()
+}
+
+const serviceCardCSS = `
   .grid {
     display: grid;
     grid-template-columns: repeat(auto-fill, minmax(240px, 1fr));
@@ -347,6 +680,8 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
     transition: background 0.15s, transform 0.1s;
   }
   .svc-card:hover { background: #334155; transform: translateY(-2px); }
+  .svc-card.down { opacity: 0.45; cursor: not-allowed; }
+  .svc-card.down:hover { background: #1e293b; transform: none; }
   .icon {
     width: 48px;
     height: 48px;
@@ -376,34 +711,4 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
     white-space: nowrap;
     overflow: hidden;
     text-overflow: ellipsis;
-  }
-</style>
-</head>
-<body>
-<div class="login-card">
-  ` + closeBtn + `
-  ` + errorBlock + `
-  <form method="POST" action="/login">
-    ` + redirectInput + `
-    <input type="text" id="handle" name="handle" placeholder="you.bsky.social" autocomplete="username" autofocus required>
-    <button type="submit">Sign in with Bluesky</button>
-  </form>
-</div>
-` + serviceSection + `
-<script>
-(function() {
-  if (typeof BroadcastChannel === 'undefined') return;
-  var ch = new BroadcastChannel('noknok_portal');
-  ch.postMessage({ type: 'ping' });
-  ch.onmessage = function(e) {
-    if (e.data.type === 'pong') {
-      ch.postMessage({ type: 'focus' });
-      window.close();
-    }
-  };
-  setTimeout(function() { ch.close(); }, 500);
-})();
-</script>
-</body>
-</html>`
-}
+  }`
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// PUBLIC_DOWN_SERVICES decides what anonymous pages do with a public
+// service the poller saw down; one not checked yet always counts as up.
+func TestPublicServicesDownMatrix(t *testing.T) {
+	for _, mode := range []string{"show", "dim", "hide"} {
+		t.Run(mode, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"PUBLIC_DOWN_SERVICES": mode})
+			up, down, unchecked := testPublicService(t, s), testPublicService(t, s), testPublicService(t, s)
+			s.setCachedHealth(*up, healthSample{Alive: true})
+			s.setCachedHealth(*down, healthSample{Alive: false})
+
+			c := s.echo.NewContext(httptest.NewRequest(http.MethodGet, "/login", nil), httptest.NewRecorder())
+			svcs, downSet, err := s.publicServices(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := make(map[int64]bool)
+			for _, svc := range svcs {
+				listed[svc.ID] = true
+			}
+			if !listed[up.ID] || !listed[unchecked.ID] {
+				t.Errorf("up or unchecked service not listed")
+			}
+			if downSet[up.ID] || downSet[unchecked.ID] {
+				t.Errorf("up or unchecked service marked down")
+			}
+			if got, want := listed[down.ID], mode != "hide"; got != want {
+				t.Errorf("down service listed %v, want %v", got, want)
+			}
+			if got, want := downSet[down.ID], mode == "dim"; got != want {
+				t.Errorf("down service marked down %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// A service in the down set renders as an inert card, not a link.
+func TestServiceCardsHTMLDown(t *testing.T) {
+	svcs := []database.Service{
+		{ID: 1, Slug: "up", Name: "Up", URL: "https://up.example.com"},
+		{ID: 2, Slug: "down", Name: "Down", URL: "https://down.example.com"},
+	}
+	out := serviceCardsHTML(svcs, map[int64]bool{2: true}, false)
+	if !strings.Contains(out, `href="https://up.example.com"`) {
+		t.Errorf("up service is not a link:\n%s", out)
+	}
+	if strings.Contains(out, `href="https://down.example.com"`) || !strings.Contains(out, `class="card svc-card down"`) {
+		t.Errorf("down service is not rendered inert:\n%s", out)
+	}
+}
+
+// testPublicService creates an enabled, public service, deleted when the
+// test ends.
+func testPublicService(t *testing.T, s *Server) *database.Service {
+	t.Helper()
+	ctx := context.Background()
+	name := randomName(t)
+	svc, err := s.db.CreateService(ctx, database.Service{Slug: name, Name: name, URL: "https://" + name + ".test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.DeleteService(context.Background(), svc.ID) })
+	if _, err := s.db.ToggleServicePublic(ctx, svc.ID); err != nil {
+		t.Fatal(err)
+	}
+	svc.Public = true
+	return svc
+}
+
+// The redirect target survives OAuth in a cookie: whatever setRedirectCookie
+// stores, loginDestination must hand back byte for byte.
+func TestRedirectCookieRoundTrip(t *testing.T) {
+	s := &Server{echo: echo.New(), cfg: &config.Config{
+		PublicURL:     "https://auth.example.com",
+		CookieDomain:  ".example.com",
+		CookieDomains: []string{".example.com"},
+		CookiePath:    "/",
+	}}
+	for _, tt := range []struct {
+		name, redirect, want string
+	}{
+		{"bare host", "https://app.example.com", ""},
+		{"trailing slash", "https://app.example.com/", ""},
+		{"path trailing slash", "https://app.example.com/docs/", ""},
+		{"no trailing slash", "https://app.example.com/docs", ""},
+		{"encoded path", "https://app.example.com/a%20b/c%2Fd/", ""},
+		{"multiple params", "https://app.example.com/search?q=x&page=2&sort=desc", ""},
+		{"repeated param", "https://app.example.com/?tag=a&tag=b", ""},
+		{"encoded query", "https://app.example.com/?q=caf%C3%A9&next=%2Fhome%3Fa%3D1%26b%3D2", ""},
+		{"plus and percent", "https://app.example.com/?q=a+b&p=100%25", ""},
+		{"fragment", "https://app.example.com/page?x=1#section-2", ""},
+		{"public host", "https://auth.example.com/sessions", ""},
+		{"foreign host", "https://evil.test/", "https://auth.example.com/"},
+		{"lookalike host", "https://example.com.evil.test/", "https://auth.example.com/"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.redirect
+			}
+			rec := httptest.NewRecorder()
+			s.setRedirectCookie(s.echo.NewContext(httptest.NewRequest(http.MethodPost, "/login", nil), rec), tt.redirect)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth/callback", nil)
+			for _, ck := range rec.Result().Cookies() {
+				req.AddCookie(ck)
+			}
+			rec = httptest.NewRecorder()
+			if got := s.loginDestination(s.echo.NewContext(req, rec), "token"); got != want {
+				t.Errorf("destination %q, want %q", got, want)
+			}
+		})
+	}
+}
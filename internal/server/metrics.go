@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds noknok's Prometheus collectors. They live in their own
+// registry, so /metrics shows only what noknok registers.
+type metrics struct {
+	handler       http.Handler
+	logins        *prometheus.CounterVec // sign-in attempts by result: success, denied, error
+	authDecisions *prometheus.CounterVec // forwardAuth outcomes by decision
+	healthPolls   prometheus.Counter
+	healthProbes  *prometheus.CounterVec // poller probes by result: up, down
+
+	accessLogDropped prometheus.Counter // decisions the access log writer had no room for
+}
+
+// newMetrics registers the collectors. Session and service gauges are read
+// at scrape time.
+func (s *Server) newMetrics() *metrics {
+	m := &metrics{
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noknok_logins_total",
+			Help: "Sign-in attempts by result (success, denied, error).",
+		}, []string{"result"}),
+		authDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noknok_forwardauth_decisions_total",
+			Help: "ForwardAuth decisions (allow, deny, redirect-login, redirect-portal).",
+		}, []string{"decision"}),
+		healthPolls: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "noknok_health_polls_total",
+			Help: "Completed health poller runs.",
+		}),
+		healthProbes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "noknok_health_probes_total",
+			Help: "Health probes by the poller, by result (up, down).",
+		}, []string{"result"}),
+		accessLogDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "noknok_access_log_dropped_total",
+			Help: "ForwardAuth decisions left out of the access log because its write queue was full.",
+		}),
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.logins, m.authDecisions, m.healthPolls, m.healthProbes, m.accessLogDropped,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "noknok_active_sessions",
+			Help: "Sessions that would still validate.",
+		}, s.countActiveSessions),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "noknok_host_cache_hits_total",
+			Help: "ForwardAuth host lookups answered by the cache.",
+		}, func() float64 { hits, _ := s.db.HostCacheStats(); return float64(hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "noknok_host_cache_misses_total",
+			Help: "ForwardAuth host lookups that went to the database.",
+		}, func() float64 { _, misses := s.db.HostCacheStats(); return float64(misses) }),
+		serviceHealthCollector{s.scrapeHealthExport},
+	)
+	// A failing collector (e.g. the database is down) leaves its metrics
+	// out instead of failing the whole scrape.
+	m.handler = promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+	return m
+}
+
+// countActiveSessions is the noknok_active_sessions gauge. A failed count
+// reports 0 and is logged.
+func (s *Server) countActiveSessions() float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n, err := s.sess.CountActive(ctx)
+	if err != nil {
+		slog.Warn("metrics: failed to count sessions", "error", err)
+		return 0
+	}
+	return float64(n)
+}
+
+var (
+	serviceUpDesc = prometheus.NewDesc("noknok_service_up",
+		"Whether the last health check of the service passed.", []string{"slug"}, nil)
+	serviceFailuresDesc = prometheus.NewDesc("noknok_service_consecutive_failures",
+		"Consecutive failed health checks of the service.", []string{"slug"}, nil)
+	serviceLastCheckDesc = prometheus.NewDesc("noknok_service_last_check_timestamp_seconds",
+		"Unix time of the service's last health check.", []string{"slug"}, nil)
+	serviceProbeDurationDesc = prometheus.NewDesc("noknok_service_probe_duration_seconds",
+		"Round trip of the service's last health probe.", []string{"slug"}, nil)
+)
+
+// serviceHealthCollector exports a health export (see healthExport) per
+// service, for /metrics and the export's Prometheus format alike: services
+// not yet checked or skipping health checks have no up or last-check
+// gauge.
+type serviceHealthCollector struct {
+	load func() ([]serviceHealthExport, error)
+}
+
+func (c serviceHealthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serviceUpDesc
+	ch <- serviceFailuresDesc
+	ch <- serviceLastCheckDesc
+	ch <- serviceProbeDurationDesc
+}
+
+func (c serviceHealthCollector) Collect(ch chan<- prometheus.Metric) {
+	svcs, err := c.load()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(serviceUpDesc, err)
+		return
+	}
+	for _, e := range svcs {
+		if e.Status != "unknown" && e.Status != "skipped" {
+			up := 0.0
+			if e.Status == "up" {
+				up = 1
+			}
+			ch <- prometheus.MustNewConstMetric(serviceUpDesc, prometheus.GaugeValue, up, e.Slug)
+		}
+		ch <- prometheus.MustNewConstMetric(serviceFailuresDesc, prometheus.GaugeValue, float64(e.ConsecutiveFailures), e.Slug)
+		if e.LastChecked != nil {
+			ch <- prometheus.MustNewConstMetric(serviceLastCheckDesc, prometheus.GaugeValue, float64(e.LastChecked.Unix()), e.Slug)
+		}
+		if e.LatencyMs != nil {
+			ch <- prometheus.MustNewConstMetric(serviceProbeDurationDesc, prometheus.GaugeValue, float64(*e.LatencyMs)/1000, e.Slug)
+		}
+	}
+}
+
+// healthMetricsHandler serves svcs in the Prometheus format, with the
+// gauges serviceHealthCollector adds to /metrics.
+func healthMetricsHandler(svcs []serviceHealthExport) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(serviceHealthCollector{func() ([]serviceHealthExport, error) { return svcs, nil }})
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// scrapeHealthExport loads the health export for a /metrics scrape.
+func (s *Server) scrapeHealthExport() ([]serviceHealthExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.healthExport(ctx)
+}
+
+// handleMetrics serves Prometheus metrics to callers that send METRICS_TOKEN
+// as a bearer token. The route only exists when the token is set.
+// GET /metrics
+func (s *Server) handleMetrics(c echo.Context) error {
+	bearer, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(s.cfg.MetricsToken)) != 1 {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid metrics token"})
+	}
+	s.metrics.handler.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,830 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+)
+
+// handleOpenAPI serves the admin API's OpenAPI document. TestOpenAPIMatchesRoutes
+// keeps it in step with the routes.
+func (s *Server) handleOpenAPI(c echo.Context) error {
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(openAPIDoc), &doc); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "invalid OpenAPI document"})
+	}
+	doc["info"].(map[string]any)["version"] = config.Version
+	doc["servers"] = []map[string]string{{"url": s.cfg.PublicURL + "/admin/api"}}
+	if scheme, ok := doc["components"].(map[string]any)["securitySchemes"].(map[string]any)["session"].(map[string]any); ok {
+		scheme["name"] = s.sess.CookieName()
+	}
+	return c.JSON(http.StatusOK, doc)
+}
+
+// openAPIPath converts Echo's :param segments to OpenAPI's {param}.
+func openAPIPath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, ":") {
+			segs[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// openAPIDoc describes /admin/api. All endpoints require an owner or admin
+// session cookie; errors are {"error": "..."} with the listed status codes.
+const openAPIDoc = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "noknok admin API",
+    "description": "Manage users, identities, services, and grants. Requires a noknok session cookie for an owner or admin.",
+    "version": ""
+  },
+  "components": {
+    "securitySchemes": {
+      "session": {"type": "apiKey", "in": "cookie", "name": "noknok_session"}
+    },
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}},
+      "after": {"name": "after", "in": "query", "description": "Cursor from a previous page's next_cursor", "schema": {"type": "string"}},
+      "limit": {"name": "limit", "in": "query", "description": "Page size (default 50, max 200)", "schema": {"type": "integer"}}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Status": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "properties": {"status": {"type": "string"}}}}}},
+      "NoContent": {"description": "Done"},
+      "UsernameTaken": {"description": "Username (or, on create, identity) already taken", "content": {"application/json": {"schema": {"type": "object", "properties": {
+        "error": {"type": "string"},
+        "suggestion": {"type": "string", "description": "A free numbered alternative, when a username was taken and one exists"}
+      }}}}}
+    },
+    "schemas": {
+      "Error": {"type": "object", "properties": {"error": {"type": "string"}}},
+      "User": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "did": {"type": "string"},
+        "handle": {"type": "string"},
+        "username": {"type": "string"},
+        "role": {"type": "string", "enum": ["owner", "admin", "user"]},
+        "status": {"type": "string", "enum": ["active", "pending", "denied"]},
+        "created_at": {"type": "string", "format": "date-time"},
+        "updated_at": {"type": "string", "format": "date-time"}
+      }},
+      "Identity": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "user_id": {"type": "integer", "format": "int64"},
+        "did": {"type": "string"},
+        "handle": {"type": "string"},
+        "is_primary": {"type": "boolean"},
+        "created_at": {"type": "string", "format": "date-time"}
+      }},
+      "Service": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "slug": {"type": "string"},
+        "name": {"type": "string"},
+        "description": {"type": "string"},
+        "url": {"type": "string"},
+        "icon_url": {"type": "string"},
+        "admin_role": {"type": "string"},
+        "enabled": {"type": "boolean"},
+        "public": {"type": "boolean"},
+        "grant_ttl_days": {"type": "integer", "description": "Default grant lifetime; 0 = no expiry"},
+        "domain": {"type": "string", "description": "Cookie domain the service is listed on; empty = all"},
+        "require_reauth_max_age": {"type": "integer", "description": "Seconds since last sign-in before forwardAuth requires a new one; 0 = off"},
+        "deny_message": {"type": "string", "description": "Shown to signed-in users without a grant; empty = redirect to portal"},
+        "skip_health_check": {"type": "boolean", "description": "Never probed; always counts as up"},
+        "health_head_only": {"type": "boolean", "description": "Judge the HEAD probe alone; by default a HEAD refused with 405 or 501 is retried as GET"},
+        "health_path": {"type": "string", "description": "Appended to url for health probes, e.g. /healthz; it must answer 2xx or 3xx. Empty = probe url (below 404 is up)"},
+        "health_method": {"type": "string", "enum": ["HEAD", "GET"], "description": "Health probe method; HEAD falls back to GET unless health_head_only"},
+        "health_expect_status": {"type": "integer", "description": "The only probe status that counts as up; 0 = the default rule"},
+        "skip_tls_verify": {"type": "boolean", "description": "Probe without verifying the TLS certificate"},
+        "health_type": {"type": "string", "enum": ["http", "tcp"], "description": "tcp only opens a connection to the url's host and port; the HTTP probe settings are ignored"},
+        "issue_token": {"type": "boolean", "description": "ForwardAuth adds a signed identity JWT in X-User-Token"},
+        "health_override": {"type": "string", "enum": ["auto", "up", "down"], "description": "Pinned health status; auto = probed. Set via /services/{id}/health-override"},
+        "category": {"type": "string", "description": "Portal heading the card is grouped under; empty = Other"},
+        "sort_order": {"type": "integer", "description": "Lower lists first; ties by name. Set via /services/{id}/sort-order or /services/{id}/move"},
+        "has_icon": {"type": "boolean", "description": "An uploaded icon is stored; set via /services/{id}/icon"},
+        "created_at": {"type": "string", "format": "date-time"}
+      }},
+      "HealthSample": {"type": "object", "properties": {
+        "alive": {"type": "boolean"},
+        "latency_ms": {"type": "integer", "description": "Round trip of the probe, including a GET fallback; 0 when not probed"},
+        "checked_at": {"type": "string", "format": "date-time", "description": "Absent for services not probed (skip_health_check or a health override)"}
+      }},
+      "ServiceInput": {"type": "object", "description": "slug, name, and url are required on create. On update, fields left out keep their current values.", "properties": {
+        "slug": {"type": "string", "description": "Required on create; ignored on update"},
+        "name": {"type": "string"},
+        "description": {"type": "string"},
+        "url": {"type": "string"},
+        "icon_url": {"type": "string"},
+        "admin_role": {"type": "string", "default": "admin"},
+        "grant_ttl_days": {"type": "integer", "minimum": 0},
+        "domain": {"type": "string", "description": "Empty or one of COOKIE_DOMAINS"},
+        "require_reauth_max_age": {"type": "integer", "minimum": 0, "description": "Seconds; 0 = off"},
+        "deny_message": {"type": "string", "maxLength": 500},
+        "skip_health_check": {"type": "boolean", "default": false},
+        "health_head_only": {"type": "boolean", "default": false},
+        "health_path": {"type": "string", "maxLength": 200, "description": "Empty or a path starting with /"},
+        "health_method": {"type": "string", "enum": ["HEAD", "GET"], "default": "HEAD"},
+        "health_expect_status": {"type": "integer", "default": 0, "description": "0 or an HTTP status (100-599)"},
+        "skip_tls_verify": {"type": "boolean", "default": false},
+        "health_type": {"type": "string", "enum": ["http", "tcp"], "default": "http", "description": "tcp needs a url with a port or a scheme with a well-known port (ssh://)"},
+        "issue_token": {"type": "boolean", "default": false},
+        "category": {"type": "string", "maxLength": 50, "description": "Portal grouping; empty = Other"}
+      }},
+      "Grant": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "user_id": {"type": "integer", "format": "int64"},
+        "service_id": {"type": "integer", "format": "int64"},
+        "role": {"type": "string"},
+        "granted_by": {"type": "integer", "format": "int64", "nullable": true},
+        "expires_at": {"type": "string", "format": "date-time", "nullable": true},
+        "note": {"type": "string", "description": "Why access was given"},
+        "created_at": {"type": "string", "format": "date-time"},
+        "user_handle": {"type": "string"},
+        "service_name": {"type": "string"}
+      }},
+      "AccessRequest": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "user_id": {"type": "integer", "format": "int64"},
+        "service_id": {"type": "integer", "format": "int64"},
+        "note": {"type": "string", "description": "The user's reason"},
+        "status": {"type": "string", "enum": ["pending", "approved", "denied"]},
+        "decided_by": {"type": "integer", "format": "int64", "nullable": true},
+        "decided_at": {"type": "string", "format": "date-time", "nullable": true},
+        "created_at": {"type": "string", "format": "date-time"},
+        "user_handle": {"type": "string"},
+        "service_name": {"type": "string"}
+      }},
+      "AdminScope": {"type": "object", "properties": {
+        "scoped": {"type": "boolean", "description": "false = manages every service"},
+        "service_ids": {"type": "array", "items": {"type": "integer", "format": "int64"}, "description": "Services a scoped admin manages; ignored when scoped is false"}
+      }},
+      "AccessTemplate": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "name": {"type": "string", "maxLength": 100},
+        "description": {"type": "string", "maxLength": 500},
+        "services": {"type": "array", "items": {"$ref": "#/components/schemas/TemplateService"}},
+        "created_at": {"type": "string", "format": "date-time"},
+        "updated_at": {"type": "string", "format": "date-time"}
+      }},
+      "TemplateService": {"type": "object", "required": ["service_id"], "properties": {
+        "service_id": {"type": "integer", "format": "int64"},
+        "service_name": {"type": "string", "readOnly": true},
+        "role": {"type": "string", "default": "user"}
+      }},
+      "AccessTemplateInput": {"type": "object", "required": ["name", "services"], "properties": {
+        "name": {"type": "string", "maxLength": 100},
+        "description": {"type": "string", "maxLength": 500},
+        "services": {"type": "array", "minItems": 1, "items": {"$ref": "#/components/schemas/TemplateService"}}
+      }},
+      "ServiceUsage": {"type": "object", "properties": {
+        "service_id": {"type": "integer", "format": "int64"},
+        "user_id": {"type": "integer", "format": "int64", "description": "Only with USAGE_PER_USER"},
+        "day": {"type": "string", "format": "date"},
+        "clicks": {"type": "integer"}
+      }},
+      "AccessEvent": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "service_id": {"type": "integer", "format": "int64"},
+        "did": {"type": "string", "description": "Empty when there was no session"},
+        "handle": {"type": "string"},
+        "decision": {"type": "string", "enum": ["allow", "deny", "redirect-login", "redirect-portal"]},
+        "reason": {"type": "string"},
+        "created_at": {"type": "string", "format": "date-time"}
+      }},
+      "AuditEntry": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "actor_did": {"type": "string"},
+        "actor_handle": {"type": "string"},
+        "action": {"type": "string"},
+        "target_type": {"type": "string"},
+        "target_id": {"type": "string"},
+        "detail": {"type": "object"},
+        "created_at": {"type": "string", "format": "date-time"}
+      }},
+      "LoginEvent": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "did": {"type": "string", "description": "Empty if sign-in failed before the DID was known"},
+        "handle": {"type": "string"},
+        "result": {"type": "string", "enum": ["success", "denied", "error"]},
+        "reason": {"type": "string"},
+        "ip": {"type": "string"},
+        "created_at": {"type": "string", "format": "date-time"}
+      }},
+      "SessionInfo": {"type": "object", "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "user_id": {"type": "integer", "format": "int64"},
+        "did": {"type": "string"},
+        "handle": {"type": "string"},
+        "group_id": {"type": "string"},
+        "created_at": {"type": "string", "format": "date-time"},
+        "last_seen": {"type": "string", "format": "date-time"},
+        "expires_at": {"type": "string", "format": "date-time"},
+        "ip": {"type": "string", "description": "Client address at sign-in"},
+        "user_agent": {"type": "string", "description": "Browser at sign-in"}
+      }},
+      "BlockedDID": {"type": "object", "properties": {
+        "did": {"type": "string"},
+        "reason": {"type": "string"},
+        "blocked_by": {"type": "integer", "format": "int64", "nullable": true},
+        "created_at": {"type": "string", "format": "date-time"}
+      }}
+    }
+  },
+  "security": [{"session": []}],
+  "paths": {
+    "/dashboard": {
+      "get": {"summary": "Admin overview: totals, cached health summary, recent audit entries", "tags": ["dashboard"], "responses": {
+        "200": {"description": "Overview", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "users": {"type": "object", "properties": {
+            "total": {"type": "integer"},
+            "by_role": {"type": "object", "additionalProperties": {"type": "integer"}}
+          }},
+          "services": {"type": "object", "properties": {
+            "total": {"type": "integer"}, "enabled": {"type": "integer"}, "public": {"type": "integer"}
+          }},
+          "grants": {"type": "object", "properties": {
+            "active": {"type": "integer", "description": "Unexpired grants"},
+            "users_with_access": {"type": "integer", "description": "Users with at least one active grant"}
+          }},
+          "health": {"type": "object", "description": "From the poller's cache; no checks are run", "properties": {
+            "up": {"type": "integer"}, "down": {"type": "integer"},
+            "unknown": {"type": "integer", "description": "Not checked yet"},
+            "skipped": {"type": "integer", "description": "skip_health_check services"},
+            "checked_at": {"type": "string", "format": "date-time", "nullable": true}
+          }},
+          "host_cache": {"type": "object", "description": "forwardAuth host lookups since startup (SERVICE_HOST_CACHE_TTL)", "properties": {
+            "enabled": {"type": "boolean"},
+            "hits": {"type": "integer", "description": "Answered from memory"},
+            "misses": {"type": "integer", "description": "Went to the database"}
+          }},
+          "recent_audit": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEntry"}, "description": "Newest 10 entries"}
+        }}}}}
+      }}
+    },
+    "/users": {
+      "get": {"summary": "List active users", "tags": ["users"], "responses": {
+        "200": {"description": "Users", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}}
+      }},
+      "post": {"summary": "Create a user from a handle", "tags": ["users"],
+        "description": "Admins may only create role user.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["handle"], "properties": {
+          "handle": {"type": "string"}, "role": {"type": "string", "default": "user"}, "username": {"type": "string"}
+        }}}}},
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/UsernameTaken"}
+        }}
+    },
+    "/users/bulk": {
+      "post": {"summary": "Create users from a list of handles", "tags": ["users"],
+        "description": "Handles are resolved BULK_RESOLVE_CONCURRENCY at a time, each limited to BULK_RESOLVE_TIMEOUT. Handles that fail are reported and the rest are created. Admins may only create role user.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["users"], "properties": {
+          "role": {"type": "string", "default": "user"},
+          "users": {"type": "array", "maxItems": 500, "items": {"type": "object", "required": ["handle"], "properties": {
+            "handle": {"type": "string"}, "username": {"type": "string"}
+          }}}
+        }}}}},
+        "responses": {
+          "200": {"description": "Per-handle outcome, in request order", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "created": {"type": "integer"},
+            "failed": {"type": "integer"},
+            "results": {"type": "array", "items": {"type": "object", "properties": {
+              "handle": {"type": "string", "description": "Canonical handle once created"},
+              "did": {"type": "string"},
+              "user_id": {"type": "integer", "format": "int64", "description": "Set when the user was created"},
+              "error": {"type": "string", "description": "Set when the user wasn't created"},
+              "elapsed_ms": {"type": "integer", "description": "Time spent resolving the handle"}
+            }}}
+          }}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/users/{id}": {
+      "delete": {"summary": "Delete a user (owners and unscoped admins; admins only user-role users)", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "204": {"$ref": "#/components/responses/NoContent"},
+        "403": {"description": "Caller is a scoped admin, the target is the seed owner or the caller, or an admin targets an admin or owner", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/users/{id}/role": {
+      "put": {"summary": "Change a user's role", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["role"], "properties": {
+          "role": {"type": "string", "enum": ["owner", "admin", "user"]}
+        }}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Status"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/users/{id}/username": {
+      "put": {"summary": "Change a user's username", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "username": {"type": "string", "description": "Alphanumeric, hyphens, underscores, 1-39 chars; empty clears"}
+        }}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Status"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/UsernameTaken"}
+        }}
+    },
+    "/users/{id}/login-link": {
+      "get": {"summary": "Login URL to send a pre-created user", "tags": ["users"], "parameters": [
+        {"$ref": "#/components/parameters/id"},
+        {"name": "redirect", "in": "query", "schema": {"type": "string"}}
+      ], "responses": {
+        "200": {"description": "Link", "content": {"application/json": {"schema": {"type": "object", "properties": {"url": {"type": "string"}, "handle": {"type": "string"}}}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/users/{id}/debug": {
+      "get": {"summary": "Sessions, grants, and effective roles for support", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "Debug snapshot", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "user": {"$ref": "#/components/schemas/User"},
+          "identities": {"type": "array", "items": {"$ref": "#/components/schemas/Identity"}},
+          "sessions": {"type": "array", "items": {"$ref": "#/components/schemas/SessionInfo"}},
+          "grants": {"type": "array", "items": {"$ref": "#/components/schemas/Grant"}, "description": "Expired grants included"},
+          "roles": {"type": "array", "items": {"type": "object", "properties": {
+            "service_id": {"type": "integer", "format": "int64"},
+            "slug": {"type": "string"},
+            "name": {"type": "string"},
+            "enabled": {"type": "boolean"},
+            "role": {"type": "string", "description": "Role forwardAuth resolves for the primary identity; empty = no access"}
+          }}}
+        }}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/users/{id}/admin-scope": {
+      "get": {"summary": "Services an admin is limited to (owner only)", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "Scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdminScope"}}}},
+        "403": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"}
+      }},
+      "put": {"summary": "Limit an admin to a set of services, or lift the limit (owner only)", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "description": "A scoped admin can only edit, toggle, and delete services in scope and grant or revoke access to them; creating services and reassigning grants are refused.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdminScope"}}}},
+        "responses": {
+          "200": {"description": "New scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdminScope"}}}},
+          "400": {"description": "User isn't an admin, or a service doesn't exist", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/users/pending": {
+      "get": {"summary": "List self-registered users who are pending or denied", "tags": ["users"], "description": "Pending first, then denied; oldest first.", "responses": {
+        "200": {"description": "Users", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}}
+      }}
+    },
+    "/users/{id}/approve": {
+      "post": {"summary": "Approve a pending or denied user", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "Approved", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "403": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"},
+        "409": {"description": "User is already active", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/users/{id}/deny": {
+      "post": {"summary": "Deny a pending user", "tags": ["users"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "Denied", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "403": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"},
+        "409": {"description": "User is already active or denied", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/users/resync-handles": {
+      "post": {"summary": "Re-resolve every identity's handle from its DID (owner only)", "tags": ["users"], "description": "Updates changed handles on identities and live sessions; lookups run with bounded concurrency.", "responses": {
+        "200": {"description": "Summary", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "checked": {"type": "integer"},
+          "changed": {"type": "array", "items": {"type": "object", "properties": {"did": {"type": "string"}, "old_handle": {"type": "string"}, "new_handle": {"type": "string"}}}},
+          "failed": {"type": "array", "items": {"type": "object", "properties": {"did": {"type": "string"}, "handle": {"type": "string"}, "error": {"type": "string"}}}}
+        }}}}},
+        "403": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/users/{id}/reassign-grants": {
+      "post": {"summary": "Move all of a user's grants to another user", "tags": ["users", "grants"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["target_user_id"], "properties": {
+          "target_user_id": {"type": "integer", "format": "int64"}
+        }}}}},
+        "responses": {
+          "200": {"description": "Moved", "content": {"application/json": {"schema": {"type": "object", "properties": {"moved": {"type": "integer"}}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/users/{id}/apply-template": {
+      "post": {"summary": "Grant a user every service in an access template", "tags": ["users", "templates"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["template_id"], "properties": {
+          "template_id": {"type": "integer", "format": "int64"}
+        }}}}},
+        "responses": {
+          "200": {"description": "Applied; grants only add access: an existing grant ranking below the template's role is raised to it and keeps its note and expiry, one with a higher or equal role or an expiry is unchanged, and an expired one is replaced", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "created": {"type": "integer"},
+            "updated": {"type": "integer"},
+            "unchanged": {"type": "integer"}
+          }}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the template includes a service outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/users/{id}/identities": {
+      "get": {"summary": "List a user's identities", "tags": ["identities"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "Identities", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Identity"}}}}}
+      }},
+      "post": {"summary": "Link an identity by handle (owners and unscoped admins, on users who don't outrank them)", "tags": ["identities"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["handle"], "properties": {"handle": {"type": "string"}}}}}},
+        "responses": {
+          "201": {"description": "Linked", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Identity"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin or the user outranks them", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/users/{id}/identities/{identityId}": {
+      "delete": {"summary": "Unlink a non-primary identity (owners and unscoped admins, on users who don't outrank them)", "tags": ["identities"], "parameters": [
+        {"$ref": "#/components/parameters/id"},
+        {"name": "identityId", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}
+      ], "responses": {
+        "204": {"$ref": "#/components/responses/NoContent"},
+        "403": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/services": {
+      "get": {"summary": "List services", "tags": ["services"], "responses": {
+        "200": {"description": "Services", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Service"}}}}}
+      }},
+      "post": {"summary": "Create a service", "tags": ["services"],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceInput"}}}},
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "409": {"description": "Slug taken, or (with UNIQUE_SERVICE_HOSTS) another service uses the URL's host", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }}
+    },
+    "/services/{id}": {
+      "put": {"summary": "Update a service's editable fields", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "description": "Only the fields sent change; the others keep their values.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceInput"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Status"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"description": "With UNIQUE_SERVICE_HOSTS, another service uses the URL's host", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }},
+      "delete": {"summary": "Delete a service and its grants", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "204": {"$ref": "#/components/responses/NoContent"},
+        "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/services/{id}/enabled": {
+      "put": {"summary": "Toggle enabled", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "New state", "content": {"application/json": {"schema": {"type": "object", "properties": {"enabled": {"type": "boolean"}}}}}},
+        "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/services/{id}/public": {
+      "put": {"summary": "Toggle public", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "New state", "content": {"application/json": {"schema": {"type": "object", "properties": {"public": {"type": "boolean"}}}}}},
+        "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/services/{id}/health-override": {
+      "put": {"summary": "Pin a service's health status for maintenance, or resume probing with auto", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["override"], "properties": {
+          "override": {"type": "string", "enum": ["up", "down", "auto"]}
+        }}}}},
+        "responses": {
+          "200": {"description": "Updated service; the health cache is updated immediately", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/services/{id}/sort-order": {
+      "put": {"summary": "Set a service's sort order", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["sort_order"], "properties": {
+          "sort_order": {"type": "integer", "minimum": -1000000, "maximum": 1000000, "description": "Lower lists first; ties by name"}
+        }}}}},
+        "responses": {
+          "200": {"description": "Updated service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/services/{id}/move": {
+      "post": {"summary": "Move a service one place up or down the list", "description": "Swaps it with its neighbour and renumbers every service's sort_order 1..n. Moving past either end changes nothing.", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["direction"], "properties": {
+          "direction": {"type": "string", "enum": ["up", "down"]}
+        }}}}},
+        "responses": {
+          "200": {"description": "All services in their new order", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Service"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/services/{id}/icon": {
+      "post": {"summary": "Upload a service icon", "description": "The body is the image itself (not a form). It replaces the fetched icon_url or favicon at /icon/{id}; the stored type is sniffed from the bytes.", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"image/*": {"schema": {"type": "string", "format": "binary", "maxLength": 262144, "description": "PNG, JPEG, GIF, WebP, or ICO; SVG is refused"}}}},
+        "responses": {
+          "200": {"description": "Updated service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "400": {"description": "Not a raster image", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"description": "Larger than 256 KB", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "415": {"description": "Content-Type isn't an image type", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }},
+      "delete": {"summary": "Remove a service's uploaded icon, falling back to its favicon", "tags": ["services"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "Updated service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/services/health": {
+      "get": {"summary": "Check every service now", "tags": ["services"], "parameters": [
+        {"name": "detail", "in": "query", "schema": {"type": "string", "enum": ["1"]}, "description": "Return a HealthSample per service instead of a boolean"}
+      ], "responses": {
+        "200": {"description": "Service ID to alive (or to HealthSample with detail=1)", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"oneOf": [
+          {"type": "boolean"},
+          {"$ref": "#/components/schemas/HealthSample"}
+        ]}}}}}
+      }}
+    },
+    "/services/health/export": {
+      "get": {"summary": "Cached health snapshot for external monitoring", "tags": ["services"], "parameters": [
+        {"name": "format", "in": "query", "schema": {"type": "string", "enum": ["json", "prometheus"], "default": "json"}}
+      ], "responses": {
+        "200": {"description": "Per-service status, last check time, and consecutive failures", "content": {
+          "application/json": {"schema": {"type": "object", "properties": {"services": {"type": "array", "items": {"type": "object", "properties": {
+            "id": {"type": "integer", "format": "int64"},
+            "slug": {"type": "string"},
+            "name": {"type": "string"},
+            "url": {"type": "string"},
+            "enabled": {"type": "boolean"},
+            "status": {"type": "string", "enum": ["up", "down", "unknown", "skipped"], "description": "A health override pins up or down"},
+            "health_override": {"type": "string", "enum": ["auto", "up", "down"]},
+            "last_checked": {"type": "string", "format": "date-time", "nullable": true},
+            "latency_ms": {"type": "integer", "nullable": true, "description": "Round trip of the last probe"},
+            "consecutive_failures": {"type": "integer"}
+          }}}}}},
+          "text/plain": {"schema": {"type": "string"}}
+        }}
+      }}
+    },
+    "/services/usage": {
+      "get": {"summary": "Click counts per service and day", "tags": ["services"], "parameters": [
+        {"name": "days", "in": "query", "schema": {"type": "integer", "default": 30, "maximum": 365}}
+      ], "responses": {
+        "200": {"description": "Usage", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ServiceUsage"}}}}}
+      }}
+    },
+    "/services/uptime": {
+      "get": {"summary": "Share of health checks in the last 24 hours that passed, per service", "tags": ["services"], "responses": {
+        "200": {"description": "Service ID to percentage; services without samples are absent", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "number"}}}}}
+      }}
+    },
+    "/services/{id}/history": {
+      "get": {"summary": "Recent health transitions of a service, newest first", "tags": ["services"], "description": "Read from the in-memory history (HEALTH_HISTORY_SIZE poller samples per service, reloaded from health_history at startup when HEALTH_HISTORY_RETENTION is set).", "parameters": [
+        {"$ref": "#/components/parameters/id"}
+      ], "responses": {
+        "200": {"description": "History", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "enabled": {"type": "boolean", "description": "false when HEALTH_HISTORY_SIZE is 0"},
+          "samples": {"type": "integer", "description": "Samples in the history"},
+          "since": {"type": "string", "format": "date-time", "nullable": true, "description": "Time of the oldest sample"},
+          "uptime_24h": {"type": "number", "nullable": true, "description": "Percentage of samples in the last 24 hours that were up"},
+          "transitions": {"type": "array", "description": "The oldest entry is the state the history starts in", "items": {"type": "object", "properties": {
+            "alive": {"type": "boolean"},
+            "at": {"type": "string", "format": "date-time"}
+          }}}
+        }}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/services/{id}/access-log": {
+      "get": {"summary": "Recent forwardAuth decisions for a service, newest first", "tags": ["services"], "description": "Recorded only when ACCESS_LOG_RETENTION is set.", "parameters": [
+        {"$ref": "#/components/parameters/id"}, {"$ref": "#/components/parameters/after"}, {"$ref": "#/components/parameters/limit"},
+        {"name": "decision", "in": "query", "schema": {"type": "string", "enum": ["allow", "deny", "redirect-login", "redirect-portal"]}}
+      ], "responses": {
+        "200": {"description": "Page", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "enabled": {"type": "boolean", "description": "Whether decisions are currently being recorded"},
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/AccessEvent"}},
+          "next_cursor": {"type": "string", "description": "Empty on the last page"}
+        }}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+        "404": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/grants": {
+      "get": {"summary": "List unexpired grants", "tags": ["grants"], "responses": {
+        "200": {"description": "Grants", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Grant"}}}}}
+      }},
+      "post": {"summary": "Create or update a grant", "tags": ["grants"],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["user_id", "service_id"], "properties": {
+          "user_id": {"type": "integer", "format": "int64"},
+          "service_id": {"type": "integer", "format": "int64"},
+          "role": {"type": "string", "default": "user"},
+          "expires_at": {"type": "string", "format": "date-time", "description": "Omitted (with expires_in and no_expiry): an existing grant keeps its expiry, a new or expired one gets the service's grant_ttl_days"},
+          "expires_in": {"type": "string", "example": "168h", "description": "Go duration from now; instead of expires_at"},
+          "no_expiry": {"type": "boolean", "default": false, "description": "Make the grant permanent, overriding grant_ttl_days; not with expires_at or expires_in"},
+          "note": {"type": "string", "maxLength": 500, "description": "Justification; omit to keep the existing note"}
+        }}}}},
+        "responses": {
+          "201": {"description": "Granted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Grant"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }}
+    },
+    "/grants/counts": {
+      "get": {"summary": "Active grant counts per user and per service", "tags": ["grants"], "responses": {
+        "200": {"description": "Counts keyed by ID; IDs without active grants are omitted", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "users": {"type": "object", "additionalProperties": {"type": "integer"}, "description": "Active grants per user ID"},
+          "services": {"type": "object", "additionalProperties": {"type": "integer"}, "description": "Users with active access per service ID"}
+        }}}}}
+      }}
+    },
+    "/grants/cleanup": {
+      "post": {"summary": "Delete grants that can never be used again (owner only)", "tags": ["grants"],
+        "description": "Removes expired grants, grants of users whose sign-up was declined, and grants of users with no identity. A grant in several categories counts in the first of those. Users and services cascade to grants, so none reference missing rows.",
+        "parameters": [{"name": "dry_run", "in": "query", "schema": {"type": "boolean"}, "description": "Only count what would be deleted"}],
+        "responses": {
+          "200": {"description": "Grants deleted (or, on a dry run, that would be)", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "dry_run": {"type": "boolean"},
+            "deleted": {"type": "object", "properties": {
+              "expired": {"type": "integer"},
+              "denied_user": {"type": "integer"},
+              "no_identity": {"type": "integer"}
+            }},
+            "total": {"type": "integer"}
+          }}}}},
+          "403": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/grants/{id}": {
+      "delete": {"summary": "Revoke a grant", "tags": ["grants"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "204": {"$ref": "#/components/responses/NoContent"},
+        "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+        "404": {"description": "Caller is a scoped admin and the grant doesn't exist", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/requests": {
+      "get": {"summary": "List access requests", "tags": ["grants"], "description": "Users ask for public services from the portal (POST /request-access). Scoped admins only see requests for services in their scope.",
+        "parameters": [{"name": "status", "in": "query", "schema": {"type": "string", "enum": ["pending", "approved", "denied", "all"], "default": "pending"}}],
+        "responses": {
+          "200": {"description": "Requests, oldest first", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AccessRequest"}}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/requests/{id}/approve": {
+      "post": {"summary": "Approve an access request", "tags": ["grants"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "description": "Grants the service and marks the request approved in one transaction, with the service's grant_ttl_days and the user's reason as a new grant's note. Only adds access, as applying a template does: an existing permanent grant with an equal or higher role, or an unexpired one, is kept as is.",
+        "requestBody": {"content": {"application/json": {"schema": {"type": "object", "properties": {
+          "role": {"type": "string", "default": "user"}
+        }}}}},
+        "responses": {
+          "200": {"description": "Approved", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessRequest"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"description": "Request was already decided", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }}
+    },
+    "/requests/{id}/deny": {
+      "post": {"summary": "Deny an access request", "tags": ["grants"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "200": {"description": "Denied; the user can ask again", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessRequest"}}}},
+        "400": {"$ref": "#/components/responses/Error"},
+        "403": {"description": "Caller is a scoped admin and the service is outside their scope", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+        "404": {"$ref": "#/components/responses/Error"},
+        "409": {"description": "Request was already decided", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+      }}
+    },
+    "/webhooks/test": {
+      "post": {"summary": "Send ACCESS_REQUEST_WEBHOOK a sample access request with event \"test\" (owner only)", "tags": ["grants"],
+        "description": "Signed like real notices when ACCESS_REQUEST_WEBHOOK_SECRET is set: X-Noknok-Signature is sha256= and the hex HMAC-SHA256 of the body.",
+        "responses": {
+        "200": {"description": "The webhook answered", "content": {"application/json": {"schema": {"type": "object", "properties": {"status": {"type": "integer", "description": "HTTP status the webhook returned"}, "latency_ms": {"type": "integer"}}}}}},
+        "400": {"description": "ACCESS_REQUEST_WEBHOOK is not set", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+        "403": {"$ref": "#/components/responses/Error"},
+        "502": {"description": "The webhook couldn't be reached", "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}, "latency_ms": {"type": "integer"}}}}}}
+      }}
+    },
+    "/access-templates": {
+      "get": {"summary": "List access templates", "tags": ["templates"], "responses": {
+        "200": {"description": "Templates with their services", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AccessTemplate"}}}}}
+      }},
+      "post": {"summary": "Create an access template (owner only)", "tags": ["templates"],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessTemplateInput"}}}},
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessTemplate"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/access-templates/{id}": {
+      "put": {"summary": "Replace an access template's name, description, and services (owner only)", "tags": ["templates"], "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessTemplateInput"}}}},
+        "responses": {
+          "200": {"description": "Updated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessTemplate"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }},
+      "delete": {"summary": "Delete an access template (owner only); grants applied from it stay", "tags": ["templates"], "parameters": [{"$ref": "#/components/parameters/id"}], "responses": {
+        "204": {"$ref": "#/components/responses/NoContent"},
+        "403": {"$ref": "#/components/responses/Error"},
+        "404": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/audit": {
+      "get": {"summary": "Audit log, newest first", "tags": ["audit"], "parameters": [
+        {"$ref": "#/components/parameters/after"}, {"$ref": "#/components/parameters/limit"},
+        {"name": "action", "in": "query", "description": "Only entries with this action, e.g. login.failed", "schema": {"type": "string"}}
+      ], "responses": {
+        "200": {"description": "Page", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEntry"}},
+          "next_cursor": {"type": "string", "description": "Empty on the last page"}
+        }}}}},
+        "400": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/login-events": {
+      "get": {"summary": "Sign-in attempts, newest first", "tags": ["audit"], "parameters": [
+        {"$ref": "#/components/parameters/after"}, {"$ref": "#/components/parameters/limit"},
+        {"name": "result", "in": "query", "schema": {"type": "string", "enum": ["success", "denied", "error"]}},
+        {"name": "ip", "in": "query", "description": "Only attempts from this client IP", "schema": {"type": "string"}},
+        {"name": "did", "in": "query", "description": "Only attempts by this DID", "schema": {"type": "string"}}
+      ], "responses": {
+        "200": {"description": "Page", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "enabled": {"type": "boolean", "description": "false when LOGIN_EVENT_RETENTION is 0 (nothing is recorded)"},
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/LoginEvent"}},
+          "next_cursor": {"type": "string", "description": "Empty on the last page"}
+        }}}}},
+        "400": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/sessions": {
+      "get": {"summary": "Active sessions, newest first", "tags": ["sessions"], "parameters": [
+        {"$ref": "#/components/parameters/after"}, {"$ref": "#/components/parameters/limit"}
+      ], "responses": {
+        "200": {"description": "Page", "content": {"application/json": {"schema": {"type": "object", "properties": {
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/SessionInfo"}},
+          "next_cursor": {"type": "string", "description": "Empty on the last page"}
+        }}}}},
+        "400": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/blocked-dids": {
+      "get": {"summary": "List blocked DIDs (owner only)", "tags": ["blocked-dids"], "responses": {
+        "200": {"description": "Blocked DIDs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/BlockedDID"}}}}},
+        "403": {"$ref": "#/components/responses/Error"}
+      }},
+      "post": {"summary": "Block a DID and end its sessions (owner only)", "tags": ["blocked-dids"],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "required": ["did"], "properties": {
+          "did": {"type": "string"}, "reason": {"type": "string"}
+        }}}}},
+        "responses": {
+          "201": {"description": "Blocked", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockedDID"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }}
+    },
+    "/blocked-dids/{did}": {
+      "delete": {"summary": "Unblock a DID (owner only)", "tags": ["blocked-dids"], "parameters": [
+        {"name": "did", "in": "path", "required": true, "schema": {"type": "string"}}
+      ], "responses": {
+        "204": {"$ref": "#/components/responses/NoContent"},
+        "403": {"$ref": "#/components/responses/Error"}
+      }}
+    },
+    "/openapi.json": {
+      "get": {"summary": "This document", "tags": ["meta"], "responses": {"200": {"description": "OpenAPI document"}}}
+    }
+  }
+}`
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/atproto"
+	"github.com/primal-host/noknok/internal/config"
+)
+
+// Every admin API route must be documented in openAPIDoc, and every
+// documented operation must be routed.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPIDoc), &doc); err != nil {
+		t.Fatalf("openAPIDoc is not valid JSON: %v", err)
+	}
+	documented := make(map[string]bool)
+	for p, item := range doc.Paths {
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "patch":
+				documented[strings.ToUpper(method)+" "+p] = true
+			}
+		}
+	}
+
+	routed := make(map[string]bool)
+	for _, r := range routesOnly(t).Routes() {
+		p, ok := strings.CutPrefix(r.Path, "/admin/api")
+		if !ok || r.Method == echo.RouteNotFound {
+			continue
+		}
+		routed[r.Method+" "+openAPIPath(p)] = true
+	}
+
+	var missing, stale []string
+	for op := range routed {
+		if !documented[op] {
+			missing = append(missing, op)
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			stale = append(stale, op)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	for _, op := range missing {
+		t.Errorf("routed but not in openAPIDoc: %s", op)
+	}
+	for _, op := range stale {
+		t.Errorf("in openAPIDoc but not routed: %s", op)
+	}
+}
+
+// routesOnly registers the server's routes on a bare server, without the
+// database or background work New starts.
+func routesOnly(t *testing.T) *echo.Echo {
+	t.Helper()
+	key, err := atcrypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := atproto.OAuthPaths{Metadata: "/oauth/client-metadata.json", Callback: "/oauth/callback", JWKS: "/oauth/jwks.json"}
+	oauth, err := atproto.NewOAuthClient("https://noknok.example.com", paths, key.Multibase(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{echo: echo.New(), cfg: &config.Config{}, oauth: oauth}
+	s.registerRoutes()
+	return s.echo
+}
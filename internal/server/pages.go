@@ -0,0 +1,200 @@
+package server
+
+import (
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// handleNotFound is the catch-all for unknown routes. Browsers with a valid
+// session (e.g. following a stale bookmark) are sent to the portal; other
+// browsers get a themed 404 page and API clients get JSON.
+func (s *Server) handleNotFound(c echo.Context) error {
+	if !wantsHTML(c) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if c.Request().Method == http.MethodGet && s.hasValidSession(c) {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
+	}
+	return c.HTML(http.StatusNotFound, statusPageHTML(s.brand(), "Not found",
+		"The page you were looking for doesn't exist.", "/", "Go to portal"))
+}
+
+// accessDeniedHTML is shown to blocked DIDs at sign-in and at forwardAuth.
+func (s *Server) accessDeniedHTML() string {
+	return statusPageHTML(s.brand(), "Access denied",
+		"This account has been blocked from signing in.", s.cfg.PublicURL+"/login", "Sign in with another account")
+}
+
+// unavailableHTML is shown at sign-in and at forwardAuth when the block list
+// can't be checked.
+func (s *Server) unavailableHTML() string {
+	return statusPageHTML(s.brand(), "Temporarily unavailable",
+		"Sign-in can't be checked right now. Please try again in a minute.", s.cfg.PublicURL+"/login", "Back to sign in")
+}
+
+// pendingApprovalHTML is shown at sign-in to users who signed themselves up
+// under SIGNUP_MODE=approval and haven't been approved yet.
+func (s *Server) pendingApprovalHTML() string {
+	return statusPageHTML(s.brand(), "Awaiting approval",
+		"Your account has been registered and is waiting for an admin to approve it. Sign in again once you've been approved.",
+		s.cfg.PublicURL+"/login", "Back to sign in")
+}
+
+// signupDeniedHTML is shown at sign-in to self-registered users an admin
+// turned down.
+func (s *Server) signupDeniedHTML() string {
+	return statusPageHTML(s.brand(), "Access denied",
+		"Your request for an account was declined.", s.cfg.PublicURL+"/login", "Sign in with another account")
+}
+
+// welcomeHTML is shown once, after a user's first sign-in, when
+// WELCOME_PAGE is on; its link continues to where the sign-in was headed.
+func (s *Server) welcomeHTML(handle, dest string) string {
+	msg := s.cfg.WelcomeMessage
+	if msg == "" {
+		msg = "You're signed in as @" + handle + ". The portal lists the services you have access to; " +
+			"sign in here whenever one of them asks who you are."
+	}
+	return statusPageHTML(s.brand(), "Welcome to "+s.cfg.BrandName, msg, dest, "Continue")
+}
+
+// noAccessHTML is shown at forwardAuth to signed-in users without a grant
+// for a service that has a deny message.
+func (s *Server) noAccessHTML(svc *database.Service) string {
+	return statusPageHTML(s.brand(), "No access to "+svc.Name, svc.DenyMessage, s.cfg.PublicURL+"/", "Go to portal")
+}
+
+// statusPageHTML renders a minimal page in the portal theme with a title,
+// a message, and a single link.
+func statusPageHTML(b brand, title, message, linkHref, linkText string) string {
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>` + b.title(title) + `</title>
+` + faviconLink + `
+<style>
+  *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+  body {
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+    background: #0f172a;
+    color: #e2e8f0;
+    min-height: 100vh;
+    padding: 2rem;
+  }
+  .status-card {
+    background: #1e293b;
+    border-radius: 12px;
+    padding: 1.5rem;
+    max-width: 480px;
+    margin: 4rem auto 0;
+    text-align: center;
+  }
+  h1 { font-size: 1.25rem; color: #f8fafc; margin-bottom: 0.5rem; }
+  p { font-size: 0.875rem; color: #94a3b8; margin-bottom: 1.25rem; }
+  a {
+    display: inline-block;
+    padding: 0.5rem 1rem;
+    background: #3b82f6;
+    color: #fff;
+    border-radius: 8px;
+    font-size: 0.875rem;
+    text-decoration: none;
+    transition: background 0.15s;
+  }
+  a:hover { background: #2563eb; }
+  .status-card .brand { justify-content: center; margin-bottom: 1rem; }` + b.css() + `
+</style>
+</head>
+<body>
+<div class="status-card">
+  ` + b.headerHTML() + `
+  <h1>` + html.EscapeString(title) + `</h1>
+  <p>` + html.EscapeString(message) + `</p>
+  <a href="` + html.EscapeString(linkHref) + `">` + html.EscapeString(linkText) + `</a>
+</div>
+</body>
+</html>`
+}
+
+// brand is the deployment's display name, optional logo, theme, and title
+// format, shown in page headers and browser tabs (BRAND_NAME,
+// BRAND_LOGO_URL, THEME, PAGE_TITLE_FORMAT).
+type brand struct {
+	Name        string
+	LogoURL     string
+	Theme       string
+	TitleFormat string
+}
+
+func (s *Server) brand() brand {
+	return brand{Name: s.cfg.BrandName, LogoURL: s.cfg.BrandLogoURL, Theme: s.cfg.Theme, TitleFormat: s.cfg.PageTitleFormat}
+}
+
+// css returns the shared header styles plus the theme's overrides.
+func (b brand) css() string {
+	if b.Theme == "high-contrast" {
+		return brandCSS + highContrastCSS
+	}
+	return brandCSS
+}
+
+// headerHTML renders the logo (if any) and name for page headers.
+func (b brand) headerHTML() string {
+	logo := ""
+	if b.LogoURL != "" {
+		logo = `<img src="` + html.EscapeString(b.LogoURL) + `" alt="" class="brand-logo">`
+	}
+	return `<div class="brand">` + logo + `<span>` + html.EscapeString(b.Name) + `</span></div>`
+}
+
+// title returns a page's browser tab title (PAGE_TITLE_FORMAT, by default
+// the brand name and the page), escaped for <title>.
+func (b brand) title(page string) string {
+	format := b.TitleFormat
+	if format == "" {
+		format = "{brand} — {page}"
+	}
+	return html.EscapeString(strings.NewReplacer("{brand}", b.Name, "{page}", page).Replace(format))
+}
+
+// faviconLink is the <link> every page carries; /favicon.ico serves
+// FAVICON_FILE, the brand logo, or a generated icon (see handleFavicon).
+const faviconLink = `<link rel="icon" href="/favicon.ico">`
+
+const brandCSS = `
+  .brand { display: flex; align-items: center; gap: 0.5rem; font-size: 1.125rem; font-weight: 600; color: #f8fafc; }
+  .brand-logo { width: 28px; height: 28px; border-radius: 6px; object-fit: contain; }`
+
+// highContrastCSS overrides the dark palette for THEME=high-contrast: black
+// surfaces with white text and outlines, and a visible focus ring. Rules are
+// !important because page and admin-panel styles load after this block.
+const highContrastCSS = `
+  body { background: #000 !important; color: #fff !important; }
+  .card, .svc-card, .login-card, .status-card, .admin-card, .dd-menu, .idle-box, .ov-stat, .svc-toast {
+    background: #000 !important;
+    border: 2px solid #fff !important;
+  }
+  .card:hover, .svc-card:hover, .dd-btn:hover, .dd-add:hover, .admin-tbl tr:hover td { background: #262626 !important; }
+  p, .info p, .idle-box p, .empty, .user, .dd-item, .dd-add, .tl-legend, .admin-tab, .admin-close, .close-btn,
+  .admin-tbl th, .admin-tbl td, .grant-note, .ov-label, .ov-detail, .ov-heading, .remember { color: #fff !important; }
+  .dd-danger, .dd-logout-all { color: #ff8080 !important; }
+  .admin-tab.active { color: #ffd700 !important; border-bottom-color: #ffd700 !important; }
+  input, select, textarea, .admin-input, .admin-select {
+    background: #000 !important;
+    color: #fff !important;
+    border: 2px solid #fff !important;
+  }
+  a:focus-visible, button:focus-visible, input:focus-visible, select:focus-visible {
+    outline: 3px solid #ffd700 !important;
+    outline-offset: 2px;
+  }
+  .error, .admin-msg-err { background: #000 !important; color: #ff8080 !important; border: 2px solid #ff8080; }
+  .admin-msg-ok { background: #000 !important; color: #7CFC00 !important; border: 2px solid #7CFC00; }
+  .tl-dot.tl-off { background: #000 !important; border: 1px solid #fff; }
+  .card-disabled, .svc-card.down { opacity: 0.7 !important; }`
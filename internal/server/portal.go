@@ -1,10 +1,17 @@
 package server
 
 import (
+	"cmp"
+	"encoding/json"
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/primal-host/noknok/internal/database"
@@ -13,14 +20,14 @@ import (
 
 // handlePortal renders the service catalog page (requires valid session).
 func (s *Server) handlePortal(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err != nil || cookie.Value == "" {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
 
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.validateSession(c, cookie.Value)
 	if err != nil {
-		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+		return s.portalLoginRedirect(c, cookie.Value)
 	}
 
 	ctx := c.Request().Context()
@@ -33,12 +40,7 @@ func (s *Server) handlePortal(c echo.Context) error {
 
 	isAdmin := user.Role == "owner" || user.Role == "admin"
 
-	var svcs []database.Service
-	if isAdmin {
-		svcs, err = s.db.ListServices(ctx)
-	} else {
-		svcs, err = s.db.ListServicesForUser(ctx, user.ID)
-	}
+	svcs, err := s.portalServices(c, user)
 	if err != nil {
 		slog.Error("portal: failed to load services", "error", err)
 		svcs = nil
@@ -66,10 +68,201 @@ func (s *Server) handlePortal(c echo.Context) error {
 	adminOpen = adminOpen && isAdmin
 	adminTab := c.QueryParam("tab")
 	if adminTab == "" {
-		adminTab = "users"
+		adminTab = "overview"
+	}
+
+	opts := s.portalOptions()
+	opts.GreyDisabled = !isAdmin && s.cfg.UserDisabledServices == "grey"
+	if !isAdmin {
+		opts.Requestable = s.requestableServices(c, user.ID, svcs)
+	}
+	if len(group) > 1 && len(s.cfg.CookieDomains) > 1 {
+		prefs, err := s.sess.DomainIdentities(ctx, sess.GroupID)
+		if err != nil {
+			slog.Warn("portal: failed to load domain identities", "error", err)
+		}
+		for _, d := range s.cfg.CookieDomains {
+			if d != s.cfg.CookieDomain {
+				opts.DomainIdentities = append(opts.DomainIdentities, domainIdentity{Domain: d, DID: prefs[d]})
+			}
+		}
+	}
+
+	return c.HTML(http.StatusOK, portalHTML(sess, group, svcs, healthMap, isAdmin, user.Role, adminOpen, adminTab, opts))
+}
+
+// portalServices returns the services the portal shows user on this host:
+// all of the host's services for owners and admins, the granted ones for
+// everyone else.
+func (s *Server) portalServices(c echo.Context, user *database.User) ([]database.Service, error) {
+	ctx := c.Request().Context()
+	if user.Role == "owner" || user.Role == "admin" {
+		return s.db.ListServicesByCategory(ctx, s.requestDomain(c))
 	}
+	svcs, err := s.db.ListServicesForUser(ctx, user.ID, s.requestDomain(c))
+	if err != nil {
+		return nil, err
+	}
+	return s.filterDisabledForUser(svcs), nil
+}
 
-	return c.HTML(http.StatusOK, portalHTML(sess, group, svcs, healthMap, isAdmin, user.Role, adminOpen, adminTab))
+// portalService is a service as GET /api/services lists it.
+type portalService struct {
+	ID          int64  `json:"id"`
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+	Category    string `json:"category"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// handleListServices returns the services the signed-in user's portal
+// shows, for catalogs too large to filter in the page. ?q= keeps those
+// whose name, description, or slug contains it, ignoring case.
+//
+// GET /api/services
+func (s *Server) handleListServices(c echo.Context) error {
+	cookie, err := c.Cookie(s.sess.CookieName())
+	if err != nil || cookie.Value == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	sess, err := s.validateSession(c, cookie.Value)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
+	}
+	user, err := s.db.GetUserByIdentityDID(c.Request().Context(), sess.DID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
+	}
+	svcs, err := s.portalServices(c, user)
+	if err != nil {
+		slog.Error("api services: failed to load services", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list services"})
+	}
+
+	q := strings.ToLower(strings.TrimSpace(c.QueryParam("q")))
+	result := make([]portalService, 0, len(svcs))
+	for _, svc := range svcs {
+		if q != "" && !strings.Contains(strings.ToLower(svc.Name+"\n"+svc.Description+"\n"+svc.Slug), q) {
+			continue
+		}
+		result = append(result, portalService{
+			ID:          svc.ID,
+			Slug:        svc.Slug,
+			Name:        svc.Name,
+			Description: svc.Description,
+			URL:         svc.URL,
+			Category:    svc.Category,
+			Enabled:     svc.Enabled,
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// portalLoginRedirect sends a portal visitor whose session didn't validate
+// to sign in. When it expired within SESSION_EXPIRY_GRACE, the login page is
+// asked to sign the same identity back in and return to this very page, so
+// the user only sees the OAuth round trip.
+func (s *Server) portalLoginRedirect(c echo.Context, token string) error {
+	if s.cfg.SessionExpiryGrace > 0 {
+		if sess, err := s.sess.ValidateGrace(c.Request().Context(), token); err == nil && sess.NeedsRefresh {
+			back := s.cfg.PublicURL + c.Request().URL.RequestURI()
+			return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?refresh=1&redirect="+url.QueryEscape(back))
+		}
+	}
+	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+}
+
+// filterDisabledForUser drops disabled services from a non-admin's list when
+// USER_DISABLED_SERVICES=hide. Admins always see everything.
+func (s *Server) filterDisabledForUser(svcs []database.Service) []database.Service {
+	if s.cfg.UserDisabledServices != "hide" {
+		return svcs
+	}
+	shown := svcs[:0]
+	for _, svc := range svcs {
+		if svc.Enabled {
+			shown = append(shown, svc)
+		}
+	}
+	return shown
+}
+
+// portalOptions holds deployment settings that shape the rendered portal.
+type portalOptions struct {
+	Brand  brand
+	Client clientConfig // settings the page's scripts run on
+	// GreyDisabled renders disabled services greyed out with a note
+	// instead of as red cards (non-admins, USER_DISABLED_SERVICES=grey).
+	GreyDisabled bool
+	Colors       statusColors
+	HealthAt     time.Time // last health poller run; zero before the first
+	// DomainIdentities offers a choice of identity per external cookie
+	// domain; only set for groups with more than one identity.
+	DomainIdentities []domainIdentity
+	// Requestable lists public services a non-admin can request access to.
+	Requestable []requestableService
+}
+
+// domainIdentity is the identity relayed to an external cookie domain. An
+// empty DID means the active identity.
+type domainIdentity struct {
+	Domain string
+	DID    string
+}
+
+func (s *Server) portalOptions() portalOptions {
+	opts := portalOptions{
+		Brand:  s.brand(),
+		Client: s.clientConfig(),
+		Colors: statusColors{Red: s.cfg.StatusColorRed, Yellow: s.cfg.StatusColorYellow, Green: s.cfg.StatusColorGreen},
+	}
+	_, opts.HealthAt = s.healthTimes()
+	return opts
+}
+
+// statusColors are the traffic-light dot colors (STATUS_COLOR_*), written
+// into the page as CSS variables.
+type statusColors struct {
+	Red, Yellow, Green string
+}
+
+func (sc statusColors) css() string {
+	return `
+  :root { --tl-red: ` + sc.Red + `; --tl-yellow: ` + sc.Yellow + `; --tl-green: ` + sc.Green + `; }`
+}
+
+// legendEntry explains one traffic-light dot.
+type legendEntry struct {
+	Class   string // tl-red, tl-yellow, or tl-green
+	Label   string
+	Meaning string
+}
+
+// statusLegend is what the dots mean on the portal: each card lights the
+// one dot for its current state.
+var statusLegend = []legendEntry{
+	{"tl-red", "Disabled", "Turned off by an admin"},
+	{"tl-yellow", "Unreachable", "Enabled but failing health checks"},
+	{"tl-green", "Online", "Enabled and healthy"},
+}
+
+// adminLegend is what the dots mean while the admin panel is open, where
+// each dot reports a separate property of the service.
+var adminLegend = []legendEntry{
+	{"tl-red", "Disabled", "The service is turned off"},
+	{"tl-yellow", "Public", "Reachable without signing in"},
+	{"tl-green", "Healthy / granted", "Passing health checks, or the selected user has a grant"},
+}
+
+func legendHTML(entries []legendEntry) string {
+	out := `<div class="tl-legend">`
+	for _, e := range entries {
+		out += `<span class="tl-legend-item" title="` + html.EscapeString(e.Meaning) + `"><span class="tl-dot ` + e.Class + `"></span>` +
+			html.EscapeString(e.Label) + `</span>`
+	}
+	return out + `</div>`
 }
 
 func truncate(s string, max int) string {
@@ -86,41 +279,141 @@ type identityInfo struct {
 	Active bool
 }
 
-func portalHTML(active *session.Session, group []session.Session, svcs []database.Service, healthMap map[int64]bool, isAdmin bool, role string, adminOpen bool, adminTab string) string {
-	cards := ""
-	for _, svc := range svcs {
-		initial := "?"
-		if len(svc.Name) > 0 {
-			initial = string([]rune(svc.Name)[0])
+// otherCategory heads the portal group of services without a category.
+const otherCategory = "Other"
+
+// serviceGroup is one category heading on the portal and its services.
+type serviceGroup struct {
+	Category string
+	Services []database.Service
+}
+
+// groupByCategory groups services for the portal: categories alphabetically
+// (ignoring case), uncategorized services last under "Other", and services
+// by sort order then name within each.
+func groupByCategory(svcs []database.Service) []serviceGroup {
+	sorted := slices.Clone(svcs)
+	slices.SortStableFunc(sorted, func(a, b database.Service) int {
+		if (a.Category == "") != (b.Category == "") {
+			if a.Category == "" {
+				return 1
+			}
+			return -1
 		}
-		// Determine service status: red=disabled, yellow=enabled+unhealthy, green=enabled+healthy.
-		status := "green"
-		dot1Class := "tl-off"
-		dot2Class := "tl-off"
-		dot3Class := "tl-green"
-		if !svc.Enabled {
-			status = "red"
-			dot1Class = "tl-red"
-			dot3Class = "tl-off"
-		} else if !healthMap[svc.ID] {
-			status = "yellow"
-			dot2Class = "tl-yellow"
-			dot3Class = "tl-off"
+		if c := strings.Compare(strings.ToLower(a.Category), strings.ToLower(b.Category)); c != 0 {
+			return c
 		}
-		faviconURL := strings.TrimRight(svc.URL, "/") + "/favicon.ico"
-		cards += `
-      <a href="` + svc.URL + `" target="` + svc.Slug + `" rel="noopener" class="card" data-svc-id="` + fmt.Sprintf("%d", svc.ID) + `" data-svc-status="` + status + `" onclick="return openService(this)">
-        <div class="icon"><img src="` + faviconURL + `" onerror="this.style.display='none';this.nextSibling.style.display=''" style="width:28px;height:28px;border-radius:4px"><span style="display:none">` + initial + `</span></div>
+		if a.SortOrder != b.SortOrder {
+			return cmp.Compare(a.SortOrder, b.SortOrder)
+		}
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	var groups []serviceGroup
+	for _, svc := range sorted {
+		category := svc.Category
+		if category == "" {
+			category = otherCategory
+		}
+		if n := len(groups); n > 0 && strings.EqualFold(groups[n-1].Category, category) {
+			groups[n-1].Services = append(groups[n-1].Services, svc)
+			continue
+		}
+		groups = append(groups, serviceGroup{Category: category, Services: []database.Service{svc}})
+	}
+	return groups
+}
+
+// serviceCardHTML renders one portal card. greyDisabled renders a disabled
+// service greyed out instead of red (see portalOptions).
+func serviceCardHTML(svc database.Service, healthMap map[int64]healthSample, greyDisabled bool) string {
+	// Determine service status: red=disabled, yellow=enabled+unhealthy, green=enabled+healthy.
+	status := "green"
+	dot1Class := "tl-off"
+	dot2Class := "tl-off"
+	dot3Class := "tl-green"
+	if !svc.Enabled {
+		status = "red"
+		dot1Class = "tl-red"
+		dot3Class = "tl-off"
+	} else if !healthMap[svc.ID].Alive {
+		status = "yellow"
+		dot2Class = "tl-yellow"
+		dot3Class = "tl-off"
+	}
+	cardClass := "card"
+	if greyDisabled && !svc.Enabled {
+		cardClass = "card card-disabled"
+	}
+	search := strings.ToLower(svc.Name + " " + svc.Description + " " + svc.Slug)
+	return `
+      <a href="` + html.EscapeString(svc.URL) + `" target="` + html.EscapeString(svc.Slug) + `" rel="noopener" class="` + cardClass + `" data-svc-id="` + fmt.Sprintf("%d", svc.ID) + `" data-svc-status="` + status + `" data-search="` + html.EscapeString(search) + `" onclick="return openService(this)">
+        <div class="icon">` + serviceIconHTML(svc) + `</div>
         <div class="info">
-          <h3>` + svc.Name + `</h3>
-          <p>` + truncate(svc.Description, 20) + `</p>
+          <h3>` + html.EscapeString(svc.Name) + `</h3>
+          <p>` + html.EscapeString(truncate(svc.Description, 20)) + `</p>
         </div>
         <div class="traffic-light"><div class="tl-dot tl-enabled ` + dot1Class + `"></div><div class="tl-dot tl-public ` + dot2Class + `"></div><div class="tl-dot tl-health ` + dot3Class + `"></div></div>
       </a>`
+}
+
+func portalHTML(active *session.Session, group []session.Session, svcs []database.Service, healthMap map[int64]healthSample, isAdmin bool, role string, adminOpen bool, adminTab string, opts portalOptions) string {
+	cards := ""
+	groups := groupByCategory(svcs)
+	for _, g := range groups {
+		// Headings only once something is categorized; a lone "Other"
+		// group is the flat grid.
+		if len(groups) > 1 || g.Category != otherCategory {
+			cards += `
+      <h2 class="svc-heading">` + html.EscapeString(g.Category) + `</h2>`
+		}
+		for _, svc := range g.Services {
+			cards += serviceCardHTML(svc, healthMap, opts.GreyDisabled)
+		}
 	}
 
-	if cards == "" {
+	legend := ""
+	search := ""
+	if cards != "" {
+		search = `
+<div class="svc-search"><input type="search" id="svc-search" placeholder="Search services (press /)" aria-label="Search services" autocomplete="off" oninput="filterServices()"></div>
+<p class="empty" id="svc-search-empty" style="display:none">No services match.</p>`
+	}
+	if cards == "" && !isAdmin && len(opts.Requestable) > 0 {
+		cards = `<p class="empty">You don't have access to any services yet. Request access below, or ask an admin.</p>`
+	} else if cards == "" && !isAdmin {
+		// Typically a user who just signed themselves up (SIGNUP_MODE=open).
+		cards = `<p class="empty">You don't have access to any services yet. Ask an admin to grant you access.</p>`
+	} else if cards == "" {
 		cards = `<p class="empty">No services configured.</p>`
+	} else if adminOpen {
+		legend = legendHTML(adminLegend)
+	} else {
+		legend = legendHTML(statusLegend) + `
+<div class="tl-asof" id="tl-asof"></div>`
+	}
+
+	// Public services the user may ask for.
+	requestable := ""
+	if len(opts.Requestable) > 0 {
+		for _, svc := range opts.Requestable {
+			button := `<button type="button" class="req-btn" onclick="requestAccess(` + strconv.FormatInt(svc.ID, 10) + `,this)">Request access</button>`
+			if svc.Pending {
+				button = `<button type="button" class="req-btn" disabled>Requested</button>`
+			}
+			requestable += `
+      <div class="req-card">
+        <div class="icon">` + serviceIconHTML(svc.Service) + `</div>
+        <div class="info">
+          <h3>` + html.EscapeString(svc.Name) + `</h3>
+          <p>` + html.EscapeString(truncate(svc.Description, 20)) + `</p>
+        </div>
+        ` + button + `
+      </div>`
+		}
+		requestable = `
+<h2 class="req-heading">More services</h2>
+<div class="grid">` + requestable + `
+</div>`
 	}
 
 	// Build identity list.
@@ -143,6 +436,28 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
 		}
 	}
 
+	// Per-domain identity choice: which identity external domains get.
+	domainItems := ""
+	for _, d := range opts.DomainIdentities {
+		options := `<option value="">Active identity</option>`
+		for _, s := range group {
+			selected := ""
+			if s.DID == d.DID {
+				selected = " selected"
+			}
+			options += `<option value="` + s.DID + `"` + selected + `>` + s.Handle + `</option>`
+		}
+		domainItems += `<form method="POST" action="/identity/domain" class="dd-domain"><input type="hidden" name="domain" value="` + d.Domain + `">` +
+			`<label>On ` + strings.TrimPrefix(d.Domain, ".") + `</label><select name="did" onchange="this.form.submit()">` + options + `</select></form>`
+	}
+	if domainItems != "" {
+		domainItems = `
+      <div class="dd-sep"></div>
+      <div class="dd-section">
+        ` + domainItems + `
+      </div>`
+	}
+
 	// Logout items.
 	logoutItems := ""
 	for _, id := range identities {
@@ -159,17 +474,33 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
       </div>`
 	}
 
+	// Self-service account deletion (owners can't delete themselves).
+	deleteItem := ""
+	if role != "owner" {
+		deleteItem = `<a href="/account/delete" class="dd-add dd-danger">Delete account...</a>`
+	}
+
 	adminHTML := ""
 	if isAdmin {
 		adminHTML = adminPanelHTML(role, adminOpen, adminTab)
 	}
 
+	greyDisabledJS := strconv.FormatBool(opts.GreyDisabled)
+	healthAtMS := "0"
+	if !opts.HealthAt.IsZero() {
+		healthAtMS = strconv.FormatInt(opts.HealthAt.UnixMilli(), 10)
+	}
+	// JSON is safe inside <script>: json.Marshal escapes <, >, and & as well
+	// as quotes.
+	configJS, _ := json.Marshal(opts.Client)
+
 	return `<!DOCTYPE html>
 <html lang="en">
 <head>
 <meta charset="utf-8">
 <meta name="viewport" content="width=device-width, initial-scale=1">
-<title>nokNok — Portal</title>
+<title>` + opts.Brand.title("Portal") + `</title>
+` + faviconLink + `
 <style>
   *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
   body {
@@ -186,7 +517,7 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     max-width: 800px;
     margin: 0 auto 2rem;
   }
-  h1 { font-size: 1.5rem; color: #f8fafc; }
+  h1 { font-size: 1.5rem; color: #f8fafc; }` + opts.Brand.css() + opts.Colors.css() + `
   .user {
     display: flex;
     align-items: center;
@@ -257,6 +588,24 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     transition: background 0.15s;
   }
   .dd-add:hover { background: #334155; color: #e2e8f0; }
+  .dd-domain {
+    display: flex;
+    align-items: center;
+    justify-content: space-between;
+    gap: 0.5rem;
+    margin: 0;
+    padding: 0.375rem 0.75rem;
+    font-size: 0.75rem;
+    color: #94a3b8;
+  }
+  .dd-domain select {
+    background: #0f172a;
+    color: #e2e8f0;
+    border: 1px solid #334155;
+    border-radius: 4px;
+    font-size: 0.75rem;
+    padding: 0.125rem 0.25rem;
+  }
   .dd-logout-all {
     display: block;
     width: 100%;
@@ -292,6 +641,10 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     flex-wrap: wrap;
   }
   .card:hover { background: #334155; transform: translateY(-2px); }
+  .card-disabled { opacity: 0.45; filter: grayscale(1); cursor: not-allowed; }
+  .card-disabled:hover { background: #1e293b; transform: none; }
+  .card-disabled .info p { display: none; }
+  .card-disabled .info::after { content: "Disabled"; font-size: 0.8125rem; color: #94a3b8; }
   .traffic-light {
     position: absolute;
     right: 0.5rem;
@@ -307,11 +660,94 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     border-radius: 4px;
     background: #475569;
     transition: background 0.15s;
+    display: flex;
+    align-items: center;
+    justify-content: center;
+    font-size: 0.625rem;
+    font-weight: 700;
+    line-height: 1;
+    color: #0f172a;
   }
+  /* Glyphs so status doesn't rely on color alone. */
+  .tl-dot.tl-red::after { content: "\2715"; }
+  .tl-dot.tl-yellow::after { content: "!"; }
+  .tl-dot.tl-green::after { content: "\2713"; }
   .tl-dot.tl-off { background: #475569; }
-  .tl-dot.tl-red { background: #ef4444; }
-  .tl-dot.tl-yellow { background: #eab308; }
-  .tl-dot.tl-green { background: #22c55e; }
+  .tl-dot.tl-red { background: var(--tl-red); }
+  .tl-dot.tl-yellow { background: var(--tl-yellow); }
+  .tl-dot.tl-green { background: var(--tl-green); }
+  .tl-legend {
+    display: flex;
+    justify-content: center;
+    flex-wrap: wrap;
+    gap: 1rem;
+    max-width: 800px;
+    margin: 1.25rem auto 0;
+    font-size: 0.75rem;
+    color: #94a3b8;
+  }
+  .idle-overlay {
+    position: fixed;
+    inset: 0;
+    display: none;
+    align-items: center;
+    justify-content: center;
+    background: rgba(15, 23, 42, 0.85);
+    z-index: 1000;
+  }
+  .idle-overlay.open { display: flex; }
+  .idle-box {
+    background: #1e293b;
+    border-radius: 12px;
+    padding: 1.5rem;
+    max-width: 360px;
+    text-align: center;
+  }
+  .idle-box p { font-size: 0.875rem; color: #94a3b8; margin: 0.5rem 0 1rem; }
+  .idle-box button {
+    padding: 0.5rem 1rem;
+    background: #3b82f6;
+    color: #fff;
+    border: none;
+    border-radius: 8px;
+    font-size: 0.875rem;
+    cursor: pointer;
+  }
+  .idle-box button:hover { background: #2563eb; }
+  .svc-toast {
+    position: fixed;
+    left: 50%;
+    bottom: 1.5rem;
+    transform: translateX(-50%);
+    display: none;
+    align-items: center;
+    gap: 0.75rem;
+    max-width: calc(100% - 2rem);
+    padding: 0.75rem 1rem;
+    background: #1e293b;
+    border: 1px solid #334155;
+    border-radius: 8px;
+    box-shadow: 0 4px 24px rgba(0,0,0,0.4);
+    font-size: 0.875rem;
+    color: #e2e8f0;
+    z-index: 900;
+  }
+  .svc-toast.show { display: flex; }
+  .svc-toast button {
+    flex-shrink: 0;
+    padding: 0.25rem 0.625rem;
+    background: none;
+    color: #93c5fd;
+    border: 1px solid #475569;
+    border-radius: 6px;
+    font-size: 0.8125rem;
+    cursor: pointer;
+  }
+  .svc-toast button:hover { border-color: #93c5fd; }
+  .tl-asof { text-align: center; margin-top: 0.375rem; font-size: 0.6875rem; color: #64748b; }
+  .tl-asof.stale { color: var(--tl-yellow); }
+  .tl-legend-item { display: flex; align-items: center; gap: 0.375rem; cursor: help; }
+  .tl-legend .tl-dot { width: 0.875rem; height: 0.875rem; border-radius: 3px; font-size: 0.5625rem; }
   .detail-panel {
     flex-basis: 100%;
     max-height: 0;
@@ -335,13 +771,14 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
   }
   .detail-btn:hover { opacity: 0.8; }
   .detail-btn.db-off { background: #475569; }
-  .detail-btn.db-red { background: #ef4444; }
-  .detail-btn.db-yellow { background: #eab308; }
-  .detail-btn.db-green { background: #22c55e; }
+  .detail-btn.db-red { background: var(--tl-red); }
+  .detail-btn.db-yellow { background: var(--tl-yellow); }
+  .detail-btn.db-green { background: var(--tl-green); }
   .detail-btn.db-readonly { cursor: default; opacity: 0.5; }
   .detail-btn.db-readonly:hover { opacity: 0.5; }
   .detail-btn.db-outline { background: transparent; border: 1.5px solid #475569; cursor: default; }
   .detail-btn.db-outline:hover { opacity: 1; }
+  .detail-latency { text-align: center; font-size: 0.6875rem; color: #64748b; }
   .icon {
     width: 48px;
     height: 48px;
@@ -376,6 +813,51 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     overflow: hidden;
     text-overflow: ellipsis;
   }
+  .svc-search { max-width: 800px; margin: 0 auto 1rem; }
+  .svc-search input {
+    width: 100%;
+    padding: 0.5rem 0.75rem;
+    background: #1e293b;
+    border: 1px solid #334155;
+    border-radius: 8px;
+    color: #e2e8f0;
+    font-size: 0.875rem;
+  }
+  .svc-search input:focus { outline: none; border-color: #3b82f6; }
+  .svc-heading {
+    grid-column: 1 / -1;
+    margin-top: 0.5rem;
+    font-size: 0.75rem;
+    font-weight: 600;
+    color: #94a3b8;
+    text-transform: uppercase;
+    letter-spacing: 0.05em;
+  }
+  .svc-heading:first-child { margin-top: 0; }
+  .req-heading { max-width: 800px; margin: 2rem auto 1rem; font-size: 1rem; font-weight: 600; color: #94a3b8; }
+  .req-card {
+    display: flex;
+    align-items: center;
+    gap: 1rem;
+    background: #1e293b;
+    border: 1px dashed #334155;
+    border-radius: 12px;
+    padding: 1.25rem;
+    flex-wrap: wrap;
+  }
+  .req-card .info { padding-right: 0; }
+  .req-btn {
+    flex-basis: 100%;
+    padding: 0.375rem 0.75rem;
+    background: #334155;
+    color: #e2e8f0;
+    border: none;
+    border-radius: 6px;
+    font-size: 0.8125rem;
+    cursor: pointer;
+  }
+  .req-btn:hover { background: #475569; }
+  .req-btn:disabled { background: transparent; color: #94a3b8; cursor: default; }
   .empty {
     color: #475569;
     text-align: center;
@@ -386,6 +868,7 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
 </head>
 <body>
 <div class="header">
+  ` + opts.Brand.headerHTML() + `
   <div class="user">
     <button class="dd-trigger" onclick="toggleDropdown(event)">
       ` + active.Handle + ` <span class="dd-arrow">&#9660;</span>
@@ -397,23 +880,37 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
       <div class="dd-sep"></div>
       <div class="dd-section">
         <a href="/login" class="dd-add">+ New sign-in...</a>
+        <a href="/sessions" class="dd-add">Sessions...</a>
       </div>
+      ` + domainItems + `
       ` + adminItem + `
       <div class="dd-sep"></div>
       <div class="dd-section">
         ` + logoutItems + `
-        <form method="POST" action="/logout" style="margin:0" onsubmit="closeAllTracked()">
+        <form method="POST" action="/logout" id="logout-all-form" style="margin:0" onsubmit="closeAllTracked()">
           <button type="submit" class="dd-logout-all">Log out all</button>
         </form>
+        ` + deleteItem + `
       </div>
     </div>
   </div>
 </div>
-` + adminHTML + `
+` + adminHTML + search + `
 <div class="grid">` + cards + `
 </div>
+` + legend + requestable + `
+<div class="svc-toast" id="svc-toast" role="status" aria-live="polite"></div>
+<div class="idle-overlay" id="idle-overlay" role="alertdialog" aria-labelledby="idle-title">
+  <div class="idle-box">
+    <strong id="idle-title">Still there?</strong>
+    <p>You'll be signed out in <span id="idle-countdown"></span> seconds.</p>
+    <button type="button" id="idle-stay">Stay signed in</button>
+  </div>
+</div>
 <script>
 var openWindows = {};
+var CONFIG = ` + string(configJS) + `;
+var GREY_DISABLED = ` + greyDisabledJS + `;
 function openService(el) {
   var ap = document.getElementById('admin-panel');
   if (ap && ap.style.display !== 'none' && typeof toggleDetail === 'function') {
@@ -421,10 +918,64 @@ function openService(el) {
     return false;
   }
   var status = el.getAttribute('data-svc-status');
-  if (status !== 'green') return false;
+  if (status === 'green' || (status === 'yellow' && CONFIG.down_click === 'open')) {
+    launchService(el);
+  } else {
+    showServiceToast(el, status);
+  }
+  return false;
+}
+function launchService(el) {
   var w = window.open(el.href, el.target);
   if (w) openWindows[el.target] = w;
-  return false;
+  if (CONFIG.track_usage) recordUsage(el.getAttribute('data-svc-id'));
+}
+// showServiceToast explains why a card didn't open. With
+// PORTAL_DOWN_CLICK=confirm, unreachable services offer "Open anyway",
+// since a failing health check doesn't always mean the service is down.
+var toastTimer = null;
+function showServiceToast(el, status) {
+  var t = document.getElementById('svc-toast');
+  var name = el.querySelector('h3').textContent;
+  t.textContent = '';
+  var msg = document.createElement('span');
+  msg.textContent = (status === 'red' ? CONFIG.disabled_message : CONFIG.down_message).split('{name}').join(name);
+  t.appendChild(msg);
+  if (status === 'yellow' && CONFIG.down_click === 'confirm') {
+    var b = document.createElement('button');
+    b.type = 'button';
+    b.textContent = 'Open anyway';
+    b.onclick = function() { t.classList.remove('show'); launchService(el); };
+    t.appendChild(b);
+  }
+  t.classList.add('show');
+  clearTimeout(toastTimer);
+  toastTimer = setTimeout(function() { t.classList.remove('show'); }, 6000);
+}
+// requestAccess asks admins for a grant to a public service, with an
+// optional reason.
+function requestAccess(id, btn) {
+  var note = prompt('Why do you need access? (optional)', '');
+  if (note === null) return;
+  btn.disabled = true;
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', '/request-access', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState !== 4) return;
+    if (xhr.status === 201 || xhr.status === 409) { btn.textContent = 'Requested'; return; }
+    btn.disabled = false;
+    var msg = 'Could not request access';
+    try { msg = JSON.parse(xhr.responseText).error || msg; } catch (e) {}
+    alert(msg);
+  };
+  xhr.send('id=' + encodeURIComponent(id) + '&note=' + encodeURIComponent(note));
+}
+function recordUsage(id) {
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', '/api/usage', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.send('id=' + encodeURIComponent(id));
 }
 function closeTrackedWindow(slug) {
   if (openWindows[slug]) {
@@ -451,6 +1002,52 @@ document.addEventListener('click', function(e) {
 document.addEventListener('keydown', function(e) {
   if (e.key === 'Escape') document.getElementById('identity-menu').classList.remove('open');
 });
+// Service search: "/" focuses the box (unless typing elsewhere), Escape
+// clears it, and Enter opens the first match.
+function filterServices() {
+  var q = document.getElementById('svc-search').value.trim().toLowerCase();
+  var cards = document.querySelectorAll('.grid .card');
+  var shown = 0;
+  for (var i = 0; i < cards.length; i++) {
+    var match = !q || cards[i].getAttribute('data-search').indexOf(q) !== -1;
+    cards[i].style.display = match ? '' : 'none';
+    if (match) shown++;
+  }
+  // Category headings hide along with all of their cards.
+  var headings = document.querySelectorAll('.grid .svc-heading');
+  for (var h = 0; h < headings.length; h++) {
+    var any = false;
+    for (var el = headings[h].nextElementSibling; el && !el.classList.contains('svc-heading'); el = el.nextElementSibling) {
+      if (el.style.display !== 'none') { any = true; break; }
+    }
+    headings[h].style.display = any ? '' : 'none';
+  }
+  document.getElementById('svc-search-empty').style.display = shown ? 'none' : '';
+}
+(function() {
+  var box = document.getElementById('svc-search');
+  if (!box) return;
+  document.addEventListener('keydown', function(e) {
+    if (e.key !== '/' || e.ctrlKey || e.metaKey || e.altKey) return;
+    var t = e.target;
+    if (t.tagName === 'INPUT' || t.tagName === 'TEXTAREA' || t.tagName === 'SELECT' || t.isContentEditable) return;
+    e.preventDefault();
+    box.focus();
+    box.select();
+  });
+  box.addEventListener('keydown', function(e) {
+    if (e.key === 'Escape') {
+      box.value = '';
+      filterServices();
+      box.blur();
+    } else if (e.key === 'Enter') {
+      var cards = document.querySelectorAll('.grid .card');
+      for (var i = 0; i < cards.length; i++) {
+        if (cards[i].style.display !== 'none') { openService(cards[i]); break; }
+      }
+    }
+  });
+})();
 // Duplicate-tab detection via BroadcastChannel.
 // The first portal tab claims "primary". Any subsequent portal tab
 // that arrives (e.g. from a forwardAuth deny redirect) asks the
@@ -461,10 +1058,10 @@ document.addEventListener('keydown', function(e) {
   var isPrimary = false;
   // Ask if a primary exists.
   ch.postMessage({ type: 'ping' });
-  // If no pong within 200ms, claim primary.
+  // If no pong within PORTAL_TAB_CLAIM, claim primary.
   var timer = setTimeout(function() {
     isPrimary = true;
-  }, 200);
+  }, CONFIG.tab_claim_ms);
   ch.onmessage = function(e) {
     if (e.data.type === 'ping' && isPrimary) {
       ch.postMessage({ type: 'pong' });
@@ -479,20 +1076,80 @@ document.addEventListener('keydown', function(e) {
   };
 })();
 // Reload on tab focus to refresh grants and service cards.
-// Only if the tab was hidden for more than 5 seconds, to avoid
-// reloading during quick tab switches.
+// Only if the tab was hidden for longer than PORTAL_RELOAD_AFTER, to avoid
+// reloading during quick tab switches. Disabled when set to 0.
 (function() {
+  var reloadAfter = CONFIG.reload_after_ms;
+  if (!reloadAfter) return;
   var hiddenAt = 0;
   document.addEventListener('visibilitychange', function() {
     if (document.hidden) {
       hiddenAt = Date.now();
-    } else if (hiddenAt && (Date.now() - hiddenAt) > 5000) {
+    } else if (hiddenAt && (Date.now() - hiddenAt) > reloadAfter) {
       window.location.reload();
     }
   });
 })();
-// Poll health status every 60 seconds and update traffic lights.
+// Sign out after PORTAL_IDLE_LOGOUT without interaction, for shared
+// machines. A "Still there?" prompt appears for the last minute (or quarter
+// of the period, if shorter). Activity is shared through localStorage so an
+// idle portal tab doesn't sign out a user who is active in another one.
+// Health polling doesn't count as activity. Disabled when set to 0.
+(function() {
+  var idleAfter = CONFIG.idle_logout_ms;
+  if (!idleAfter) return;
+  var warnFor = Math.min(60000, Math.floor(idleAfter / 4));
+  var key = 'noknok-last-activity';
+  var overlay = document.getElementById('idle-overlay');
+  var countdown = document.getElementById('idle-countdown');
+  var last = Date.now();
+  function lastActivity() {
+    var shared = 0;
+    try { shared = parseInt(localStorage.getItem(key), 10) || 0; } catch (e) {}
+    return Math.max(last, shared);
+  }
+  function touch() {
+    if (overlay.className.indexOf('open') !== -1) return;
+    last = Date.now();
+    try { localStorage.setItem(key, String(last)); } catch (e) {}
+  }
+  function stay() {
+    overlay.className = 'idle-overlay';
+    touch();
+  }
+  var events = ['mousedown', 'keydown', 'scroll', 'touchstart', 'mousemove'];
+  for (var i = 0; i < events.length; i++) document.addEventListener(events[i], touch, { passive: true });
+  document.getElementById('idle-stay').onclick = stay;
+  touch();
+  setInterval(function() {
+    var left = lastActivity() + idleAfter - Date.now();
+    if (left <= 0) {
+      closeAllTracked();
+      document.getElementById('logout-all-form').submit();
+    } else if (left <= warnFor) {
+      countdown.textContent = Math.ceil(left / 1000);
+      if (overlay.className.indexOf('open') === -1) overlay.className = 'idle-overlay open';
+    } else if (overlay.className.indexOf('open') !== -1) {
+      overlay.className = 'idle-overlay';
+    }
+  }, 1000);
+})();
+// Poll health status every PORTAL_STATUS_POLL and update traffic lights.
+// The "status as of" line turns into a warning once the poller's last run
+// is older than three polling intervals.
 (function() {
+  var checkedAt = ` + healthAtMS + `;
+  function showAge() {
+    var el = document.getElementById('tl-asof');
+    if (!el || !checkedAt) return;
+    var secs = Math.max(0, Math.round((Date.now() - checkedAt) / 1000));
+    var age = secs < 60 ? secs + 's' : Math.round(secs / 60) + 'm';
+    var stale = secs * 1000 > CONFIG.status_stale_ms;
+    el.className = stale ? 'tl-asof stale' : 'tl-asof';
+    el.textContent = stale ? 'Status may be out of date (checked ' + age + ' ago)' : 'Status as of ' + age + ' ago';
+  }
+  setInterval(showAge, 10000);
+  showAge();
   function refreshStatus() {
     var xhr = new XMLHttpRequest();
     xhr.open('GET', '/api/health', true);
@@ -500,6 +1157,7 @@ document.addEventListener('keydown', function(e) {
       if (xhr.readyState !== 4 || xhr.status !== 200) return;
       try {
         var data = JSON.parse(xhr.responseText);
+        if (data.checked_at) { checkedAt = Date.parse(data.checked_at); showAge(); }
         var ap = document.getElementById('admin-panel');
         if (ap && ap.style.display !== 'none') return;
         var allIds = {}, i;
@@ -521,6 +1179,7 @@ document.addEventListener('keydown', function(e) {
           var svcId = card.getAttribute('data-svc-id');
           var status = disabledMap[svcId] ? 'red' : (downMap[svcId] ? 'yellow' : 'green');
           card.setAttribute('data-svc-status', status);
+          if (GREY_DISABLED) card.className = status === 'red' ? 'card card-disabled' : 'card';
           var dots = card.querySelectorAll('.tl-dot');
           if (dots.length < 3) continue;
           dots[0].className = 'tl-dot tl-enabled ' + (status === 'red' ? 'tl-red' : 'tl-off');
@@ -531,20 +1190,23 @@ document.addEventListener('keydown', function(e) {
     };
     xhr.send();
   }
-  setInterval(refreshStatus, 60000);
+  setInterval(refreshStatus, CONFIG.status_poll_ms);
 })();
 </script>
 </body>
 </html>`
 }
 
-// handleHealthStatus returns user-specific service status as three arrays.
+// handleHealthStatus returns user-specific service status as three arrays,
+// plus when the health poller last ran (checked_at, null before its first
+// run) and when each listed service was last checked (service_checked_at,
+// keyed by ID; services not yet checked are absent).
 func (s *Server) handleHealthStatus(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
+	cookie, err := c.Cookie(s.sess.CookieName())
 	if err != nil || cookie.Value == "" {
 		return c.NoContent(http.StatusUnauthorized)
 	}
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.validateSession(c, cookie.Value)
 	if err != nil {
 		return c.NoContent(http.StatusUnauthorized)
 	}
@@ -558,9 +1220,10 @@ func (s *Server) handleHealthStatus(c echo.Context) error {
 	isAdmin := user.Role == "owner" || user.Role == "admin"
 	var svcs []database.Service
 	if isAdmin {
-		svcs, err = s.db.ListServices(ctx)
+		svcs, err = s.db.ListServices(ctx, s.requestDomain(c))
 	} else {
-		svcs, err = s.db.ListServicesForUser(ctx, user.ID)
+		svcs, err = s.db.ListServicesForUser(ctx, user.ID, s.requestDomain(c))
+		svcs = s.filterDisabledForUser(svcs)
 	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed"})
@@ -573,13 +1236,68 @@ func (s *Server) handleHealthStatus(c echo.Context) error {
 	for _, svc := range svcs {
 		if !svc.Enabled {
 			disabled = append(disabled, svc.ID)
-		} else if !health[svc.ID] {
+		} else if !health[svc.ID].Alive {
 			down = append(down, svc.ID)
 		} else {
 			enabled = append(enabled, svc.ID)
 		}
 	}
-	return c.JSON(http.StatusOK, map[string][]int64{
+	last, at := s.healthTimes()
+	var checkedAt *time.Time
+	if !at.IsZero() {
+		checkedAt = &at
+	}
+	serviceCheckedAt := make(map[string]time.Time, len(svcs))
+	for _, svc := range svcs {
+		if t, ok := last[svc.ID]; ok {
+			serviceCheckedAt[strconv.FormatInt(svc.ID, 10)] = t
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]any{
 		"down": down, "disabled": disabled, "enabled": enabled,
+		"checked_at": checkedAt, "service_checked_at": serviceCheckedAt,
 	})
 }
+
+// handleUsage records a service click from the portal. Counts are aggregated
+// per service and day; the user is only attributed when USAGE_PER_USER is set.
+// Only services the user's portal shows count; any other ID is a 404.
+func (s *Server) handleUsage(c echo.Context) error {
+	if !s.cfg.UsageTracking {
+		return c.NoContent(http.StatusNoContent)
+	}
+	cookie, err := c.Cookie(s.sess.CookieName())
+	if err != nil || cookie.Value == "" {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	sess, err := s.validateSession(c, cookie.Value)
+	if err != nil {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	svcID, err := strconv.ParseInt(c.FormValue("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	user, err := s.db.GetUserByIdentityDID(c.Request().Context(), sess.DID)
+	if err != nil {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	svcs, err := s.portalServices(c, user)
+	if err != nil {
+		slog.Error("usage: failed to load services", "error", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !slices.ContainsFunc(svcs, func(svc database.Service) bool { return svc.ID == svcID }) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	var userID int64
+	if s.cfg.UsagePerUser {
+		userID = sess.UserID
+	}
+	if err := s.db.RecordServiceClick(c.Request().Context(), svcID, userID); err != nil {
+		slog.Warn("usage: failed to record click", "service_id", svcID, "error", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
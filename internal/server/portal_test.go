@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// Clicks only count for services the user's portal shows.
+func TestUsageOnlyPortalServices(t *testing.T) {
+	s := newTestServer(t, map[string]string{"USAGE_TRACKING": "true"})
+	ctx := context.Background()
+	owner, _ := testUser(t, s, "owner")
+	user, cookie := testUser(t, s, "user")
+	service := func(grant bool) *database.Service {
+		t.Helper()
+		name := randomName(t)
+		svc, err := s.db.CreateService(ctx, database.Service{Slug: name, Name: name, URL: "https://" + name + ".test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.db.DeleteService(context.Background(), svc.ID) })
+		if grant {
+			if _, err := s.db.CreateGrant(ctx, user.ID, svc.ID, owner.ID, "user", database.GrantExpiry{}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return svc
+	}
+	granted, other := service(true), service(false)
+
+	click := func(id int64) int {
+		form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+		req := httptest.NewRequest(http.MethodPost, "/api/usage", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := click(granted.ID); code != http.StatusNoContent {
+		t.Errorf("granted service: status %d, want 204", code)
+	}
+	if code := click(other.ID); code != http.StatusNotFound {
+		t.Errorf("ungranted service: status %d, want 404", code)
+	}
+	if code := click(-1); code != http.StatusNotFound {
+		t.Errorf("nonexistent service: status %d, want 404", code)
+	}
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -20,23 +22,42 @@ func (s *Server) handleRelay(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	// Validate the session token.
+	// Redirect must be a same-host path to prevent open redirect:
+	// "//host" and "/\host" are treated as absolute by browsers, and so is
+	// "/\t/host", as they drop tabs and newlines from URLs.
+	if redirect == "" || !strings.HasPrefix(redirect, "/") ||
+		strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") ||
+		strings.ContainsFunc(redirect, isASCIIControl) {
+		redirect = "/"
+	}
+
+	// Validate the session token. If it has gone stale in transit, send the
+	// user back through login with the deep link intact.
 	sess, err := s.sess.Validate(c.Request().Context(), token)
 	if err != nil {
-		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+		target := c.Scheme() + "://" + c.Request().Host + redirect
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?redirect="+url.QueryEscape(target))
 	}
 
 	// Determine the cookie domain from the request host.
 	host := c.Request().Host
 	domain := s.cfg.DomainForHost(host)
 
-	// Set the session cookie for this domain.
-	c.SetCookie(s.sess.MakeCookieForDomain(token, sess.ExpiresAt, domain))
-
-	// Redirect must be a relative path to prevent open redirect.
-	if redirect == "" || !strings.HasPrefix(redirect, "/") {
-		redirect = "/"
+	// The group may have chosen an identity for this domain; it wins over
+	// whichever identity was active when the relay started.
+	if pref, err := s.sess.DomainSession(c.Request().Context(), sess.GroupID, domain); err != nil {
+		slog.Warn("relay: domain identity lookup failed", "domain", domain, "error", err)
+	} else if pref != nil {
+		sess = pref
 	}
 
+	// Set the session cookie for this domain.
+	c.SetCookie(s.sess.MakeCookieForDomain(sess.Token, sess.ExpiresAt, domain))
+
 	return c.Redirect(http.StatusFound, redirect)
 }
+
+// isASCIIControl reports whether r is an ASCII control character.
+func isASCIIControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// A deep link on an external cookie domain survives sign-in: the login
+// destination relays the session to that domain, and the relay lands the
+// browser on the original path, query, and fragment with a cookie set.
+func TestRelayDeepLinkRoundTrip(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"PUBLIC_URL":     "https://auth.example.com",
+		"COOKIE_DOMAIN":  ".example.com",
+		"COOKIE_DOMAINS": ".example.com,.ker.test",
+	})
+	_, session := testUser(t, s, "user")
+	const deepLink = "https://app.ker.test/docs/a%20b/?q=x%26y&lang=en#section-2"
+
+	req := httptest.NewRequest(http.MethodGet, "https://auth.example.com/oauth/callback", nil)
+	req.AddCookie(&http.Cookie{Name: redirectCookieName, Value: url.QueryEscape(deepLink)})
+	dest := s.loginDestination(s.echo.NewContext(req, httptest.NewRecorder()), session.Value)
+
+	relay, err := url.Parse(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relay.Host != "app.ker.test" || relay.Path != "/__noknok_set" || relay.Query().Get("t") != session.Value {
+		t.Fatalf("login destination %s, want a relay to app.ker.test with the session", dest)
+	}
+	// The browser keeps the fragment and reapplies it after the relay's
+	// redirect; it never reaches the server.
+	fragment := relay.Fragment
+	relay.Fragment = ""
+
+	rec := serve(s, http.MethodGet, relay.String(), nil, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("relay status %d, want 302", rec.Code)
+	}
+	if got := "https://app.ker.test" + rec.Header().Get("Location") + "#" + fragment; got != deepLink {
+		t.Errorf("relay lands on %s, want %s", got, deepLink)
+	}
+	var set bool
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == s.sess.CookieName() && ck.Value == session.Value && ck.Domain == "ker.test" {
+			set = true
+		}
+	}
+	if !set {
+		t.Errorf("relay didn't set the session cookie on .ker.test: %v", rec.Header()["Set-Cookie"])
+	}
+
+	// Paths browsers would read as another host, or carrying control
+	// characters, land on the root instead.
+	for _, r := range []string{"//evil.com", "/\\evil.com", "/\t/evil.com", "/\n/evil.com", "/\r/evil.com", "/a\x7f"} {
+		q := url.Values{"t": {session.Value}, "r": {r}}
+		rec := serve(s, http.MethodGet, "https://app.ker.test/__noknok_set?"+q.Encode(), nil, nil)
+		if loc := rec.Header().Get("Location"); rec.Code != http.StatusFound || loc != "/" {
+			t.Errorf("relay to %q: status %d to %q, want 302 to /", r, rec.Code, loc)
+		}
+	}
+
+	// A token gone stale in transit sends the user back through login with
+	// the deep link (less the fragment, which the browser still holds).
+	q := relay.Query()
+	q.Set("t", "stale")
+	relay.RawQuery = q.Encode()
+	rec = serve(s, http.MethodGet, relay.String(), nil, nil)
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusFound || loc.Host != "auth.example.com" || loc.Path != "/login" {
+		t.Fatalf("stale relay: status %d to %s, want 302 to the login page", rec.Code, loc)
+	}
+	if got, want := loc.Query().Get("redirect"), strings.TrimSuffix(deepLink, "#"+fragment); got != want {
+		t.Errorf("stale relay redirect %q, want %q", got, want)
+	}
+}
@@ -2,40 +2,100 @@ package server
 
 func (s *Server) registerRoutes() {
 	s.echo.GET("/health", s.handleHealth)
+	s.echo.GET("/readyz", s.handleReadyz)
 	s.echo.GET("/auth", s.handleAuth)
 	s.echo.GET("/login", s.handleLoginPage)
+	s.echo.GET("/catalog", s.handleCatalog)
+	s.echo.GET("/icon/:id", s.handleServiceIcon)
+	s.echo.GET("/favicon.ico", s.handleFavicon)
 	s.echo.POST("/login", s.handleLogin)
 	s.echo.POST("/logout", s.handleLogout)
 	s.echo.POST("/switch", s.handleSwitchIdentity)
+	s.echo.POST("/identity/domain", s.handleDomainIdentity)
 	s.echo.POST("/logout/one", s.handleLogoutOne)
+	s.echo.GET("/sessions", s.handleSessionsPage)
+	s.echo.POST("/sessions/revoke", s.handleRevokeSession)
+	s.echo.GET("/account/delete", s.handleAccountDeletePage)
+	s.echo.POST("/account/delete", s.handleAccountDelete)
 	s.echo.GET("/api/identities", s.handleListIdentities)
+	s.echo.GET("/api/services", s.handleListServices)
 	s.echo.GET("/api/health", s.handleHealthStatus)
+	s.echo.GET("/api/config", s.handleClientConfig)
+	s.echo.POST("/api/usage", s.handleUsage)
+	s.echo.POST("/request-access", s.handleRequestAccess)
+	s.echo.POST("/api/validate", s.handleValidate, s.validateRateLimiter())
 	s.echo.GET("/__noknok_set", s.handleRelay)
 	s.echo.GET("/", s.handlePortal)
+	if s.cfg.MetricsToken != "" {
+		s.echo.GET("/metrics", s.handleMetrics)
+	}
 
-	// OAuth endpoints.
-	s.echo.GET("/oauth/callback", s.handleOAuthCallback)
-	s.echo.GET("/.well-known/oauth-client-metadata", s.handleClientMetadata)
-	s.echo.GET("/oauth/jwks.json", s.handleJWKS)
+	// OAuth endpoints (paths shared with the client so they stay consistent).
+	oauthPaths := s.oauth.Paths()
+	s.echo.GET(oauthPaths.Callback, s.handleOAuthCallback)
+	s.echo.GET(oauthPaths.Metadata, s.handleClientMetadata)
+	s.echo.GET(oauthPaths.JWKS, s.handleJWKS)
 
 	// Admin API (protected by requireAdmin middleware).
 	admin := s.echo.Group("/admin/api", s.requireAdmin)
+	admin.GET("/dashboard", s.handleDashboard)
 	admin.GET("/users", s.handleListUsers)
 	admin.POST("/users", s.handleCreateUser)
+	admin.POST("/users/bulk", s.handleBulkCreateUsers)
 	admin.PUT("/users/:id/role", s.handleUpdateUserRole)
 	admin.PUT("/users/:id/username", s.handleUpdateUserUsername)
 	admin.DELETE("/users/:id", s.handleDeleteUser)
+	admin.POST("/users/resync-handles", s.handleResyncHandles)
+	admin.GET("/users/pending", s.handleListSignups)
+	admin.POST("/users/:id/approve", s.handleApproveSignup)
+	admin.POST("/users/:id/deny", s.handleDenySignup)
+	admin.POST("/users/:id/reassign-grants", s.handleReassignGrants)
+	admin.GET("/users/:id/login-link", s.handleUserLoginLink)
+	admin.GET("/users/:id/debug", s.handleUserDebug)
+	admin.GET("/users/:id/admin-scope", s.handleGetAdminScope)
+	admin.PUT("/users/:id/admin-scope", s.handleSetAdminScope)
 	admin.GET("/services", s.handleListServicesAdmin)
 	admin.POST("/services", s.handleCreateService)
 	admin.PUT("/services/:id", s.handleUpdateService)
 	admin.PUT("/services/:id/enabled", s.handleToggleServiceEnabled)
 	admin.PUT("/services/:id/public", s.handleToggleServicePublic)
+	admin.PUT("/services/:id/health-override", s.handleServiceHealthOverride)
+	admin.PUT("/services/:id/sort-order", s.handleSetServiceSortOrder)
+	admin.POST("/services/:id/move", s.handleMoveService)
+	admin.POST("/services/:id/icon", s.handleUploadServiceIcon)
+	admin.DELETE("/services/:id/icon", s.handleDeleteServiceIcon)
 	admin.DELETE("/services/:id", s.handleDeleteService)
 	admin.GET("/services/health", s.handleServiceHealth)
+	admin.GET("/services/health/export", s.handleServiceHealthExport)
+	admin.GET("/services/usage", s.handleServiceUsage)
+	admin.GET("/services/uptime", s.handleServiceUptime)
+	admin.GET("/services/:id/history", s.handleServiceHealthHistory)
+	admin.GET("/services/:id/access-log", s.handleServiceAccessLog)
 	admin.GET("/grants", s.handleListGrants)
+	admin.GET("/grants/counts", s.handleGrantCounts)
 	admin.POST("/grants", s.handleCreateGrant)
+	admin.POST("/grants/cleanup", s.handleCleanupGrants)
 	admin.DELETE("/grants/:id", s.handleDeleteGrant)
+	admin.GET("/requests", s.handleListAccessRequests)
+	admin.POST("/requests/:id/approve", s.handleApproveAccessRequest)
+	admin.POST("/requests/:id/deny", s.handleDenyAccessRequest)
+	admin.POST("/webhooks/test", s.handleTestWebhook)
+	admin.GET("/access-templates", s.handleListAccessTemplates)
+	admin.POST("/access-templates", s.handleCreateAccessTemplate)
+	admin.PUT("/access-templates/:id", s.handleUpdateAccessTemplate)
+	admin.DELETE("/access-templates/:id", s.handleDeleteAccessTemplate)
+	admin.POST("/users/:id/apply-template", s.handleApplyAccessTemplate)
 	admin.GET("/users/:id/identities", s.handleListUserIdentities)
 	admin.POST("/users/:id/identities", s.handleAddIdentity)
 	admin.DELETE("/users/:id/identities/:identityId", s.handleRemoveIdentity)
+	admin.GET("/audit", s.handleListAudit)
+	admin.GET("/login-events", s.handleListLoginEvents)
+	admin.GET("/sessions", s.handleListSessions)
+	admin.GET("/blocked-dids", s.handleListBlockedDIDs)
+	admin.POST("/blocked-dids", s.handleBlockDID)
+	admin.DELETE("/blocked-dids/:did", s.handleUnblockDID)
+	admin.GET("/openapi.json", s.handleOpenAPI)
+
+	// Fallback for unknown paths.
+	s.echo.RouteNotFound("/*", s.handleNotFound)
 }
@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"log/slog"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -12,6 +14,7 @@ import (
 	"github.com/primal-host/noknok/internal/config"
 	"github.com/primal-host/noknok/internal/database"
 	"github.com/primal-host/noknok/internal/session"
+	"golang.org/x/sync/singleflight"
 )
 
 // Server wraps the Echo instance and dependencies.
@@ -22,9 +25,25 @@ type Server struct {
 	cfg        *config.Config
 	oauth      *atproto.OAuthClient
 	addr       string
+	metrics    *metrics
 	healthMu   sync.RWMutex
-	healthData map[int64]bool
+	healthData map[int64]healthSample
+	healthAt   time.Time     // when healthData was last refreshed
+	healthFail map[int64]int // consecutive failed checks per service
+	healthHist map[int64]*healthRing
 	healthStop chan struct{}
+	iconMu     sync.Mutex
+	icons      map[int64]iconEntry // service icons by service ID; see serviceIcon
+	iconFlight singleflight.Group  // icon fetches in progress, by service ID
+	tlsOnce    sync.Once
+	transports [2]*http.Transport // verifying and not, shared by health probes and icon fetches; see healthTransport
+	oauthStop  chan struct{}
+	accessStop chan struct{}
+	accessLog  chan database.AccessRecord // decisions waiting for the access log writer; nil when it's off
+	accessDone chan struct{}              // closed once the writer has flushed and stopped
+	loginStop  chan struct{}
+	histStop   chan struct{}
+	grantStop  chan struct{}
 }
 
 // New creates a configured Echo server.
@@ -41,13 +60,29 @@ func New(db *database.DB, sess *session.Manager, cfg *config.Config, oauth *atpr
 	s.echo.HideBanner = true
 	s.echo.HidePort = true
 
+	// Client IPs come from X-Forwarded-For only through TRUSTED_PROXIES,
+	// the same peers forwardAuth accepts X-Forwarded-* headers from.
+	trust := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, n := range cfg.TrustedProxies {
+		trust = append(trust, echo.TrustIPRange(n))
+	}
+	s.echo.IPExtractor = echo.ExtractIPFromXFFHeader(trust...)
+
 	s.echo.Use(middleware.Recover())
 	s.echo.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus: true,
 		LogURI:    true,
 		LogMethod: true,
 		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			slog.Info("request",
+			// forwardAuth, backend token checks, and health polling fire on
+			// every proxied request and every few seconds; keep them out of
+			// info-level logs.
+			level := slog.LevelInfo
+			switch c.Path() {
+			case "/auth", "/health", "/readyz", "/metrics", "/api/health", "/api/validate", "/icon/:id", "/favicon.ico":
+				level = slog.LevelDebug
+			}
+			slog.Log(c.Request().Context(), level, "request",
 				"method", v.Method,
 				"uri", v.URI,
 				"status", v.Status,
@@ -55,15 +90,25 @@ func New(db *database.DB, sess *session.Manager, cfg *config.Config, oauth *atpr
 			return nil
 		},
 	}))
+	s.echo.Use(s.rejectCrossSite)
 
+	s.metrics = s.newMetrics()
 	s.registerRoutes()
 	s.startHealthPoller()
+	s.startOAuthRevalidation()
+	s.startAccessLogPruner()
+	s.startAccessLogWriter()
+	s.startLoginEventPruner()
+	s.startHealthHistoryPruner()
+	s.startExpiredGrantPruner()
 
 	return s
 }
 
 // Start begins listening for HTTP requests.
 func (s *Server) Start() error {
+	s.loadHealthHistory()
+	s.prewarmHealth()
 	slog.Info("server listening", "addr", s.addr)
 	return s.echo.Start(s.addr)
 }
@@ -71,21 +116,45 @@ func (s *Server) Start() error {
 // Shutdown gracefully stops the server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	close(s.healthStop)
-	return s.echo.Shutdown(ctx)
+	if s.oauthStop != nil {
+		close(s.oauthStop)
+	}
+	if s.loginStop != nil {
+		close(s.loginStop)
+	}
+	if s.histStop != nil {
+		close(s.histStop)
+	}
+	if s.grantStop != nil {
+		close(s.grantStop)
+	}
+	err := s.echo.Shutdown(ctx)
+	// The access log stops last, so the writer flushes decisions from
+	// requests that were still in flight.
+	if s.accessStop != nil {
+		close(s.accessStop)
+		select {
+		case <-s.accessDone:
+		case <-ctx.Done():
+		}
+	}
+	return err
 }
 
-// startHealthPoller runs service health checks every 60 seconds in the background.
+// startHealthPoller runs service health checks every HEALTH_POLL_INTERVAL
+// in the background.
 func (s *Server) startHealthPoller() {
+	interval := s.cfg.HealthPollInterval
 	s.healthStop = make(chan struct{})
 	go func() {
 		// Wait one cycle before the first check to let Traefik routes settle after startup.
 		select {
-		case <-time.After(60 * time.Second):
+		case <-time.After(interval):
 		case <-s.healthStop:
 			return
 		}
 		s.refreshHealth()
-		ticker := time.NewTicker(60 * time.Second)
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 		for {
 			select {
@@ -98,22 +167,360 @@ func (s *Server) startHealthPoller() {
 	}()
 }
 
+// startOAuthRevalidation checks signed-in users' OAuth sessions every
+// OAUTH_REVALIDATE_INTERVAL, ending the noknok sessions of anyone whose
+// authorization was revoked upstream. Disabled when the interval is 0.
+func (s *Server) startOAuthRevalidation() {
+	interval := s.cfg.OAuthRevalidateInterval
+	if interval == 0 {
+		return
+	}
+	s.oauthStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.revalidateOAuthSessions()
+			case <-s.oauthStop:
+				return
+			}
+		}
+	}()
+}
+
+// startAccessLogPruner deletes access log entries older than
+// ACCESS_LOG_RETENTION every 15 minutes. Disabled when the access log is.
+func (s *Server) startAccessLogPruner() {
+	retention := s.cfg.AccessLogRetention
+	if retention == 0 {
+		return
+	}
+	s.accessStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				n, err := s.db.PruneAccess(ctx, retention)
+				cancel()
+				if err != nil {
+					slog.Error("access log: prune failed", "error", err)
+				} else if n > 0 {
+					slog.Debug("access log: pruned", "deleted", n)
+				}
+			case <-s.accessStop:
+				return
+			}
+		}
+	}()
+}
+
+// The access log writer batches forwardAuth decisions: it writes when
+// accessLogBatch are waiting or accessLogFlush has passed. Decisions beyond
+// accessLogQueue waiting are dropped (noknok_access_log_dropped_total)
+// rather than held in memory or delaying forwardAuth.
+const (
+	accessLogQueue = 4096
+	accessLogBatch = 500
+	accessLogFlush = time.Second
+)
+
+// startAccessLogWriter starts the goroutine that writes queued forwardAuth
+// decisions to the access log. Disabled when the access log is. On shutdown
+// it writes whatever is queued before stopping.
+func (s *Server) startAccessLogWriter() {
+	if s.cfg.AccessLogRetention == 0 {
+		return
+	}
+	s.accessLog = make(chan database.AccessRecord, accessLogQueue)
+	s.accessDone = make(chan struct{})
+	go func() {
+		defer close(s.accessDone)
+		ticker := time.NewTicker(accessLogFlush)
+		defer ticker.Stop()
+		batch := make([]database.AccessRecord, 0, accessLogBatch)
+		flush := func() {
+			if len(batch) > 0 {
+				s.writeAccessLog(batch)
+				batch = batch[:0]
+			}
+		}
+		add := func(r database.AccessRecord) {
+			if batch = append(batch, r); len(batch) == accessLogBatch {
+				flush()
+			}
+		}
+		for {
+			select {
+			case r := <-s.accessLog:
+				add(r)
+			case <-ticker.C:
+				flush()
+			case <-s.accessStop:
+				for {
+					select {
+					case r := <-s.accessLog:
+						add(r)
+					default:
+						flush()
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// writeAccessLog writes one batch of decisions. A failed write is logged
+// and the batch lost: the access log is a diagnostic aid, not an audit trail.
+func (s *Server) writeAccessLog(batch []database.AccessRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.db.RecordAccess(ctx, batch); err != nil {
+		slog.Warn("access log: failed to record decisions", "decisions", len(batch), "error", err)
+	}
+}
+
+// startLoginEventPruner deletes sign-in attempts older than
+// LOGIN_EVENT_RETENTION every 15 minutes. Disabled when the trail is.
+func (s *Server) startLoginEventPruner() {
+	retention := s.cfg.LoginEventRetention
+	if retention == 0 {
+		return
+	}
+	s.loginStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				n, err := s.db.PruneLoginEvents(ctx, retention)
+				cancel()
+				if err != nil {
+					slog.Error("login events: prune failed", "error", err)
+				} else if n > 0 {
+					slog.Debug("login events: pruned", "deleted", n)
+				}
+			case <-s.loginStop:
+				return
+			}
+		}
+	}()
+}
+
+// startExpiredGrantPruner deletes grants that expired more than
+// EXPIRED_GRANT_RETENTION ago every 15 minutes. Until then they give no
+// access but still show in a user's grants. Disabled when the retention is 0.
+func (s *Server) startExpiredGrantPruner() {
+	retention := s.cfg.ExpiredGrantRetention
+	if retention == 0 {
+		return
+	}
+	s.grantStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				n, err := s.db.PruneExpiredGrants(ctx, retention)
+				cancel()
+				if err != nil {
+					slog.Error("grants: expired prune failed", "error", err)
+				} else if n > 0 {
+					slog.Info("grants: pruned expired", "deleted", n)
+				}
+			case <-s.grantStop:
+				return
+			}
+		}
+	}()
+}
+
+// revalidateOAuthSessions checks each signed-in DID's newest OAuth session,
+// OAUTH_REVALIDATE_CONCURRENCY at a time, each within 15 seconds.
+func (s *Server) revalidateOAuthSessions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	toCheck, err := s.db.ListOAuthSessionsToCheck(ctx)
+	cancel()
+	if err != nil {
+		slog.Error("oauth revalidation: failed to list sessions", "error", err)
+		return
+	}
+
+	var (
+		wg      sync.WaitGroup
+		revoked atomic.Int64
+	)
+	sem := make(chan struct{}, s.cfg.OAuthRevalidateWorkers)
+	for _, o := range toCheck {
+		select {
+		case <-s.oauthStop:
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if s.revalidateOAuthSession(o.DID, o.SessionID) {
+				revoked.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Debug("oauth revalidation: done", "checked", len(toCheck), "revoked", revoked.Load())
+}
+
+// revalidateOAuthSession checks one OAuth session upstream and, if its
+// authorization was revoked, ends all of the DID's noknok sessions. It
+// reports whether it did.
+func (s *Server) revalidateOAuthSession(did, sessionID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	gone, err := s.oauth.Revalidate(ctx, did, sessionID)
+	if err != nil && !gone {
+		slog.Debug("oauth revalidation: check failed, keeping sessions", "did", did, "error", err)
+	}
+	if !gone {
+		return false
+	}
+	n, derr := s.sess.DestroyDID(ctx, did)
+	if derr != nil {
+		slog.Error("oauth revalidation: failed to end sessions", "did", did, "error", derr)
+	} else {
+		slog.Info("oauth authorization revoked upstream, sessions ended", "did", did, "sessions", n)
+	}
+	if aerr := s.db.RecordAudit(ctx, nil, "session.revoke_upstream", "did", did, map[string]any{"sessions": n}); aerr != nil {
+		slog.Warn("audit record failed", "action", "session.revoke_upstream", "error", aerr)
+	}
+	return true
+}
+
+// prewarmHealth runs one health refresh before the listener opens, so early
+// portal loads don't fall back to inline checks while the poller waits out
+// its initial delay. It stops waiting after HEALTH_PREWARM_TIMEOUT; a slow
+// refresh still fills the cache when it finishes.
+func (s *Server) prewarmHealth() {
+	if s.cfg.HealthPrewarmTimeout == 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		s.refreshHealth()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("health cache warmed")
+	case <-time.After(s.cfg.HealthPrewarmTimeout):
+		slog.Warn("health pre-warm timed out, serving with a cold cache", "timeout", s.cfg.HealthPrewarmTimeout)
+	}
+}
+
 func (s *Server) refreshHealth() {
-	svcs, err := s.db.ListServices(context.Background())
+	svcs, err := s.db.ListServices(context.Background(), "")
 	if err != nil {
 		slog.Error("health poller: failed to list services", "error", err)
 		return
 	}
 	health := s.checkServicesHealth(svcs)
+	s.metrics.healthPolls.Inc()
+	for _, sample := range health {
+		if sample.CheckedAt.IsZero() {
+			continue
+		}
+		result := "down"
+		if sample.Alive {
+			result = "up"
+		}
+		s.metrics.healthProbes.WithLabelValues(result).Inc()
+	}
 	s.healthMu.Lock()
+	fail := make(map[int64]int, len(health))
+	for id, sample := range health {
+		if !sample.Alive {
+			fail[id] = s.healthFail[id] + 1
+		}
+	}
 	s.healthData = health
+	s.healthAt = time.Now()
+	s.healthFail = fail
+	s.addHealthHistory(health)
 	s.healthMu.Unlock()
+	s.persistHealthHistory(health)
+
+	down := 0
+	for _, sample := range health {
+		if !sample.Alive {
+			down++
+		}
+	}
+	slog.Debug("health poller: refreshed", "services", len(health), "down", down)
+}
+
+// probed reports whether the poller actually checks svc, as opposed to
+// taking its status from skip_health_check or a health override.
+func probed(svc database.Service) bool {
+	return !svc.SkipHealthCheck && svc.HealthOverride == "auto"
+}
+
+// setCachedHealth updates one service's cached status between polls, as
+// refreshHealth would have.
+func (s *Server) setCachedHealth(svc database.Service, sample healthSample) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if s.healthData == nil {
+		s.healthData = make(map[int64]healthSample)
+		s.healthFail = make(map[int64]int)
+	}
+	s.healthData[svc.ID] = sample
+	delete(s.healthFail, svc.ID)
+	if probed(svc) && !sample.Alive {
+		s.healthFail[svc.ID] = 1
+	}
+}
+
+// healthStreaks returns the consecutive-failure count per service (absent
+// means the last check passed) and when the cache was last refreshed.
+func (s *Server) healthStreaks() (map[int64]int, time.Time) {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+	m := make(map[int64]int, len(s.healthFail))
+	for k, v := range s.healthFail {
+		m[k] = v
+	}
+	return m, s.healthAt
+}
+
+// healthTimes returns when each service was last checked and when the
+// poller last completed a run. Both are zero before the first run.
+func (s *Server) healthTimes() (map[int64]time.Time, time.Time) {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+	m := make(map[int64]time.Time, len(s.healthData))
+	for k, v := range s.healthData {
+		if !v.CheckedAt.IsZero() {
+			m[k] = v.CheckedAt
+		}
+	}
+	return m, s.healthAt
 }
 
-func (s *Server) cachedHealth() map[int64]bool {
+// cachedHealth returns the poller's last sample per service. Services not
+// yet checked are absent.
+func (s *Server) cachedHealth() map[int64]healthSample {
 	s.healthMu.RLock()
 	defer s.healthMu.RUnlock()
-	m := make(map[int64]bool, len(s.healthData))
+	m := make(map[int64]healthSample, len(s.healthData))
 	for k, v := range s.healthData {
 		m[k] = v
 	}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/primal-host/noknok/internal/atproto"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// testDSNEnv names the Postgres database the tests that need one run
+// against; they are skipped without it. The tests create their own users
+// and services under random names, so a shared database is fine.
+const testDSNEnv = "NOKNOK_TEST_DSN"
+
+// newTestServer returns a server on the test database, configured from the
+// environment as noknok is, with env applied on top.
+func newTestServer(t *testing.T, env map[string]string) *Server {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv + " not set")
+	}
+	key, err := atcrypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OWNER_DID", "did:plc:"+randomName(t))
+	t.Setenv("OAUTH_KEY", key.Multibase())
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db, err := database.Open(ctx, dsn, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	oauth, err := atproto.NewOAuthClient(cfg.PublicURL, atproto.OAuthPaths{
+		Metadata: cfg.OAuthMetadataPath,
+		Callback: cfg.OAuthCallbackPath,
+		JWKS:     cfg.OAuthJWKSPath,
+	}, cfg.OAuthPrivateKey, atproto.NewPgStore(db.Pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := session.NewManager(db.Pool, time.Hour, cfg.CookieDomain, false)
+	s := New(db, sess, cfg, oauth)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s
+}
+
+// testUser creates a user with the given role and one identity, signed in:
+// the returned cookie is their session.
+func testUser(t *testing.T, s *Server, role string) (*database.User, *http.Cookie) {
+	t.Helper()
+	ctx := context.Background()
+	name := randomName(t)
+	user, err := s.db.CreateUser(ctx, role, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := "did:plc:" + name
+	if _, err := s.db.AddIdentity(ctx, user.ID, did, name+".test", true); err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := s.sess.Create(ctx, user.ID, did, name+".test", "", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.DID, user.Handle = did, name+".test"
+	return user, cookie
+}
+
+// serve sends a request through the server's router. A non-nil body is
+// sent as JSON.
+func serve(s *Server, method, target string, body []byte, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func randomName(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "t" + hex.EncodeToString(b)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// handleSessionsPage lists the sessions in the current session group, so a
+// user can see where they're signed in and revoke one.
+// GET /sessions
+func (s *Server) handleSessionsPage(c echo.Context) error {
+	sess, ok := s.currentSession(c)
+	if !ok {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+	list, err := s.sess.ListGroupDetailed(c.Request().Context(), sess.GroupID)
+	if err != nil {
+		return c.HTML(http.StatusInternalServerError, sessionsHTML(s.brand(), sess.ID, nil, "Could not load your sessions. Please try again."))
+	}
+	return c.HTML(http.StatusOK, sessionsHTML(s.brand(), sess.ID, list, ""))
+}
+
+// handleRevokeSession signs one session of the current group out. Revoking
+// the current session switches to the group's next one, like /logout/one.
+// POST /sessions/revoke
+func (s *Server) handleRevokeSession(c echo.Context) error {
+	sess, ok := s.currentSession(c)
+	if !ok {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+	targetID, err := strconv.ParseInt(c.FormValue("id"), 10, 64)
+	if err != nil {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/sessions")
+	}
+
+	wasActive := targetID == sess.ID
+	newCookie, err := s.sess.DestroyOne(c.Request().Context(), sess.GroupID, targetID, wasActive)
+	if err != nil {
+		return c.HTML(http.StatusInternalServerError, sessionsHTML(s.brand(), sess.ID, nil, "Could not revoke that session. Please try again."))
+	}
+	if newCookie != nil {
+		c.SetCookie(newCookie)
+	}
+	if wasActive && newCookie != nil && newCookie.MaxAge == -1 {
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
+	}
+	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/sessions")
+}
+
+// sessionLabel identifies a session without revealing its token: the first
+// eight characters, enough to tell sessions apart.
+func sessionLabel(token string) string {
+	if len(token) > 8 {
+		token = token[:8]
+	}
+	return token + "…"
+}
+
+func sessionsHTML(b brand, currentID int64, list []session.Session, errMsg string) string {
+	errorBlock := ""
+	if errMsg != "" {
+		errorBlock = `<div class="error">` + html.EscapeString(errMsg) + `</div>`
+	}
+	when := func(t time.Time) string {
+		return `<time datetime="` + t.UTC().Format(time.RFC3339) + `">` + t.UTC().Format("2006-01-02 15:04 UTC") + `</time>`
+	}
+	var rows strings.Builder
+	for _, ss := range list {
+		current := ""
+		if ss.ID == currentID {
+			current = ` <span class="current">this session</span>`
+		}
+		device := ss.IP
+		if device == "" {
+			device = "unknown"
+		}
+		rows.WriteString(`<tr><td>` + html.EscapeString(ss.Handle) + current + `</td><td><code>` + html.EscapeString(sessionLabel(ss.Token)) +
+			`</code></td><td title="` + html.EscapeString(ss.UserAgent) + `">` + html.EscapeString(device) +
+			`<div class="ua">` + html.EscapeString(ss.UserAgent) + `</div></td><td>` + when(ss.CreatedAt) + `</td><td>` + when(ss.LastSeen) + `</td><td>` +
+			`<form method="POST" action="/sessions/revoke"><input type="hidden" name="id" value="` + strconv.FormatInt(ss.ID, 10) +
+			`"><button type="submit">Revoke</button></form></td></tr>`)
+	}
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>` + b.title("Sessions") + `</title>
+` + faviconLink + `
+<style>
+  *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+  body {
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+    background: #0f172a;
+    color: #e2e8f0;
+    min-height: 100vh;
+    padding: 2rem;
+  }
+  .card {
+    background: #1e293b;
+    border-radius: 12px;
+    padding: 1.5rem;
+    max-width: 760px;
+    margin: 4rem auto 0;
+  }
+  h1 { font-size: 1.25rem; color: #f8fafc; margin-bottom: 0.5rem; }
+  p { font-size: 0.875rem; color: #94a3b8; margin-bottom: 1rem; line-height: 1.5; }
+  .error {
+    background: #7f1d1d;
+    color: #fca5a5;
+    padding: 0.75rem 1rem;
+    border-radius: 8px;
+    font-size: 0.875rem;
+    margin-bottom: 1rem;
+  }
+  table { width: 100%; border-collapse: collapse; font-size: 0.875rem; margin-bottom: 1rem; }
+  th { text-align: left; color: #94a3b8; font-weight: 500; padding: 0.5rem; border-bottom: 1px solid #334155; }
+  td { padding: 0.5rem; border-bottom: 1px solid #1e293b; vertical-align: middle; }
+  code { color: #94a3b8; }
+  .ua { font-size: 0.75rem; color: #64748b; max-width: 220px; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+  .current { font-size: 0.75rem; color: #60a5fa; margin-left: 0.25rem; }
+  td form { margin: 0; }
+  td button {
+    padding: 0.25rem 0.625rem;
+    border: none;
+    border-radius: 6px;
+    background: #dc2626;
+    color: #fff;
+    font-size: 0.8125rem;
+    cursor: pointer;
+  }
+  td button:hover { background: #b91c1c; }
+  .back {
+    display: inline-block;
+    padding: 0.5rem 1rem;
+    border-radius: 8px;
+    background: #334155;
+    color: #e2e8f0;
+    font-size: 0.875rem;
+    text-decoration: none;
+  }
+  .back:hover { background: #475569; }
+  .card .brand { margin-bottom: 1rem; }` + b.css() + `
+</style>
+</head>
+<body>
+<div class="card">
+  ` + b.headerHTML() + `
+  <h1>Sessions</h1>
+  ` + errorBlock + `
+  <p>Identities signed in on this browser. Revoking one signs it out here; revoking this session switches to the next one.</p>
+  <table>
+    <thead><tr><th>Identity</th><th>Session</th><th>Signed in from</th><th>Signed in</th><th>Last seen</th><th></th></tr></thead>
+    <tbody>` + rows.String() + `</tbody>
+  </table>
+  <a href="/" class="back">Back to portal</a>
+</div>
+</body>
+</html>`
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// identityTokenTTL is how long an X-User-Token stays valid. ForwardAuth
+// issues a fresh one on every request, so it only has to outlive the
+// request it rides on.
+const identityTokenTTL = 5 * time.Minute
+
+// identityClaims is the payload of the identity JWT issued to services with
+// issue_token set. Backends verify it against noknok's published JWKS.
+type identityClaims struct {
+	Issuer      string `json:"iss"`
+	Subject     string `json:"sub"` // user's DID
+	Audience    string `json:"aud"` // service URL
+	IssuedAt    int64  `json:"iat"`
+	ExpiresAt   int64  `json:"exp"`
+	Handle      string `json:"handle"`
+	Username    string `json:"username,omitempty"`
+	Role        string `json:"role"`         // noknok role: owner, admin, or user
+	ServiceRole string `json:"service_role"` // effective role for this service, as in X-User-Role
+}
+
+// identityToken signs a short-lived JWT asserting who the session belongs
+// to and what they may do on svc.
+func (s *Server) identityToken(ctx context.Context, sess *session.Session, svc *database.Service, serviceRole string) (string, error) {
+	user, err := s.db.GetUserByID(ctx, sess.UserID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return s.oauth.SignJWT(identityClaims{
+		Issuer:      s.cfg.PublicURL,
+		Subject:     sess.DID,
+		Audience:    svc.URL,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(identityTokenTTL).Unix(),
+		Handle:      sess.Handle,
+		Username:    sess.Username,
+		Role:        user.Role,
+		ServiceRole: serviceRole,
+	})
+}
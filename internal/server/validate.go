@@ -0,0 +1,98 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// validateResponse is the identity behind a valid session token, returned
+// by POST /api/validate.
+type validateResponse struct {
+	DID       string    `json:"did"`
+	Handle    string    `json:"handle"`
+	Username  string    `json:"username,omitempty"`
+	Role      string    `json:"role"` // noknok role: owner, admin, or user
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleValidate lets backends check a noknok session token themselves, for
+// API routes they serve outside forwardAuth. The token comes from the JSON
+// body ({"token": "..."}) or, without one, from the session cookie on the
+// request. With VALIDATE_API_TOKEN set, callers must also send it as a
+// bearer token. Invalid, expired, and blocked sessions all get 401.
+func (s *Server) handleValidate(c echo.Context) error {
+	if s.cfg.ValidateAPIToken != "" {
+		bearer, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(s.cfg.ValidateAPIToken)) != 1 {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid API token"})
+		}
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	token := strings.TrimSpace(req.Token)
+	if token == "" {
+		if cookie, err := c.Cookie(s.sess.CookieName()); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "no session token"})
+	}
+
+	ctx := c.Request().Context()
+	sess, err := s.sess.Validate(ctx, token)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid or expired session"})
+	}
+	if blocked, err := s.db.IsDIDBlocked(ctx, sess.DID); err != nil {
+		slog.Error("validate: block list lookup failed", "did", sess.DID, "error", err)
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "block list unavailable"})
+	} else if blocked {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid or expired session"})
+	}
+	user, err := s.db.GetUserByIdentityDID(ctx, sess.DID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid or expired session"})
+	}
+	return c.JSON(http.StatusOK, validateResponse{
+		DID:       sess.DID,
+		Handle:    sess.Handle,
+		Username:  sess.Username,
+		Role:      user.Role,
+		ExpiresAt: sess.ExpiresAt,
+	})
+}
+
+// validateRateLimiter limits POST /api/validate to VALIDATE_RATE_LIMIT
+// requests per second per client IP, so the endpoint can't be used to
+// guess tokens quickly. A limit of 0 turns it off.
+func (s *Server) validateRateLimiter() echo.MiddlewareFunc {
+	if s.cfg.ValidateRateLimit == 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(s.cfg.ValidateRateLimit),
+			Burst:     s.cfg.ValidateRateLimit,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, _ string, _ error) error {
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+		},
+	})
+}
@@ -4,16 +4,39 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// cookieName is the session cookie's name before any COOKIE_PREFIX.
 const cookieName = "noknok_session"
 
+// Validation errors that say why an existing session was refused. A token
+// that matches no session (or one in a group past its max age) gives
+// pgx.ErrNoRows.
+var (
+	// ErrExpired: the session reached its absolute expiry (SESSION_TTL).
+	ErrExpired = errors.New("session expired")
+	// ErrIdleExpired: the session went unused for longer than the idle
+	// timeout, though its absolute expiry is still ahead.
+	ErrIdleExpired = errors.New("session idle timeout")
+)
+
+// maxUserAgent bounds the User-Agent stored with a session.
+const maxUserAgent = 512
+
+// rotationGrace is how long a rotated-out token stays valid, so concurrent
+// requests that raced the rotation (or arrive before the browser stores the
+// new cookie) aren't logged out.
+const rotationGrace = 30 * time.Second
+
 // Session represents an active user session.
 type Session struct {
 	ID        int64
@@ -24,6 +47,16 @@ type Session struct {
 	GroupID   string
 	UserID    int64
 	ExpiresAt time.Time
+	AuthAt    time.Time // when the user last completed OAuth for this session
+	CreatedAt time.Time // set by ListGroupDetailed
+	LastSeen  time.Time // set by ListGroupDetailed
+	IP        string    // client address at sign-in; set by ListGroup and ListGroupDetailed
+	UserAgent string    // browser at sign-in; set by ListGroup and ListGroupDetailed
+
+	// NeedsRefresh is set by ValidateGrace on a session that has expired
+	// but is still within the expiry grace: it identifies the user, but
+	// grants nothing until they sign in again.
+	NeedsRefresh bool
 }
 
 // Manager handles session creation, validation, and cleanup.
@@ -32,9 +65,30 @@ type Manager struct {
 	ttl          time.Duration
 	cookieDomain string
 	secure       bool
+	cookiePath   string
+	cookieName   string
+	hostOnly     bool // __Host- cookie: no Domain attribute
+	partitioned  bool
+	rotate       bool
+	groupMaxAge  time.Duration // 0: groups live as long as their sessions
+	expiryGrace  time.Duration // how long ValidateGrace still finds an expired session
+	refresh      time.Duration // sliding expiration: extend sessions with less than this left; 0 disables
+	idleTTL      time.Duration // sessions unused for longer stop validating; 0 disables
+	maxPerUser   int           // session groups a user may have at once; 0: unlimited
+	router       ReadRouter    // nil: everything uses pool
 	stopCleanup  chan struct{}
 }
 
+// ReadRouter sends session validation to a read replica when one is
+// attached. It is told about session writes so that a session just created
+// or destroyed is read back from the primary; one destroyed by another
+// instance may still validate for the window database.AttachReplica
+// describes. *database.DB implements it.
+type ReadRouter interface {
+	ReadPool() *pgxpool.Pool
+	MarkWrite()
+}
+
 // NewManager creates a session manager.
 func NewManager(pool *pgxpool.Pool, ttl time.Duration, cookieDomain string, secure bool) *Manager {
 	return &Manager{
@@ -42,13 +96,140 @@ func NewManager(pool *pgxpool.Pool, ttl time.Duration, cookieDomain string, secu
 		ttl:          ttl,
 		cookieDomain: cookieDomain,
 		secure:       secure,
+		cookiePath:   "/",
+		cookieName:   cookieName,
 		stopCleanup:  make(chan struct{}),
 	}
 }
 
+// SetCookiePath scopes every session cookie the manager builds to path
+// instead of "/".
+func (m *Manager) SetCookiePath(path string) {
+	m.cookiePath = path
+}
+
+// SetCookiePrefix prepends a __Host- or __Secure- prefix to the session
+// cookie name. Browsers only accept such cookies when Secure; __Host- ones
+// must also have Path=/ and no Domain, so the manager stops setting Domain
+// and the cookie stays on the host that set it. Config validation rules out
+// combinations the prefix can't satisfy.
+func (m *Manager) SetCookiePrefix(prefix string) {
+	m.cookieName = prefix + cookieName
+	m.hostOnly = prefix == "__Host-"
+}
+
+// domain returns the Domain attribute for a cookie meant for d: d itself,
+// or empty for host-only __Host- cookies.
+func (m *Manager) domain(d string) string {
+	if m.hostOnly {
+		return ""
+	}
+	return d
+}
+
+// EnablePartitioning marks session cookies Partitioned (CHIPS) with
+// SameSite=None, so services embedded cross-site still receive them under
+// third-party cookie restrictions. The cookies must also be Secure.
+func (m *Manager) EnablePartitioning() {
+	m.partitioned = true
+}
+
+// sameSite is Lax, or None for partitioned cookies: a Lax cookie is never
+// sent in the cross-site embeds partitioning exists for.
+func (m *Manager) sameSite() http.SameSite {
+	if m.partitioned {
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// UseReadRouter routes Validate through r.
+func (m *Manager) UseReadRouter(r ReadRouter) {
+	m.router = r
+}
+
+// readPool returns the pool Validate queries first.
+func (m *Manager) readPool() *pgxpool.Pool {
+	if m.router == nil {
+		return m.pool
+	}
+	return m.router.ReadPool()
+}
+
+// writer returns the primary pool for a write, noting it with the router.
+func (m *Manager) writer() *pgxpool.Pool {
+	if m.router != nil {
+		m.router.MarkWrite()
+	}
+	return m.pool
+}
+
+// SetGroupMaxAge caps how long a session group (a browser's set of signed-in
+// identities) lasts, counted from the group's first sign-in. Past it, every
+// session in the group stops validating, whatever its own expiry, and the
+// browser has to sign in from scratch. Adding or re-authenticating an
+// identity doesn't extend it.
+func (m *Manager) SetGroupMaxAge(d time.Duration) {
+	m.groupMaxAge = d
+}
+
+// groupCutoff returns the oldest group_created_at still allowed. Without a
+// cap it is the zero time, which every group passes.
+func (m *Manager) groupCutoff() time.Time {
+	if m.groupMaxAge <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-m.groupMaxAge)
+}
+
+// SetExpiryGrace keeps expired sessions findable through ValidateGrace for
+// d, so the portal can send a user whose session just ran out straight back
+// through sign-in as the same identity. Session cookies outlive the session
+// by d so the browser still presents them. Validate is unaffected.
+func (m *Manager) SetExpiryGrace(d time.Duration) {
+	m.expiryGrace = d
+}
+
+// SetRefreshWindow turns on sliding expiration: a session used (through
+// ValidateAndRefresh) with less than d of its lifetime left is extended to
+// the full TTL from now. Sessions that go unused still expire on time.
+func (m *Manager) SetRefreshWindow(d time.Duration) {
+	m.refresh = d
+}
+
+// SetIdleTTL makes sessions that haven't been used (validated) for d stop
+// validating, with ErrIdleExpired, even before they expire.
+func (m *Manager) SetIdleTTL(d time.Duration) {
+	m.idleTTL = d
+}
+
+// SetMaxSessionsPerUser caps how many browsers a user can be signed in on at
+// once. The limit counts session groups, not sessions, so signing another
+// identity of the same user into a browser doesn't use it up. Past the
+// limit, Create signs the user out of the browser they signed in on longest
+// ago.
+func (m *Manager) SetMaxSessionsPerUser(n int) {
+	m.maxPerUser = n
+}
+
+// idleCutoff returns the oldest last_seen still allowed. Without an idle
+// timeout it is the zero time, which every session passes.
+func (m *Manager) idleCutoff() time.Time {
+	if m.idleTTL <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-m.idleTTL)
+}
+
+// EnableRotation makes Rotate issue a fresh token on every use.
+func (m *Manager) EnableRotation() {
+	m.rotate = true
+}
+
 // Create inserts a new session and returns a cookie to set on the response.
-// If groupID is empty, a new group is created.
-func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID string) (*http.Cookie, error) {
+// If groupID is empty, a new group is created. ip and userAgent describe the
+// client signing in, for the user's and admins' review.
+func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID, ip, userAgent string) (*http.Cookie, error) {
 	token, err := generateToken()
 	if err != nil {
 		return nil, fmt.Errorf("generate token: %w", err)
@@ -65,11 +246,23 @@ func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID
 	var username string
 	_ = m.pool.QueryRow(ctx, `SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
 
+	if m.maxPerUser > 0 {
+		m.evictOverLimit(ctx, userID, groupID)
+	}
+
+	if len(userAgent) > maxUserAgent {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgent], "")
+	}
+
+	// A session joining a group inherits the group's start, so adding an
+	// identity doesn't extend the group's lifetime.
 	expiresAt := time.Now().Add(m.ttl)
-	_, err = m.pool.Exec(ctx, `
-		INSERT INTO sessions (token, did, handle, username, group_id, user_id, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, token, did, handle, username, groupID, userID, expiresAt)
+	_, err = m.writer().Exec(ctx, `
+		INSERT INTO sessions (token, did, handle, username, group_id, user_id, expires_at, group_created_at, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7,
+			COALESCE((SELECT MIN(group_created_at) FROM sessions WHERE group_id = $5 AND expires_at > now()), now()),
+			$8, $9)
+	`, token, did, handle, username, groupID, userID, expiresAt, ip, userAgent)
 	if err != nil {
 		return nil, fmt.Errorf("insert session: %w", err)
 	}
@@ -85,37 +278,184 @@ func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID
 	return m.makeCookie(token, expiresAt), nil
 }
 
+// evictOverLimit makes room for a user's session in groupID by deleting the
+// user's sessions in their oldest other groups, keeping maxPerUser-1 of
+// them. Other users' sessions in those groups are untouched. A failure is
+// logged and doesn't stop the sign-in.
+func (m *Manager) evictOverLimit(ctx context.Context, userID int64, groupID string) {
+	rows, err := m.writer().Query(ctx, `
+		DELETE FROM sessions WHERE user_id = $1 AND group_id IN (
+			SELECT group_id FROM sessions
+			WHERE user_id = $1 AND group_id != $2 AND expires_at > now()
+			GROUP BY group_id
+			ORDER BY MIN(created_at) DESC
+			OFFSET $3
+		)
+		RETURNING id, group_id
+	`, userID, groupID, m.maxPerUser-1)
+	if err != nil {
+		slog.Warn("session limit: eviction failed", "user_id", userID, "error", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var group string
+		if err := rows.Scan(&id, &group); err != nil {
+			break
+		}
+		slog.Info("session limit: evicted oldest session", "user_id", userID, "session_id", id, "group_id", group, "limit", m.maxPerUser)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Warn("session limit: eviction failed", "user_id", userID, "error", err)
+	}
+}
+
 // Validate checks a session token and returns the session if valid.
 func (m *Manager) Validate(ctx context.Context, token string) (*Session, error) {
+	return m.validate(ctx, token, 0)
+}
+
+// ValidateGrace is Validate, except that a session expired (or idle) less
+// than the expiry grace ago is returned too, with NeedsRefresh set. It is only for
+// deciding how to send a user back to sign-in; a session with NeedsRefresh
+// must not be treated as signed in.
+func (m *Manager) ValidateGrace(ctx context.Context, token string) (*Session, error) {
+	return m.validate(ctx, token, m.expiryGrace)
+}
+
+func (m *Manager) validate(ctx context.Context, token string, grace time.Duration) (*Session, error) {
 	var s Session
-	err := m.pool.QueryRow(ctx, `
-		SELECT id, token, did, handle, username, COALESCE(group_id, ''), user_id, expires_at FROM sessions
-		WHERE token = $1 AND expires_at > now()
-	`, token).Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt)
+	var lastSeen time.Time
+	// A token that was just rotated out is still accepted for rotationGrace;
+	// the returned session carries the current token. Expired sessions are
+	// still found (until cleanup) so the caller learns why they failed.
+	lookup := func(pool *pgxpool.Pool) error {
+		return pool.QueryRow(ctx, `
+			SELECT id, token, did, handle, username, COALESCE(group_id, ''), user_id, expires_at, auth_at, last_seen FROM sessions
+			WHERE (token = $1 OR (prev_token = $1 AND rotated_at > now() - $2::INTERVAL))
+			  AND group_created_at > $3
+		`, token, rotationGrace.String(), m.groupCutoff()).Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt, &s.AuthAt, &lastSeen)
+	}
+	pool := m.readPool()
+	err := lookup(pool)
+	if errors.Is(err, pgx.ErrNoRows) && pool != m.pool {
+		// The replica may not have a session created moments ago (by
+		// another instance, or before the write window closed).
+		err = lookup(m.pool)
+	}
 	if err != nil {
 		return nil, err
 	}
+	// The session ends at its expiry or when it goes idle, whichever is
+	// first; within the grace it is still returned, for sign-in only.
+	now := time.Now()
+	end, endErr := s.ExpiresAt, ErrExpired
+	if m.idleTTL > 0 && lastSeen.Add(m.idleTTL).Before(end) {
+		end, endErr = lastSeen.Add(m.idleTTL), ErrIdleExpired
+	}
+	if !end.After(now) {
+		if !end.Add(grace).After(now) {
+			return nil, endErr
+		}
+		s.NeedsRefresh = true
+		return &s, nil
+	}
 
 	// Update last_seen asynchronously.
-	go func() {
+	go func(id int64) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		_, _ = m.pool.Exec(ctx, `UPDATE sessions SET last_seen = now() WHERE token = $1`, token)
-	}()
+		_, _ = m.pool.Exec(ctx, `UPDATE sessions SET last_seen = now() WHERE id = $1`, id)
+	}(s.ID)
 
 	return &s, nil
 }
 
-// ListGroup returns all non-expired sessions in a group, ordered by creation time.
+// ValidateAndRefresh is Validate with sliding expiration (see
+// SetRefreshWindow). The returned cookie is non-nil only if this call
+// extended the session; it carries the new expiry and must be set on the
+// response.
+func (m *Manager) ValidateAndRefresh(ctx context.Context, token string) (*Session, *http.Cookie, error) {
+	s, err := m.Validate(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.refresh <= 0 || time.Until(s.ExpiresAt) >= m.refresh {
+		return s, nil, nil
+	}
+	// Only move expiry forward, so concurrent refreshes can't shorten it.
+	expiresAt := time.Now().Add(m.ttl)
+	result, err := m.writer().Exec(ctx, `
+		UPDATE sessions SET expires_at = $2 WHERE id = $1 AND expires_at < $2
+	`, s.ID, expiresAt)
+	if err != nil {
+		slog.Warn("session refresh failed", "session_id", s.ID, "error", err)
+		return s, nil, nil
+	}
+	if result.RowsAffected() == 0 {
+		return s, nil, nil
+	}
+	s.ExpiresAt = expiresAt
+	return s, m.makeCookie(s.Token, expiresAt), nil
+}
+
+// Rotate validates and refreshes a token (see ValidateAndRefresh) and, when
+// rotation is enabled, replaces it with a fresh one. The returned cookie is
+// non-nil only if this call rotated or extended the session; it must be set
+// on the response. If two requests race with the same token, the first
+// rotation wins and the other keeps using the old token until the grace
+// period ends.
+func (m *Manager) Rotate(ctx context.Context, token string) (*Session, *http.Cookie, error) {
+	s, refreshed, err := m.ValidateAndRefresh(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !m.rotate || s.Token != token {
+		return s, refreshed, nil
+	}
+
+	newToken, err := generateToken()
+	if err != nil {
+		return s, refreshed, nil
+	}
+	result, err := m.writer().Exec(ctx, `
+		UPDATE sessions SET prev_token = token, token = $2, rotated_at = now()
+		WHERE id = $1 AND token = $3
+	`, s.ID, newToken, token)
+	if err != nil {
+		slog.Warn("session rotation failed", "session_id", s.ID, "error", err)
+		return s, refreshed, nil
+	}
+	if result.RowsAffected() == 0 {
+		return s, refreshed, nil // lost the race; the winner's cookie is on its way
+	}
+	s.Token = newToken
+	return s, m.makeCookie(newToken, s.ExpiresAt), nil
+}
+
+// CountActive returns how many sessions would still validate: unexpired,
+// not idle, and in a group within SESSION_GROUP_MAX_AGE.
+func (m *Manager) CountActive(ctx context.Context) (int, error) {
+	var n int
+	err := m.readPool().QueryRow(ctx, `
+		SELECT count(*) FROM sessions
+		WHERE expires_at > now() AND group_created_at > $1 AND last_seen > $2
+	`, m.groupCutoff(), m.idleCutoff()).Scan(&n)
+	return n, err
+}
+
+// ListGroup returns all non-expired, non-idle sessions in a group, ordered by
+// creation time.
 func (m *Manager) ListGroup(ctx context.Context, groupID string) ([]Session, error) {
 	if groupID == "" {
 		return nil, nil
 	}
 	rows, err := m.pool.Query(ctx, `
-		SELECT id, token, did, handle, username, group_id, user_id, expires_at FROM sessions
-		WHERE group_id = $1 AND expires_at > now()
+		SELECT id, token, did, handle, username, group_id, user_id, expires_at, ip, user_agent FROM sessions
+		WHERE group_id = $1 AND expires_at > now() AND group_created_at > $2 AND last_seen > $3
 		ORDER BY created_at
-	`, groupID)
+	`, groupID, m.groupCutoff(), m.idleCutoff())
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +464,34 @@ func (m *Manager) ListGroup(ctx context.Context, groupID string) ([]Session, err
 	var sessions []Session
 	for rows.Next() {
 		var s Session
-		if err := rows.Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt, &s.IP, &s.UserAgent); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// ListGroupDetailed is ListGroup with each session's creation and last-use
+// times, for showing a user where they're signed in.
+func (m *Manager) ListGroupDetailed(ctx context.Context, groupID string) ([]Session, error) {
+	if groupID == "" {
+		return nil, nil
+	}
+	rows, err := m.pool.Query(ctx, `
+		SELECT id, token, did, handle, username, group_id, user_id, expires_at, auth_at, created_at, last_seen, ip, user_agent FROM sessions
+		WHERE group_id = $1 AND expires_at > now() AND group_created_at > $2 AND last_seen > $3
+		ORDER BY created_at
+	`, groupID, m.groupCutoff(), m.idleCutoff())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt, &s.AuthAt, &s.CreatedAt, &s.LastSeen, &s.IP, &s.UserAgent); err != nil {
 			return nil, err
 		}
 		sessions = append(sessions, s)
@@ -133,6 +500,7 @@ func (m *Manager) ListGroup(ctx context.Context, groupID string) ([]Session, err
 }
 
 // GroupHasDID checks if a DID already exists in a group and returns the session ID if so.
+// An idle session counts: signing in again revives it (MarkAuthenticated).
 func (m *Manager) GroupHasDID(ctx context.Context, groupID, did string) (int64, string, bool) {
 	if groupID == "" {
 		return 0, "", false
@@ -141,22 +509,30 @@ func (m *Manager) GroupHasDID(ctx context.Context, groupID, did string) (int64,
 	var token string
 	err := m.pool.QueryRow(ctx, `
 		SELECT id, token FROM sessions
-		WHERE group_id = $1 AND did = $2 AND expires_at > now()
-	`, groupID, did).Scan(&id, &token)
+		WHERE group_id = $1 AND did = $2 AND expires_at > now() AND group_created_at > $3
+	`, groupID, did, m.groupCutoff()).Scan(&id, &token)
 	if err != nil {
 		return 0, "", false
 	}
 	return id, token, true
 }
 
+// MarkAuthenticated records that the user just completed OAuth again for an
+// existing session, resetting its auth age for services that require a
+// recent sign-in, and counting as use for the idle timeout.
+func (m *Manager) MarkAuthenticated(ctx context.Context, sessionID int64) error {
+	_, err := m.writer().Exec(ctx, `UPDATE sessions SET auth_at = now(), last_seen = now() WHERE id = $1`, sessionID)
+	return err
+}
+
 // SwitchTo switches the active session within a group. Returns a cookie for the target session.
 func (m *Manager) SwitchTo(ctx context.Context, groupID string, sessionID int64) (*http.Cookie, error) {
 	var token string
 	var expiresAt time.Time
 	err := m.pool.QueryRow(ctx, `
 		SELECT token, expires_at FROM sessions
-		WHERE id = $1 AND group_id = $2 AND expires_at > now()
-	`, sessionID, groupID).Scan(&token, &expiresAt)
+		WHERE id = $1 AND group_id = $2 AND expires_at > now() AND group_created_at > $3 AND last_seen > $4
+	`, sessionID, groupID, m.groupCutoff(), m.idleCutoff()).Scan(&token, &expiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("session not found in group: %w", err)
 	}
@@ -166,7 +542,7 @@ func (m *Manager) SwitchTo(ctx context.Context, groupID string, sessionID int64)
 // DestroyOne deletes one session from a group. If wasActive is true, returns a cookie
 // for the next session in the group, or ClearCookie if none remain.
 func (m *Manager) DestroyOne(ctx context.Context, groupID string, sessionID int64, wasActive bool) (*http.Cookie, error) {
-	_, err := m.pool.Exec(ctx, `
+	_, err := m.writer().Exec(ctx, `
 		DELETE FROM sessions WHERE id = $1 AND group_id = $2
 	`, sessionID, groupID)
 	if err != nil {
@@ -182,9 +558,9 @@ func (m *Manager) DestroyOne(ctx context.Context, groupID string, sessionID int6
 	var expiresAt time.Time
 	err = m.pool.QueryRow(ctx, `
 		SELECT token, expires_at FROM sessions
-		WHERE group_id = $1 AND expires_at > now()
+		WHERE group_id = $1 AND expires_at > now() AND group_created_at > $2 AND last_seen > $3
 		ORDER BY created_at LIMIT 1
-	`, groupID).Scan(&token, &expiresAt)
+	`, groupID, m.groupCutoff(), m.idleCutoff()).Scan(&token, &expiresAt)
 	if err != nil {
 		// No sessions left — clear cookie.
 		return m.ClearCookie(), nil
@@ -192,41 +568,127 @@ func (m *Manager) DestroyOne(ctx context.Context, groupID string, sessionID int6
 	return m.makeCookie(token, expiresAt), nil
 }
 
-// DestroyGroup deletes all sessions in a group.
+// DestroyGroup deletes all sessions in a group, and its per-domain
+// identity choices.
 func (m *Manager) DestroyGroup(ctx context.Context, groupID string) error {
 	if groupID == "" {
 		return nil
 	}
-	_, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE group_id = $1`, groupID)
+	if _, err := m.writer().Exec(ctx, `DELETE FROM sessions WHERE group_id = $1`, groupID); err != nil {
+		return err
+	}
+	_, err := m.pool.Exec(ctx, `DELETE FROM session_domain_identities WHERE group_id = $1`, groupID)
 	return err
 }
 
+// SetDomainIdentity makes did the identity relayed to a cookie domain for a
+// session group, whichever identity is active when the relay happens. With
+// did "" the domain goes back to getting the active identity.
+func (m *Manager) SetDomainIdentity(ctx context.Context, groupID, domain, did string) error {
+	if did == "" {
+		_, err := m.writer().Exec(ctx, `
+			DELETE FROM session_domain_identities WHERE group_id = $1 AND domain = $2`, groupID, domain)
+		return err
+	}
+	_, err := m.writer().Exec(ctx, `
+		INSERT INTO session_domain_identities (group_id, domain, did) VALUES ($1, $2, $3)
+		ON CONFLICT (group_id, domain) DO UPDATE SET did = EXCLUDED.did, updated_at = now()`,
+		groupID, domain, did)
+	return err
+}
+
+// DomainIdentities returns a group's identity choices, cookie domain → DID.
+func (m *Manager) DomainIdentities(ctx context.Context, groupID string) (map[string]string, error) {
+	rows, err := m.pool.Query(ctx, `
+		SELECT domain, did FROM session_domain_identities WHERE group_id = $1`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prefs := make(map[string]string)
+	for rows.Next() {
+		var domain, did string
+		if err := rows.Scan(&domain, &did); err != nil {
+			return nil, err
+		}
+		prefs[domain] = did
+	}
+	return prefs, rows.Err()
+}
+
+// DomainSession returns the group's session for the identity chosen for a
+// cookie domain, or nil if none was chosen or that identity has since
+// signed out of the group.
+func (m *Manager) DomainSession(ctx context.Context, groupID, domain string) (*Session, error) {
+	if groupID == "" {
+		return nil, nil
+	}
+	var s Session
+	err := m.pool.QueryRow(ctx, `
+		SELECT s.id, s.token, s.did, s.handle, s.username, s.group_id, s.user_id, s.expires_at, s.auth_at
+		FROM session_domain_identities p
+		JOIN sessions s ON s.group_id = p.group_id AND s.did = p.did
+		WHERE p.group_id = $1 AND p.domain = $2 AND s.expires_at > now() AND s.group_created_at > $3
+		  AND s.last_seen > $4`,
+		groupID, domain, m.groupCutoff(), m.idleCutoff()).Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt, &s.AuthAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DestroyUser deletes every session belonging to a user, across all groups.
+// Returns the number of sessions removed.
+func (m *Manager) DestroyUser(ctx context.Context, userID int64) (int64, error) {
+	result, err := m.writer().Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// DestroyDID deletes every session signed in as a DID, across all groups.
+// Returns the number of sessions removed.
+func (m *Manager) DestroyDID(ctx context.Context, did string) (int64, error) {
+	result, err := m.writer().Exec(ctx, `DELETE FROM sessions WHERE did = $1`, did)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 // Destroy removes a session (logout).
 func (m *Manager) Destroy(ctx context.Context, token string) error {
-	_, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE token = $1`, token)
+	_, err := m.writer().Exec(ctx, `DELETE FROM sessions WHERE token = $1 OR prev_token = $1`, token)
 	return err
 }
 
 // ClearCookie returns a cookie that clears the session cookie.
 func (m *Manager) ClearCookie() *http.Cookie {
 	return &http.Cookie{
-		Name:     cookieName,
-		Value:    "",
-		Path:     "/",
-		Domain:   m.cookieDomain,
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
+		Name:        m.cookieName,
+		Value:       "",
+		Path:        m.cookiePath,
+		Domain:      m.domain(m.cookieDomain),
+		MaxAge:      -1,
+		HttpOnly:    true,
+		Secure:      m.secure,
+		SameSite:    m.sameSite(),
+		Partitioned: m.partitioned,
 	}
 }
 
-// CookieName returns the session cookie name.
-func CookieName() string {
-	return cookieName
+// CookieName returns the session cookie name, including any prefix.
+func (m *Manager) CookieName() string {
+	return m.cookieName
 }
 
-// StartCleanup starts a background goroutine that deletes expired sessions.
+// StartCleanup starts a background goroutine that deletes expired and idle
+// sessions (once past the expiry grace), including those in groups past the
+// group max age.
 func (m *Manager) StartCleanup() {
 	go func() {
 		ticker := time.NewTicker(15 * time.Minute)
@@ -235,13 +697,26 @@ func (m *Manager) StartCleanup() {
 			select {
 			case <-ticker.C:
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				result, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= now()`)
+				result, err := m.pool.Exec(ctx, `
+					DELETE FROM sessions
+					WHERE expires_at <= now() - $2::INTERVAL OR group_created_at <= $1
+					   OR ($3::INTERVAL > '0' AND last_seen <= now() - $3::INTERVAL - $2::INTERVAL)`,
+					m.groupCutoff(), m.expiryGrace.String(), m.idleTTL.String())
 				cancel()
 				if err != nil {
 					slog.Error("session cleanup failed", "error", err)
 				} else if result.RowsAffected() > 0 {
 					slog.Info("cleaned up expired sessions", "count", result.RowsAffected())
 				}
+				// Identity choices of groups that no longer have sessions.
+				ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+				_, err = m.pool.Exec(ctx, `
+					DELETE FROM session_domain_identities p
+					WHERE NOT EXISTS (SELECT 1 FROM sessions s WHERE s.group_id = p.group_id)`)
+				cancel()
+				if err != nil {
+					slog.Error("domain identity cleanup failed", "error", err)
+				}
 			case <-m.stopCleanup:
 				return
 			}
@@ -257,41 +732,44 @@ func (m *Manager) StopCleanup() {
 // MakeCookieForDomain creates a session cookie for a specific domain.
 func (m *Manager) MakeCookieForDomain(token string, expiresAt time.Time, domain string) *http.Cookie {
 	return &http.Cookie{
-		Name:     cookieName,
-		Value:    token,
-		Path:     "/",
-		Domain:   domain,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
+		Name:        m.cookieName,
+		Value:       token,
+		Path:        m.cookiePath,
+		Domain:      m.domain(domain),
+		Expires:     expiresAt.Add(m.expiryGrace),
+		HttpOnly:    true,
+		Secure:      m.secure,
+		SameSite:    m.sameSite(),
+		Partitioned: m.partitioned,
 	}
 }
 
 // ClearCookieForDomain creates a cookie that clears the session for a specific domain.
 func (m *Manager) ClearCookieForDomain(domain string) *http.Cookie {
 	return &http.Cookie{
-		Name:     cookieName,
-		Value:    "",
-		Path:     "/",
-		Domain:   domain,
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
+		Name:        m.cookieName,
+		Value:       "",
+		Path:        m.cookiePath,
+		Domain:      m.domain(domain),
+		MaxAge:      -1,
+		HttpOnly:    true,
+		Secure:      m.secure,
+		SameSite:    m.sameSite(),
+		Partitioned: m.partitioned,
 	}
 }
 
 func (m *Manager) makeCookie(token string, expiresAt time.Time) *http.Cookie {
 	return &http.Cookie{
-		Name:     cookieName,
-		Value:    token,
-		Path:     "/",
-		Domain:   m.cookieDomain,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
+		Name:        m.cookieName,
+		Value:       token,
+		Path:        m.cookiePath,
+		Domain:      m.domain(m.cookieDomain),
+		Expires:     expiresAt.Add(m.expiryGrace),
+		HttpOnly:    true,
+		Secure:      m.secure,
+		SameSite:    m.sameSite(),
+		Partitioned: m.partitioned,
 	}
 }
 